@@ -3,6 +3,7 @@ module calendar
 go 1.24.2
 
 require (
+	github.com/google/btree v1.1.3
 	github.com/google/uuid v1.6.0
 	github.com/ilyakaznacheev/cleanenv v1.5.0
 	go.uber.org/mock v0.6.0
@@ -17,82 +17,97 @@ type Repository struct {
 	data *data.Data
 }
 
-func (r *Repository) checkUserId(userId string) bool {
+func (r *Repository) userEvents(userId string) (*data.Events, bool) {
 	r.data.Mu.RLock()
 	defer r.data.Mu.RUnlock()
-	_, b := r.data.Users[userId]
-	return b
+	events, b := r.data.Users[userId]
+	return events, b
 }
 
-func (r *Repository) CreateEvent(userEvent *models.UserEvent) error {
+func (r *Repository) userEventsOrCreate(userId string) *data.Events {
+	if events, b := r.userEvents(userId); b {
+		return events
+	}
+
 	r.data.Mu.Lock()
 	defer r.data.Mu.Unlock()
-	unixDate, _ := time.Parse("2006-01-02", userEvent.Date)
-	for i, v := range r.data.Users[userEvent.UserId] {
-		curUnixDate, _ := time.Parse("2006-01-02", v.Date)
-		if curUnixDate.Unix() > unixDate.Unix() {
-			r.data.Users[userEvent.UserId] = slices.Insert(r.data.Users[userEvent.UserId], i, userEvent.Event)
-			return nil
-		}
+	events, b := r.data.Users[userId]
+	if !b {
+		events = data.NewEvents()
+		r.data.Users[userId] = events
 	}
-	r.data.Users[userEvent.UserId] = append(r.data.Users[userEvent.UserId], userEvent.Event)
+	return events
+}
+
+func (r *Repository) CreateEvent(userEvent *models.UserEvent) error {
+	events := r.userEventsOrCreate(userEvent.UserId)
+
+	unixDate, _ := time.Parse("2006-01-02", userEvent.Date)
+
+	events.Mu.Lock()
+	defer events.Mu.Unlock()
+
+	events.Seq++
+	item := &data.Item{Start: unixDate.Unix(), Seq: events.Seq, Event: userEvent.Event}
+	events.Tree.ReplaceOrInsert(item)
+	events.Ids[item.Event.EventId] = append(events.Ids[item.Event.EventId], item)
 	return nil
 }
 
 func (r *Repository) UpdateEvent(userEvent *models.UserEvent) error {
-	if !r.checkUserId(userEvent.UserId) {
+	events, b := r.userEvents(userEvent.UserId)
+	if !b {
 		return ErrNonExistUserId
 	}
 
-	r.data.Mu.Lock()
-	defer r.data.Mu.Unlock()
+	events.Mu.Lock()
+	defer events.Mu.Unlock()
 
-	for i, v := range r.data.Users[userEvent.UserId] {
-		if v.EventId == userEvent.EventId {
-			r.data.Users[userEvent.UserId][i].Message = userEvent.Message
-			return nil
-		}
+	items := events.Ids[userEvent.EventId]
+	if len(items) == 0 {
+		return ErrNonExistEventId
 	}
-	return ErrNonExistEventId
+	items[0].Event.Message = userEvent.Message
+	return nil
 }
 
 func (r *Repository) DeleteEvent(userEvent *models.UserEvent) error {
-	if !r.checkUserId(userEvent.UserId) {
+	events, b := r.userEvents(userEvent.UserId)
+	if !b {
 		return ErrNonExistUserId
 	}
 
-	r.data.Mu.Lock()
-	defer r.data.Mu.Unlock()
+	events.Mu.Lock()
+	defer events.Mu.Unlock()
 
-	for i, v := range r.data.Users[userEvent.UserId] {
-		if v.EventId == userEvent.EventId {
-			r.data.Users[userEvent.UserId] = slices.Delete(r.data.Users[userEvent.UserId], i, i+1)
-			return nil
-		}
+	items := events.Ids[userEvent.EventId]
+	if len(items) == 0 {
+		return ErrNonExistEventId
 	}
-	return ErrNonExistEventId
+	events.Tree.Delete(items[0])
+	if len(items) == 1 {
+		delete(events.Ids, userEvent.EventId)
+	} else {
+		events.Ids[userEvent.EventId] = slices.Delete(items, 0, 1)
+	}
+	return nil
 }
 
 func (r *Repository) ReadEvents(userId string, dateFrom, dateTo int64) ([]models.Event, error) {
 	result := make([]models.Event, 0)
 
-	if !r.checkUserId(userId) {
+	events, b := r.userEvents(userId)
+	if !b {
 		return result, ErrNonExistUserId
 	}
 
-	r.data.Mu.RLock()
-	defer r.data.Mu.RUnlock()
+	events.Mu.RLock()
+	defer events.Mu.RUnlock()
 
-	for _, v := range r.data.Users[userId] {
-		curUnixDate, _ := time.Parse("2006-01-02", v.Date)
-		if curUnixDate.Unix() >= dateFrom {
-			if curUnixDate.Unix() < dateTo {
-				result = append(result, v)
-			} else {
-				return result, nil
-			}
-		}
-	}
+	events.Tree.AscendRange(&data.Item{Start: dateFrom}, &data.Item{Start: dateTo}, func(item *data.Item) bool {
+		result = append(result, item.Event)
+		return true
+	})
 	return result, nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"calendar/internal/models"
 	"calendar/pkg/data"
 	"slices"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -173,3 +174,96 @@ func TestMain(t *testing.T) {
 	})
 
 }
+
+const benchEventsPerUser = 20000
+
+func benchUserEvent(userId string, i int) *models.UserEvent {
+	date := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, (i*7919)%benchEventsPerUser)
+	return &models.UserEvent{UserId: userId, Event: models.Event{EventId: strconv.Itoa(i), Message: defaultMessage, Date: date.Format("2006-01-02")}}
+}
+
+func newBenchRepository(b *testing.B, userId string) *Repository {
+	repo := New(data.New())
+	for i := range benchEventsPerUser {
+		if err := repo.CreateEvent(benchUserEvent(userId, i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func BenchmarkCreateEvent(b *testing.B) {
+	userId := "bench"
+	repo := newBenchRepository(b, userId)
+
+	for i := 0; b.Loop(); i++ {
+		if err := repo.CreateEvent(benchUserEvent(userId, benchEventsPerUser+i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUpdateEvent(b *testing.B) {
+	userId := "bench"
+	repo := newBenchRepository(b, userId)
+
+	for i := 0; b.Loop(); i++ {
+		if err := repo.UpdateEvent(benchUserEvent(userId, i%benchEventsPerUser)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDeleteEvent(b *testing.B) {
+	userId := "bench"
+	repo := newBenchRepository(b, userId)
+
+	for i := 0; b.Loop(); i++ {
+		userEvent := benchUserEvent(userId, i%benchEventsPerUser)
+		if err := repo.DeleteEvent(userEvent); err != nil {
+			b.Fatal(err)
+		}
+		if err := repo.CreateEvent(userEvent); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadEventsWeek(b *testing.B) {
+	userId := "bench"
+	repo := newBenchRepository(b, userId)
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for b.Loop() {
+		res, err := repo.ReadEvents(userId, from.Unix(), from.AddDate(0, 0, 7).Unix())
+		if err != nil || len(res) != 7 {
+			b.Fatal(len(res), err)
+		}
+	}
+}
+
+func BenchmarkReadEventsParallelUsers(b *testing.B) {
+	repo := New(data.New())
+	for u := range 8 {
+		for i := range benchEventsPerUser / 8 {
+			if err := repo.CreateEvent(benchUserEvent(strconv.Itoa(u), i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	from := time.Date(2000, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			userId := strconv.Itoa(i % 8)
+			if i%4 == 0 {
+				_ = repo.UpdateEvent(benchUserEvent(userId, i%(benchEventsPerUser/8)))
+			} else if _, err := repo.ReadEvents(userId, from.Unix(), from.AddDate(0, 1, 0).Unix()); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
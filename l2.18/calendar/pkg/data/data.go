@@ -3,13 +3,44 @@ package data
 import (
 	"calendar/internal/models"
 	"sync"
+
+	"github.com/google/btree"
 )
 
+const eventsDegree = 32
+
+// Item is an event stored in the per-user tree. Start is the parsed event date,
+// Seq keeps insertion order for events that share the same date.
+type Item struct {
+	Start int64
+	Seq   uint64
+	Event models.Event
+}
+
+func itemLess(a, b *Item) bool {
+	if a.Start != b.Start {
+		return a.Start < b.Start
+	}
+	return a.Seq < b.Seq
+}
+
+// Events holds a single user's events ordered by start time with an index by event id.
+type Events struct {
+	Tree *btree.BTreeG[*Item]
+	Ids  map[string][]*Item
+	Seq  uint64
+	Mu   sync.RWMutex
+}
+
+func NewEvents() *Events {
+	return &Events{Tree: btree.NewG(eventsDegree, itemLess), Ids: make(map[string][]*Item)}
+}
+
 type Data struct {
-	Users map[string][]models.Event
+	Users map[string]*Events
 	Mu    sync.RWMutex
 }
 
 func New() *Data {
-	return &Data{Users: make(map[string][]models.Event)}
+	return &Data{Users: make(map[string]*Events)}
 }
@@ -2,15 +2,20 @@ package main
 
 import (
 	"app/internal/config"
+	"app/internal/models"
 	"app/internal/repository"
 	"app/internal/service"
 	"app/internal/transport"
-	"app/pkg/data/inmem"
+	"app/pkg/channel"
+	"app/pkg/data/postgres"
 	"app/pkg/data/rabbit"
 	"app/pkg/logger"
+	"app/pkg/scheduler"
+	"app/pkg/wrk/relay"
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
@@ -19,17 +24,40 @@ func main() {
 	ctx := context.WithValue(context.Background(), logger.LoggerKey, lg)
 
 	cfg := config.New()
-	rabbitConn, rabbitCh := rabbit.New(cfg.RabbitConfig)
-	data := inmem.New()
-	repo := repository.New(data, rabbitCh)
+	rabbitConn := rabbit.New(cfg.RabbitConfig)
+	db := postgres.New(cfg.PostgresConfig)
+	repo := repository.New(db)
+	broker := rabbit.NewBroker(rabbitConn)
+	sched := scheduler.New(cfg.SchedulerConfig, broker)
+	if err := sched.Declare(); err != nil {
+		panic(err)
+	}
+	relay := relay.New(repo, sched, cfg.RelayConfig)
 	service := service.New(repo)
-	server := transport.New(service, rabbitConn, rabbitCh, &cfg.ServerConfig, &cfg.EmailSenderConfig, ctx)
+	channels := map[string]transport.ChannelInterface{
+		models.ChannelEmail:    channel.NewEmail(cfg.EmailSenderConfig, cfg.ChannelRetry),
+		models.ChannelTelegram: channel.NewTelegram(cfg.TelegramConfig, cfg.ChannelRetry),
+		models.ChannelWebhook:  channel.NewWebhook(cfg.WebhookConfig, cfg.ChannelRetry),
+		models.ChannelSms:      channel.NewSmsStub(),
+	}
+	server := transport.New(service, broker, channels, rabbitConn, &cfg.ServerConfig, ctx)
 
 	graceCh := make(chan os.Signal, 1)
 	signal.Notify(graceCh, syscall.SIGINT, syscall.SIGTERM)
 
+	relayCtx, relayCanc := context.WithCancel(ctx)
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go relay.Start(relayCtx, wg)
+	go sched.Start(relayCtx, wg)
+
 	go server.Start()
 
 	<-graceCh
+	relayCanc()
+	wg.Wait()
+	if err := broker.Close(); err != nil {
+		lg.Lg.Error().Err(err).Msg("while closing broker channel")
+	}
 	server.Stop()
 }
@@ -3,7 +3,11 @@ package config
 import (
 	"app/internal/models"
 	"app/internal/transport"
+	"app/pkg/channel"
+	"app/pkg/data/postgres"
 	"app/pkg/data/rabbit"
+	"app/pkg/scheduler"
+	"app/pkg/wrk/relay"
 	"fmt"
 	"strconv"
 
@@ -13,8 +17,14 @@ import (
 
 type Config struct {
 	ServerConfig      transport.ServerConfig
-	EmailSenderConfig transport.EmailSenderConfig
+	EmailSenderConfig channel.EmailConfig
+	TelegramConfig    channel.TelegramConfig
+	WebhookConfig     channel.WebhookConfig
+	ChannelRetry      channel.RetryConfig
 	RabbitConfig      rabbit.RabbitConfig
+	PostgresConfig    postgres.PostgresConfig
+	RelayConfig       relay.RelayConfig
+	SchedulerConfig   scheduler.SchedulerConfig
 }
 
 func (c *Config) valid() error {
@@ -28,9 +38,15 @@ func (c *Config) valid() error {
 	if err := validPort(c.RabbitConfig.Port); err != nil {
 		resultErr = multierr.Append(resultErr, err)
 	}
+	if err := validPort(c.PostgresConfig.Port); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
 	if err := validReleaseMode(c.ServerConfig.ReleaseMode); err != nil {
 		resultErr = multierr.Append(resultErr, err)
 	}
+	resultErr = multierr.Append(resultErr, c.RelayConfig.Valid())
+	resultErr = multierr.Append(resultErr, c.SchedulerConfig.Valid())
+	resultErr = multierr.Append(resultErr, c.ChannelRetry.Valid())
 	return resultErr
 }
 
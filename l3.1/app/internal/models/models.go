@@ -6,18 +6,101 @@ import (
 )
 
 var (
-	ErrBadPort       = errors.New("bad port")
-	ErrBadReleaseMod = errors.New("bad release mod")
-	ErrNonExistId    = errors.New("non exist id")
-	ErrBadEmail      = errors.New("bad email")
+	ErrBadPort         = errors.New("bad port")
+	ErrBadReleaseMod   = errors.New("bad release mod")
+	ErrNonExistId      = errors.New("non exist id")
+	ErrBadEmail        = errors.New("bad email")
+	ErrBadRecipient    = errors.New("bad recipient")
+	ErrBadChannel      = errors.New("unsupported channel")
+	ErrOnDelivery      = errors.New("on delivery")
+	ErrBadChannelCfg   = errors.New("bad channel config")
+	ErrBadTransition   = errors.New("bad status transition")
+	ErrKeyReused       = errors.New("idempotency key reused with a different request")
+	ErrEmptyPatch      = errors.New("nothing to update")
+	ErrOnDatabase      = errors.New("on database")
+	ErrNotConfirmed    = errors.New("publishing not confirmed by broker")
+	ErrBadRelayCfg     = errors.New("bad relay config")
+	ErrBadSchedulerCfg = errors.New("bad scheduler config")
+	ErrStuckSending    = errors.New("stuck in sending, reclaimed")
+)
+
+// Notification lifecycle: scheduled -> queued -> sending -> sent | failed,
+// scheduled and queued notifications can be cancelled.
+const (
+	StatusScheduled = "scheduled"
+	StatusQueued    = "queued"
+	StatusSending   = "sending"
+	StatusSent      = "sent"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Delivery channels, the recipient is an email address, a Telegram chat id,
+// a webhook URL or a phone number respectively.
+const (
+	ChannelEmail    = "email"
+	ChannelTelegram = "telegram"
+	ChannelWebhook  = "webhook"
+	ChannelSms      = "sms-stub"
 )
 
 type Notification struct {
-	Email        string    `json:"email" binding:"required"`
-	Id           string    `json:"id"`
-	CreationDate time.Time `json:"creation_date" time_format:"2006-01-02 15:04:05"`
-	SendingDate  time.Time `json:"sending_date" time_format:"2006-01-02 15:04:05" binding:"required"`
-	Data         string    `json:"data" binding:"required"`
+	// Email is the recipient of email notifications for clients that don't send channel and recipient.
+	Email        string         `json:"email,omitempty"`
+	Id           string         `json:"id"`
+	Channel      string         `json:"channel"`
+	Recipient    string         `json:"recipient"`
+	CreationDate time.Time      `json:"creation_date" time_format:"2006-01-02 15:04:05"`
+	SendingDate  time.Time      `json:"sending_date" time_format:"2006-01-02 15:04:05" binding:"required"`
+	Data         string         `json:"data" binding:"required"`
+	Status       string         `json:"status"`
+	Version      int            `json:"version"`
+	Attempts     int            `json:"attempts"`
+	LastError    string         `json:"last_error,omitempty"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	History      []StatusChange `json:"history,omitempty"`
+	// IdempotencyKey and RequestHash let a retried POST return the notification
+	// created by the first attempt.
+	IdempotencyKey string `json:"-"`
+	RequestHash    string `json:"-"`
+}
+
+// NotificationPatch changes a scheduled notification, nil fields stay as they are.
+type NotificationPatch struct {
+	Recipient   *string    `json:"recipient"`
+	Email       *string    `json:"email"`
+	SendingDate *time.Time `json:"sending_date"`
+	Data        *string    `json:"data"`
+}
+
+type StatusChange struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Transition moves a notification to status To if its current status is one of From
+// and, when Version isn't zero, its version is Version. Attempts is added to the
+// notification's attempt count.
+type Transition struct {
+	Id       string
+	From     []string
+	To       string
+	Version  int
+	Attempts int
+	Error    string
+}
+
+// OutboxMessage is a message written in the same transaction as its notification
+// and published later by the relay.
+type OutboxMessage struct {
+	Id             int64
+	NotificationId string
+	Exchange       string
+	RoutingKey     string
+	Payload        []byte
+	DeliverAt      time.Time
+	Attempts       int
 }
 
 func NewNotification() *Notification {
@@ -2,69 +2,383 @@ package repository
 
 import (
 	"app/internal/models"
-	"app/pkg/data/inmem"
+	"app/pkg/data/rabbit"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"time"
 
-	"github.com/rabbitmq/amqp091-go"
-	"github.com/wb-go/wbf/rabbitmq"
+	"github.com/lib/pq"
+	"github.com/wb-go/wbf/dbpg"
 )
 
-// type PublisherConfig struct {
-// 	ExchangeName string
-// 	RoutingKey   string
-// 	ContentType  string
-// }
-
 type Repository struct {
-	data *inmem.Data
-	pub  *rabbitmq.Publisher
+	db *dbpg.DB
 }
 
-func New(data *inmem.Data, rabbitCh *rabbitmq.Channel) *Repository {
-	return &Repository{data, rabbitmq.NewPublisher(rabbitCh, "main_exchange")}
+func New(db *dbpg.DB) *Repository {
+	return &Repository{db}
 }
 
-func (r *Repository) CreateNotification(notif *models.Notification) error {
-	marshalled, err := json.Marshal(notif)
+// CreateNotification stores the notification together with its outbox message,
+// the relay publishes the message once the transaction is committed. If a notification
+// with the same idempotency key exists, notif.Id is set to its id instead.
+func (r *Repository) CreateNotification(ctx context.Context, notif *models.Notification) error {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
+
+	q := `INSERT INTO notifications (id, channel, recipient, data, sending_date, status, version, idempotency_key, request_hash, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $10)
+	ON CONFLICT (idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING`
+	res, err := tx.ExecContext(ctx, q, notif.Id, notif.Channel, notif.Recipient, notif.Data, notif.SendingDate, notif.Status, notif.Version, notif.IdempotencyKey, notif.RequestHash, notif.CreationDate)
 	if err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	} else if n == 0 {
+		return r.existingByKey(ctx, notif)
+	}
+
+	if err := insertHistory(ctx, tx, notif.Id, notif.Status, ""); err != nil {
+		return err
+	}
+	if err := insertOutbox(ctx, tx, notif); err != nil {
 		return err
 	}
 
-	r.data.Mu.Lock()
-	r.data.Notifications[notif.Id] = notif
-	r.data.Mu.Unlock()
+	if err := tx.Commit(); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	return nil
+}
 
-	headers := amqp091.Table{"x-delay": (notif.SendingDate.Sub(notif.CreationDate)).Milliseconds()}
-	err = r.pub.Publish(marshalled, "main_routing_key", "json", rabbitmq.PublishingOptions{Headers: headers})
+func (r *Repository) existingByKey(ctx context.Context, notif *models.Notification) error {
+	var id, hash string
+	q := `SELECT id, request_hash FROM notifications WHERE idempotency_key = $1`
+	if err := r.db.Master.QueryRowContext(ctx, q, notif.IdempotencyKey).Scan(&id, &hash); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	if hash != notif.RequestHash {
+		return models.ErrKeyReused
+	}
+	notif.Id = id
+	return nil
+}
+
+func (r *Repository) ReadNotification(ctx context.Context, id string) (*models.Notification, error) {
+	q1 := `SELECT id, channel, recipient, data, sending_date, status, version, attempts, last_error, created_at, updated_at FROM notifications WHERE id = $1`
+
+	notif := models.NewNotification()
+	err := r.db.QueryRowContext(ctx, q1, id).Scan(&notif.Id, &notif.Channel, &notif.Recipient, &notif.Data, &notif.SendingDate, &notif.Status, &notif.Version, &notif.Attempts, &notif.LastError, &notif.CreationDate, &notif.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrNonExistId
+	} else if err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	q2 := `SELECT status, error, changed_at FROM notification_status_history WHERE notification_id = $1 ORDER BY id`
+	rows, err := r.db.QueryContext(ctx, q2, id)
 	if err != nil {
-		r.data.Mu.Lock()
-		delete(r.data.Notifications, notif.Id)
-		r.data.Mu.Unlock()
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+	defer rows.Close()
+
+	notif.History = make([]models.StatusChange, 0)
+	for rows.Next() {
+		var change models.StatusChange
+		if err := rows.Scan(&change.Status, &change.Error, &change.ChangedAt); err != nil {
+			return nil, errors.Join(models.ErrOnDatabase, err)
+		}
+		notif.History = append(notif.History, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	return notif, nil
+}
+
+// UpdateNotification applies patch to a scheduled or queued notification, bumps its
+// version and replaces its outbox message. Messages already in the broker carry the
+// old version and are skipped by the consumer.
+func (r *Repository) UpdateNotification(ctx context.Context, id string, patch models.NotificationPatch) (*models.Notification, error) {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
+
+	q := `UPDATE notifications
+	SET recipient = COALESCE($2, recipient), data = COALESCE($3, data), sending_date = COALESCE($4, sending_date),
+		status = $5, version = version + 1, updated_at = NOW()
+	WHERE id = $1 AND status = ANY($6)
+	RETURNING id, channel, recipient, data, sending_date, status, version, created_at`
+
+	notif := models.NewNotification()
+	from := []string{models.StatusScheduled, models.StatusQueued}
+	err = tx.QueryRowContext(ctx, q, id, patch.Recipient, patch.Data, patch.SendingDate, models.StatusScheduled, pq.Array(from)).
+		Scan(&notif.Id, &notif.Channel, &notif.Recipient, &notif.Data, &notif.SendingDate, &notif.Status, &notif.Version, &notif.CreationDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, missingOrBadTransition(ctx, tx, id)
+	} else if err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	if err := insertHistory(ctx, tx, id, models.StatusScheduled, ""); err != nil {
+		return nil, err
+	}
+	if err := deleteOutbox(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	if err := insertOutbox(ctx, tx, notif); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+	return r.ReadNotification(ctx, id)
+}
+
+// CancelNotification cancels a scheduled or queued notification and drops its
+// unpublished outbox message.
+func (r *Repository) CancelNotification(ctx context.Context, id string) error {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
+
+	t := models.Transition{Id: id, From: []string{models.StatusScheduled, models.StatusQueued}, To: models.StatusCancelled}
+	if err := transition(ctx, tx, t); err != nil {
+		return err
+	}
+	if err := deleteOutbox(ctx, tx, id); err != nil {
 		return err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
 	return nil
 }
 
-func (r *Repository) ReadNotification(id string) (*models.Notification, error) {
-	r.data.Mu.Lock()
-	defer r.data.Mu.Unlock()
+// Transition changes the status and records it in the history in one transaction.
+// It returns ErrBadTransition if the notification doesn't match t.
+func (r *Repository) Transition(ctx context.Context, t models.Transition) error {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
 
-	if v, b := r.data.Notifications[id]; !b {
-		return nil, models.ErrNonExistId
-	} else {
-		return v, nil
+	if err := transition(ctx, tx, t); err != nil {
+		return err
 	}
+	if err := tx.Commit(); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	return nil
 }
 
-func (r *Repository) DeleteNotification(id string) error {
-	r.data.Mu.Lock()
-	defer r.data.Mu.Unlock()
+func transition(ctx context.Context, tx *sql.Tx, t models.Transition) error {
+	q := `UPDATE notifications
+	SET status = $1, attempts = attempts + $2, last_error = CASE WHEN $3 <> '' THEN $3 ELSE last_error END, updated_at = NOW()
+	WHERE id = $4 AND status = ANY($5) AND ($6::int = 0 OR version = $6)`
 
-	if _, b := r.data.Notifications[id]; !b {
+	res, err := tx.ExecContext(ctx, q, t.To, t.Attempts, t.Error, t.Id, pq.Array(t.From), t.Version)
+	if err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	if n == 0 {
+		return missingOrBadTransition(ctx, tx, t.Id)
+	}
+
+	return insertHistory(ctx, tx, t.Id, t.To, t.Error)
+}
+
+// missingOrBadTransition tells apart a notification that doesn't exist from one
+// in the wrong state.
+func missingOrBadTransition(ctx context.Context, tx *sql.Tx, id string) error {
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM notifications WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	if !exists {
 		return models.ErrNonExistId
-	} else {
-		delete(r.data.Notifications, id)
-		return nil
 	}
+	return models.ErrBadTransition
+}
+
+// ClaimOutbox leases up to limit undispatched messages due within horizon for lease,
+// messages of a crashed relay become available again once their lease expires.
+func (r *Repository) ClaimOutbox(ctx context.Context, limit int, horizon, lease time.Duration) ([]models.OutboxMessage, error) {
+	q := `WITH pending AS (
+		SELECT id FROM outbox
+		WHERE dispatched_at IS NULL AND available_at <= NOW() AND deliver_at <= NOW() + make_interval(secs => $2)
+		ORDER BY available_at, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE outbox o SET attempts = o.attempts + 1, available_at = NOW() + make_interval(secs => $3)
+	FROM pending WHERE o.id = pending.id
+	RETURNING o.id, o.notification_id, o.exchange, o.routing_key, o.payload, o.deliver_at, o.attempts`
+
+	rows, err := r.db.Master.QueryContext(ctx, q, limit, horizon.Seconds(), lease.Seconds())
+	if err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+	defer rows.Close()
+
+	msgs := make([]models.OutboxMessage, 0)
+	for rows.Next() {
+		var msg models.OutboxMessage
+		if err := rows.Scan(&msg.Id, &msg.NotificationId, &msg.Exchange, &msg.RoutingKey, &msg.Payload, &msg.DeliverAt, &msg.Attempts); err != nil {
+			return nil, errors.Join(models.ErrOnDatabase, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	return msgs, nil
+}
+
+// ReclaimSending reschedules up to limit notifications that have been in sending for
+// longer than stuckFor, their consumer most likely crashed after claiming them and
+// the redelivered message was skipped as a duplicate. The version is bumped like on
+// PATCH, so a message of the old version that is still around can't send it twice.
+// It returns the number of reclaimed notifications.
+func (r *Repository) ReclaimSending(ctx context.Context, stuckFor time.Duration, limit int) (int, error) {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
+
+	q := `WITH stuck AS (
+		SELECT id FROM notifications
+		WHERE status = $1 AND updated_at < NOW() - make_interval(secs => $2)
+		ORDER BY updated_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE notifications n SET status = $4, version = n.version + 1, updated_at = NOW()
+	FROM stuck WHERE n.id = stuck.id
+	RETURNING n.id, n.channel, n.recipient, n.data, n.sending_date, n.status, n.version, n.created_at`
+
+	rows, err := tx.QueryContext(ctx, q, models.StatusSending, stuckFor.Seconds(), limit, models.StatusScheduled)
+	if err != nil {
+		return 0, errors.Join(models.ErrOnDatabase, err)
+	}
+	defer rows.Close()
+
+	notifs := make([]*models.Notification, 0)
+	for rows.Next() {
+		notif := models.NewNotification()
+		if err := rows.Scan(&notif.Id, &notif.Channel, &notif.Recipient, &notif.Data, &notif.SendingDate, &notif.Status, &notif.Version, &notif.CreationDate); err != nil {
+			return 0, errors.Join(models.ErrOnDatabase, err)
+		}
+		notifs = append(notifs, notif)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, errors.Join(models.ErrOnDatabase, err)
+	}
+	rows.Close()
+
+	// the sending date has passed, so the relay publishes them right away
+	for _, notif := range notifs {
+		if err := insertHistory(ctx, tx, notif.Id, models.StatusScheduled, models.ErrStuckSending.Error()); err != nil {
+			return 0, err
+		}
+		if err := insertOutbox(ctx, tx, notif); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Join(models.ErrOnDatabase, err)
+	}
+	return len(notifs), nil
+}
+
+// MarkDispatched marks the message as published and moves its notification
+// from scheduled to queued.
+func (r *Repository) MarkDispatched(ctx context.Context, msg models.OutboxMessage) error {
+	tx, err := r.db.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE outbox SET dispatched_at = NOW() WHERE id = $1`, msg.Id); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+
+	// the consumer may already have picked the message up or the notification may be cancelled
+	q := `UPDATE notifications SET status = $1, updated_at = NOW() WHERE id = $2 AND status = $3`
+	res, err := tx.ExecContext(ctx, q, models.StatusQueued, msg.NotificationId, models.StatusScheduled)
+	if err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	} else if n > 0 {
+		if err := insertHistory(ctx, tx, msg.NotificationId, models.StatusQueued, ""); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	return nil
+}
+
+// RetryOutbox releases the lease on a message that failed to publish, it is
+// claimed again after delay.
+func (r *Repository) RetryOutbox(ctx context.Context, id int64, publishErr error, delay time.Duration) error {
+	q := `UPDATE outbox SET last_error = $1, available_at = NOW() + make_interval(secs => $2) WHERE id = $3 AND dispatched_at IS NULL`
+	if _, err := r.db.ExecContext(ctx, q, publishErr.Error(), delay.Seconds(), id); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	return nil
+}
+
+func insertOutbox(ctx context.Context, tx *sql.Tx, notif *models.Notification) error {
+	marshalled, err := json.Marshal(notif)
+	if err != nil {
+		return err
+	}
+
+	q := `INSERT INTO outbox (notification_id, exchange, routing_key, payload, deliver_at) VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, q, notif.Id, rabbit.Exchange, rabbit.RoutingKey, marshalled, notif.SendingDate); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	return nil
+}
+
+func deleteOutbox(ctx context.Context, tx *sql.Tx, id string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM outbox WHERE notification_id = $1 AND dispatched_at IS NULL`, id); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	return nil
+}
+
+func insertHistory(ctx context.Context, tx *sql.Tx, id, status, errMsg string) error {
+	q := `INSERT INTO notification_status_history (notification_id, status, error) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, q, id, status, errMsg); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	return nil
 }
@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"app/internal/models"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+func newMockRepo(t *testing.T) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return New(&dbpg.DB{Master: db}), mock
+}
+
+// outboxVersion matches an outbox payload carrying the given notification version.
+type outboxVersion int
+
+func (v outboxVersion) Match(arg driver.Value) bool {
+	payload, ok := arg.([]byte)
+	if !ok {
+		return false
+	}
+	var notif models.Notification
+	return json.Unmarshal(payload, &notif) == nil && notif.Version == int(v)
+}
+
+func testNotification() *models.Notification {
+	return &models.Notification{
+		Id:             "new-id",
+		Channel:        models.ChannelEmail,
+		Recipient:      "user@example.com",
+		Data:           "hello",
+		SendingDate:    time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+		Status:         models.StatusScheduled,
+		Version:        1,
+		IdempotencyKey: "order-42",
+		RequestHash:    "hash",
+	}
+}
+
+func TestCreateNotificationReturnsExistingForSameKey(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO notifications .* ON CONFLICT \(idempotency_key\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT id, request_hash FROM notifications WHERE idempotency_key = \$1`).
+		WithArgs("order-42").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "request_hash"}).AddRow("first-id", "hash"))
+	mock.ExpectRollback()
+
+	notif := testNotification()
+	if err := repo.CreateNotification(context.Background(), notif); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if notif.Id != "first-id" {
+		t.Fatalf("expected the id of the first request, got: %s", notif.Id)
+	}
+}
+
+func TestCreateNotificationRejectsReusedKey(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO notifications`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT id, request_hash FROM notifications`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "request_hash"}).AddRow("first-id", "other-hash"))
+	mock.ExpectRollback()
+
+	if err := repo.CreateNotification(context.Background(), testNotification()); !errors.Is(err, models.ErrKeyReused) {
+		t.Fatalf("expected ErrKeyReused, got: %v", err)
+	}
+}
+
+func TestCreateNotificationWritesOutbox(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO notifications`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO notification_status_history`).
+		WithArgs("new-id", models.StatusScheduled, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).
+		WithArgs("new-id", sqlmock.AnyArg(), sqlmock.AnyArg(), outboxVersion(1), testNotification().SendingDate).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := repo.CreateNotification(context.Background(), testNotification()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestUpdateNotificationBumpsVersion(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	sendingDate := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
+	data := "moved"
+
+	columns := []string{"id", "channel", "recipient", "data", "sending_date", "status", "version", "created_at"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE notifications\s+SET .* version = version \+ 1`).
+		WithArgs("id", nil, &data, &sendingDate, models.StatusScheduled, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("id", models.ChannelEmail, "user@example.com", data, sendingDate, models.StatusScheduled, 2, time.Now()))
+	mock.ExpectExec(`INSERT INTO notification_status_history`).WillReturnResult(sqlmock.NewResult(1, 1))
+	// the message of version 1 is replaced, if it's already in the broker the consumer skips it
+	mock.ExpectExec(`DELETE FROM outbox WHERE notification_id = \$1 AND dispatched_at IS NULL`).WithArgs("id").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO outbox`).
+		WithArgs("id", sqlmock.AnyArg(), sqlmock.AnyArg(), outboxVersion(2), sendingDate).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT id, channel, recipient, data, sending_date, status, version, attempts, last_error, created_at, updated_at FROM notifications`).
+		WillReturnRows(sqlmock.NewRows(append(columns[:7:7], "attempts", "last_error", "created_at", "updated_at")).
+			AddRow("id", models.ChannelEmail, "user@example.com", data, sendingDate, models.StatusScheduled, 2, 0, "", time.Now(), time.Now()))
+	mock.ExpectQuery(`SELECT status, error, changed_at FROM notification_status_history`).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "error", "changed_at"}))
+
+	notif, err := repo.UpdateNotification(context.Background(), "id", models.NotificationPatch{Data: &data, SendingDate: &sendingDate})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if notif.Version != 2 || notif.Data != data {
+		t.Fatalf("expected version 2 with the new data, got: %+v", notif)
+	}
+}
+
+func TestUpdateNotificationAfterSendingStarted(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	data := "too late"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE notifications`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT EXISTS`).WithArgs("id").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	if _, err := repo.UpdateNotification(context.Background(), "id", models.NotificationPatch{Data: &data}); !errors.Is(err, models.ErrBadTransition) {
+		t.Fatalf("expected ErrBadTransition, got: %v", err)
+	}
+}
+
+func TestTransitionChecksVersion(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE notifications\s+SET status = \$1,.* WHERE id = \$4 AND status = ANY\(\$5\) AND \(\$6::int = 0 OR version = \$6\)`).
+		WithArgs(models.StatusSending, 0, "", "id", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	tr := models.Transition{Id: "id", From: []string{models.StatusQueued}, To: models.StatusSending, Version: 1}
+	if err := repo.Transition(context.Background(), tr); !errors.Is(err, models.ErrBadTransition) {
+		t.Fatalf("expected ErrBadTransition for a stale version, got: %v", err)
+	}
+}
+
+func TestReclaimSending(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	sendingDate := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	columns := []string{"id", "channel", "recipient", "data", "sending_date", "status", "version", "created_at"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`WITH stuck AS \(.*FOR UPDATE SKIP LOCKED.*\)\s+UPDATE notifications n SET status = \$4, version = n.version \+ 1`).
+		WithArgs(models.StatusSending, float64(600), 10, models.StatusScheduled).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("a", models.ChannelEmail, "a@example.com", "a", sendingDate, models.StatusScheduled, 2, time.Now()).
+			AddRow("b", models.ChannelSms, "+79991234567", "b", sendingDate, models.StatusScheduled, 5, time.Now()))
+	for _, row := range []struct {
+		id      string
+		version int
+	}{{"a", 2}, {"b", 5}} {
+		mock.ExpectExec(`INSERT INTO notification_status_history`).
+			WithArgs(row.id, models.StatusScheduled, models.ErrStuckSending.Error()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`INSERT INTO outbox`).
+			WithArgs(row.id, sqlmock.AnyArg(), sqlmock.AnyArg(), outboxVersion(row.version), sendingDate).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	n, err := repo.ReclaimSending(context.Background(), 10*time.Minute, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 reclaimed notifications, got: %d", n)
+	}
+}
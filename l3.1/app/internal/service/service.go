@@ -2,6 +2,11 @@ package service
 
 import (
 	"app/internal/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -11,12 +16,18 @@ import (
 
 var (
 	emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
+	// a numeric chat id, negative for groups, or a public channel username
+	telegramRegex = regexp.MustCompile(`^(-?[0-9]{1,20}|@[a-zA-Z][a-zA-Z0-9_]{4,31})$`)
+	// E.164
+	phoneRegex = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
 )
 
 type RepositoryInterface interface {
-	CreateNotification(*models.Notification) error
-	ReadNotification(string) (*models.Notification, error)
-	DeleteNotification(string) error
+	CreateNotification(context.Context, *models.Notification) error
+	ReadNotification(context.Context, string) (*models.Notification, error)
+	UpdateNotification(context.Context, string, models.NotificationPatch) (*models.Notification, error)
+	CancelNotification(context.Context, string) error
+	Transition(context.Context, models.Transition) error
 }
 
 type Service struct {
@@ -27,28 +38,139 @@ func New(repo RepositoryInterface) *Service {
 	return &Service{repo}
 }
 
-func (s Service) CreateNotification(notif *models.Notification) (string, error) { // not finished
-	notif.Email = strings.TrimSpace(notif.Email)
-	if err := validEmail(notif.Email); err != nil {
+func (s Service) CreateNotification(ctx context.Context, notif *models.Notification) (string, error) {
+	if notif.Channel == "" {
+		notif.Channel = models.ChannelEmail
+	}
+	if notif.Channel == models.ChannelEmail && notif.Recipient == "" {
+		notif.Recipient = notif.Email
+	}
+	notif.Recipient = strings.TrimSpace(notif.Recipient)
+	if err := validRecipient(notif.Channel, notif.Recipient); err != nil {
 		return "", err
 	}
 
 	notif.CreationDate = time.Now()
 	notif.Id = uuid.NewString()
+	notif.Status = models.StatusScheduled
+	notif.Version = 1
+	notif.RequestHash = requestHash(notif)
 
-	if err := s.repo.CreateNotification(notif); err != nil {
+	if err := s.repo.CreateNotification(ctx, notif); err != nil {
 		return "", err
 	} else {
 		return notif.Id, nil
 	}
 }
 
-func (s Service) ReadNotification(id string) (*models.Notification, error) { // not finished
-	return s.repo.ReadNotification(id)
+func (s Service) ReadNotification(ctx context.Context, id string) (*models.Notification, error) {
+	if uuid.Validate(id) != nil {
+		return nil, models.ErrNonExistId
+	}
+	notif, err := s.repo.ReadNotification(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return withEmail(notif), nil
+}
+
+// UpdateNotification reschedules or edits a notification that hasn't started sending yet.
+func (s Service) UpdateNotification(ctx context.Context, id string, patch models.NotificationPatch) (*models.Notification, error) {
+	if uuid.Validate(id) != nil {
+		return nil, models.ErrNonExistId
+	}
+	if patch.Recipient == nil {
+		patch.Recipient = patch.Email
+	}
+	if patch.Recipient == nil && patch.Data == nil && patch.SendingDate == nil {
+		return nil, models.ErrEmptyPatch
+	}
+	if patch.Recipient != nil {
+		// the channel can't be changed, so it's safe to validate against the current one
+		current, err := s.repo.ReadNotification(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		recipient := strings.TrimSpace(*patch.Recipient)
+		if err := validRecipient(current.Channel, recipient); err != nil {
+			return nil, err
+		}
+		patch.Recipient = &recipient
+	}
+
+	notif, err := s.repo.UpdateNotification(ctx, id, patch)
+	if err != nil {
+		return nil, err
+	}
+	return withEmail(notif), nil
 }
 
-func (s Service) DeleteNotification(id string) error { // not finished
-	return s.repo.DeleteNotification(id)
+// CancelNotification cancels a notification that hasn't started sending yet.
+func (s Service) CancelNotification(ctx context.Context, id string) error {
+	if uuid.Validate(id) != nil {
+		return models.ErrNonExistId
+	}
+	return s.repo.CancelNotification(ctx, id)
+}
+
+// StartSending claims a due notification for sending, it fails with ErrBadTransition
+// if the notification was cancelled, rescheduled to another version or is already
+// being sent. A notification left in sending by a crashed consumer is re-queued by
+// the relay with a new version.
+func (s Service) StartSending(ctx context.Context, id string, version int) error {
+	return s.repo.Transition(ctx, models.Transition{
+		Id:      id,
+		From:    []string{models.StatusScheduled, models.StatusQueued},
+		To:      models.StatusSending,
+		Version: version,
+	})
+}
+
+// FinishSending records the result of sending, sendErr == nil means sent.
+func (s Service) FinishSending(ctx context.Context, id string, attempts int, sendErr error) error {
+	t := models.Transition{Id: id, From: []string{models.StatusSending}, To: models.StatusSent, Attempts: attempts}
+	if sendErr != nil {
+		t.To, t.Error = models.StatusFailed, sendErr.Error()
+	}
+	return s.repo.Transition(ctx, t)
+}
+
+// requestHash identifies the request body an idempotency key was first used with.
+func requestHash(notif *models.Notification) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", notif.Channel, notif.Recipient, notif.Data, notif.SendingDate.UTC().Format(time.RFC3339Nano))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// withEmail fills the email field of email notifications for older clients.
+func withEmail(notif *models.Notification) *models.Notification {
+	if notif.Channel == models.ChannelEmail {
+		notif.Email = notif.Recipient
+	}
+	return notif
+}
+
+func validRecipient(channel, recipient string) error {
+	switch channel {
+	case models.ChannelEmail:
+		return validEmail(recipient)
+	case models.ChannelTelegram:
+		if !telegramRegex.MatchString(recipient) {
+			return fmt.Errorf("%w: expected a chat id or @channel", models.ErrBadRecipient)
+		}
+	case models.ChannelWebhook:
+		u, err := url.Parse(recipient)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: expected an http(s) url", models.ErrBadRecipient)
+		}
+	case models.ChannelSms:
+		if !phoneRegex.MatchString(recipient) {
+			return fmt.Errorf("%w: expected a phone number in E.164 format", models.ErrBadRecipient)
+		}
+	default:
+		return fmt.Errorf("%w: %q", models.ErrBadChannel, channel)
+	}
+	return nil
 }
 
 func validEmail(email string) error {
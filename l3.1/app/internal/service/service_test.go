@@ -0,0 +1,140 @@
+package service
+
+import (
+	"app/internal/models"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeRepo records what the service passes down and serves a single notification.
+type fakeRepo struct {
+	RepositoryInterface
+
+	created []*models.Notification
+	current *models.Notification
+	patch   *models.NotificationPatch
+}
+
+func (r *fakeRepo) CreateNotification(_ context.Context, notif *models.Notification) error {
+	r.created = append(r.created, notif)
+	return nil
+}
+
+func (r *fakeRepo) ReadNotification(_ context.Context, id string) (*models.Notification, error) {
+	if r.current == nil || r.current.Id != id {
+		return nil, models.ErrNonExistId
+	}
+	notif := *r.current
+	return &notif, nil
+}
+
+func (r *fakeRepo) UpdateNotification(_ context.Context, id string, patch models.NotificationPatch) (*models.Notification, error) {
+	r.patch = &patch
+	return r.ReadNotification(context.Background(), id)
+}
+
+func newNotification(data string) *models.Notification {
+	return &models.Notification{
+		Email:          "user@example.com",
+		Data:           data,
+		SendingDate:    time.Date(2026, 1, 1, 12, 0, 0, 0, time.FixedZone("MSK", 3*60*60)),
+		IdempotencyKey: "order-42",
+	}
+}
+
+func TestCreateNotificationRequestHash(t *testing.T) {
+	t.Parallel()
+	repo := &fakeRepo{}
+	s := New(repo)
+
+	for _, notif := range []*models.Notification{newNotification("hello"), newNotification("hello"), newNotification("bye")} {
+		if _, err := s.CreateNotification(context.Background(), notif); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	first, retry, other := repo.created[0], repo.created[1], repo.created[2]
+	if first.Channel != models.ChannelEmail || first.Recipient != "user@example.com" {
+		t.Fatalf("expected an email notification to the email field, got: %s %s", first.Channel, first.Recipient)
+	}
+	// the repository returns the first notification for a retry with the same hash
+	if first.RequestHash != retry.RequestHash {
+		t.Fatal("expected a retried request to have the same hash")
+	}
+	if first.RequestHash == other.RequestHash {
+		t.Fatal("expected a different body to have a different hash")
+	}
+	// the same instant in another zone is the same request
+	utc := newNotification("hello")
+	utc.SendingDate = first.SendingDate.UTC()
+	if _, err := s.CreateNotification(context.Background(), utc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if utc.RequestHash != first.RequestHash {
+		t.Fatal("expected the hash to ignore the time zone")
+	}
+}
+
+func TestCreateNotificationValidatesRecipient(t *testing.T) {
+	t.Parallel()
+	s := New(&fakeRepo{})
+
+	notif := newNotification("hello")
+	notif.Channel, notif.Recipient = models.ChannelTelegram, "not a chat"
+	if _, err := s.CreateNotification(context.Background(), notif); !errors.Is(err, models.ErrBadRecipient) {
+		t.Fatalf("expected ErrBadRecipient, got: %v", err)
+	}
+
+	notif.Channel = "pigeon"
+	if _, err := s.CreateNotification(context.Background(), notif); !errors.Is(err, models.ErrBadChannel) {
+		t.Fatalf("expected ErrBadChannel, got: %v", err)
+	}
+}
+
+func TestUpdateNotification(t *testing.T) {
+	t.Parallel()
+	id := uuid.NewString()
+	current := &models.Notification{Id: id, Channel: models.ChannelTelegram, Recipient: "@news_channel", Version: 1}
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name      string
+		id        string
+		patch     models.NotificationPatch
+		err       error
+		recipient *string
+	}{
+		{"empty patch", id, models.NotificationPatch{}, models.ErrEmptyPatch, nil},
+		{"bad id", "42", models.NotificationPatch{Data: str("x")}, models.ErrNonExistId, nil},
+		{"data only", id, models.NotificationPatch{Data: str("x")}, nil, nil},
+		{"recipient of the current channel", id, models.NotificationPatch{Recipient: str(" -100123 ")}, nil, str("-100123")},
+		// the channel stays telegram, so an email isn't a valid recipient
+		{"recipient of another channel", id, models.NotificationPatch{Email: str("user@example.com")}, models.ErrBadRecipient, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeRepo{current: current}
+			_, err := New(repo).UpdateNotification(context.Background(), tt.id, tt.patch)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got: %v", tt.err, err)
+				}
+				if repo.patch != nil {
+					t.Fatal("expected a rejected patch not to reach the repository")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.recipient != nil && (repo.patch.Recipient == nil || *repo.patch.Recipient != *tt.recipient) {
+				t.Fatalf("expected recipient %q, got: %v", *tt.recipient, repo.patch.Recipient)
+			}
+		})
+	}
+}
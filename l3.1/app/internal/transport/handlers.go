@@ -5,19 +5,27 @@ import (
 	"app/pkg/logger"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"net/smtp"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
 	"github.com/wb-go/wbf/ginext"
 	"github.com/wb-go/wbf/retry"
 )
 
+var stateRetryStrategy = retry.Strategy{Attempts: 3, Delay: 500 * time.Millisecond, Backoff: 2}
+
+// requeueDelay keeps a message that failed on the database from spinning between
+// the broker and the consumer.
+const requeueDelay = time.Second
+
 type handlers struct {
-	ctx               context.Context
-	service           ServiceInterface
-	emailSenderConfig EmailSenderConfig
+	ctx      context.Context
+	service  ServiceInterface
+	channels map[string]ChannelInterface
 }
 
 func (h *handlers) middleware(c *ginext.Context) {
@@ -31,18 +39,15 @@ func (h *handlers) middleware(c *ginext.Context) {
 }
 
 func (h *handlers) createNotification(c *ginext.Context) {
-	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
-
 	notif := models.NewNotification()
 	if err := c.ShouldBindJSON(notif); err != nil {
 		c.JSON(http.StatusBadRequest, ginext.H{"error": "bad request"})
 		return
 	}
+	notif.IdempotencyKey = c.GetHeader(idempotencyHeader)
 
-	if id, err := h.service.CreateNotification(notif); err != nil {
-		lg.Error().Err(err).Send()
-
-		c.JSON(http.StatusInternalServerError, ginext.H{"error": "server error"})
+	if id, err := h.service.CreateNotification(c.Request.Context(), notif); err != nil {
+		h.writeError(c, err)
 	} else {
 		c.JSON(http.StatusOK, ginext.H{"id": id})
 	}
@@ -51,8 +56,24 @@ func (h *handlers) createNotification(c *ginext.Context) {
 func (h *handlers) readNotification(c *ginext.Context) {
 	id := c.Param(idParam)
 
-	if notif, err := h.service.ReadNotification(id); err != nil {
-		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+	if notif, err := h.service.ReadNotification(c.Request.Context(), id); err != nil {
+		h.writeError(c, err)
+	} else {
+		c.JSON(http.StatusOK, notif)
+	}
+}
+
+func (h *handlers) updateNotification(c *ginext.Context) {
+	id := c.Param(idParam)
+
+	var patch models.NotificationPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "bad request"})
+		return
+	}
+
+	if notif, err := h.service.UpdateNotification(c.Request.Context(), id, patch); err != nil {
+		h.writeError(c, err)
 	} else {
 		c.JSON(http.StatusOK, notif)
 	}
@@ -61,43 +82,85 @@ func (h *handlers) readNotification(c *ginext.Context) {
 func (h *handlers) deleteNotification(c *ginext.Context) {
 	id := c.Param(idParam)
 
-	if err := h.service.DeleteNotification(id); err != nil {
-		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+	if err := h.service.CancelNotification(c.Request.Context(), id); err != nil {
+		h.writeError(c, err)
 	} else {
-		c.JSON(http.StatusOK, ginext.H{"message": "notification deleted"})
+		c.JSON(http.StatusOK, ginext.H{"message": "notification cancelled"})
 	}
 }
 
-func (h *handlers) consume(ch chan []byte) {
-	lg := logger.LoggerFromCtx(h.ctx).Lg
-	for d := range ch {
-		var notif models.Notification
-		if err := json.Unmarshal(d, &notif); err != nil {
-			lg.Error().Err(err).Msg("while processing on rabbit consumer")
-			continue
-		}
+func (h *handlers) writeError(c *ginext.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrNonExistId):
+		c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
+	case errors.Is(err, models.ErrBadTransition):
+		c.JSON(http.StatusConflict, ginext.H{"error": err.Error()})
+	case errors.Is(err, models.ErrBadEmail), errors.Is(err, models.ErrBadRecipient), errors.Is(err, models.ErrBadChannel), errors.Is(err, models.ErrEmptyPatch):
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+	case errors.Is(err, models.ErrKeyReused):
+		c.JSON(http.StatusUnprocessableEntity, ginext.H{"error": err.Error()})
+	default:
+		logger.LoggerFromCtx(c.Request.Context()).Lg.Error().Err(err).Send()
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": "server error"})
+	}
+}
 
-		if _, err := h.service.ReadNotification(notif.Id); err != nil {
-			continue
-		}
+func (h *handlers) consume(deliveries <-chan amqp091.Delivery) {
+	for d := range deliveries {
+		h.handleDelivery(d)
+	}
+}
 
-		if err := retry.Do(func() error { return h.sendEmail(notif.Email, d) }, retry.Strategy{Attempts: 10, Delay: 3, Backoff: 3}); err != nil {
-			lg.Error().Err(err).Msg("while sending an email")
-		}
+// handleDelivery acks a message only after the notification's final status is saved,
+// so a crash before that gets the message redelivered.
+func (h *handlers) handleDelivery(d amqp091.Delivery) {
+	lg := logger.LoggerFromCtx(h.ctx).Lg
+
+	var notif models.Notification
+	if err := json.Unmarshal(d.Body, &notif); err != nil {
+		lg.Error().Err(err).Msg("dropping malformed message")
+		d.Nack(false, false)
+		return
+	}
+	// messages published before channels were added
+	if notif.Channel == "" {
+		notif.Channel, notif.Recipient = models.ChannelEmail, notif.Email
+	}
 
-		err := h.service.DeleteNotification(notif.Id)
-		if err != nil {
-			lg.Error().Err(err).Msg("while deleting notification after sending email")
+	// cancelled, rescheduled and already sent notifications are acked and skipped,
+	// which dedups redelivered messages by id and version. So is a notification left
+	// in sending by a crashed consumer, the relay reclaims it with a new version.
+	if err := h.service.StartSending(h.ctx, notif.Id, notif.Version); err != nil {
+		if errors.Is(err, models.ErrBadTransition) || errors.Is(err, models.ErrNonExistId) {
+			lg.Info().Str("id", notif.Id).Int("version", notif.Version).Msg("skipping stale or duplicate message")
+			d.Ack(false)
+			return
 		}
+		lg.Error().Err(err).Str("id", notif.Id).Msg("while claiming notification")
+		h.requeue(d)
+		return
 	}
-}
 
-func (h *handlers) sendEmail(to string, bd []byte) error {
-	subject := "Notification"
-	body := string(bd)
-	message := []byte(subject + "\n" + body)
+	attempts, sendErr := 0, fmt.Errorf("%w: %q", models.ErrBadChannel, notif.Channel)
+	if ch, ok := h.channels[notif.Channel]; ok {
+		attempts, sendErr = ch.Send(h.ctx, notif)
+	}
+	if sendErr != nil {
+		lg.Error().Err(sendErr).Str("id", notif.Id).Str("channel", notif.Channel).Msg("while sending notification")
+	}
 
-	// auth := smtp.PlainAuth("", "", "", h.emailSenderConfig.Host)
+	err := retry.Do(func() error {
+		return h.service.FinishSending(h.ctx, notif.Id, attempts, sendErr)
+	}, stateRetryStrategy)
+	if err != nil {
+		lg.Error().Err(err).Str("id", notif.Id).Msg("while saving notification status after sending")
+		h.requeue(d)
+		return
+	}
+	d.Ack(false)
+}
 
-	return smtp.SendMail(fmt.Sprintf("%s:%s", h.emailSenderConfig.Host, h.emailSenderConfig.Port), nil, h.emailSenderConfig.From, []string{to}, message)
+func (h *handlers) requeue(d amqp091.Delivery) {
+	time.Sleep(requeueDelay)
+	d.Nack(false, true)
 }
@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// fakeService keeps one notification's status and version and applies the same
+// transitions the repository does.
+type fakeService struct {
+	ServiceInterface
+
+	mu      sync.Mutex
+	status  string
+	version int
+	dbDown  bool
+}
+
+func (s *fakeService) StartSending(_ context.Context, id string, version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dbDown {
+		return models.ErrOnDatabase
+	}
+	if version != s.version || (s.status != models.StatusScheduled && s.status != models.StatusQueued) {
+		return models.ErrBadTransition
+	}
+	s.status = models.StatusSending
+	return nil
+}
+
+func (s *fakeService) FinishSending(_ context.Context, id string, attempts int, sendErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status != models.StatusSending {
+		return models.ErrBadTransition
+	}
+	s.status = models.StatusSent
+	if sendErr != nil {
+		s.status = models.StatusFailed
+	}
+	return nil
+}
+
+type fakeChannel struct {
+	sent []string
+}
+
+func (c *fakeChannel) Send(_ context.Context, notif models.Notification) (int, error) {
+	c.sent = append(c.sent, notif.Data)
+	return 1, nil
+}
+
+// fakeAcknowledger records how a delivery was settled.
+type fakeAcknowledger struct {
+	settled []string
+}
+
+func (a *fakeAcknowledger) Ack(uint64, bool) error {
+	a.settled = append(a.settled, "ack")
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	if requeue {
+		a.settled = append(a.settled, "requeue")
+	} else {
+		a.settled = append(a.settled, "drop")
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(_ uint64, requeue bool) error {
+	return a.Nack(0, false, requeue)
+}
+
+func newTestHandlers(svc *fakeService, ch *fakeChannel) *handlers {
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, logger.New())
+	return &handlers{ctx: ctx, service: svc, channels: map[string]ChannelInterface{models.ChannelEmail: ch}}
+}
+
+func delivery(t *testing.T, ack amqp091.Acknowledger, data string, version int) amqp091.Delivery {
+	t.Helper()
+	body, err := json.Marshal(models.Notification{Id: "id", Channel: models.ChannelEmail, Recipient: "user@example.com", Data: data, Version: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp091.Delivery{Acknowledger: ack, Body: body}
+}
+
+func TestHandleDeliverySkipsStaleVersions(t *testing.T) {
+	t.Parallel()
+	// the notification was patched to version 2 after version 1 was published
+	svc := &fakeService{status: models.StatusQueued, version: 2}
+	ch := &fakeChannel{}
+	h := newTestHandlers(svc, ch)
+	ack := &fakeAcknowledger{}
+
+	h.handleDelivery(delivery(t, ack, "old", 1))
+	h.handleDelivery(delivery(t, ack, "new", 2))
+	// a redelivery of a sent message
+	h.handleDelivery(delivery(t, ack, "new", 2))
+
+	if !slices.Equal(ch.sent, []string{"new"}) {
+		t.Fatalf("expected only the current version to be sent once, got: %v", ch.sent)
+	}
+	if !slices.Equal(ack.settled, []string{"ack", "ack", "ack"}) {
+		t.Fatalf("expected every message to be acked, got: %v", ack.settled)
+	}
+	if svc.status != models.StatusSent {
+		t.Fatalf("expected the notification to be sent, got: %s", svc.status)
+	}
+}
+
+func TestHandleDeliveryAcksRedeliveryWhileSending(t *testing.T) {
+	t.Parallel()
+	// the consumer crashed after claiming, the relay reclaims the notification later
+	svc := &fakeService{status: models.StatusSending, version: 1}
+	ch := &fakeChannel{}
+	ack := &fakeAcknowledger{}
+
+	newTestHandlers(svc, ch).handleDelivery(delivery(t, ack, "hello", 1))
+
+	if len(ch.sent) != 0 || !slices.Equal(ack.settled, []string{"ack"}) {
+		t.Fatalf("expected the duplicate to be acked without sending, got sent %v and %v", ch.sent, ack.settled)
+	}
+}
+
+func TestHandleDeliveryRequeuesOnDatabaseError(t *testing.T) {
+	t.Parallel()
+	svc := &fakeService{status: models.StatusQueued, version: 1, dbDown: true}
+	ch := &fakeChannel{}
+	ack := &fakeAcknowledger{}
+
+	newTestHandlers(svc, ch).handleDelivery(delivery(t, ack, "hello", 1))
+
+	if len(ch.sent) != 0 || !slices.Equal(ack.settled, []string{"requeue"}) {
+		t.Fatalf("expected the message to be requeued without sending, got sent %v and %v", ch.sent, ack.settled)
+	}
+}
+
+func TestHandleDeliveryDropsMalformedMessages(t *testing.T) {
+	t.Parallel()
+	ack := &fakeAcknowledger{}
+
+	newTestHandlers(&fakeService{}, &fakeChannel{}).handleDelivery(amqp091.Delivery{Acknowledger: ack, Body: []byte("{")})
+
+	if !slices.Equal(ack.settled, []string{"drop"}) {
+		t.Fatalf("expected the message to be dropped, got: %v", ack.settled)
+	}
+}
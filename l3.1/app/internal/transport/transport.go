@@ -2,32 +2,41 @@ package transport
 
 import (
 	"app/internal/models"
+	"app/pkg/data/rabbit"
 	"app/pkg/logger"
 	"context"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/rabbitmq/amqp091-go"
 	"github.com/wb-go/wbf/ginext"
 	"github.com/wb-go/wbf/rabbitmq"
 )
 
 const (
-	idParam = "id"
+	idParam           = "id"
+	idempotencyHeader = "Idempotency-Key"
 )
 
 type ServiceInterface interface {
-	CreateNotification(*models.Notification) (string, error)
-	ReadNotification(string) (*models.Notification, error)
-	DeleteNotification(string) error
+	CreateNotification(context.Context, *models.Notification) (string, error)
+	ReadNotification(context.Context, string) (*models.Notification, error)
+	UpdateNotification(context.Context, string, models.NotificationPatch) (*models.Notification, error)
+	CancelNotification(context.Context, string) error
+	StartSending(context.Context, string, int) error
+	FinishSending(context.Context, string, int, error) error
 }
 
-type EmailSenderConfig struct {
-	Host     string `env:"EMAIL_SENDER_HOST" env-default:"localhost"`
-	Port     string `env:"EMAIL_SENDER_PORT" env-default:"1025"`
-	Username string `env:"EMAIL_SENDER_USERNAME" env-default:"guest@guest.com"`
-	Password string `env:"EMAIL_SENDER_PASSWORD" env-default:"guest"`
-	From     string `env:"EMAIL_SENDER_FROM" env-default:"notification@service.com"`
+// ConsumerInterface delivers messages that have to be acked manually.
+type ConsumerInterface interface {
+	Consume(ctx context.Context, queue string) (<-chan amqp091.Delivery, error)
+}
+
+// ChannelInterface delivers a notification to its recipient, retrying by itself.
+// It returns the number of attempts made.
+type ChannelInterface interface {
+	Send(ctx context.Context, notif models.Notification) (int, error)
 }
 
 type ServerConfig struct {
@@ -78,26 +87,23 @@ func (s *Server) Stop() {
 	lg.Info().Msg("all services stopped gracefully")
 }
 
-func New(service ServiceInterface, rabbitConn *rabbitmq.Connection, rabbitCh *rabbitmq.Channel, serverCfg *ServerConfig, emailSenderCfg *EmailSenderConfig, ctx context.Context) *Server {
-	hers := &handlers{ctx, service, *emailSenderCfg}
-
-	cons := rabbitmq.NewConsumer(rabbitCh, &rabbitmq.ConsumerConfig{Queue: "main_queue", AutoAck: true, Exclusive: false, NoLocal: false, NoWait: false, Args: nil})
+func New(service ServiceInterface, cons ConsumerInterface, channels map[string]ChannelInterface, rabbitConn *rabbitmq.Connection, serverCfg *ServerConfig, ctx context.Context) *Server {
+	hers := &handlers{ctx, service, channels}
 
 	mux := ginext.New(serverCfg.ReleaseMode)
 
 	mux.Use(hers.middleware)
 	mux.POST("/notify", hers.createNotification)
 	mux.GET(fmt.Sprintf("/notify/:%s", idParam), hers.readNotification)
+	mux.PATCH(fmt.Sprintf("/notify/:%s", idParam), hers.updateNotification)
 	mux.DELETE(fmt.Sprintf("/notify/:%s", idParam), hers.deleteNotification)
 
-	consumeCh := make(chan []byte)
-	go func() {
-		if err := cons.Consume(consumeCh); err != nil {
-			panic(err)
-		}
-	}()
+	deliveries, err := cons.Consume(ctx, rabbit.Queue)
+	if err != nil {
+		panic(err)
+	}
 
-	go hers.consume(consumeCh)
+	go hers.consume(deliveries)
 
 	return &Server{&http.Server{Addr: fmt.Sprintf("%s:%s", serverCfg.Host, serverCfg.Port), Handler: mux}, context.WithValue(ctx, "rabbitConn", rabbitConn)}
 }
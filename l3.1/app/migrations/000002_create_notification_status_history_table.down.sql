@@ -0,0 +1 @@
+DROP TABLE IF EXISTS notification_status_history;
\ No newline at end of file
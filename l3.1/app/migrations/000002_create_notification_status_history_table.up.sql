@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS notification_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    notification_id UUID NOT NULL REFERENCES notifications (id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS notification_status_history_notification_id_idx ON notification_status_history (notification_id, id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS notifications_idempotency_key_idx;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS request_hash;
+ALTER TABLE notifications DROP COLUMN IF EXISTS idempotency_key;
+ALTER TABLE notifications DROP COLUMN IF EXISTS version;
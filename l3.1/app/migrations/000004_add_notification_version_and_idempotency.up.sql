@@ -0,0 +1,5 @@
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS idempotency_key TEXT;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS request_hash TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS notifications_idempotency_key_idx ON notifications (idempotency_key) WHERE idempotency_key IS NOT NULL;
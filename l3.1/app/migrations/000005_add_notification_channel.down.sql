@@ -0,0 +1,2 @@
+ALTER TABLE notifications DROP COLUMN IF EXISTS channel;
+ALTER TABLE notifications RENAME COLUMN recipient TO email;
@@ -0,0 +1,2 @@
+ALTER TABLE notifications RENAME COLUMN email TO recipient;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'email';
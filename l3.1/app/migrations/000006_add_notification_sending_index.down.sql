@@ -0,0 +1 @@
+DROP INDEX IF EXISTS notifications_sending_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS notifications_sending_idx ON notifications (updated_at) WHERE status = 'sending';
@@ -0,0 +1,85 @@
+package channel
+
+import (
+	"app/internal/models"
+	"context"
+	"errors"
+	"time"
+)
+
+type RetryConfig struct {
+	Attempts int `env:"CHANNEL_RETRY_ATTEMPTS" env-default:"5"`
+	Delay    int `env:"CHANNEL_RETRY_DELAY" env-default:"1"`
+	MaxDelay int `env:"CHANNEL_RETRY_MAX_DELAY" env-default:"30"`
+}
+
+// Valid checks that at least one attempt is made and delays are positive.
+func (cfg RetryConfig) Valid() error {
+	if cfg.Attempts <= 0 || cfg.Delay <= 0 || cfg.MaxDelay < cfg.Delay {
+		return models.ErrBadChannelCfg
+	}
+	return nil
+}
+
+// permanentError is a failure retrying won't fix, like a 4xx response.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+func permanent(err error) error {
+	return permanentError{err}
+}
+
+// retryAfterError asks to wait at least wait before the next attempt.
+type retryAfterError struct {
+	error
+	wait time.Duration
+}
+
+func (e retryAfterError) Unwrap() error {
+	return e.error
+}
+
+// retrier retries a send with exponential backoff until it succeeds, fails
+// permanently or runs out of attempts.
+type retrier struct {
+	attempts int
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+func newRetrier(cfg RetryConfig) retrier {
+	return retrier{cfg.Attempts, time.Duration(cfg.Delay) * time.Second, time.Duration(cfg.MaxDelay) * time.Second}
+}
+
+// do returns the number of attempts made and the last error.
+func (r retrier) do(ctx context.Context, send func(context.Context) error) (int, error) {
+	delay := r.delay
+	for attempt := 1; ; attempt++ {
+		err := send(ctx)
+		if err == nil {
+			return attempt, nil
+		}
+
+		var perm permanentError
+		if errors.As(err, &perm) || attempt >= r.attempts {
+			return attempt, err
+		}
+
+		wait := delay
+		var after retryAfterError
+		if errors.As(err, &after) {
+			wait = min(max(wait, after.wait), r.maxDelay)
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return attempt, errors.Join(err, ctx.Err())
+		}
+		delay = min(delay*2, r.maxDelay)
+	}
+}
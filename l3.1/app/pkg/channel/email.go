@@ -0,0 +1,142 @@
+package channel
+
+import (
+	"app/internal/models"
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+//go:embed templates
+var templatesFS embed.FS
+
+type EmailConfig struct {
+	Host     string `env:"EMAIL_SENDER_HOST" env-default:"localhost"`
+	Port     string `env:"EMAIL_SENDER_PORT" env-default:"1025"`
+	Username string `env:"EMAIL_SENDER_USERNAME" env-default:"guest@guest.com"`
+	Password string `env:"EMAIL_SENDER_PASSWORD" env-default:"guest"`
+	From     string `env:"EMAIL_SENDER_FROM" env-default:"notification@service.com"`
+}
+
+// Email sends notifications over SMTP as multipart/alternative text and html
+// rendered from the embedded templates.
+type Email struct {
+	cfg     EmailConfig
+	retry   retrier
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+func NewEmail(cfg EmailConfig, retryCfg RetryConfig) *Email {
+	return &Email{
+		cfg:     cfg,
+		retry:   newRetrier(retryCfg),
+		subject: template.Must(template.ParseFS(templatesFS, "templates/subject.txt")),
+		text:    template.Must(template.ParseFS(templatesFS, "templates/body.txt")),
+		html:    htmltemplate.Must(htmltemplate.ParseFS(templatesFS, "templates/body.html")),
+	}
+}
+
+// Send delivers notif to the recipient address, 5xx SMTP replies aren't retried.
+func (e *Email) Send(ctx context.Context, notif models.Notification) (int, error) {
+	msg, err := e.message(notif)
+	if err != nil {
+		return 0, err
+	}
+
+	addr := fmt.Sprintf("%s:%s", e.cfg.Host, e.cfg.Port)
+	return e.retry.do(ctx, func(ctx context.Context) error {
+		err := smtp.SendMail(addr, nil, e.cfg.From, []string{notif.Recipient}, msg)
+		var smtpErr *textproto.Error
+		if errors.As(err, &smtpErr) && smtpErr.Code >= 500 {
+			return permanent(err)
+		}
+		return err
+	})
+}
+
+func (e *Email) message(notif models.Notification) ([]byte, error) {
+	from, err := mail.ParseAddress(e.cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", models.ErrBadChannelCfg, err)
+	}
+	to, err := mail.ParseAddress(notif.Recipient)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", models.ErrBadRecipient, err)
+	}
+
+	var subject, text, html strings.Builder
+	if err := e.subject.Execute(&subject, notif); err != nil {
+		return nil, err
+	}
+	if err := e.text.Execute(&text, notif); err != nil {
+		return nil, err
+	}
+	if err := e.html.Execute(&html, notif); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	alt := multipart.NewWriter(&buf)
+
+	writeHeader(&buf, "From", from.String())
+	writeHeader(&buf, "To", to.String())
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", fmt.Sprintf("<%s@%s>", uuid.NewString(), domainOf(from.Address)))
+	writeHeader(&buf, "MIME-Version", "1.0")
+	writeHeader(&buf, "Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", alt.Boundary()))
+	buf.WriteString("\r\n")
+
+	if err := writeQuotedPrintable(alt, "text/plain; charset=UTF-8", text.String()); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(alt, "text/html; charset=UTF-8", html.String()); err != nil {
+		return nil, err
+	}
+	if err := alt.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key + ": " + value + "\r\n")
+}
+
+func domainOf(address string) string {
+	if _, domain, ok := strings.Cut(address, "@"); ok && domain != "" {
+		return domain
+	}
+	return "localhost"
+}
+
+func writeQuotedPrintable(mw *multipart.Writer, contentType, body string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
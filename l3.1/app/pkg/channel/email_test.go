@@ -0,0 +1,67 @@
+package channel
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestEmailMessage(t *testing.T) {
+	t.Parallel()
+	e := NewEmail(EmailConfig{From: "notification@service.com"}, RetryConfig{Attempts: 1, Delay: 1, MaxDelay: 1})
+	notif := testNotification("user@example.com")
+
+	raw, err := e.message(notif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Notification for 04.03.2026 15:00" {
+		t.Fatalf("unexpected subject: %q, %v", subject, err)
+	}
+	if msg.Header.Get("To") != "<user@example.com>" || msg.Header.Get("Message-ID") == "" {
+		t.Fatalf("unexpected headers: %v", msg.Header)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type: %q, %v", mediaType, err)
+	}
+
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(p)
+		contentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[contentType] = string(body)
+	}
+
+	if !strings.Contains(parts["text/plain"], notif.Data) || !strings.Contains(parts["text/plain"], notif.Id) {
+		t.Errorf("text part lacks the notification: %q", parts["text/plain"])
+	}
+	if !strings.Contains(parts["text/html"], "&lt;b&gt;&amp;&lt;/b&gt;") || strings.Contains(parts["text/html"], "<b>&</b>") {
+		t.Errorf("html part doesn't escape data: %q", parts["text/html"])
+	}
+}
+
+func TestEmailMessageBadRecipient(t *testing.T) {
+	t.Parallel()
+	e := NewEmail(EmailConfig{From: "notification@service.com"}, RetryConfig{Attempts: 1, Delay: 1, MaxDelay: 1})
+	if _, err := e.message(testNotification("not an address")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
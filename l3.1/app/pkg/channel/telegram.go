@@ -0,0 +1,103 @@
+package channel
+
+import (
+	"app/internal/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+type TelegramConfig struct {
+	Token   string `env:"TELEGRAM_TOKEN" env-default:""`
+	ApiUrl  string `env:"TELEGRAM_API_URL" env-default:"https://api.telegram.org"`
+	Timeout int    `env:"TELEGRAM_TIMEOUT" env-default:"5"`
+}
+
+type telegramMessage struct {
+	ChatId string `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+type telegramResponse struct {
+	Ok          bool   `json:"ok"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// Telegram sends notifications through the Bot API sendMessage method, the
+// recipient is a chat id. Any server compatible with that method can be used by
+// changing TELEGRAM_API_URL.
+type Telegram struct {
+	cfg    TelegramConfig
+	retry  retrier
+	client *http.Client
+}
+
+func NewTelegram(cfg TelegramConfig, retryCfg RetryConfig) *Telegram {
+	return &Telegram{cfg, newRetrier(retryCfg), &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}}
+}
+
+// Send retries network failures, 5xx and 429 responses, honoring retry_after.
+func (t *Telegram) Send(ctx context.Context, notif models.Notification) (int, error) {
+	if t.cfg.Token == "" {
+		return 0, fmt.Errorf("%w: telegram token isn't set", models.ErrBadChannelCfg)
+	}
+	body, err := json.Marshal(telegramMessage{ChatId: notif.Recipient, Text: notif.Data})
+	if err != nil {
+		return 0, err
+	}
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimRight(t.cfg.ApiUrl, "/"), t.cfg.Token)
+	return t.retry.do(ctx, func(ctx context.Context) error {
+		return t.send(ctx, url, body)
+	})
+}
+
+func (t *Telegram) send(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// url.Error carries the request url, which contains the bot token
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			return errors.Join(models.ErrOnDelivery, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		err = fmt.Errorf("%w: telegram responded with %d", models.ErrOnDelivery, resp.StatusCode)
+		if resp.StatusCode >= 500 {
+			return err
+		}
+		return permanent(err)
+	}
+	if res.Ok {
+		return nil
+	}
+
+	err = fmt.Errorf("%w: telegram: %s", models.ErrOnDelivery, res.Description)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return retryAfterError{err, time.Duration(res.Parameters.RetryAfter) * time.Second}
+	case resp.StatusCode >= 500:
+		return err
+	default:
+		return permanent(err)
+	}
+}
@@ -0,0 +1,101 @@
+package channel
+
+import (
+	"app/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testToken = "123456:secret-token"
+
+func newTestTelegram(url string) *Telegram {
+	return &Telegram{TelegramConfig{Token: testToken, ApiUrl: url}, testRetry, http.DefaultClient}
+}
+
+func TestTelegramSend(t *testing.T) {
+	t.Parallel()
+	var got telegramMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bot"+testToken+"/sendMessage" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"ok":false,"description":"Not Found"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	defer srv.Close()
+
+	notif := testNotification("-1001234567890")
+	attempts, err := newTestTelegram(srv.URL).Send(context.Background(), notif)
+	if err != nil || attempts != 1 {
+		t.Fatalf("expected one successful attempt, got: %d, %v", attempts, err)
+	}
+	if got.ChatId != notif.Recipient || got.Text != notif.Data {
+		t.Fatalf("unexpected message: %+v", got)
+	}
+}
+
+func TestTelegramRetries(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name      string
+		responses []string
+		statuses  []int
+		attempts  int
+		ok        bool
+	}{
+		{"retries flood wait", []string{`{"ok":false,"description":"Too Many Requests","parameters":{"retry_after":0}}`, `{"ok":true}`}, []int{429, 200}, 2, true},
+		{"retries bad gateway", []string{`<html>bad gateway</html>`, `{"ok":true}`}, []int{502, 200}, 2, true},
+		{"doesn't retry bad chat", []string{`{"ok":false,"description":"Bad Request: chat not found"}`}, []int{400}, 1, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			calls := &atomic.Int32{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := min(int(calls.Add(1)), len(tc.responses)) - 1
+				w.WriteHeader(tc.statuses[i])
+				w.Write([]byte(tc.responses[i]))
+			}))
+			defer srv.Close()
+
+			attempts, err := newTestTelegram(srv.URL).Send(context.Background(), testNotification("42"))
+			if attempts != tc.attempts || int(calls.Load()) != tc.attempts {
+				t.Fatalf("expected %d attempts, got %d (%d calls)", tc.attempts, attempts, calls.Load())
+			}
+			if tc.ok != (err == nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTelegramErrorHidesToken(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	_, err := newTestTelegram(url).Send(context.Background(), testNotification("42"))
+	if err == nil || !errors.Is(err, models.ErrOnDelivery) {
+		t.Fatalf("expected delivery error, got: %v", err)
+	}
+	if strings.Contains(err.Error(), testToken) {
+		t.Fatalf("error leaks the bot token: %v", err)
+	}
+}
+
+func TestTelegramWithoutToken(t *testing.T) {
+	t.Parallel()
+	tg := &Telegram{TelegramConfig{}, testRetry, http.DefaultClient}
+	if _, err := tg.Send(context.Background(), testNotification("42")); !errors.Is(err, models.ErrBadChannelCfg) {
+		t.Fatalf("expected ErrBadChannelCfg, got: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package channel
+
+import (
+	"app/internal/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+var errPrivateAddr = errors.New("webhook resolves to a private address")
+
+type WebhookConfig struct {
+	Timeout int `env:"WEBHOOK_TIMEOUT" env-default:"5"`
+}
+
+type webhookPayload struct {
+	Id          string    `json:"id"`
+	Version     int       `json:"version"`
+	Data        string    `json:"data"`
+	SendingDate time.Time `json:"sending_date"`
+}
+
+// Webhook POSTs notifications as JSON to the recipient url. The Idempotency-Key
+// header is the same for every attempt of one notification version, so receivers
+// can drop duplicates.
+type Webhook struct {
+	retry  retrier
+	client *http.Client
+}
+
+func NewWebhook(cfg WebhookConfig, retryCfg RetryConfig) *Webhook {
+	dialer := &net.Dialer{Timeout: time.Duration(cfg.Timeout) * time.Second, Control: denyPrivate}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &Webhook{newRetrier(retryCfg), &http.Client{Transport: transport, Timeout: time.Duration(cfg.Timeout) * time.Second}}
+}
+
+// denyPrivate keeps clients from pointing webhooks at internal services, it
+// checks the resolved address, so DNS names and redirects are covered.
+func denyPrivate(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return errPrivateAddr
+	}
+	return nil
+}
+
+// Send retries network failures, 408, 429 and 5xx responses, other responses
+// outside 2xx fail right away.
+func (w *Webhook) Send(ctx context.Context, notif models.Notification) (int, error) {
+	body, err := json.Marshal(webhookPayload{notif.Id, notif.Version, notif.Data, notif.SendingDate})
+	if err != nil {
+		return 0, err
+	}
+	key := fmt.Sprintf("%s:%d", notif.Id, notif.Version)
+
+	return w.retry.do(ctx, func(ctx context.Context) error {
+		return w.send(ctx, notif.Recipient, key, body)
+	})
+}
+
+func (w *Webhook) send(ctx context.Context, url, key string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	resp, err := w.client.Do(req)
+	if errors.Is(err, errPrivateAddr) {
+		return permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("%w: webhook responded with %d", models.ErrOnDelivery, resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return retryAfterError{err, time.Duration(seconds) * time.Second}
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		return err
+	default:
+		return permanent(err)
+	}
+}
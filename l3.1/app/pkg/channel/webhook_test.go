@@ -0,0 +1,129 @@
+package channel
+
+import (
+	"app/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testRetry = retrier{attempts: 3, delay: time.Millisecond, maxDelay: 10 * time.Millisecond}
+
+func testNotification(recipient string) models.Notification {
+	return models.Notification{
+		Id:          "550e8400-e29b-41d4-a716-446655440000",
+		Version:     2,
+		Recipient:   recipient,
+		Data:        "Встреча в 15:00 <b>&</b>",
+		SendingDate: time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC),
+	}
+}
+
+// statusServer responds with statuses in order, repeating the last one.
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	calls := &atomic.Int32{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+func TestWebhookSend(t *testing.T) {
+	t.Parallel()
+	var got webhookPayload
+	var key string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	wh := &Webhook{testRetry, srv.Client()}
+	notif := testNotification(srv.URL)
+	attempts, err := wh.Send(context.Background(), notif)
+	if err != nil || attempts != 1 {
+		t.Fatalf("expected one successful attempt, got: %d, %v", attempts, err)
+	}
+	if got.Id != notif.Id || got.Version != 2 || got.Data != notif.Data || !got.SendingDate.Equal(notif.SendingDate) {
+		t.Fatalf("unexpected payload: %+v", got)
+	}
+	if key != notif.Id+":2" {
+		t.Fatalf("unexpected idempotency key: %q", key)
+	}
+}
+
+func TestWebhookRefusesPrivateAddresses(t *testing.T) {
+	t.Parallel()
+	srv, calls := statusServer(t, http.StatusOK)
+
+	wh := NewWebhook(WebhookConfig{Timeout: 1}, RetryConfig{Attempts: 3, Delay: 1, MaxDelay: 1})
+	attempts, err := wh.Send(context.Background(), testNotification(srv.URL))
+	if !errors.Is(err, errPrivateAddr) || attempts != 1 {
+		t.Fatalf("expected one refused attempt, got: %d, %v", attempts, err)
+	}
+	if calls.Load() != 0 {
+		t.Fatal("expected the webhook at 127.0.0.1 not to be called")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name     string
+		statuses []int
+		attempts int
+		ok       bool
+	}{
+		{"recovers after 5xx", []int{500, 503, 200}, 3, true},
+		{"gives up after attempts", []int{502}, 3, false},
+		{"retries 429", []int{429, 201}, 2, true},
+		{"doesn't retry 4xx", []int{404, 200}, 1, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			srv, calls := statusServer(t, tc.statuses...)
+			wh := &Webhook{testRetry, srv.Client()}
+
+			attempts, err := wh.Send(context.Background(), testNotification(srv.URL))
+			if attempts != tc.attempts || int(calls.Load()) != tc.attempts {
+				t.Fatalf("expected %d attempts, got %d (%d calls)", tc.attempts, attempts, calls.Load())
+			}
+			if tc.ok != (err == nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil && !errors.Is(err, models.ErrOnDelivery) {
+				t.Fatalf("expected ErrOnDelivery, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestWebhookStopsOnContextDone(t *testing.T) {
+	t.Parallel()
+	srv, _ := statusServer(t, 500)
+	wh := &Webhook{retrier{attempts: 5, delay: time.Hour, maxDelay: time.Hour}, srv.Client()}
+
+	ctx, canc := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer canc()
+	attempts, err := wh.Send(ctx, testNotification(srv.URL))
+	if attempts != 1 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected to stop waiting on context, got: %d, %v", attempts, err)
+	}
+}
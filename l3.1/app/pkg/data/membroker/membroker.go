@@ -0,0 +1,266 @@
+// Package membroker is an in-memory stand-in for RabbitMQ to test schedulers and
+// consumers without a broker. It supports direct and x-delayed-message exchanges,
+// queues with x-message-ttl and dead-lettering, confirms and manual acks, and keeps
+// nothing across restarts.
+package membroker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+type message struct {
+	key string
+	pub amqp091.Publishing
+}
+
+type queue struct {
+	name    string
+	ttl     time.Duration
+	dlx     string
+	dlk     string
+	msgs    []*message
+	unacked map[uint64]*message
+	ready   *sync.Cond
+}
+
+type exchange struct {
+	kind     string
+	bindings map[string][]*queue
+}
+
+type Broker struct {
+	mu        sync.Mutex
+	exchanges map[string]*exchange
+	queues    map[string]*queue
+	tag       uint64
+}
+
+func New() *Broker {
+	return &Broker{exchanges: map[string]*exchange{}, queues: map[string]*queue{}}
+}
+
+func (b *Broker) DeclareExchange(name, kind string, args amqp091.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if kind == "x-delayed-message" {
+		kind = "delayed"
+	}
+	if kind != "direct" && kind != "delayed" {
+		return fmt.Errorf("membroker: unsupported exchange kind %q", kind)
+	}
+	if ex, ok := b.exchanges[name]; ok {
+		if ex.kind != kind {
+			return fmt.Errorf("membroker: exchange %s redeclared as %s", name, kind)
+		}
+		return nil
+	}
+	b.exchanges[name] = &exchange{kind: kind, bindings: map[string][]*queue{}}
+	return nil
+}
+
+func (b *Broker) DeclareQueue(name string, args amqp091.Table) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.queues[name]; ok {
+		return nil
+	}
+	q := &queue{name: name, unacked: map[uint64]*message{}, ready: sync.NewCond(&b.mu)}
+	if ttl, ok := args["x-message-ttl"].(int64); ok {
+		q.ttl = time.Duration(ttl) * time.Millisecond
+	}
+	q.dlx, _ = args["x-dead-letter-exchange"].(string)
+	q.dlk, _ = args["x-dead-letter-routing-key"].(string)
+	b.queues[name] = q
+	return nil
+}
+
+func (b *Broker) BindQueue(queue, key, exchange string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("membroker: no exchange %s", exchange)
+	}
+	q, ok := b.queues[queue]
+	if !ok {
+		return fmt.Errorf("membroker: no queue %s", queue)
+	}
+	ex.bindings[key] = append(ex.bindings[key], q)
+	return nil
+}
+
+// Publish routes msg right away, which counts as a confirm.
+func (b *Broker) Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("membroker: no exchange %s", exchange)
+	}
+
+	m := &message{key, msg}
+	if delay, ok := msg.Headers["x-delay"].(int64); ex.kind == "delayed" && ok && delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Millisecond, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.route(ex, m)
+		})
+		return nil
+	}
+	b.route(ex, m)
+	return nil
+}
+
+// Consume delivers messages from queue until ctx is done, unacked messages are
+// requeued when their consumer stops.
+func (b *Broker) Consume(ctx context.Context, queue string) (<-chan amqp091.Delivery, error) {
+	b.mu.Lock()
+	q, ok := b.queues[queue]
+	b.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("membroker: no queue %s", queue)
+	}
+
+	out := make(chan amqp091.Delivery)
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		q.ready.Broadcast()
+	})
+
+	go func() {
+		defer close(out)
+		defer stop()
+		for {
+			b.mu.Lock()
+			for len(q.msgs) == 0 && ctx.Err() == nil {
+				q.ready.Wait()
+			}
+			if ctx.Err() != nil {
+				b.mu.Unlock()
+				return
+			}
+			m := q.msgs[0]
+			q.msgs = q.msgs[1:]
+			b.tag++
+			tag := b.tag
+			q.unacked[tag] = m
+			b.mu.Unlock()
+
+			d := amqp091.Delivery{
+				Acknowledger: &acknowledger{b, q},
+				DeliveryTag:  tag,
+				RoutingKey:   m.key,
+				ContentType:  m.pub.ContentType,
+				DeliveryMode: m.pub.DeliveryMode,
+				MessageId:    m.pub.MessageId,
+				Headers:      m.pub.Headers,
+				Body:         m.pub.Body,
+			}
+			select {
+			case out <- d:
+			case <-ctx.Done():
+				b.requeue(q, tag)
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// Len returns the number of ready messages in queue.
+func (b *Broker) Len(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.queues[queue]; ok {
+		return len(q.msgs)
+	}
+	return 0
+}
+
+// route must be called with b.mu held.
+func (b *Broker) route(ex *exchange, m *message) {
+	for _, q := range ex.bindings[m.key] {
+		b.enqueue(q, &message{m.key, m.pub}, false)
+	}
+}
+
+// enqueue must be called with b.mu held.
+func (b *Broker) enqueue(q *queue, m *message, front bool) {
+	if front {
+		q.msgs = append([]*message{m}, q.msgs...)
+	} else {
+		q.msgs = append(q.msgs, m)
+	}
+	q.ready.Signal()
+
+	if q.ttl > 0 && q.dlx != "" {
+		time.AfterFunc(q.ttl, func() { b.expire(q, m) })
+	}
+}
+
+func (b *Broker) expire(q *queue, m *message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := slices.Index(q.msgs, m)
+	if i < 0 {
+		return
+	}
+	q.msgs = slices.Delete(q.msgs, i, i+1)
+
+	key := m.key
+	if q.dlk != "" {
+		key = q.dlk
+	}
+	if ex, ok := b.exchanges[q.dlx]; ok {
+		b.route(ex, &message{key, m.pub})
+	}
+}
+
+func (b *Broker) requeue(q *queue, tag uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if m, ok := q.unacked[tag]; ok {
+		delete(q.unacked, tag)
+		b.enqueue(q, m, true)
+	}
+}
+
+type acknowledger struct {
+	b *Broker
+	q *queue
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+	delete(a.q.unacked, tag)
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	if requeue {
+		a.b.requeue(a.q, tag)
+		return nil
+	}
+	return a.Ack(tag, multiple)
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
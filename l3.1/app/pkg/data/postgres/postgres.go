@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wb-go/wbf/dbpg"
+)
+
+type PostgresConfig struct {
+	Host     string `env:"DB_HOST" env-default:"localhost"`
+	Port     string `env:"DB_PORT" env-default:"5432"`
+	User     string `env:"DB_USER" env-default:"notifier"`
+	Password string `env:"DB_PASSWORD" env-default:"notifier"`
+	Name     string `env:"DB_NAME" env-default:"notifications"`
+}
+
+func New(cfg PostgresConfig) *dbpg.DB {
+	opts := &dbpg.Options{MaxOpenConns: 10, MaxIdleConns: 5}
+	dsn := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
+	db, err := dbpg.New(dsn, []string{}, opts)
+	if err != nil {
+		panic(fmt.Sprintf("failed to connect to db: %v", err))
+	}
+
+	ctx, canc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer canc()
+	if err := db.Master.PingContext(ctx); err != nil {
+		panic(fmt.Sprintf("failed to ping db: %v", err))
+	}
+	return db
+}
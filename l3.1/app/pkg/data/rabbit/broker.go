@@ -0,0 +1,87 @@
+package rabbit
+
+import (
+	"app/internal/models"
+	"context"
+	"fmt"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/wb-go/wbf/rabbitmq"
+)
+
+const consumePrefetch = 100
+
+// Broker declares topology and publishes on its own channel in confirm mode,
+// Publish returns only after the broker has taken responsibility for the message.
+type Broker struct {
+	conn *rabbitmq.Connection
+	ch   *rabbitmq.Channel
+}
+
+func NewBroker(conn *rabbitmq.Connection) *Broker {
+	ch, err := conn.Channel()
+	if err != nil {
+		panic(err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		panic(err)
+	}
+	return &Broker{conn, ch}
+}
+
+func (b *Broker) DeclareExchange(name, kind string, args amqp091.Table) error {
+	return b.ch.ExchangeDeclare(name, kind, true, false, false, false, args)
+}
+
+func (b *Broker) DeclareQueue(name string, args amqp091.Table) error {
+	_, err := b.ch.QueueDeclare(name, true, false, false, false, args)
+	return err
+}
+
+func (b *Broker) BindQueue(queue, key, exchange string) error {
+	return b.ch.QueueBind(queue, key, exchange, false, nil)
+}
+
+func (b *Broker) Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) error {
+	dc, err := b.ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		return err
+	}
+
+	acked, err := dc.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("%w: %s", models.ErrNotConfirmed, msg.MessageId)
+	}
+	return nil
+}
+
+// Consume delivers messages from queue with manual acks on a dedicated channel,
+// which is closed once ctx is done.
+func (b *Broker) Consume(ctx context.Context, queue string) (<-chan amqp091.Delivery, error) {
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Qos(consumePrefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	deliveries, err := ch.ConsumeWithContext(ctx, queue, "", false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		ch.Close()
+	}()
+	return deliveries, nil
+}
+
+func (b *Broker) Close() error {
+	return b.ch.Close()
+}
@@ -0,0 +1,42 @@
+package scheduler
+
+import (
+	"app/internal/models"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// maxPluginDelay is the largest x-delay rabbitmq-delayed-message-exchange accepts.
+const maxPluginDelay = (1<<32 - 1) * time.Millisecond
+
+// Delayed relies on the rabbitmq-delayed-message-exchange plugin, delayed messages
+// are kept by the plugin on a single node until they are due.
+type Delayed struct {
+	broker Broker
+}
+
+func NewDelayed(broker Broker) *Delayed {
+	return &Delayed{broker}
+}
+
+func (s *Delayed) Declare() error {
+	return declareWork(s.broker, "x-delayed-message", amqp091.Table{"x-delayed-type": "direct"})
+}
+
+func (s *Delayed) Schedule(ctx context.Context, msg models.OutboxMessage) error {
+	// rounded up, so the message never arrives before DeliverAt
+	delay := (time.Until(msg.DeliverAt) + time.Millisecond - 1).Milliseconds()
+	headers := amqp091.Table{"x-delay": max(0, delay)}
+	return s.broker.Publish(ctx, msg.Exchange, msg.RoutingKey, publishing(msg, headers))
+}
+
+func (s *Delayed) Lead() time.Duration {
+	return maxPluginDelay
+}
+
+func (s *Delayed) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Done()
+}
@@ -0,0 +1,105 @@
+package scheduler
+
+import (
+	"app/internal/models"
+	"app/pkg/data/rabbit"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	KindDelayed = "delayed"
+	KindTTL     = "ttl"
+	KindWheel   = "wheel"
+)
+
+type SchedulerConfig struct {
+	Kind string `env:"SCHEDULER_KIND" env-default:"delayed"`
+	// TTLTiers are the delay queue TTLs in seconds used by the ttl scheduler.
+	TTLTiers []int `env:"SCHEDULER_TTL_TIERS" env-default:"1,10,60,600,3600,21600,86400" env-separator:","`
+}
+
+// Valid checks the scheduler kind and that ttl tiers are positive.
+func (cfg SchedulerConfig) Valid() error {
+	switch cfg.Kind {
+	case KindDelayed, KindWheel:
+		return nil
+	case KindTTL:
+		if len(cfg.TTLTiers) == 0 {
+			return fmt.Errorf("%w: no ttl tiers", models.ErrBadSchedulerCfg)
+		}
+		for _, tier := range cfg.TTLTiers {
+			if tier <= 0 {
+				return fmt.Errorf("%w: bad ttl tier %d", models.ErrBadSchedulerCfg, tier)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported kind %q", models.ErrBadSchedulerCfg, cfg.Kind)
+	}
+}
+
+// Broker is the part of RabbitMQ schedulers rely on, implemented by rabbit.Broker
+// and, for tests, by membroker.Broker.
+type Broker interface {
+	DeclareExchange(name, kind string, args amqp091.Table) error
+	DeclareQueue(name string, args amqp091.Table) error
+	BindQueue(queue, key, exchange string) error
+	// Publish returns after the broker confirmed the message.
+	Publish(ctx context.Context, exchange, key string, msg amqp091.Publishing) error
+	// Consume delivers messages with manual acks until ctx is done.
+	Consume(ctx context.Context, queue string) (<-chan amqp091.Delivery, error)
+}
+
+// Scheduler gets outbox messages to the work queue at their DeliverAt.
+type Scheduler interface {
+	// Declare sets up the exchanges and queues the scheduler needs, including the work queue.
+	Declare() error
+	// Schedule hands msg over to the broker, msg arrives to the work queue not before DeliverAt.
+	Schedule(ctx context.Context, msg models.OutboxMessage) error
+	// Lead is how long before DeliverAt a message may be handed to Schedule,
+	// messages due later stay in the database.
+	Lead() time.Duration
+	// Start runs the scheduler's background work, if any, until ctx is done.
+	Start(ctx context.Context, wg *sync.WaitGroup)
+}
+
+func New(cfg SchedulerConfig, broker Broker) Scheduler {
+	switch cfg.Kind {
+	case KindTTL:
+		tiers := make([]time.Duration, 0, len(cfg.TTLTiers))
+		for _, tier := range cfg.TTLTiers {
+			tiers = append(tiers, time.Duration(tier)*time.Second)
+		}
+		return NewTTL(broker, tiers)
+	case KindWheel:
+		return NewWheel(broker)
+	default:
+		return NewDelayed(broker)
+	}
+}
+
+// declareWork declares the exchange consumers read notifications from.
+func declareWork(broker Broker, kind string, args amqp091.Table) error {
+	if err := broker.DeclareExchange(rabbit.Exchange, kind, args); err != nil {
+		return err
+	}
+	if err := broker.DeclareQueue(rabbit.Queue, nil); err != nil {
+		return err
+	}
+	return broker.BindQueue(rabbit.Queue, rabbit.RoutingKey, rabbit.Exchange)
+}
+
+func publishing(msg models.OutboxMessage, headers amqp091.Table) amqp091.Publishing {
+	return amqp091.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp091.Persistent,
+		MessageId:    msg.NotificationId,
+		Headers:      headers,
+		Body:         msg.Payload,
+	}
+}
@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+	"app/internal/models"
+	"app/pkg/data/membroker"
+	"app/pkg/data/rabbit"
+	"app/pkg/logger"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// start declares sched on an in-memory broker, runs it and consumes the work queue.
+func start(t *testing.T, newScheduler func(Broker) Scheduler) (context.Context, Scheduler, *membroker.Broker, <-chan amqp091.Delivery) {
+	t.Helper()
+	ctx, canc := context.WithCancel(context.WithValue(context.Background(), logger.LoggerKey, logger.New()))
+	broker := membroker.New()
+	sched := newScheduler(broker)
+	if err := sched.Declare(); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go sched.Start(ctx, wg)
+	t.Cleanup(func() {
+		canc()
+		wg.Wait()
+	})
+
+	deliveries, err := broker.Consume(ctx, rabbit.Queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx, sched, broker, deliveries
+}
+
+func outboxMessage(id string, deliverAt time.Time) models.OutboxMessage {
+	return models.OutboxMessage{NotificationId: id, Exchange: rabbit.Exchange, RoutingKey: rabbit.RoutingKey, Payload: []byte(id), DeliverAt: deliverAt}
+}
+
+// receive waits for the next message in the work queue and acks it.
+func receive(t *testing.T, deliveries <-chan amqp091.Delivery) (amqp091.Delivery, time.Time) {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		d.Ack(false)
+		return d, time.Now()
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message in the work queue")
+		return amqp091.Delivery{}, time.Time{}
+	}
+}
+
+func TestSchedulersDeliverOnTime(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name         string
+		newScheduler func(Broker) Scheduler
+		// tolerance is how late a message may arrive
+		tolerance time.Duration
+	}{
+		{"delayed", func(b Broker) Scheduler { return NewDelayed(b) }, 100 * time.Millisecond},
+		{"ttl", func(b Broker) Scheduler {
+			return NewTTL(b, []time.Duration{100 * time.Millisecond, 20 * time.Millisecond})
+		}, 150 * time.Millisecond},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, sched, _, deliveries := start(t, tc.newScheduler)
+
+			deliverAt := time.Now().Add(250 * time.Millisecond)
+			if err := sched.Schedule(ctx, outboxMessage("a", deliverAt)); err != nil {
+				t.Fatal(err)
+			}
+
+			d, arrived := receive(t, deliveries)
+			if string(d.Body) != "a" || d.MessageId != "a" || d.DeliveryMode != amqp091.Persistent {
+				t.Fatalf("unexpected message: %+v", d)
+			}
+			if arrived.Before(deliverAt) {
+				t.Fatalf("message arrived %v early", deliverAt.Sub(arrived))
+			}
+			if late := arrived.Sub(deliverAt); late > tc.tolerance {
+				t.Fatalf("message arrived %v late", late)
+			}
+		})
+	}
+}
+
+func TestTTLScheduleStripsRoutingHeaders(t *testing.T) {
+	t.Parallel()
+	ctx, sched, _, deliveries := start(t, func(b Broker) Scheduler { return NewTTL(b, []time.Duration{20 * time.Millisecond}) })
+
+	if err := sched.Schedule(ctx, outboxMessage("a", time.Now().Add(30*time.Millisecond))); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := receive(t, deliveries)
+	for _, h := range []string{headerDeliverAt, headerExchange, headerKey} {
+		if _, ok := d.Headers[h]; ok {
+			t.Errorf("header %s reached the work queue", h)
+		}
+	}
+}
+
+func TestTTLScheduleDueMessage(t *testing.T) {
+	t.Parallel()
+	ctx, sched, broker, deliveries := start(t, func(b Broker) Scheduler { return NewTTL(b, []time.Duration{time.Hour}) })
+
+	if err := sched.Schedule(ctx, outboxMessage("a", time.Now().Add(-time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, deliveries)
+	if n := broker.Len(tierQueue(time.Hour)); n != 0 {
+		t.Fatalf("expected due message to skip delay queues, %d in tier", n)
+	}
+}
+
+func TestTTLPutsOffUnroutableMessage(t *testing.T) {
+	t.Parallel()
+	ctx, sched, broker, _ := start(t, func(b Broker) Scheduler { return NewTTL(b, []time.Duration{10 * time.Millisecond}) })
+
+	msg := outboxMessage("a", time.Now().Add(20*time.Millisecond))
+	msg.Exchange = "missing"
+	if err := sched.Schedule(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// every attempt waits out the tier, so the message isn't retried in a loop
+	started := time.Now()
+	deadline := time.After(3 * time.Second)
+	for broker.Len(deadQueue) == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("expected the message in the dead queue")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if elapsed := time.Since(started); elapsed < maxRouteAttempts*10*time.Millisecond {
+		t.Fatalf("expected %d attempts a tier apart, the message was given up after %v", maxRouteAttempts, elapsed)
+	}
+	if n := broker.Len(tierQueue(10 * time.Millisecond)); n != 0 {
+		t.Fatalf("expected the message to leave the delay queues, %d in tier", n)
+	}
+}
+
+func TestTTLTierFor(t *testing.T) {
+	t.Parallel()
+	s := NewTTL(nil, []time.Duration{time.Hour, time.Second, time.Minute})
+	testCases := []struct {
+		remaining time.Duration
+		expected  time.Duration
+	}{
+		{100 * time.Millisecond, time.Second},
+		{time.Second, time.Second},
+		{59 * time.Second, time.Second},
+		{90 * time.Second, time.Minute},
+		{48 * time.Hour, time.Hour},
+	}
+	for _, tc := range testCases {
+		if got := s.tierFor(tc.remaining); got != tc.expected {
+			t.Errorf("remaining %v: expected %v, got %v", tc.remaining, tc.expected, got)
+		}
+	}
+}
+
+func TestWheelPublishesImmediately(t *testing.T) {
+	t.Parallel()
+	ctx, sched, _, deliveries := start(t, func(b Broker) Scheduler { return NewWheel(b) })
+
+	if sched.Lead() != 0 {
+		t.Fatalf("expected zero lead, got: %v", sched.Lead())
+	}
+	if err := sched.Schedule(ctx, outboxMessage("a", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := receive(t, deliveries); string(d.Body) != "a" {
+		t.Fatalf("unexpected message: %s", d.Body)
+	}
+}
+
+func TestSchedulerConfigValid(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		cfg   SchedulerConfig
+		valid bool
+	}{
+		{SchedulerConfig{Kind: KindDelayed}, true},
+		{SchedulerConfig{Kind: KindWheel}, true},
+		{SchedulerConfig{Kind: KindTTL, TTLTiers: []int{1, 10}}, true},
+		{SchedulerConfig{Kind: KindTTL}, false},
+		{SchedulerConfig{Kind: KindTTL, TTLTiers: []int{1, 0}}, false},
+		{SchedulerConfig{Kind: "cron"}, false},
+	}
+	for _, tc := range testCases {
+		err := tc.cfg.Valid()
+		if tc.valid && err != nil || !tc.valid && !errors.Is(err, models.ErrBadSchedulerCfg) {
+			t.Errorf("%+v: unexpected error: %v", tc.cfg, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package scheduler
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	delayExchange = "delay_exchange"
+	routerQueue   = "delay_router"
+	// deadQueue keeps the messages that could not be routed for
+	// maxRouteAttempts smallest tiers, for someone to look into.
+	deadQueue = "delay_dead"
+
+	headerDeliverAt = "x-deliver-at"
+	headerExchange  = "x-target-exchange"
+	headerKey       = "x-target-key"
+	headerAttempts  = "x-route-attempts"
+
+	maxRouteAttempts = 10
+	// retryWait paces the router when even the delay queues refuse a message,
+	// so a requeued message doesn't come straight back.
+	retryWait = time.Second
+)
+
+var errNoDeliveryHeaders = errors.New("message has no delivery headers")
+
+// TTL delays messages with plain RabbitMQ features. Every tier is a queue without
+// consumers whose x-message-ttl is the tier delay and whose expired messages are
+// dead-lettered into the router queue. The router sends a message to the largest
+// tier not exceeding its remaining delay, or to the work queue once it is due, so a
+// message makes a few hops (at most one per tier and a few of the largest one) and
+// arrives at most one smallest tier late. A message that can't be routed waits
+// in the smallest tier and is tried again, up to maxRouteAttempts times.
+type TTL struct {
+	broker Broker
+	tiers  []time.Duration
+	now    func() time.Time
+}
+
+func NewTTL(broker Broker, tiers []time.Duration) *TTL {
+	tiers = slices.Clone(tiers)
+	slices.Sort(tiers)
+	return &TTL{broker, tiers, time.Now}
+}
+
+func tierQueue(tier time.Duration) string {
+	return fmt.Sprintf("delay_%dms", tier.Milliseconds())
+}
+
+func (s *TTL) Declare() error {
+	if err := declareWork(s.broker, "direct", nil); err != nil {
+		return err
+	}
+	if err := s.broker.DeclareExchange(delayExchange, "direct", nil); err != nil {
+		return err
+	}
+	if err := s.broker.DeclareQueue(routerQueue, nil); err != nil {
+		return err
+	}
+	if err := s.broker.BindQueue(routerQueue, routerQueue, delayExchange); err != nil {
+		return err
+	}
+	if err := s.broker.DeclareQueue(deadQueue, nil); err != nil {
+		return err
+	}
+	if err := s.broker.BindQueue(deadQueue, deadQueue, delayExchange); err != nil {
+		return err
+	}
+
+	for _, tier := range s.tiers {
+		args := amqp091.Table{
+			"x-message-ttl":             tier.Milliseconds(),
+			"x-dead-letter-exchange":    delayExchange,
+			"x-dead-letter-routing-key": routerQueue,
+		}
+		if err := s.broker.DeclareQueue(tierQueue(tier), args); err != nil {
+			return err
+		}
+		if err := s.broker.BindQueue(tierQueue(tier), tierQueue(tier), delayExchange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TTL) Schedule(ctx context.Context, msg models.OutboxMessage) error {
+	headers := amqp091.Table{
+		headerDeliverAt: msg.DeliverAt.UnixMilli(),
+		headerExchange:  msg.Exchange,
+		headerKey:       msg.RoutingKey,
+	}
+	return s.route(ctx, publishing(msg, headers))
+}
+
+// Lead is unlimited, delays longer than the largest tier just take more hops.
+func (s *TTL) Lead() time.Duration {
+	return math.MaxInt64
+}
+
+// Start routes messages that expired from tier queues until ctx is done.
+func (s *TTL) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	deliveries, err := s.broker.Consume(ctx, routerQueue)
+	if err != nil {
+		lg.Error().Str("worker", "ttl scheduler").Err(err).Msg("while consuming router queue")
+		return
+	}
+
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return
+			}
+			pub := amqp091.Publishing{
+				ContentType:  d.ContentType,
+				DeliveryMode: d.DeliveryMode,
+				MessageId:    d.MessageId,
+				Headers:      d.Headers,
+				Body:         d.Body,
+			}
+			err := s.route(ctx, pub)
+			switch {
+			case errors.Is(err, errNoDeliveryHeaders):
+				// not ours, requeueing it would loop forever
+				lg.Error().Str("worker", "ttl scheduler").Err(err).Msg("dropping delayed message")
+				d.Ack(false)
+			case err != nil:
+				lg.Error().Str("worker", "ttl scheduler").Err(err).Str("id", d.MessageId).Msg("while routing delayed message")
+				if err := s.putOff(ctx, pub); err != nil {
+					lg.Error().Str("worker", "ttl scheduler").Err(err).Str("id", d.MessageId).Msg("while putting off delayed message")
+					select {
+					case <-time.After(retryWait):
+					case <-ctx.Done():
+					}
+					d.Nack(false, true)
+					continue
+				}
+				d.Ack(false)
+			default:
+				d.Ack(false)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// putOff sends pub to the smallest tier to be routed again, or to the dead
+// queue once it has run out of attempts.
+func (s *TTL) putOff(ctx context.Context, pub amqp091.Publishing) error {
+	attempts, _ := headerInt(pub.Headers[headerAttempts])
+	attempts++
+	pub.Headers = maps.Clone(pub.Headers)
+	pub.Headers[headerAttempts] = attempts
+
+	if attempts >= maxRouteAttempts {
+		logger.LoggerFromCtx(ctx).Lg.Warn().Str("worker", "ttl scheduler").Str("id", pub.MessageId).Msg("moving delayed message to the dead queue")
+		return s.broker.Publish(ctx, delayExchange, deadQueue, pub)
+	}
+	return s.broker.Publish(ctx, delayExchange, tierQueue(s.tiers[0]), pub)
+}
+
+// route publishes pub to the tier matching its remaining delay or to its target once due.
+func (s *TTL) route(ctx context.Context, pub amqp091.Publishing) error {
+	deliverAt, ok := headerInt(pub.Headers[headerDeliverAt])
+	exchange, _ := pub.Headers[headerExchange].(string)
+	key, _ := pub.Headers[headerKey].(string)
+	if !ok || exchange == "" {
+		return fmt.Errorf("%w: %s", errNoDeliveryHeaders, pub.MessageId)
+	}
+
+	remaining := time.UnixMilli(deliverAt).Sub(s.now())
+	if remaining <= 0 {
+		headers := amqp091.Table{}
+		for k, v := range pub.Headers {
+			if k != headerDeliverAt && k != headerExchange && k != headerKey && k != headerAttempts && k != "x-death" {
+				headers[k] = v
+			}
+		}
+		pub.Headers = headers
+		return s.broker.Publish(ctx, exchange, key, pub)
+	}
+
+	return s.broker.Publish(ctx, delayExchange, tierQueue(s.tierFor(remaining)), pub)
+}
+
+// tierFor returns the largest tier not exceeding remaining, or the smallest one.
+func (s *TTL) tierFor(remaining time.Duration) time.Duration {
+	tier := s.tiers[0]
+	for _, t := range s.tiers {
+		if t > remaining {
+			break
+		}
+		tier = t
+	}
+	return tier
+}
+
+func headerInt(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,35 @@
+package scheduler
+
+import (
+	"app/internal/models"
+	"context"
+	"sync"
+	"time"
+)
+
+// Wheel publishes straight to the work queue. Its Lead is zero, so the relay keeps
+// claimed messages in its timing wheel and the database until they are due, and the
+// broker never holds a message that isn't ready to be sent.
+type Wheel struct {
+	broker Broker
+}
+
+func NewWheel(broker Broker) *Wheel {
+	return &Wheel{broker}
+}
+
+func (s *Wheel) Declare() error {
+	return declareWork(s.broker, "direct", nil)
+}
+
+func (s *Wheel) Schedule(ctx context.Context, msg models.OutboxMessage) error {
+	return s.broker.Publish(ctx, msg.Exchange, msg.RoutingKey, publishing(msg, nil))
+}
+
+func (s *Wheel) Lead() time.Duration {
+	return 0
+}
+
+func (s *Wheel) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Done()
+}
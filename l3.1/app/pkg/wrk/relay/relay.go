@@ -0,0 +1,207 @@
+package relay
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"sync"
+	"time"
+)
+
+type RelayConfig struct {
+	Interval       int `env:"RELAY_INTERVAL" env-default:"1"`
+	BatchSize      int `env:"RELAY_BATCH_SIZE" env-default:"100"`
+	Lease          int `env:"RELAY_LEASE" env-default:"30"`
+	ConfirmTimeout int `env:"RELAY_CONFIRM_TIMEOUT" env-default:"5"`
+	RetryDelay     int `env:"RELAY_RETRY_DELAY" env-default:"1"`
+	MaxRetryDelay  int `env:"RELAY_MAX_RETRY_DELAY" env-default:"60"`
+	// Lookahead is how far ahead the timing wheel holds messages for schedulers
+	// that only take due messages.
+	Lookahead int `env:"RELAY_LOOKAHEAD" env-default:"60"`
+	// ReclaimAfter is how long a notification may stay in sending before it is
+	// queued again, it's well above the time a channel retries.
+	ReclaimAfter int `env:"RELAY_RECLAIM_AFTER" env-default:"600"`
+}
+
+// Valid checks that all intervals and sizes are positive.
+func (cfg RelayConfig) Valid() error {
+	if cfg.Interval <= 0 || cfg.BatchSize <= 0 || cfg.Lease <= 0 || cfg.ConfirmTimeout <= 0 || cfg.RetryDelay <= 0 || cfg.MaxRetryDelay < cfg.RetryDelay || cfg.Lookahead <= 0 || cfg.ReclaimAfter <= 0 {
+		return models.ErrBadRelayCfg
+	}
+	return nil
+}
+
+type RepositoryInterface interface {
+	ClaimOutbox(ctx context.Context, limit int, horizon, lease time.Duration) ([]models.OutboxMessage, error)
+	MarkDispatched(ctx context.Context, msg models.OutboxMessage) error
+	RetryOutbox(ctx context.Context, id int64, publishErr error, delay time.Duration) error
+	ReclaimSending(ctx context.Context, stuckFor time.Duration, limit int) (int, error)
+}
+
+// Scheduler hands messages over to the broker, a nil error means the broker confirmed it.
+type Scheduler interface {
+	Schedule(ctx context.Context, msg models.OutboxMessage) error
+	Lead() time.Duration
+}
+
+// Relay moves messages from the outbox table to the broker. Messages are claimed
+// with a lease, so after a restart or a crash undispatched messages are picked up
+// again. A message is marked dispatched only after the broker confirmed it, which
+// makes delivery at-least-once: a crash between the confirm and the mark publishes
+// the message twice.
+//
+// Only messages due within the scheduler's Lead are claimed. If the Lead is shorter
+// than cfg.Lookahead, messages due within the lookahead are claimed early, leased for
+// the lookahead on top of cfg.Lease and kept in a timing wheel until they are due.
+//
+// The relay also reclaims notifications stuck in sending for cfg.ReclaimAfter: the
+// consumer acks the redelivered message of a notification it already claimed, so
+// after a consumer crash nothing else would ever send it.
+type Relay struct {
+	repo  RepositoryInterface
+	sched Scheduler
+	cfg   RelayConfig
+	wheel *wheel
+	now   func() time.Time
+}
+
+func New(repo RepositoryInterface, sched Scheduler, cfg RelayConfig) *Relay {
+	r := &Relay{repo: repo, sched: sched, cfg: cfg, now: time.Now}
+	if sched.Lead() < r.lookahead() {
+		r.wheel = newWheel(r.tick(), r.lookahead(), r.now())
+	}
+	return r
+}
+
+func (r *Relay) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	t := time.NewTicker(r.tick())
+	defer t.Stop()
+	for {
+		r.process(ctx)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Relay) tick() time.Duration {
+	return time.Duration(r.cfg.Interval) * time.Second
+}
+
+func (r *Relay) lookahead() time.Duration {
+	return time.Duration(r.cfg.Lookahead) * time.Second
+}
+
+func (r *Relay) process(ctx context.Context) {
+	r.reclaim(ctx)
+	if !r.claim(ctx) || r.wheel == nil {
+		return
+	}
+	r.dispatchAll(ctx, r.wheel.advance(r.now()))
+}
+
+// reclaim puts notifications stuck in sending back into the outbox, batch by batch.
+func (r *Relay) reclaim(ctx context.Context) {
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	for {
+		n, err := r.repo.ReclaimSending(ctx, time.Duration(r.cfg.ReclaimAfter)*time.Second, r.cfg.BatchSize)
+		if err != nil {
+			lg.Error().Str("worker", "relay").Err(err).Msg("while reclaiming notifications stuck in sending")
+			return
+		}
+		if n > 0 {
+			lg.Warn().Str("worker", "relay").Int("count", n).Msg("reclaimed notifications stuck in sending")
+		}
+		if n < r.cfg.BatchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// claim claims and dispatches messages batch by batch, it returns false if the
+// broker or the database failed.
+func (r *Relay) claim(ctx context.Context) bool {
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	horizon, lease := r.sched.Lead(), time.Duration(r.cfg.Lease)*time.Second
+	if r.wheel != nil {
+		horizon, lease = r.lookahead(), lease+r.lookahead()
+	}
+
+	for {
+		msgs, err := r.repo.ClaimOutbox(ctx, r.cfg.BatchSize, horizon, lease)
+		if err != nil {
+			lg.Error().Str("worker", "relay").Err(err).Msg("while claiming outbox messages")
+			return false
+		}
+
+		due := make([]models.OutboxMessage, 0, len(msgs))
+		for _, msg := range msgs {
+			if r.wheel != nil && msg.DeliverAt.Sub(r.now()) > r.sched.Lead() {
+				r.wheel.add(msg)
+			} else {
+				due = append(due, msg)
+			}
+		}
+		if !r.dispatchAll(ctx, due) {
+			return false
+		}
+
+		if len(msgs) < r.cfg.BatchSize || ctx.Err() != nil {
+			return true
+		}
+	}
+}
+
+// dispatchAll stops at the first failure, the broker is most likely down, so
+// the whole rest of msgs is retried later.
+func (r *Relay) dispatchAll(ctx context.Context, msgs []models.OutboxMessage) bool {
+	for i, msg := range msgs {
+		if err := r.dispatch(ctx, msg); err != nil {
+			r.retry(ctx, msgs[i:], err, r.backoff(msg.Attempts))
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Relay) dispatch(ctx context.Context, msg models.OutboxMessage) error {
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	pubCtx, canc := context.WithTimeout(ctx, time.Duration(r.cfg.ConfirmTimeout)*time.Second)
+	err := r.sched.Schedule(pubCtx, msg)
+	canc()
+	if err != nil {
+		lg.Error().Str("worker", "relay").Err(err).Int64("outbox_id", msg.Id).Str("id", msg.NotificationId).Msg("while publishing outbox message")
+		return err
+	}
+
+	if err := r.repo.MarkDispatched(ctx, msg); err != nil {
+		lg.Error().Str("worker", "relay").Err(err).Int64("outbox_id", msg.Id).Msg("while marking outbox message dispatched")
+	}
+	return nil
+}
+
+func (r *Relay) retry(ctx context.Context, msgs []models.OutboxMessage, publishErr error, delay time.Duration) {
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	for _, msg := range msgs {
+		if err := r.repo.RetryOutbox(ctx, msg.Id, publishErr, delay); err != nil {
+			lg.Error().Str("worker", "relay").Err(err).Int64("outbox_id", msg.Id).Msg("while rescheduling outbox message")
+		}
+	}
+}
+
+// backoff returns RetryDelay doubled after every failed attempt, capped at MaxRetryDelay.
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.cfg.RetryDelay
+	for i := 1; i < attempts && delay < r.cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return time.Duration(min(delay, r.cfg.MaxRetryDelay)) * time.Second
+}
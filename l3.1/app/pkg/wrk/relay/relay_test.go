@@ -0,0 +1,357 @@
+package relay
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type outboxRow struct {
+	msg         models.OutboxMessage
+	availableAt time.Time
+	dispatched  bool
+	lastError   string
+}
+
+// fakeRepo keeps the outbox in memory and uses a manual clock for leases.
+// sending holds the notifications in sending with the time they were claimed.
+type fakeRepo struct {
+	mu      sync.Mutex
+	now     time.Time
+	rows    []*outboxRow
+	sending map[string]time.Time
+}
+
+// newFakeRepo creates messages a, b, c... due after the given delays, all due now by default.
+func newFakeRepo(n int, delays ...time.Duration) *fakeRepo {
+	r := &fakeRepo{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for i := 1; i <= n; i++ {
+		msg := models.OutboxMessage{Id: int64(i), NotificationId: string(rune('a' + i - 1)), DeliverAt: r.now}
+		if i <= len(delays) {
+			msg.DeliverAt = r.now.Add(delays[i-1])
+		}
+		r.rows = append(r.rows, &outboxRow{msg: msg, availableAt: r.now})
+	}
+	return r
+}
+
+func (r *fakeRepo) clock() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.now
+}
+
+func (r *fakeRepo) ClaimOutbox(ctx context.Context, limit int, horizon, lease time.Duration) ([]models.OutboxMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending := make([]*outboxRow, 0)
+	for _, row := range r.rows {
+		if !row.dispatched && !row.availableAt.After(r.now) && !row.msg.DeliverAt.After(r.now.Add(horizon)) {
+			pending = append(pending, row)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].availableAt.Before(pending[j].availableAt) })
+
+	msgs := make([]models.OutboxMessage, 0)
+	for _, row := range pending[:min(limit, len(pending))] {
+		row.msg.Attempts++
+		row.availableAt = r.now.Add(lease)
+		msgs = append(msgs, row.msg)
+	}
+	return msgs, nil
+}
+
+func (r *fakeRepo) MarkDispatched(ctx context.Context, msg models.OutboxMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rows[msg.Id-1].dispatched = true
+	return nil
+}
+
+func (r *fakeRepo) RetryOutbox(ctx context.Context, id int64, publishErr error, delay time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rows[id-1].lastError = publishErr.Error()
+	r.rows[id-1].availableAt = r.now.Add(delay)
+	return nil
+}
+
+func (r *fakeRepo) ReclaimSending(ctx context.Context, stuckFor time.Duration, limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]string, 0)
+	for id, since := range r.sending {
+		if len(ids) < limit && r.now.Sub(since) > stuckFor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		delete(r.sending, id)
+		msg := models.OutboxMessage{Id: int64(len(r.rows) + 1), NotificationId: id, DeliverAt: r.now}
+		r.rows = append(r.rows, &outboxRow{msg: msg, availableAt: r.now})
+	}
+	return len(ids), nil
+}
+
+func (r *fakeRepo) advance(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = r.now.Add(d)
+}
+
+func (r *fakeRepo) dispatched() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, row := range r.rows {
+		if row.dispatched {
+			n++
+		}
+	}
+	return n
+}
+
+// fakeScheduler records scheduled messages and fails while down is set.
+type fakeScheduler struct {
+	mu        sync.Mutex
+	lead      time.Duration
+	down      bool
+	published []string
+}
+
+func (s *fakeScheduler) Schedule(ctx context.Context, msg models.OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down {
+		return models.ErrNotConfirmed
+	}
+	s.published = append(s.published, msg.NotificationId)
+	return nil
+}
+
+func (s *fakeScheduler) Lead() time.Duration {
+	return s.lead
+}
+
+// newTestRelay creates a relay running on the repo's clock.
+func newTestRelay(repo *fakeRepo, sched *fakeScheduler) *Relay {
+	r := New(repo, sched, testCfg())
+	r.now = repo.clock
+	if r.wheel != nil {
+		r.wheel = newWheel(r.tick(), r.lookahead(), repo.clock())
+	}
+	return r
+}
+
+func testCtx() context.Context {
+	return context.WithValue(context.Background(), logger.LoggerKey, logger.New())
+}
+
+func testCfg() RelayConfig {
+	return RelayConfig{Interval: 1, BatchSize: 2, Lease: 30, ConfirmTimeout: 1, RetryDelay: 1, MaxRetryDelay: 8, Lookahead: 60, ReclaimAfter: 600}
+}
+
+func TestProcessDispatchesAllBatches(t *testing.T) {
+	t.Parallel()
+	repo := newFakeRepo(5)
+	pub := &fakeScheduler{lead: time.Hour}
+	newTestRelay(repo, pub).process(testCtx())
+
+	if got := repo.dispatched(); got != 5 {
+		t.Fatalf("expected 5 dispatched messages, got: %d", got)
+	}
+	if len(pub.published) != 5 || pub.published[0] != "a" || pub.published[4] != "e" {
+		t.Fatalf("unexpected publish order: %v", pub.published)
+	}
+}
+
+func TestProcessRetriesAfterBrokerOutage(t *testing.T) {
+	t.Parallel()
+	repo := newFakeRepo(3)
+	pub := &fakeScheduler{lead: time.Hour, down: true}
+	relay := newTestRelay(repo, pub)
+
+	relay.process(testCtx())
+	if got := repo.dispatched(); got != 0 {
+		t.Fatalf("expected nothing dispatched while the broker is down, got: %d", got)
+	}
+	if repo.rows[0].lastError != models.ErrNotConfirmed.Error() {
+		t.Fatalf("expected the publish error to be saved, got: %q", repo.rows[0].lastError)
+	}
+
+	pub.down = false
+	relay.process(testCtx())
+	if got := repo.dispatched(); got != 1 {
+		t.Fatalf("expected only the unclaimed message to be dispatched before the retry delay, got: %d", got)
+	}
+
+	// the failed batch is retried after RetryDelay, not after the whole lease
+	repo.advance(time.Duration(testCfg().RetryDelay) * time.Second)
+	relay.process(testCtx())
+	if got := repo.dispatched(); got != 3 {
+		t.Fatalf("expected 3 dispatched messages after the outage, got: %d", got)
+	}
+}
+
+func TestProcessResumesAfterCrash(t *testing.T) {
+	t.Parallel()
+	repo := newFakeRepo(2)
+
+	// a crashed relay claimed both messages and never published them
+	if _, err := repo.ClaimOutbox(context.Background(), 2, time.Hour, time.Duration(testCfg().Lease)*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	pub := &fakeScheduler{lead: time.Hour}
+	relay := newTestRelay(repo, pub)
+	relay.process(testCtx())
+	if len(pub.published) != 0 {
+		t.Fatalf("expected leased messages to be skipped, got: %v", pub.published)
+	}
+
+	repo.advance(time.Duration(testCfg().Lease) * time.Second)
+	relay.process(testCtx())
+	if got := repo.dispatched(); got != 2 {
+		t.Fatalf("expected 2 dispatched messages after the lease expired, got: %d", got)
+	}
+}
+
+func TestProcessReclaimsStuckSending(t *testing.T) {
+	t.Parallel()
+	repo := newFakeRepo(0)
+	reclaimAfter := time.Duration(testCfg().ReclaimAfter) * time.Second
+	// x, y and z were claimed by a consumer that crashed, w is still being sent
+	repo.sending = map[string]time.Time{
+		"x": repo.now.Add(-reclaimAfter - time.Second),
+		"y": repo.now.Add(-reclaimAfter - time.Minute),
+		"z": repo.now.Add(-2 * reclaimAfter),
+		"w": repo.now.Add(-time.Minute),
+	}
+
+	pub := &fakeScheduler{lead: time.Hour}
+	newTestRelay(repo, pub).process(testCtx())
+
+	if !slices.Equal(pub.published, []string{"x", "y", "z"}) {
+		t.Fatalf("expected the stuck notifications in every batch to be published again, got: %v", pub.published)
+	}
+	if _, ok := repo.sending["w"]; !ok || len(repo.sending) != 1 {
+		t.Fatalf("expected only w to stay in sending, got: %v", repo.sending)
+	}
+}
+
+func TestProcessLeavesMessagesBeyondLeadInDatabase(t *testing.T) {
+	t.Parallel()
+	repo := newFakeRepo(2, time.Minute, 3*time.Hour)
+	pub := &fakeScheduler{lead: time.Hour}
+	relay := newTestRelay(repo, pub)
+
+	relay.process(testCtx())
+	if len(pub.published) != 1 || pub.published[0] != "a" {
+		t.Fatalf("expected only the message within lead to be scheduled, got: %v", pub.published)
+	}
+	if relay.wheel != nil {
+		t.Fatal("expected no timing wheel for a scheduler with a long lead")
+	}
+
+	repo.advance(2 * time.Hour)
+	relay.process(testCtx())
+	if len(pub.published) != 2 {
+		t.Fatalf("expected the second message once it is within lead, got: %v", pub.published)
+	}
+}
+
+func TestProcessHoldsMessagesInWheelUntilDue(t *testing.T) {
+	t.Parallel()
+	repo := newFakeRepo(4, 0, 2500*time.Millisecond, 10*time.Second, 2*time.Minute)
+	pub := &fakeScheduler{}
+	relay := newTestRelay(repo, pub)
+
+	relay.process(testCtx())
+	if len(pub.published) != 1 || pub.published[0] != "a" {
+		t.Fatalf("expected only the due message to be scheduled, got: %v", pub.published)
+	}
+	if repo.rows[3].msg.Attempts != 0 {
+		t.Fatal("expected the message beyond lookahead to stay unclaimed")
+	}
+
+	steps := []struct {
+		after    time.Duration
+		expected []string
+	}{
+		{2 * time.Second, []string{"a"}},
+		{time.Second, []string{"a", "b"}},
+		{6 * time.Second, []string{"a", "b"}},
+		{time.Second, []string{"a", "b", "c"}},
+	}
+	for _, step := range steps {
+		repo.advance(step.after)
+		relay.process(testCtx())
+		if !slices.Equal(pub.published, step.expected) {
+			t.Fatalf("at %v expected %v, got: %v", repo.clock(), step.expected, pub.published)
+		}
+	}
+
+	repo.advance(2 * time.Minute)
+	relay.process(testCtx())
+	if got := repo.dispatched(); got != 4 {
+		t.Fatalf("expected 4 dispatched messages, got: %d", got)
+	}
+}
+
+func TestWheelNeverFiresEarly(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := newWheel(time.Second, 10*time.Second, start)
+
+	for ms := 0; ms <= 11000; ms += 250 {
+		w.add(models.OutboxMessage{Id: int64(ms), DeliverAt: start.Add(time.Duration(ms) * time.Millisecond)})
+	}
+
+	fired := 0
+	for now := start; now.Before(start.Add(13 * time.Second)); now = now.Add(100 * time.Millisecond) {
+		for _, msg := range w.advance(now) {
+			fired++
+			if msg.DeliverAt.After(now) {
+				t.Fatalf("message due at %v fired at %v", msg.DeliverAt, now)
+			}
+			if now.Sub(msg.DeliverAt) > time.Second {
+				t.Fatalf("message due at %v fired late at %v", msg.DeliverAt, now)
+			}
+		}
+	}
+	if fired != 45 {
+		t.Fatalf("expected 45 fired messages, got: %d", fired)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	relay := New(nil, &fakeScheduler{}, testCfg())
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i, want := range expected {
+		if got := relay.backoff(i + 1); got != want {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestRelayConfigValid(t *testing.T) {
+	t.Parallel()
+	if err := testCfg().Valid(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testCfg()
+	cfg.MaxRetryDelay = 0
+	if err := cfg.Valid(); !errors.Is(err, models.ErrBadRelayCfg) {
+		t.Fatalf("expected ErrBadRelayCfg, got: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package relay
+
+import (
+	"app/internal/models"
+	"time"
+)
+
+// wheel is a hashed timing wheel with one slot per tick. Messages are never added
+// more than span ahead, so a message never has to wait for more than one revolution
+// and slots don't need round counters.
+type wheel struct {
+	tick   time.Duration
+	slots  [][]models.OutboxMessage
+	cursor int
+	// last is when the slot at cursor fired
+	last time.Time
+}
+
+func newWheel(tick, span time.Duration, now time.Time) *wheel {
+	return &wheel{
+		tick:  tick,
+		slots: make([][]models.OutboxMessage, int(span/tick)+2),
+		last:  now,
+	}
+}
+
+// add puts msg into the first slot firing not earlier than msg.DeliverAt.
+func (w *wheel) add(msg models.OutboxMessage) {
+	wait := msg.DeliverAt.Sub(w.last)
+	ticks := int((wait + w.tick - 1) / w.tick)
+	ticks = min(max(ticks, 1), len(w.slots)-1)
+
+	i := (w.cursor + ticks) % len(w.slots)
+	w.slots[i] = append(w.slots[i], msg)
+}
+
+// advance fires every slot up to now and returns their messages.
+func (w *wheel) advance(now time.Time) []models.OutboxMessage {
+	due := make([]models.OutboxMessage, 0)
+	for !w.last.Add(w.tick).After(now) {
+		w.last = w.last.Add(w.tick)
+		w.cursor = (w.cursor + 1) % len(w.slots)
+		due = append(due, w.slots[w.cursor]...)
+		w.slots[w.cursor] = nil
+	}
+	return due
+}
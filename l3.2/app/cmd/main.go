@@ -2,14 +2,24 @@ package main
 
 import (
 	"app/internal/config"
+	"app/internal/models"
 	"app/internal/repository"
 	"app/internal/service"
 	"app/internal/transport"
+	"app/pkg/cache"
+	"app/pkg/codegen"
 	"app/pkg/data"
+	"app/pkg/enrich"
+	"app/pkg/geo"
 	"app/pkg/logger"
+	"app/pkg/preview"
+	"app/pkg/safety"
+	"app/pkg/wrk/clicks"
+	"app/pkg/wrk/rollup"
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
@@ -20,14 +30,35 @@ func main() {
 	cfg := config.New()
 	data := data.New(cfg.DataConfig)
 	repo := repository.New(data)
-	service := service.New(repo)
+	gen := codegen.New(cfg.CodegenConfig, repo)
+	linkCache := cache.New[string, models.Link](cfg.CacheConfig)
+	geo := geo.New(cfg.EnrichConfig.GeoIPDb)
+	pipeline := clicks.New(repo, enrich.New(cfg.EnrichConfig, geo), cfg.ClicksConfig)
+	aggregator := rollup.New(repo, cfg.RollupConfig)
+	checker := safety.New(cfg.SafetyConfig, cfg.ServiceConfig.BaseURL)
+	previewer := preview.New(repo, cfg.PreviewConfig, ctx)
+	service := service.New(repo, gen, linkCache, pipeline, checker, previewer, cfg.ServiceConfig)
 	server := transport.New(service, &cfg.ServerConfig, ctx)
 
 	graceCh := make(chan os.Signal, 1)
 	signal.Notify(graceCh, syscall.SIGINT, syscall.SIGTERM)
 
+	clicksCtx, clicksCanc := context.WithCancel(ctx)
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go pipeline.Start(clicksCtx, wg)
+	go aggregator.Start(clicksCtx, wg)
+
 	go server.Start()
 
 	<-graceCh
+	// Redirects push clicks, so the server stops first and the pipeline
+	// flushes everything they pushed.
 	server.Stop()
+	previewer.Close()
+	clicksCanc()
+	wg.Wait()
+	if err := geo.Close(); err != nil {
+		lg.Lg.Error().Err(err).Msg("while closing geoip database")
+	}
 }
@@ -2,8 +2,16 @@ package config
 
 import (
 	"app/internal/models"
+	"app/internal/service"
 	"app/internal/transport"
+	"app/pkg/cache"
+	"app/pkg/codegen"
 	"app/pkg/data"
+	"app/pkg/enrich"
+	"app/pkg/preview"
+	"app/pkg/safety"
+	"app/pkg/wrk/clicks"
+	"app/pkg/wrk/rollup"
 	"fmt"
 	"strconv"
 
@@ -12,8 +20,16 @@ import (
 )
 
 type Config struct {
-	ServerConfig transport.ServerConfig
-	DataConfig   data.DataConfig
+	ServerConfig  transport.ServerConfig
+	DataConfig    data.DataConfig
+	CodegenConfig codegen.Config
+	CacheConfig   cache.CacheConfig
+	ClicksConfig  clicks.ClicksConfig
+	EnrichConfig  enrich.EnrichConfig
+	RollupConfig  rollup.RollupConfig
+	ServiceConfig service.ServiceConfig
+	SafetyConfig  safety.SafetyConfig
+	PreviewConfig preview.PreviewConfig
 }
 
 func (c *Config) valid() error {
@@ -27,6 +43,24 @@ func (c *Config) valid() error {
 	if err := validReleaseMode(c.ServerConfig.ReleaseMode); err != nil {
 		resultErr = multierr.Append(resultErr, err)
 	}
+	if err := c.CodegenConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.CacheConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.ClicksConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.RollupConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.ServiceConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.PreviewConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
 	return resultErr
 }
 
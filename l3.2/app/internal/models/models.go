@@ -1,21 +1,50 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"time"
 )
 
 var (
-	ErrNonExistURL       = errors.New("non exist url")
-	ErrNonShorten        = errors.New("non exist shorten")
-	ErrAlreadyExistURL   = errors.New("already exist url")
-	ErrOccupiedShortCode = errors.New("occupied short code")
-	ErrBadPort           = errors.New("bad port value")
-	ErrBadReleaseMode    = errors.New("bad release mode value")
-	ErrBadURL            = errors.New("bad url value")
+	ErrNonExistURL        = errors.New("non exist url")
+	ErrNonShorten         = errors.New("non exist shorten")
+	ErrOccupiedShortCode  = errors.New("occupied short code")
+	ErrGoneURL            = errors.New("url is expired or inactive")
+	ErrBadPort            = errors.New("bad port value")
+	ErrBadReleaseMode     = errors.New("bad release mode value")
+	ErrBadURL             = errors.New("bad url value")
+	ErrBadAlias           = errors.New("bad alias value")
+	ErrBadLinkParams      = errors.New("bad link params")
+	ErrEmptyPatch         = errors.New("empty patch")
+	ErrBadPagination      = errors.New("bad limit or offset value")
+	ErrBadCodegenCfg      = errors.New("bad code generator config")
+	ErrCodeSpaceExhausted = errors.New("short code space exhausted")
+	ErrNoFreeCode         = errors.New("failed to find a free short code")
+	ErrBadCacheCfg        = errors.New("bad cache config")
+	ErrBadClicksCfg       = errors.New("bad clicks pipeline config")
+	ErrBadRollupCfg       = errors.New("bad rollup config")
+	ErrBadAnalyticsQuery  = errors.New("bad from, to or group_by value")
+	ErrBadPreviewCfg      = errors.New("bad preview config")
+	ErrBadBaseURL         = errors.New("bad base url value")
+	ErrBlockedURL         = errors.New("url is blocked")
+	ErrLoopURL            = errors.New("url points to the shortener itself")
+	ErrBadQRParams        = errors.New("bad qr format or size")
+)
+
+const (
+	StatusActive    = "active"
+	StatusInactive  = "inactive"
+	StatusExpired   = "expired"
+	StatusExhausted = "exhausted"
 )
 
 type ShortenRequest struct {
-	URL string `json:"url" validate:"required,url"`
+	URL       string     `json:"url" validate:"required,url"`
+	Alias     string     `json:"alias"`
+	Owner     string     `json:"owner" validate:"max=64"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxClicks *int       `json:"max_clicks" validate:"omitempty,min=1"`
 }
 
 type ShortenResponse struct {
@@ -23,14 +52,148 @@ type ShortenResponse struct {
 	ShortCode   string `json:"short_code"`
 }
 
-type ClickData struct {
-	Date  string `json:"date"`
-	Count int    `json:"count"`
+// LinkPatch holds the fields to change, nil fields are left as is. The limits
+// are Nullable, an explicit null removes them.
+type LinkPatch struct {
+	URL       *string             `json:"url" validate:"omitempty,url"`
+	ExpiresAt Nullable[time.Time] `json:"expires_at"`
+	MaxClicks Nullable[int]       `json:"max_clicks"`
+	IsActive  *bool               `json:"is_active"`
+}
+
+// Nullable tells a field missing from JSON, with Set false, from an explicit
+// null, with Set true and a nil Value.
+type Nullable[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (n *Nullable[T]) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if string(data) == "null" {
+		n.Value = nil
+		return nil
+	}
+	return json.Unmarshal(data, &n.Value)
+}
+
+type Link struct {
+	Id          int64      `json:"-"`
+	ShortCode   string     `json:"short_code"`
+	OriginalURL string     `json:"original_url"`
+	Owner       string     `json:"owner,omitempty"`
+	Clicks      int64      `json:"clicks"`
+	MaxClicks   *int       `json:"max_clicks,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	IsActive    bool       `json:"is_active"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	Preview
+}
+
+// Preview is what the destination page says about itself.
+type Preview struct {
+	Title       string `json:"preview_title,omitempty"`
+	Description string `json:"preview_description,omitempty"`
+}
+
+// StatusAt mirrors the status computed by the repository, it is used for
+// links served from the cache.
+func (l *Link) StatusAt(now time.Time) string {
+	switch {
+	case !l.IsActive:
+		return StatusInactive
+	case l.ExpiresAt != nil && !l.ExpiresAt.After(now):
+		return StatusExpired
+	case l.MaxClicks != nil && l.Clicks >= int64(*l.MaxClicks):
+		return StatusExhausted
+	default:
+		return StatusActive
+	}
+}
+
+type LinksFilter struct {
+	Owner  string
+	Limit  int
+	Offset int
+}
+
+type LinksPage struct {
+	Links  []Link `json:"links"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+const (
+	GroupByHour     = "hour"
+	GroupByDay      = "day"
+	GroupByBrowser  = "browser"
+	GroupByOS       = "os"
+	GroupByDevice   = "device"
+	GroupByCountry  = "country"
+	GroupByReferrer = "referrer"
+)
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// Visit is what a redirect knows about the visitor.
+type Visit struct {
+	UserAgent string
+	Referrer  string
+	IP        string
+}
+
+type Click struct {
+	Visit
+	UrlId     int64
+	ClickedAt time.Time
+	// Counted is set when urls.clicks was already incremented on redirect.
+	Counted bool
+
+	// Filled in by the clicks pipeline, IP may be anonymized by then.
+	ReferrerHost string
+	VisitorHash  string
+	Browser      string
+	OS           string
+	Device       string
+	Country      string
+}
+
+type ClickStats struct {
+	Accepted int64 `json:"accepted"`
+	Dropped  int64 `json:"dropped"`
+	Written  int64 `json:"written"`
+	Failed   int64 `json:"failed"`
+	Batches  int64 `json:"batches"`
+	Queued   int   `json:"queued"`
+}
+
+type AnalyticsQuery struct {
+	ShortCode string
+	From      time.Time
+	To        time.Time
+	GroupBy   string
+}
+
+type AnalyticsGroup struct {
+	Key      string `json:"key"`
+	Clicks   int64  `json:"clicks"`
+	Visitors int64  `json:"visitors"`
 }
 
 type AnalyticsResponse struct {
-	ShortCode   string      `json:"short_code"`
-	TotalClicks int         `json:"total_clicks"`
-	ClicksByDay []ClickData `json:"clicks_by_day"`
-	UserAgents  []string    `json:"user_agents"`
+	ShortCode      string           `json:"short_code"`
+	From           time.Time        `json:"from"`
+	To             time.Time        `json:"to"`
+	GroupBy        string           `json:"group_by"`
+	TotalClicks    int64            `json:"total_clicks"`
+	UniqueVisitors int64            `json:"unique_visitors"`
+	Groups         []AnalyticsGroup `json:"groups"`
 }
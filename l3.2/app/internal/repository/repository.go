@@ -5,7 +5,13 @@ import (
 	"app/pkg/data"
 	"context"
 	"database/sql"
-	"net/http"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
 )
 
 type Repository struct {
@@ -16,101 +22,335 @@ func New(data *data.Data) *Repository {
 	return &Repository{data: data}
 }
 
-func (r *Repository) CreateLink(orig string, shorten string) error {
-	q := "INSERT INTO urls (original_url, short_code, created_at, is_active) VALUES ($1, $2, NOW(), true) RETURNING id, original_url, short_code, created_at;"
-	_, err := r.data.DB.ExecContext(context.Background(), q, orig, shorten)
+const (
+	linkColumns = "id, short_code, original_url, COALESCE(owner, ''), clicks, max_clicks, expires_at, is_active, created_at, updated_at, COALESCE(preview_title, ''), COALESCE(preview_description, ''), " + statusExpr
+	// statusExpr must stay in sync with aliveCond and models.Link.StatusAt.
+	statusExpr = `CASE WHEN NOT is_active THEN 'inactive'
+		WHEN expires_at IS NOT NULL AND expires_at <= NOW() THEN 'expired'
+		WHEN max_clicks IS NOT NULL AND clicks >= max_clicks THEN 'exhausted'
+		ELSE 'active' END`
+	aliveCond = "is_active AND (expires_at IS NULL OR expires_at > NOW()) AND (max_clicks IS NULL OR clicks < max_clicks)"
+)
+
+const uniqueViolation = "23505"
+
+type scanner interface {
+	Scan(...any) error
+}
+
+func scanLink(row scanner) (*models.Link, error) {
+	var l models.Link
+	var maxClicks sql.NullInt32
+	var expiresAt sql.NullTime
+	err := row.Scan(&l.Id, &l.ShortCode, &l.OriginalURL, &l.Owner, &l.Clicks, &maxClicks, &expiresAt, &l.IsActive, &l.CreatedAt, &l.UpdatedAt, &l.Title, &l.Description, &l.Status)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	if maxClicks.Valid {
+		v := int(maxClicks.Int32)
+		l.MaxClicks = &v
+	}
+	if expiresAt.Valid {
+		l.ExpiresAt = &expiresAt.Time
+	}
+	return &l, nil
 }
 
-func (r *Repository) CheckOriginalLink(url string) bool {
-	q := "SELECT short_code FROM urls WHERE original_url = $1;"
-
-	err := r.data.DB.QueryRowContext(context.Background(), q, url).Scan()
-	if err == sql.ErrNoRows {
-		return false
-	} else {
-		return true
+func (r *Repository) CreateLink(ctx context.Context, req *models.ShortenRequest, shorten string) error {
+	q := "INSERT INTO urls (original_url, short_code, owner, expires_at, max_clicks, created_at, is_active) VALUES ($1, $2, NULLIF($3, ''), $4, $5, NOW(), true);"
+	_, err := r.data.DB.ExecContext(ctx, q, req.URL, shorten, req.Owner, req.ExpiresAt, req.MaxClicks)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return models.ErrOccupiedShortCode
 	}
+	return err
+}
+
+// AllocateRange reserves the next size ids for a code generator.
+func (r *Repository) AllocateRange(ctx context.Context, size int64) (int64, error) {
+	q := "UPDATE code_ranges SET next_id = next_id + $1 WHERE name = 'urls' RETURNING next_id - $1;"
+
+	var start int64
+	err := r.data.DB.Master.QueryRowContext(ctx, q, size).Scan(&start)
+	return start, err
 }
 
-func (r *Repository) CheckShorten(url string) bool {
-	q := "SELECT id FROM urls WHERE short_code = $1;"
+// CountClick increments the counter of a link with max_clicks on redirect,
+// so the limit can't be exceeded by concurrent redirects.
+func (r *Repository) CountClick(ctx context.Context, urlId int64) error {
+	q := "UPDATE urls SET clicks = clicks + 1 WHERE id = $1 AND " + aliveCond + " RETURNING id;"
 
-	err := r.data.DB.QueryRowContext(context.Background(), q, url).Scan()
+	err := r.data.DB.Master.QueryRowContext(ctx, q, urlId).Scan(&urlId)
 	if err == sql.ErrNoRows {
-		return false
-	} else {
-		return true
+		return models.ErrGoneURL
 	}
+	return err
 }
 
-func (r *Repository) Redirect(req *http.Request) (string, error) {
-	q1 := "SELECT id, original_url FROM urls WHERE short_code = $1 AND is_active = true;"
-	q2 := "INSERT INTO clicks (url_id, clicked_at, user_agent) VALUES ($1, NOW(), $2);"
+// WriteClicks copies the batch into clicks and adds clicks not yet counted
+// on redirect to urls.clicks, both in one transaction.
+func (r *Repository) WriteClicks(ctx context.Context, batch []models.Click) error {
+	tx, err := r.data.DB.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	var originalURL, id string
-	err := r.data.DB.QueryRowContext(context.Background(), q1, req.URL.String()[3:]).Scan(&id, &originalURL)
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("clicks", "url_id", "clicked_at", "user_agent", "referrer", "referrer_host",
+		"ip", "visitor_hash", "browser", "os", "device", "country"))
 	if err != nil {
-		return "", models.ErrNonExistURL
-	} else {
-		// go func() {
-		// 	_, err = r.data.DB.ExecContext(context.Background(), q2, id, req.UserAgent())
-		// }()
-		_, err = r.data.DB.ExecContext(context.Background(), q2, id, req.UserAgent())
+		return err
+	}
+	uncounted := map[int64]int64{}
+	for _, c := range batch {
+		_, err = stmt.ExecContext(ctx, c.UrlId, c.ClickedAt, c.UserAgent, nullIfEmpty(c.Referrer), nullIfEmpty(c.ReferrerHost),
+			nullIfEmpty(c.IP), c.VisitorHash, c.Browser, c.OS, c.Device, nullIfEmpty(c.Country))
 		if err != nil {
-			return "", err
+			stmt.Close()
+			return err
+		}
+		if !c.Counted {
+			uncounted[c.UrlId]++
 		}
-		return originalURL, nil
 	}
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return err
+	}
+	if err = stmt.Close(); err != nil {
+		return err
+	}
+
+	if len(uncounted) > 0 {
+		// Sorted ids lock rows in the same order as concurrent writers.
+		ids := slices.Sorted(maps.Keys(uncounted))
+		counts := make([]int64, 0, len(ids))
+		for _, id := range ids {
+			counts = append(counts, uncounted[id])
+		}
+		q := `UPDATE urls SET clicks = urls.clicks + c.n
+		FROM (SELECT unnest($1::bigint[]) AS id, unnest($2::bigint[]) AS n) c
+		WHERE urls.id = c.id;`
+		if _, err = tx.ExecContext(ctx, q, pq.Array(ids), pq.Array(counts)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func (r *Repository) GetAnalytics(shortCode string) (*models.AnalyticsResponse, error) {
-	var res models.AnalyticsResponse
-	var urlID int
+func (r *Repository) GetLink(ctx context.Context, shortCode string) (*models.Link, error) {
+	q := "SELECT " + linkColumns + " FROM urls WHERE short_code = $1;"
 
-	err := r.data.DB.QueryRowContext(context.Background(), "SELECT id FROM urls WHERE short_code = $1", shortCode).Scan(&urlID)
-	if err != nil {
+	l, err := scanLink(r.data.DB.QueryRowContext(ctx, q, shortCode))
+	if err == sql.ErrNoRows {
 		return nil, models.ErrNonExistURL
 	}
-	res.ShortCode = shortCode
+	return l, err
+}
 
-	err = r.data.DB.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM clicks WHERE url_id = $1", urlID).Scan(&res.TotalClicks)
+func (r *Repository) ListLinks(ctx context.Context, f models.LinksFilter) (*models.LinksPage, error) {
+	res := models.LinksPage{Links: []models.Link{}, Limit: f.Limit, Offset: f.Offset}
+
+	err := r.data.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM urls WHERE ($1 = '' OR owner = $1);", f.Owner).Scan(&res.Total)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := r.data.DB.QueryContext(context.Background(), `SELECT DATE(clicked_at) as date, COUNT(*) as count FROM clicks WHERE url_id = $1 GROUP BY DATE(clicked_at) ORDER BY date DESC`, urlID)
+	q := "SELECT " + linkColumns + " FROM urls WHERE ($1 = '' OR owner = $1) ORDER BY id DESC LIMIT $2 OFFSET $3;"
+	rows, err := r.data.DB.QueryContext(ctx, q, f.Owner, f.Limit, f.Offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var cd models.ClickData
-		err = rows.Scan(&cd.Date, &cd.Count)
+		l, err := scanLink(rows)
 		if err != nil {
 			return nil, err
 		}
-		res.ClicksByDay = append(res.ClicksByDay, cd)
+		res.Links = append(res.Links, *l)
 	}
+	return &res, rows.Err()
+}
+
+func (r *Repository) UpdateLink(ctx context.Context, shortCode string, patch *models.LinkPatch) (*models.Link, error) {
+	q := `UPDATE urls SET
+		original_url = COALESCE($2, original_url),
+		expires_at = CASE WHEN $3 THEN $4::timestamptz ELSE expires_at END,
+		max_clicks = CASE WHEN $5 THEN $6::integer ELSE max_clicks END,
+		is_active = COALESCE($7, is_active),
+		updated_at = NOW()
+	WHERE short_code = $1
+	RETURNING ` + linkColumns + ";"
 
-	rows, err = r.data.DB.QueryContext(context.Background(), `SELECT user_agent FROM clicks WHERE url_id = $1 AND user_agent IS NOT NULL GROUP BY user_agent ORDER BY MAX(clicked_at) DESC LIMIT 20`, urlID)
+	l, err := scanLink(r.data.DB.Master.QueryRowContext(ctx, q, shortCode, patch.URL,
+		patch.ExpiresAt.Set, patch.ExpiresAt.Value, patch.MaxClicks.Set, patch.MaxClicks.Value, patch.IsActive))
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNonExistURL
+	}
+	return l, err
+}
+
+func (r *Repository) SavePreview(ctx context.Context, shortCode string, p models.Preview) error {
+	q := "UPDATE urls SET preview_title = $2, preview_description = $3, preview_fetched_at = NOW() WHERE short_code = $1;"
+	_, err := r.data.DB.ExecContext(ctx, q, shortCode, p.Title, p.Description)
+	return err
+}
+
+func (r *Repository) DeactivateLink(ctx context.Context, shortCode string) error {
+	res, err := r.data.DB.ExecContext(ctx, "UPDATE urls SET is_active = false, updated_at = NOW() WHERE short_code = $1;", shortCode)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return models.ErrNonExistURL
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// RollupClicks claims clicks with SKIP LOCKED and recomputes the hourly and
+// daily buckets they touch in one transaction. Clicks committed after the
+// snapshot are claimed by a later call and recompute their buckets again.
+func (r *Repository) RollupClicks(ctx context.Context, limit int) (int, error) {
+	tx, err := r.data.DB.Master.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Aggregators take turns, so an older snapshot can't overwrite a bucket
+	// recomputed by a newer one.
+	if _, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('click_rollups'));"); err != nil {
+		return 0, err
+	}
+
+	q := `WITH claimed AS (
+		UPDATE clicks SET rolled_up = true
+		WHERE id IN (SELECT id FROM clicks WHERE NOT rolled_up ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING url_id, clicked_at
+	)
+	SELECT url_id, date_trunc('hour', clicked_at, 'UTC'), COUNT(*) FROM claimed GROUP BY 1, 2;`
+	rows, err := tx.QueryContext(ctx, q, limit)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var claimed int
+	var hourIds, dayIds []int64
+	var hours, days []string
+	seenDays := map[string]bool{}
+	for rows.Next() {
+		var urlId int64
+		var bucket time.Time
+		var n int
+		if err = rows.Scan(&urlId, &bucket, &n); err != nil {
+			return 0, err
+		}
+		claimed += n
+		hourIds, hours = append(hourIds, urlId), append(hours, bucket.UTC().Format(time.RFC3339))
+
+		day := bucket.UTC().Truncate(24 * time.Hour).Format(time.RFC3339)
+		if key := fmt.Sprint(urlId, day); !seenDays[key] {
+			seenDays[key] = true
+			dayIds, days = append(dayIds, urlId), append(days, day)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if claimed == 0 {
+		return 0, nil
+	}
+
+	for _, rollup := range []struct {
+		table, step string
+		ids         []int64
+		buckets     []string
+	}{
+		{"click_rollups_hourly", "1 hour", hourIds, hours},
+		{"click_rollups_daily", "1 day", dayIds, days},
+	} {
+		q := `INSERT INTO ` + rollup.table + ` (url_id, bucket, clicks, visitors)
+		SELECT t.url_id, t.bucket, COUNT(*), COUNT(DISTINCT c.visitor_hash)
+		FROM unnest($1::int[], $2::timestamptz[]) AS t(url_id, bucket)
+		JOIN clicks c ON c.url_id = t.url_id AND c.clicked_at >= t.bucket AND c.clicked_at < t.bucket + interval '` + rollup.step + `'
+		GROUP BY t.url_id, t.bucket
+		ON CONFLICT (url_id, bucket) DO UPDATE SET clicks = EXCLUDED.clicks, visitors = EXCLUDED.visitors;`
+		if _, err = tx.ExecContext(ctx, q, pq.Array(rollup.ids), pq.Array(rollup.buckets)); err != nil {
+			return 0, err
+		}
+	}
+
+	return claimed, tx.Commit()
+}
+
+// groupColumns maps group_by values to click columns, keys also whitelist
+// what gets into the query.
+var groupColumns = map[string]string{
+	models.GroupByBrowser:  "browser",
+	models.GroupByOS:       "os",
+	models.GroupByDevice:   "device",
+	models.GroupByCountry:  "country",
+	models.GroupByReferrer: "referrer_host",
+}
+
+// GetAnalytics counts totals over raw clicks and reads time series from the
+// rollups, which lag behind by up to the rollup interval.
+func (r *Repository) GetAnalytics(ctx context.Context, aq models.AnalyticsQuery) (*models.AnalyticsResponse, error) {
+	res := models.AnalyticsResponse{ShortCode: aq.ShortCode, From: aq.From, To: aq.To, GroupBy: aq.GroupBy, Groups: []models.AnalyticsGroup{}}
+
+	var urlId int64
+	q := `SELECT u.id, COUNT(c.id), COUNT(DISTINCT c.visitor_hash)
+	FROM urls u LEFT JOIN clicks c ON c.url_id = u.id AND c.clicked_at >= $2 AND c.clicked_at < $3
+	WHERE u.short_code = $1
+	GROUP BY u.id;`
+	err := r.data.DB.QueryRowContext(ctx, q, aq.ShortCode, aq.From, aq.To).Scan(&urlId, &res.TotalClicks, &res.UniqueVisitors)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNonExistURL
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch aq.GroupBy {
+	case models.GroupByHour, models.GroupByDay:
+		table := map[string]string{models.GroupByHour: "click_rollups_hourly", models.GroupByDay: "click_rollups_daily"}[aq.GroupBy]
+		q = `SELECT to_char(bucket AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'), clicks, visitors FROM ` + table + `
+		WHERE url_id = $1 AND bucket >= date_trunc('` + aq.GroupBy + `', $2::timestamptz, 'UTC') AND bucket < $3
+		ORDER BY bucket;`
+	default:
+		fallback := "unknown"
+		if aq.GroupBy == models.GroupByReferrer {
+			fallback = "direct"
+		}
+		q = `SELECT COALESCE(NULLIF(` + groupColumns[aq.GroupBy] + `, ''), '` + fallback + `') AS key, COUNT(*), COUNT(DISTINCT visitor_hash)
+		FROM clicks WHERE url_id = $1 AND clicked_at >= $2 AND clicked_at < $3
+		GROUP BY key ORDER BY 2 DESC, key;`
+	}
+
+	rows, err := r.data.DB.QueryContext(ctx, q, urlId, aq.From, aq.To)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var ua string
-		err = rows.Scan(&ua)
-		if err != nil {
+		var g models.AnalyticsGroup
+		if err = rows.Scan(&g.Key, &g.Clicks, &g.Visitors); err != nil {
 			return nil, err
 		}
-		res.UserAgents = append(res.UserAgents, ua)
+		res.Groups = append(res.Groups, g)
 	}
 
-	return &res, nil
+	return &res, rows.Err()
 }
@@ -2,69 +2,295 @@ package service
 
 import (
 	"app/internal/models"
-	"math/rand/v2"
-	"net/http"
+	"app/pkg/qr"
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 )
 
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+
+	// maxCodeAttempts bounds retries when a generated code is taken,
+	// which only happens for random codes or a clash with a custom alias.
+	maxCodeAttempts = 5
+
+	defaultAnalyticsRange = 30 * 24 * time.Hour
+)
+
+var aliasRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{3,32}$`)
+
+type CodeGenerator interface {
+	Next(context.Context) (string, error)
+}
+
+type ServiceConfig struct {
+	BaseURL string `env:"BASE_URL" env-default:"http://localhost:8080"`
+}
+
+// Valid checks that the base url is absolute.
+func (cfg ServiceConfig) Valid() error {
+	u, err := url.Parse(cfg.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return models.ErrBadBaseURL
+	}
+	return nil
+}
+
+type URLChecker interface {
+	Check(string) error
+}
+
+type PreviewInterface interface {
+	Refresh(shortCode string, url string)
+}
+
+type LinkCache interface {
+	Get(string) (models.Link, bool)
+	Set(string, models.Link)
+	Delete(string)
+}
+
+type ClicksInterface interface {
+	Push(models.Click) bool
+	Stats() models.ClickStats
+}
+
 type RepositoryInterface interface {
-	CheckOriginalLink(string) bool
-	CheckShorten(string) bool
-	CreateLink(string, string) error
-	Redirect(*http.Request) (string, error)
-	GetAnalytics(string) (*models.AnalyticsResponse, error)
+	CreateLink(context.Context, *models.ShortenRequest, string) error
+	CountClick(context.Context, int64) error
+	GetLink(context.Context, string) (*models.Link, error)
+	ListLinks(context.Context, models.LinksFilter) (*models.LinksPage, error)
+	UpdateLink(context.Context, string, *models.LinkPatch) (*models.Link, error)
+	DeactivateLink(context.Context, string) error
+	GetAnalytics(context.Context, models.AnalyticsQuery) (*models.AnalyticsResponse, error)
 }
 
 type Service struct {
-	repo RepositoryInterface
-	vld  *validator.Validate
+	repo     RepositoryInterface
+	gen      CodeGenerator
+	cache    LinkCache
+	clicks   ClicksInterface
+	checker  URLChecker
+	previews PreviewInterface
+	cfg      ServiceConfig
+	vld      *validator.Validate
 }
 
-func New(repo RepositoryInterface) *Service {
-	return &Service{repo, validator.New(validator.WithRequiredStructEnabled())}
+func New(repo RepositoryInterface, gen CodeGenerator, cache LinkCache, clicks ClicksInterface, checker URLChecker, previews PreviewInterface, cfg ServiceConfig) *Service {
+	return &Service{repo, gen, cache, clicks, checker, previews, cfg, validator.New(validator.WithRequiredStructEnabled())}
 }
 
-func (s Service) CreateLink(data *models.ShortenRequest) (string, error) {
+func (s Service) CreateLink(ctx context.Context, data *models.ShortenRequest) (string, error) {
 	if err := s.vld.Struct(data); err != nil {
-		return "", models.ErrBadURL
+		return "", validationErr(err)
+	}
+	if data.ExpiresAt != nil && !data.ExpiresAt.After(time.Now()) {
+		return "", models.ErrBadLinkParams
+	}
+	if err := s.checker.Check(data.URL); err != nil {
+		return "", err
+	}
+
+	shorten, err := s.insert(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	s.previews.Refresh(shorten, data.URL)
+	return shorten, nil
+}
+
+func (s Service) insert(ctx context.Context, data *models.ShortenRequest) (string, error) {
+	if data.Alias != "" {
+		if !aliasRegex.MatchString(data.Alias) {
+			return "", models.ErrBadAlias
+		}
+		if err := s.repo.CreateLink(ctx, data, data.Alias); err != nil {
+			return "", err
+		}
+		return data.Alias, nil
+	}
+
+	for range maxCodeAttempts {
+		shorten, err := s.gen.Next(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		err = s.repo.CreateLink(ctx, data, shorten)
+		if err == nil {
+			return shorten, nil
+		} else if !errors.Is(err, models.ErrOccupiedShortCode) {
+			return "", err
+		}
+	}
+
+	return "", models.ErrNoFreeCode
+}
+
+// Redirect resolves the link through the cache and hands the click over to the
+// pipeline. Links with max_clicks aren't cached and are counted synchronously,
+// so the limit stays exact. Other instances see edits after the cache ttl.
+func (s Service) Redirect(ctx context.Context, shortCode string, visit models.Visit) (string, error) {
+	link, ok := s.cache.Get(shortCode)
+	if !ok {
+		l, err := s.repo.GetLink(ctx, shortCode)
+		if err != nil {
+			return "", err
+		}
+		link = *l
+		if link.MaxClicks == nil {
+			s.cache.Set(shortCode, link)
+		}
 	}
 
-	if s.repo.CheckOriginalLink(data.URL) {
-		return "", models.ErrAlreadyExistURL
+	now := time.Now()
+	if link.StatusAt(now) != models.StatusActive {
+		return "", models.ErrGoneURL
 	}
 
-	shorten := newShorten()
-	for s.repo.CheckShorten(shorten) {
-		shorten = newShorten()
+	click := models.Click{Visit: visit, UrlId: link.Id, ClickedAt: now}
+	if link.MaxClicks != nil {
+		if err := s.repo.CountClick(ctx, link.Id); err != nil {
+			return "", err
+		}
+		click.Counted = true
 	}
+	s.clicks.Push(click)
 
-	err := s.repo.CreateLink(data.URL, shorten)
+	return link.OriginalURL, nil
+}
+
+func (s Service) ClickStats() models.ClickStats {
+	return s.clicks.Stats()
+}
+
+func (s Service) GetLink(ctx context.Context, shortCode string) (*models.Link, error) {
+	return s.repo.GetLink(ctx, shortCode)
+}
+
+func (s Service) ListLinks(ctx context.Context, f models.LinksFilter) (*models.LinksPage, error) {
+	if f.Limit == 0 {
+		f.Limit = defaultLimit
+	}
+	if f.Limit < 0 || f.Limit > maxLimit || f.Offset < 0 {
+		return nil, models.ErrBadPagination
+	}
+	return s.repo.ListLinks(ctx, f)
+}
+
+func (s Service) UpdateLink(ctx context.Context, shortCode string, patch *models.LinkPatch) (*models.Link, error) {
+	if patch.URL == nil && !patch.ExpiresAt.Set && !patch.MaxClicks.Set && patch.IsActive == nil {
+		return nil, models.ErrEmptyPatch
+	}
+	if err := s.vld.Struct(patch); err != nil {
+		return nil, validationErr(err)
+	}
+	if expiresAt := patch.ExpiresAt.Value; expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, models.ErrBadLinkParams
+	}
+	if maxClicks := patch.MaxClicks.Value; maxClicks != nil && *maxClicks < 1 {
+		return nil, models.ErrBadLinkParams
+	}
+	if patch.URL != nil {
+		if err := s.checker.Check(*patch.URL); err != nil {
+			return nil, err
+		}
+	}
+	link, err := s.repo.UpdateLink(ctx, shortCode, patch)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	s.cache.Delete(shortCode)
+	if patch.URL != nil {
+		s.previews.Refresh(shortCode, link.OriginalURL)
 	}
+	return link, nil
+}
 
-	return shorten, nil
+// Preview returns the link for the interstitial page, dead links are gone
+// there too.
+func (s Service) Preview(ctx context.Context, shortCode string) (*models.Link, error) {
+	link, err := s.repo.GetLink(ctx, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if link.StatusAt(time.Now()) != models.StatusActive {
+		return nil, models.ErrGoneURL
+	}
+	return link, nil
+}
+
+// QRCode renders the short url of an active link as a png or svg, dead links
+// are gone there too.
+func (s Service) QRCode(ctx context.Context, shortCode string, format string, size int) ([]byte, error) {
+	if size < qr.MinSize || size > qr.MaxSize {
+		return nil, models.ErrBadQRParams
+	}
+	render := map[string]func(string, int) ([]byte, error){"png": qr.PNG, "svg": qr.SVG}[format]
+	if render == nil {
+		return nil, models.ErrBadQRParams
+	}
 
+	link, err := s.repo.GetLink(ctx, shortCode)
+	if err != nil {
+		return nil, err
+	}
+	if link.StatusAt(time.Now()) != models.StatusActive {
+		return nil, models.ErrGoneURL
+	}
+	return render(s.ShortURL(shortCode), size)
 }
 
-func (s Service) Redirect(req *http.Request) (string, error) {
-	return s.repo.Redirect(req)
+func (s Service) ShortURL(shortCode string) string {
+	return strings.TrimSuffix(s.cfg.BaseURL, "/") + "/s/" + url.PathEscape(shortCode)
 }
 
-func (s Service) GetAnalytics(url string) (*models.AnalyticsResponse, error) {
-	return s.repo.GetAnalytics(url)
+func (s Service) DeactivateLink(ctx context.Context, shortCode string) error {
+	if err := s.repo.DeactivateLink(ctx, shortCode); err != nil {
+		return err
+	}
+	s.cache.Delete(shortCode)
+	return nil
 }
 
-func newShorten() string {
-	var shorten []byte
-	charset1 := "abcdefghijklmnopqrstuvwxyz"
-	for range 3 {
-		shorten = append(shorten, charset1[rand.IntN(len(charset1))])
+// GetAnalytics defaults to the last 30 days grouped by day.
+func (s Service) GetAnalytics(ctx context.Context, aq models.AnalyticsQuery) (*models.AnalyticsResponse, error) {
+	if aq.To.IsZero() {
+		aq.To = time.Now()
+	}
+	if aq.From.IsZero() {
+		aq.From = aq.To.Add(-defaultAnalyticsRange)
+	}
+	if aq.GroupBy == "" {
+		aq.GroupBy = models.GroupByDay
+	}
+	aq.From, aq.To = aq.From.UTC(), aq.To.UTC()
+
+	switch aq.GroupBy {
+	case models.GroupByHour, models.GroupByDay, models.GroupByBrowser, models.GroupByOS,
+		models.GroupByDevice, models.GroupByCountry, models.GroupByReferrer:
+	default:
+		return nil, models.ErrBadAnalyticsQuery
 	}
-	charset2 := "0123456789"
-	for range 3 {
-		shorten = append(shorten, charset2[rand.IntN(len(charset2))])
+	if !aq.From.Before(aq.To) {
+		return nil, models.ErrBadAnalyticsQuery
+	}
+
+	return s.repo.GetAnalytics(ctx, aq)
+}
+
+// validationErr tells a bad url apart from bad link parameters.
+func validationErr(err error) error {
+	var vErrs validator.ValidationErrors
+	if errors.As(err, &vErrs) && len(vErrs) > 0 && vErrs[0].Field() != "URL" {
+		return models.ErrBadLinkParams
 	}
-	return string(shorten)
+	return models.ErrBadURL
 }
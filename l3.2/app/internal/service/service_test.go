@@ -0,0 +1,479 @@
+package service
+
+import (
+	"app/internal/models"
+	"app/pkg/cache"
+	"app/pkg/codegen"
+	"app/pkg/safety"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// uniqueRepo emulates the unique constraint on urls.short_code.
+type uniqueRepo struct {
+	RepositoryInterface
+
+	mu    sync.Mutex
+	codes map[string]string
+	next  atomic.Int64
+}
+
+func newUniqueRepo() *uniqueRepo {
+	r := &uniqueRepo{codes: map[string]string{}}
+	r.next.Store(1)
+	return r
+}
+
+func (r *uniqueRepo) CreateLink(_ context.Context, req *models.ShortenRequest, code string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.codes[code]; ok {
+		return models.ErrOccupiedShortCode
+	}
+	r.codes[code] = req.URL
+	return nil
+}
+
+func (r *uniqueRepo) AllocateRange(_ context.Context, size int64) (int64, error) {
+	return r.next.Add(size) - size, nil
+}
+
+func TestCreateLinkConcurrent(t *testing.T) {
+	t.Parallel()
+	const workers, perWorker = 16, 500
+
+	repo := newUniqueRepo()
+	// Occupy some codes the generator will produce, as custom aliases would.
+	probe := codegen.NewSequence(newUniqueRepo(), 1000, 6)
+	for i := range 300 {
+		code, _ := probe.Next(context.Background())
+		if i%3 == 0 {
+			repo.codes[code] = "alias"
+		}
+	}
+
+	// Two instances share the database but not the generator.
+	gens := []CodeGenerator{codegen.NewSequence(repo, 50, 6), codegen.NewSequence(repo, 50, 6)}
+	created := make(chan string, workers*perWorker)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := newTestService(repo, gens[w%len(gens)])
+			for range perWorker {
+				code, err := s.CreateLink(context.Background(), &models.ShortenRequest{URL: "https://example.com/page"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				created <- code
+			}
+		}()
+	}
+	wg.Wait()
+	close(created)
+
+	seen := map[string]bool{}
+	for code := range created {
+		if seen[code] {
+			t.Fatalf("duplicate code %q", code)
+		}
+		if repo.codes[code] != "https://example.com/page" {
+			t.Fatalf("code %q isn't stored", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) != workers*perWorker {
+		t.Fatalf("expected %d links, got %d", workers*perWorker, len(seen))
+	}
+}
+
+type memClicks struct {
+	mu     sync.Mutex
+	clicks []models.Click
+}
+
+func (m *memClicks) Push(c models.Click) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clicks = append(m.clicks, c)
+	return true
+}
+
+func (m *memClicks) Stats() models.ClickStats {
+	return models.ClickStats{}
+}
+
+type memPreviews struct {
+	mu       sync.Mutex
+	refreshs map[string]string
+}
+
+func (m *memPreviews) Refresh(code string, url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.refreshs == nil {
+		m.refreshs = map[string]string{}
+	}
+	m.refreshs[code] = url
+}
+
+var testCfg = ServiceConfig{BaseURL: "https://sho.rt"}
+
+func newTestService(repo RepositoryInterface, gen CodeGenerator) *Service {
+	return New(repo, gen, cache.New[string, models.Link](cache.CacheConfig{Size: 100, TTL: 60}), &memClicks{},
+		safety.New(safety.SafetyConfig{}, testCfg.BaseURL), &memPreviews{}, testCfg)
+}
+
+type constGenerator string
+
+func (g constGenerator) Next(context.Context) (string, error) {
+	return string(g), nil
+}
+
+func TestCreateLinkNoFreeCode(t *testing.T) {
+	t.Parallel()
+	repo := newUniqueRepo()
+	repo.codes["taken1"] = "alias"
+
+	_, err := newTestService(repo, constGenerator("taken1")).CreateLink(context.Background(), &models.ShortenRequest{URL: "https://example.com"})
+	if !errors.Is(err, models.ErrNoFreeCode) {
+		t.Fatalf("expected ErrNoFreeCode, got: %v", err)
+	}
+}
+
+func TestCreateLinkAlias(t *testing.T) {
+	t.Parallel()
+	repo := newUniqueRepo()
+	s := newTestService(repo, constGenerator("unused"))
+
+	testCases := []struct {
+		alias string
+		err   error
+	}{
+		{"spring-sale", nil},
+		{"spring-sale", models.ErrOccupiedShortCode},
+		{"ab", models.ErrBadAlias},
+		{"with space", models.ErrBadAlias},
+	}
+	for _, tc := range testCases {
+		_, err := s.CreateLink(context.Background(), &models.ShortenRequest{URL: "https://example.com", Alias: tc.alias})
+		if !errors.Is(err, tc.err) {
+			t.Errorf("alias %q: expected %v, got %v", tc.alias, tc.err, err)
+		}
+	}
+}
+
+// linkRepo serves links from memory and counts lookups.
+type linkRepo struct {
+	RepositoryInterface
+
+	links   map[string]*models.Link
+	lookups int
+}
+
+func (r *linkRepo) GetLink(_ context.Context, code string) (*models.Link, error) {
+	r.lookups++
+	l, ok := r.links[code]
+	if !ok {
+		return nil, models.ErrNonExistURL
+	}
+	res := *l
+	return &res, nil
+}
+
+func (r *linkRepo) CountClick(_ context.Context, id int64) error {
+	for _, l := range r.links {
+		if l.Id == id {
+			if l.StatusAt(time.Now()) != models.StatusActive {
+				return models.ErrGoneURL
+			}
+			l.Clicks++
+		}
+	}
+	return nil
+}
+
+func (r *linkRepo) DeactivateLink(_ context.Context, code string) error {
+	r.links[code].IsActive = false
+	return nil
+}
+
+func (r *linkRepo) UpdateLink(_ context.Context, code string, patch *models.LinkPatch) (*models.Link, error) {
+	l, ok := r.links[code]
+	if !ok {
+		return nil, models.ErrNonExistURL
+	}
+	if patch.ExpiresAt.Set {
+		l.ExpiresAt = patch.ExpiresAt.Value
+	}
+	if patch.MaxClicks.Set {
+		l.MaxClicks = patch.MaxClicks.Value
+	}
+	res := *l
+	return &res, nil
+}
+
+func TestRedirect(t *testing.T) {
+	t.Parallel()
+	maxClicks, past := 2, time.Now().Add(-time.Hour)
+	repo := &linkRepo{links: map[string]*models.Link{
+		"plain":   {Id: 1, OriginalURL: "https://example.com/1", IsActive: true},
+		"limited": {Id: 2, OriginalURL: "https://example.com/2", IsActive: true, MaxClicks: &maxClicks},
+		"expired": {Id: 3, OriginalURL: "https://example.com/3", IsActive: true, ExpiresAt: &past},
+	}}
+	clicks := &memClicks{}
+	s := New(repo, constGenerator("unused"), cache.New[string, models.Link](cache.CacheConfig{Size: 100, TTL: 60}), clicks,
+		safety.New(safety.SafetyConfig{}, testCfg.BaseURL), &memPreviews{}, testCfg)
+	ctx := context.Background()
+
+	for range 3 {
+		if url, err := s.Redirect(ctx, "plain", models.Visit{UserAgent: "curl/8.4.0"}); err != nil || url != "https://example.com/1" {
+			t.Fatalf("unexpected redirect: %q, %v", url, err)
+		}
+	}
+	if repo.lookups != 1 {
+		t.Fatalf("expected one lookup thanks to the cache, got %d", repo.lookups)
+	}
+
+	for i := range 3 {
+		_, err := s.Redirect(ctx, "limited", models.Visit{UserAgent: "curl/8.4.0"})
+		if i < maxClicks && err != nil {
+			t.Fatalf("click %d: unexpected error: %v", i, err)
+		} else if i == maxClicks && !errors.Is(err, models.ErrGoneURL) {
+			t.Fatalf("expected ErrGoneURL after the limit, got: %v", err)
+		}
+	}
+	if repo.lookups != 4 {
+		t.Fatalf("expected limited links to bypass the cache, got %d lookups", repo.lookups)
+	}
+
+	if _, err := s.Redirect(ctx, "expired", models.Visit{UserAgent: "curl/8.4.0"}); !errors.Is(err, models.ErrGoneURL) {
+		t.Fatalf("expected ErrGoneURL, got: %v", err)
+	}
+	if _, err := s.Redirect(ctx, "missing", models.Visit{UserAgent: "curl/8.4.0"}); !errors.Is(err, models.ErrNonExistURL) {
+		t.Fatalf("expected ErrNonExistURL, got: %v", err)
+	}
+
+	if err := s.DeactivateLink(ctx, "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Redirect(ctx, "plain", models.Visit{UserAgent: "curl/8.4.0"}); !errors.Is(err, models.ErrGoneURL) {
+		t.Fatalf("expected deactivation to invalidate the cache, got: %v", err)
+	}
+
+	var counted, uncounted int
+	for _, c := range clicks.clicks {
+		if c.Counted {
+			counted++
+		} else {
+			uncounted++
+		}
+	}
+	if counted != maxClicks || uncounted != 3 {
+		t.Fatalf("expected %d counted and 3 uncounted clicks, got %d and %d", maxClicks, counted, uncounted)
+	}
+}
+
+type analyticsRepo struct {
+	RepositoryInterface
+	got models.AnalyticsQuery
+}
+
+func (r *analyticsRepo) GetAnalytics(_ context.Context, aq models.AnalyticsQuery) (*models.AnalyticsResponse, error) {
+	r.got = aq
+	return &models.AnalyticsResponse{}, nil
+}
+
+func TestGetAnalyticsQuery(t *testing.T) {
+	t.Parallel()
+	moscow := time.FixedZone("MSK", 3*60*60)
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, moscow)
+	to := time.Date(2026, 3, 8, 0, 0, 0, 0, moscow)
+
+	repo := &analyticsRepo{}
+	s := newTestService(repo, constGenerator("unused"))
+
+	if _, err := s.GetAnalytics(context.Background(), models.AnalyticsQuery{ShortCode: "abc", From: from, To: to, GroupBy: models.GroupByCountry}); err != nil {
+		t.Fatal(err)
+	}
+	if !repo.got.From.Equal(from) || repo.got.From.Location() != time.UTC || repo.got.GroupBy != models.GroupByCountry {
+		t.Fatalf("unexpected query: %+v", repo.got)
+	}
+
+	if _, err := s.GetAnalytics(context.Background(), models.AnalyticsQuery{ShortCode: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if repo.got.GroupBy != models.GroupByDay || repo.got.To.Sub(repo.got.From) != defaultAnalyticsRange {
+		t.Fatalf("unexpected defaults: %+v", repo.got)
+	}
+
+	for _, aq := range []models.AnalyticsQuery{
+		{ShortCode: "abc", GroupBy: "user_agent"},
+		{ShortCode: "abc", From: to, To: from},
+		{ShortCode: "abc", From: from, To: from},
+	} {
+		if _, err := s.GetAnalytics(context.Background(), aq); !errors.Is(err, models.ErrBadAnalyticsQuery) {
+			t.Errorf("%+v: expected ErrBadAnalyticsQuery, got %v", aq, err)
+		}
+	}
+}
+
+func TestCreateLinkChecksAndPreviews(t *testing.T) {
+	t.Parallel()
+	previews := &memPreviews{}
+	s := New(newUniqueRepo(), constGenerator("abc1234"), cache.New[string, models.Link](cache.CacheConfig{Size: 100, TTL: 60}), &memClicks{},
+		safety.New(safety.SafetyConfig{}, testCfg.BaseURL), previews, testCfg)
+
+	if _, err := s.CreateLink(context.Background(), &models.ShortenRequest{URL: "https://SHO.RT/s/other"}); !errors.Is(err, models.ErrLoopURL) {
+		t.Fatalf("expected ErrLoopURL, got: %v", err)
+	}
+	if len(previews.refreshs) != 0 {
+		t.Fatal("expected no preview for a rejected link")
+	}
+
+	code, err := s.CreateLink(context.Background(), &models.ShortenRequest{URL: "https://example.com/article"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previews.refreshs[code] != "https://example.com/article" {
+		t.Fatalf("expected a preview refresh, got: %v", previews.refreshs)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	t.Parallel()
+	past := time.Now().Add(-time.Hour)
+	one := 1
+	repo := &linkRepo{links: map[string]*models.Link{
+		"abc":       {Id: 1, OriginalURL: "https://example.com", IsActive: true},
+		"inactive":  {Id: 2, OriginalURL: "https://example.com"},
+		"expired":   {Id: 3, OriginalURL: "https://example.com", IsActive: true, ExpiresAt: &past},
+		"exhausted": {Id: 4, OriginalURL: "https://example.com", IsActive: true, MaxClicks: &one, Clicks: 1},
+	}}
+	s := newTestService(repo, constGenerator("unused"))
+	ctx := context.Background()
+
+	svg, err := s.QRCode(ctx, "abc", "svg", 128)
+	if err != nil || !bytes.HasPrefix(svg, []byte("<svg")) || !bytes.Contains(svg, []byte(`width="128"`)) {
+		t.Fatalf("unexpected svg: %.60q, %v", svg, err)
+	}
+	png, err := s.QRCode(ctx, "abc", "png", 128)
+	if err != nil || !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Fatalf("unexpected png: %.8q, %v", png, err)
+	}
+
+	if _, err := s.QRCode(ctx, "missing", "png", 128); !errors.Is(err, models.ErrNonExistURL) {
+		t.Fatalf("expected ErrNonExistURL, got: %v", err)
+	}
+	for _, code := range []string{"inactive", "expired", "exhausted"} {
+		if _, err := s.QRCode(ctx, code, "png", 128); !errors.Is(err, models.ErrGoneURL) {
+			t.Errorf("%s: expected ErrGoneURL, got: %v", code, err)
+		}
+	}
+	for _, tc := range []struct {
+		format string
+		size   int
+	}{{"gif", 128}, {"png", 16}, {"svg", 4096}} {
+		if _, err := s.QRCode(ctx, "abc", tc.format, tc.size); !errors.Is(err, models.ErrBadQRParams) {
+			t.Errorf("%s %d: expected ErrBadQRParams, got: %v", tc.format, tc.size, err)
+		}
+	}
+
+	if got := s.ShortURL("abc"); got != "https://sho.rt/s/abc" {
+		t.Fatalf("unexpected short url: %s", got)
+	}
+}
+
+func TestLinkPatchDecodesNull(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		body      string
+		expireSet bool
+		clicksSet bool
+		clicks    bool
+	}{
+		{`{}`, false, false, false},
+		{`{"expires_at": null, "max_clicks": null}`, true, true, false},
+		{`{"max_clicks": 5}`, false, true, true},
+	}
+	for _, tc := range testCases {
+		var patch models.LinkPatch
+		if err := json.Unmarshal([]byte(tc.body), &patch); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.body, err)
+		}
+		if patch.ExpiresAt.Set != tc.expireSet || patch.ExpiresAt.Value != nil {
+			t.Fatalf("%s: unexpected expires_at: %+v", tc.body, patch.ExpiresAt)
+		}
+		if patch.MaxClicks.Set != tc.clicksSet || (patch.MaxClicks.Value != nil) != tc.clicks {
+			t.Fatalf("%s: unexpected max_clicks: %+v", tc.body, patch.MaxClicks)
+		}
+	}
+}
+
+func TestUpdateLink(t *testing.T) {
+	t.Parallel()
+	past, future, zero := time.Now().Add(-time.Hour), time.Now().Add(time.Hour), 0
+	testCases := []struct {
+		name  string
+		patch models.LinkPatch
+		err   error
+	}{
+		{"empty", models.LinkPatch{}, models.ErrEmptyPatch},
+		{"past expiry", models.LinkPatch{ExpiresAt: models.Nullable[time.Time]{Set: true, Value: &past}}, models.ErrBadLinkParams},
+		{"no clicks", models.LinkPatch{MaxClicks: models.Nullable[int]{Set: true, Value: &zero}}, models.ErrBadLinkParams},
+		{"future expiry", models.LinkPatch{ExpiresAt: models.Nullable[time.Time]{Set: true, Value: &future}}, nil},
+		{"clear limits", models.LinkPatch{ExpiresAt: models.Nullable[time.Time]{Set: true}, MaxClicks: models.Nullable[int]{Set: true}}, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			maxClicks := 1
+			repo := &linkRepo{links: map[string]*models.Link{"abc": {Id: 1, OriginalURL: "https://example.com", IsActive: true, MaxClicks: &maxClicks}}}
+			s := newTestService(repo, constGenerator("unused"))
+
+			link, err := s.UpdateLink(context.Background(), "abc", &tc.patch)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expected %v, got: %v", tc.err, err)
+			}
+			if tc.err == nil && tc.patch.MaxClicks.Set && link.MaxClicks != nil {
+				t.Fatalf("expected the click limit to be cleared, got %d", *link.MaxClicks)
+			}
+		})
+	}
+}
+
+func TestRedirectExpiry(t *testing.T) {
+	t.Parallel()
+	soon := time.Now().Add(50 * time.Millisecond)
+	repo := &linkRepo{links: map[string]*models.Link{"abc": {Id: 1, OriginalURL: "https://example.com", IsActive: true, ExpiresAt: &soon}}}
+	s := newTestService(repo, constGenerator("unused"))
+	ctx := context.Background()
+
+	if _, err := s.Redirect(ctx, "abc", models.Visit{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(time.Until(soon))
+	// the link is cached, the expiry is checked on every redirect
+	if _, err := s.Redirect(ctx, "abc", models.Visit{}); !errors.Is(err, models.ErrGoneURL) {
+		t.Fatalf("expected ErrGoneURL after the expiry, got: %v", err)
+	}
+	if repo.lookups != 1 {
+		t.Fatalf("expected the cached link to be used, got %d lookups", repo.lookups)
+	}
+
+	// removing the expiry brings the link back, past the cache
+	if _, err := s.UpdateLink(ctx, "abc", &models.LinkPatch{ExpiresAt: models.Nullable[time.Time]{Set: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if url, err := s.Redirect(ctx, "abc", models.Visit{}); err != nil || url != "https://example.com" {
+		t.Fatalf("expected the link to work again, got %q, %v", url, err)
+	}
+}
@@ -3,14 +3,37 @@ package transport
 import (
 	"app/internal/models"
 	"app/pkg/logger"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"html/template"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/wb-go/wbf/ginext"
 )
 
+//go:embed templates/preview.html
+var previewHTML string
+
+var previewTmpl = template.Must(template.New("preview").Parse(previewHTML))
+
+const (
+	defaultQRSize = 256
+	// qrMaxAge is short, so a deleted or deactivated link stops serving its
+	// QR code soon, clients revalidate with the ETag after it.
+	qrMaxAge = "public, max-age=300"
+)
+
 type handlers struct {
 	ctx     context.Context
 	service ServiceInterface
@@ -26,8 +49,33 @@ func (h *handlers) middleware(c *ginext.Context) {
 	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), logger.LoggerKey, lgWithReqId))
 }
 
-func (h *handlers) createLink(c *ginext.Context) {
+func writeError(c *ginext.Context, err error) {
 	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	// missing and dead links are what clients ask for, not failures
+	if errors.Is(err, models.ErrNonExistURL) || errors.Is(err, models.ErrGoneURL) {
+		lg.Debug().Err(err).Send()
+	} else {
+		lg.Error().Err(err).Send()
+	}
+
+	switch {
+	case errors.Is(err, models.ErrNonExistURL):
+		c.JSON(http.StatusNotFound, ginext.H{"error": "non exist url"})
+	case errors.Is(err, models.ErrGoneURL):
+		c.JSON(http.StatusGone, ginext.H{"error": "url is expired or inactive"})
+	case errors.Is(err, models.ErrOccupiedShortCode):
+		c.JSON(http.StatusConflict, ginext.H{"error": "short code is occupied"})
+	case errors.Is(err, models.ErrBlockedURL), errors.Is(err, models.ErrLoopURL):
+		c.JSON(http.StatusUnprocessableEntity, ginext.H{"error": err.Error()})
+	case errors.Is(err, models.ErrBadURL), errors.Is(err, models.ErrBadAlias), errors.Is(err, models.ErrBadQRParams), errors.Is(err, models.ErrBadLinkParams),
+		errors.Is(err, models.ErrEmptyPatch), errors.Is(err, models.ErrBadPagination), errors.Is(err, models.ErrBadAnalyticsQuery):
+		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, ginext.H{"error": "server error"})
+	}
+}
+
+func (h *handlers) createLink(c *ginext.Context) {
 	var sh models.ShortenRequest
 
 	err := c.ShouldBindJSON(&sh)
@@ -36,49 +84,203 @@ func (h *handlers) createLink(c *ginext.Context) {
 		return
 	}
 
-	res, err := h.service.CreateLink(&sh)
+	res, err := h.service.CreateLink(c.Request.Context(), &sh)
 	if err != nil {
-		lg.Error().Err(err).Send()
-		if errors.Is(err, models.ErrAlreadyExistURL) {
-			c.JSON(http.StatusBadRequest, ginext.H{"error": "url already exists"})
-		} else if errors.Is(err, models.ErrBadURL) {
-			c.JSON(http.StatusBadRequest, ginext.H{"error": "bad url value"})
-		} else {
-			c.JSON(http.StatusInternalServerError, ginext.H{"error": "server error"})
-		}
+		writeError(c, err)
 	} else {
 		c.JSON(http.StatusOK, ginext.H{"original_url": sh.URL, "short_code": res})
 	}
 }
 
 func (h *handlers) redirect(c *ginext.Context) {
-	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	visit := models.Visit{UserAgent: c.Request.UserAgent(), Referrer: c.Request.Referer(), IP: c.ClientIP()}
 
-	res, err := h.service.Redirect(c.Request)
+	res, err := h.service.Redirect(c.Request.Context(), c.Param(shorten), visit)
 	if err != nil {
-		lg.Error().Err(err).Send()
-		if err == models.ErrNonExistURL {
-			c.JSON(http.StatusBadRequest, ginext.H{"error": "non exist url"})
-		} else {
-			c.JSON(http.StatusInternalServerError, ginext.H{"error": "server error"})
-		}
+		writeError(c, err)
 	} else {
-		c.Redirect(http.StatusPermanentRedirect, res)
+		// Not permanent: browsers cache permanent redirects, so repeated
+		// clicks wouldn't be counted and expired links would keep working.
+		c.Redirect(http.StatusFound, res)
 	}
 }
 
+// parseTime accepts RFC 3339 timestamps and plain dates.
+func parseTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, raw)
+}
+
 func (h *handlers) getAnalytics(c *ginext.Context) {
-	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	aq := models.AnalyticsQuery{ShortCode: c.Param(shorten), GroupBy: c.Query("group_by")}
 
-	data, err := h.service.GetAnalytics(c.Request.URL.String()[11:])
+	var errFrom, errTo error
+	aq.From, errFrom = parseTime(c.Query("from"))
+	aq.To, errTo = parseTime(c.Query("to"))
+	if errFrom != nil || errTo != nil {
+		writeError(c, models.ErrBadAnalyticsQuery)
+		return
+	}
+
+	data, err := h.service.GetAnalytics(c.Request.Context(), aq)
 	if err != nil {
-		lg.Error().Err(err).Send()
-		if err == models.ErrNonExistURL {
-			c.JSON(http.StatusBadRequest, ginext.H{"error": "non exist url"})
-		} else {
-			c.JSON(http.StatusInternalServerError, ginext.H{"error": "server error"})
-		}
+		writeError(c, err)
+	} else if c.Query("format") == "csv" || c.NegotiateFormat(mimeJSON, mimeCSV) == mimeCSV {
+		writeAnalyticsCSV(c, data)
 	} else {
 		c.JSON(http.StatusOK, data)
 	}
 }
+
+const (
+	mimeJSON = "application/json"
+	mimeCSV  = "text/csv"
+)
+
+func writeAnalyticsCSV(c *ginext.Context, data *models.AnalyticsResponse) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, data.ShortCode, data.GroupBy))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{data.GroupBy, "clicks", "visitors"})
+	for _, g := range data.Groups {
+		w.Write([]string{g.Key, strconv.FormatInt(g.Clicks, 10), strconv.FormatInt(g.Visitors, 10)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logger.LoggerFromCtx(c.Request.Context()).Lg.Error().Err(err).Msg("failed to write csv")
+	}
+}
+
+// qrCode serves /qr/{code}.png and /qr/{code}.svg.
+func (h *handlers) qrCode(c *ginext.Context) {
+	file := c.Param(qrFile)
+	dot := strings.LastIndexByte(file, '.')
+	if dot < 0 {
+		writeError(c, models.ErrBadQRParams)
+		return
+	}
+	code, format := file[:dot], file[dot+1:]
+
+	size := defaultQRSize
+	if raw := c.Query("size"); raw != "" {
+		var err error
+		if size, err = strconv.Atoi(raw); err != nil {
+			writeError(c, models.ErrBadQRParams)
+			return
+		}
+	}
+
+	img, err := h.service.QRCode(c.Request.Context(), code, format, size)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	sum := sha256.Sum256(img)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("Cache-Control", qrMaxAge)
+	c.Header("ETag", etag)
+	if match := c.GetHeader("If-None-Match"); match == "*" || strings.Contains(match, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	contentType := map[string]string{"png": "image/png", "svg": "image/svg+xml"}[format]
+	c.Data(http.StatusOK, contentType, img)
+}
+
+func (h *handlers) preview(c *ginext.Context) {
+	link, err := h.service.Preview(c.Request.Context(), c.Param(shorten))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	host := link.OriginalURL
+	if u, err := url.Parse(link.OriginalURL); err == nil {
+		host = u.Host
+	}
+
+	var buf bytes.Buffer
+	err = previewTmpl.Execute(&buf, map[string]string{
+		"Title":       link.Title,
+		"Description": link.Description,
+		"Host":        host,
+		"URL":         link.OriginalURL,
+		"Continue":    h.service.ShortURL(link.ShortCode),
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (h *handlers) clickStats(c *ginext.Context) {
+	c.JSON(http.StatusOK, h.service.ClickStats())
+}
+
+func (h *handlers) listLinks(c *ginext.Context) {
+	f := models.LinksFilter{Owner: c.Query("owner")}
+
+	var err error
+	if raw := c.Query("limit"); raw != "" {
+		if f.Limit, err = strconv.Atoi(raw); err != nil {
+			writeError(c, models.ErrBadPagination)
+			return
+		}
+	}
+	if raw := c.Query("offset"); raw != "" {
+		if f.Offset, err = strconv.Atoi(raw); err != nil {
+			writeError(c, models.ErrBadPagination)
+			return
+		}
+	}
+
+	res, err := h.service.ListLinks(c.Request.Context(), f)
+	if err != nil {
+		writeError(c, err)
+	} else {
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+func (h *handlers) getLink(c *ginext.Context) {
+	res, err := h.service.GetLink(c.Request.Context(), c.Param(shorten))
+	if err != nil {
+		writeError(c, err)
+	} else {
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+func (h *handlers) updateLink(c *ginext.Context) {
+	var patch models.LinkPatch
+
+	err := c.ShouldBindJSON(&patch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ginext.H{"error": "bad request body"})
+		return
+	}
+
+	res, err := h.service.UpdateLink(c.Request.Context(), c.Param(shorten), &patch)
+	if err != nil {
+		writeError(c, err)
+	} else {
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+func (h *handlers) deactivateLink(c *ginext.Context) {
+	err := h.service.DeactivateLink(c.Request.Context(), c.Param(shorten))
+	if err != nil {
+		writeError(c, err)
+	} else {
+		c.JSON(http.StatusOK, ginext.H{"message": "link deactivated"})
+	}
+}
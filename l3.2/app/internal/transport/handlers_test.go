@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeService answers analytics, redirects and QR codes and keeps the last
+// visit.
+type fakeService struct {
+	ServiceInterface
+	visit models.Visit
+}
+
+func (s *fakeService) GetAnalytics(_ context.Context, aq models.AnalyticsQuery) (*models.AnalyticsResponse, error) {
+	return &models.AnalyticsResponse{ShortCode: aq.ShortCode, GroupBy: "day"}, nil
+}
+
+func (s *fakeService) Redirect(_ context.Context, _ string, visit models.Visit) (string, error) {
+	s.visit = visit
+	return "https://example.com", nil
+}
+
+func (s *fakeService) QRCode(_ context.Context, shortCode string, format string, _ int) ([]byte, error) {
+	return []byte(shortCode + "." + format), nil
+}
+
+func newTestHandler(service ServiceInterface, trusted []string) http.Handler {
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, logger.New())
+	return New(service, &ServerConfig{TrustedProxies: trusted}, ctx).httpServer.Handler
+}
+
+func TestAnalyticsFormat(t *testing.T) {
+	t.Parallel()
+	handler := newTestHandler(&fakeService{}, nil)
+	testCases := []struct {
+		query    string
+		accept   string
+		expected string
+	}{
+		{"", "", "application/json"},
+		{"", "*/*", "application/json"},
+		{"", "text/csv", "text/csv"},
+		{"", "text/csv; charset=utf-8", "text/csv"},
+		{"", "text/html, text/csv", "text/csv"},
+		{"", "application/json, text/csv", "application/json"},
+		{"?format=csv", "application/json", "text/csv"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/abc"+tc.query, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Type"); rec.Code != http.StatusOK || !strings.HasPrefix(got, tc.expected) {
+			t.Errorf("%q %q: expected %s, got %d %s", tc.query, tc.accept, tc.expected, rec.Code, got)
+		}
+	}
+}
+
+func TestRedirectTrustsOnlyConfiguredProxies(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name     string
+		trusted  []string
+		expected string
+	}{
+		{"no proxies", nil, "10.0.0.1"},
+		{"trusted proxy", []string{"10.0.0.0/8"}, "203.0.113.7"},
+		{"other proxy", []string{"192.168.0.0/16"}, "10.0.0.1"},
+	}
+	for _, tc := range testCases {
+		service := &fakeService{}
+		req := httptest.NewRequest(http.MethodGet, "/s/abc", nil)
+		req.RemoteAddr = "10.0.0.1:4242"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		rec := httptest.NewRecorder()
+		newTestHandler(service, tc.trusted).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusFound || service.visit.IP != tc.expected {
+			t.Errorf("%s: expected a redirect from %s, got %d from %s", tc.name, tc.expected, rec.Code, service.visit.IP)
+		}
+	}
+}
+
+func TestQRCodeRevalidates(t *testing.T) {
+	t.Parallel()
+	handler := newTestHandler(&fakeService{}, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/qr/abc.png", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || rec.Header().Get("Cache-Control") != qrMaxAge {
+		t.Fatalf("expected a png with an etag and %q, got %d %q %q", qrMaxAge, rec.Code, etag, rec.Header().Get("Cache-Control"))
+	}
+
+	testCases := []struct {
+		format   string
+		match    string
+		expected int
+	}{
+		{"png", etag, http.StatusNotModified},
+		{"png", `"other", ` + etag, http.StatusNotModified},
+		{"png", "*", http.StatusNotModified},
+		{"png", `"other"`, http.StatusOK},
+		{"svg", etag, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/qr/abc."+tc.format, nil)
+		req.Header.Set("If-None-Match", tc.match)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tc.expected {
+			t.Errorf("%s %s: expected %d, got %d", tc.format, tc.match, tc.expected, rec.Code)
+		}
+		if tc.expected == http.StatusNotModified && rec.Body.Len() != 0 {
+			t.Errorf("%s %s: expected no body, got %q", tc.format, tc.match, rec.Body)
+		}
+	}
+}
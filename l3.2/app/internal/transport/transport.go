@@ -13,18 +13,30 @@ import (
 
 const (
 	shorten = "shorten"
+	qrFile  = "file"
 )
 
 type ServiceInterface interface {
-	CreateLink(*models.ShortenRequest) (string, error)
-	Redirect(*http.Request) (string, error)
-	GetAnalytics(string) (*models.AnalyticsResponse, error)
+	CreateLink(context.Context, *models.ShortenRequest) (string, error)
+	Redirect(context.Context, string, models.Visit) (string, error)
+	GetLink(context.Context, string) (*models.Link, error)
+	ListLinks(context.Context, models.LinksFilter) (*models.LinksPage, error)
+	UpdateLink(context.Context, string, *models.LinkPatch) (*models.Link, error)
+	DeactivateLink(context.Context, string) error
+	GetAnalytics(context.Context, models.AnalyticsQuery) (*models.AnalyticsResponse, error)
+	ClickStats() models.ClickStats
+	Preview(context.Context, string) (*models.Link, error)
+	QRCode(context.Context, string, string, int) ([]byte, error)
+	ShortURL(string) string
 }
 
 type ServerConfig struct {
 	Host        string `env:"SERVER_HOST" env-default:"localhost"`
 	Port        string `env:"SERVER_PORT" env-default:"8080"`
 	ReleaseMode string `env:"RELEASE_MODE" env-default:""`
+	// TrustedProxies may set X-Forwarded-For, the client address of anyone
+	// else is the one the request came from.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" env-separator:"," env-default:""`
 }
 
 type Server struct {
@@ -62,11 +74,21 @@ func New(service ServiceInterface, serverCfg *ServerConfig, ctx context.Context)
 	hers := &handlers{ctx, service}
 
 	mux := ginext.New(serverCfg.ReleaseMode)
+	if err := mux.SetTrustedProxies(serverCfg.TrustedProxies); err != nil {
+		panic(fmt.Sprintf("failed to set trusted proxies: %v", err))
+	}
 
 	mux.Use(hers.middleware)
 	mux.POST("/shorten", hers.createLink)
 	mux.GET(fmt.Sprintf("/s/:%s", shorten), hers.redirect)
+	mux.GET(fmt.Sprintf("/p/:%s", shorten), hers.preview)
+	mux.GET(fmt.Sprintf("/qr/:%s", qrFile), hers.qrCode)
 	mux.GET(fmt.Sprintf("/analytics/:%s", shorten), hers.getAnalytics)
+	mux.GET("/metrics/clicks", hers.clickStats)
+	mux.GET("/links", hers.listLinks)
+	mux.GET(fmt.Sprintf("/links/:%s", shorten), hers.getLink)
+	mux.PATCH(fmt.Sprintf("/links/:%s", shorten), hers.updateLink)
+	mux.DELETE(fmt.Sprintf("/links/:%s", shorten), hers.deactivateLink)
 
 	return &Server{&http.Server{Addr: fmt.Sprintf("%s:%s", serverCfg.Host, serverCfg.Port), Handler: mux}, ctx}
 }
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_urls_owner;
+ALTER TABLE urls
+    DROP COLUMN IF EXISTS owner,
+    DROP COLUMN IF EXISTS expires_at,
+    DROP COLUMN IF EXISTS max_clicks,
+    DROP COLUMN IF EXISTS clicks,
+    DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE urls ALTER COLUMN is_active DROP NOT NULL;
+ALTER TABLE urls ALTER COLUMN short_code TYPE VARCHAR(10);
@@ -0,0 +1,10 @@
+ALTER TABLE urls ALTER COLUMN short_code TYPE VARCHAR(32);
+ALTER TABLE urls ALTER COLUMN is_active SET NOT NULL;
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS owner TEXT,
+    ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS max_clicks INTEGER,
+    ADD COLUMN IF NOT EXISTS clicks BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+UPDATE urls SET clicks = (SELECT COUNT(*) FROM clicks WHERE clicks.url_id = urls.id);
+CREATE INDEX IF NOT EXISTS idx_urls_owner ON urls(owner, id);
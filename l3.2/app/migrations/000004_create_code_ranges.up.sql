@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS code_ranges (
+    name TEXT PRIMARY KEY,
+    next_id BIGINT NOT NULL
+);
+INSERT INTO code_ranges (name, next_id) VALUES ('urls', 1) ON CONFLICT DO NOTHING;
@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS click_rollups_daily;
+DROP TABLE IF EXISTS click_rollups_hourly;
+DROP INDEX IF EXISTS idx_clicks_not_rolled_up;
+DROP INDEX IF EXISTS idx_clicks_url_id_clicked_at;
+ALTER TABLE clicks
+    DROP COLUMN IF EXISTS referrer,
+    DROP COLUMN IF EXISTS referrer_host,
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS visitor_hash,
+    DROP COLUMN IF EXISTS browser,
+    DROP COLUMN IF EXISTS os,
+    DROP COLUMN IF EXISTS device,
+    DROP COLUMN IF EXISTS country,
+    DROP COLUMN IF EXISTS rolled_up;
+ALTER TABLE clicks ALTER COLUMN clicked_at TYPE TIMESTAMP USING clicked_at AT TIME ZONE 'UTC';
@@ -0,0 +1,29 @@
+ALTER TABLE clicks ALTER COLUMN clicked_at TYPE TIMESTAMPTZ USING clicked_at AT TIME ZONE 'UTC';
+ALTER TABLE clicks
+    ADD COLUMN IF NOT EXISTS referrer TEXT,
+    ADD COLUMN IF NOT EXISTS referrer_host TEXT,
+    ADD COLUMN IF NOT EXISTS ip INET,
+    ADD COLUMN IF NOT EXISTS visitor_hash TEXT,
+    ADD COLUMN IF NOT EXISTS browser TEXT,
+    ADD COLUMN IF NOT EXISTS os TEXT,
+    ADD COLUMN IF NOT EXISTS device TEXT,
+    ADD COLUMN IF NOT EXISTS country TEXT,
+    ADD COLUMN IF NOT EXISTS rolled_up BOOLEAN NOT NULL DEFAULT false;
+CREATE INDEX IF NOT EXISTS idx_clicks_url_id_clicked_at ON clicks(url_id, clicked_at);
+CREATE INDEX IF NOT EXISTS idx_clicks_not_rolled_up ON clicks(id) WHERE NOT rolled_up;
+
+CREATE TABLE IF NOT EXISTS click_rollups_hourly (
+    url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    bucket TIMESTAMPTZ NOT NULL,
+    clicks BIGINT NOT NULL,
+    visitors BIGINT NOT NULL,
+    PRIMARY KEY (url_id, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS click_rollups_daily (
+    url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    bucket TIMESTAMPTZ NOT NULL,
+    clicks BIGINT NOT NULL,
+    visitors BIGINT NOT NULL,
+    PRIMARY KEY (url_id, bucket)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE urls
+    DROP COLUMN IF EXISTS preview_title,
+    DROP COLUMN IF EXISTS preview_description,
+    DROP COLUMN IF EXISTS preview_fetched_at;
@@ -0,0 +1,4 @@
+ALTER TABLE urls
+    ADD COLUMN IF NOT EXISTS preview_title TEXT,
+    ADD COLUMN IF NOT EXISTS preview_description TEXT,
+    ADD COLUMN IF NOT EXISTS preview_fetched_at TIMESTAMPTZ;
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"app/internal/models"
+	"container/list"
+	"sync"
+	"time"
+)
+
+type CacheConfig struct {
+	Size int `env:"CACHE_SIZE" env-default:"10000"`
+	TTL  int `env:"CACHE_TTL" env-default:"60"`
+}
+
+// Valid checks that the size and ttl are positive.
+func (cfg CacheConfig) Valid() error {
+	if cfg.Size <= 0 || cfg.TTL <= 0 {
+		return models.ErrBadCacheCfg
+	}
+	return nil
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// LRU is a size-bounded cache that evicts the least recently used entry and
+// treats entries older than ttl as missing.
+type LRU[K comparable, V any] struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu    sync.Mutex
+	order *list.List
+	items map[K]*list.Element
+}
+
+func New[K comparable, V any](cfg CacheConfig) *LRU[K, V] {
+	return &LRU[K, V]{
+		size:  cfg.Size,
+		ttl:   time.Duration(cfg.TTL) * time.Second,
+		now:   time.Now,
+		order: list.New(),
+		items: make(map[K]*list.Element, cfg.Size),
+	}
+}
+
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	e := el.Value.(*entry[K, V])
+	if !c.now().Before(e.expires) {
+		c.remove(el)
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *LRU[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&entry[K, V]{key, value, expires})
+	if c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRU[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRU[K, V]) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
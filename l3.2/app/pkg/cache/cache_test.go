@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLRU(size int) (*LRU[string, int], *time.Time) {
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	c := New[string, int](CacheConfig{Size: size, TTL: 60})
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+	c, _ := newTestLRU(2)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected a to stay, got %d, %v", v, ok)
+	}
+	if c.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.Len())
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	t.Parallel()
+	c, now := newTestLRU(2)
+	c.Set("a", 1)
+
+	*now = now.Add(59 * time.Second)
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a before ttl")
+	}
+	*now = now.Add(time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a to expire")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("expected the expired entry to be removed, got %d", c.Len())
+	}
+}
+
+func TestLRUSetRefreshesAndDelete(t *testing.T) {
+	t.Parallel()
+	c, now := newTestLRU(2)
+	c.Set("a", 1)
+	*now = now.Add(50 * time.Second)
+	c.Set("a", 2)
+	*now = now.Add(50 * time.Second)
+
+	if v, ok := c.Get("a"); !ok || v != 2 {
+		t.Fatalf("expected refreshed a, got %d, %v", v, ok)
+	}
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected a to be deleted")
+	}
+}
@@ -0,0 +1,74 @@
+package codegen
+
+import (
+	"app/internal/models"
+	"context"
+	"fmt"
+)
+
+const (
+	KindSequence  = "sequence"
+	KindSnowflake = "snowflake"
+	KindRandom    = "random"
+)
+
+const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+type Config struct {
+	Kind      string `env:"CODEGEN_KIND" env-default:"sequence"`
+	Length    int    `env:"CODEGEN_LENGTH" env-default:"7"`
+	RangeSize int64  `env:"CODEGEN_RANGE_SIZE" env-default:"1000"`
+	NodeId    int64  `env:"CODEGEN_NODE_ID" env-default:"0"`
+}
+
+func (c *Config) Valid() error {
+	switch {
+	case c.Kind == KindSequence && (c.Length < 4 || c.Length > maxSequenceLength):
+		return fmt.Errorf("%w: sequence code length must be in [4, %d]", models.ErrBadCodegenCfg, maxSequenceLength)
+	case c.Kind == KindSequence && c.RangeSize < 1:
+		return fmt.Errorf("%w: range size must be positive", models.ErrBadCodegenCfg)
+	case c.Kind == KindSnowflake && (c.Length < snowflakeLength || c.Length > 32):
+		return fmt.Errorf("%w: snowflake code length must be in [%d, 32]", models.ErrBadCodegenCfg, snowflakeLength)
+	case c.Kind == KindSnowflake && (c.NodeId < 0 || c.NodeId > maxNodeId):
+		return fmt.Errorf("%w: node id must be in [0, %d]", models.ErrBadCodegenCfg, maxNodeId)
+	case c.Kind == KindRandom && (c.Length < 4 || c.Length > 32):
+		return fmt.Errorf("%w: random code length must be in [4, 32]", models.ErrBadCodegenCfg)
+	case c.Kind != KindSequence && c.Kind != KindSnowflake && c.Kind != KindRandom:
+		return fmt.Errorf("%w: unknown kind %q", models.ErrBadCodegenCfg, c.Kind)
+	}
+	return nil
+}
+
+// Generator returns short codes. Codes are unique among themselves but may
+// still collide with custom aliases, so callers retry on a unique violation.
+type Generator interface {
+	Next(context.Context) (string, error)
+}
+
+// RangeAllocator reserves ids [start, start+size) for one instance.
+type RangeAllocator interface {
+	AllocateRange(ctx context.Context, size int64) (start int64, err error)
+}
+
+func New(cfg Config, alloc RangeAllocator) Generator {
+	switch cfg.Kind {
+	case KindSnowflake:
+		return NewSnowflake(cfg.NodeId, cfg.Length)
+	case KindRandom:
+		return NewRandom(cfg.Length)
+	default:
+		return NewSequence(alloc, cfg.RangeSize, cfg.Length)
+	}
+}
+
+// encode writes n in base62, left-padded to length.
+func encode(n uint64, length int) string {
+	buf := make([]byte, max(length, 11))
+	i := len(buf)
+	for n > 0 || i > len(buf)-length {
+		i--
+		buf[i] = alphabet[n%62]
+		n /= 62
+	}
+	return string(buf[i:])
+}
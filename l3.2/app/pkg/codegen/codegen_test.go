@@ -0,0 +1,178 @@
+package codegen
+
+import (
+	"app/internal/models"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memAllocator stands in for the code_ranges table.
+type memAllocator struct {
+	next  atomic.Int64
+	calls atomic.Int64
+}
+
+func newMemAllocator() *memAllocator {
+	a := &memAllocator{}
+	a.next.Store(1)
+	return a
+}
+
+func (a *memAllocator) AllocateRange(_ context.Context, size int64) (int64, error) {
+	a.calls.Add(1)
+	return a.next.Add(size) - size, nil
+}
+
+// generateConcurrently calls Next on gens from many goroutines and fails on
+// the first duplicate or malformed code.
+func generateConcurrently(t *testing.T, gens []Generator, workers, perWorker, length int) {
+	t.Helper()
+	var seen sync.Map
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gen := gens[w%len(gens)]
+			for range perWorker {
+				code, err := gen.Next(context.Background())
+				if err != nil {
+					errs <- err
+					return
+				}
+				if len(code) != length {
+					errs <- errors.New("bad code length: " + code)
+					return
+				}
+				if _, dup := seen.LoadOrStore(code, struct{}{}); dup {
+					errs <- errors.New("duplicate code: " + code)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestSequenceConcurrentInstances(t *testing.T) {
+	t.Parallel()
+	alloc := newMemAllocator()
+	var gens []Generator
+	for range 8 {
+		gens = append(gens, NewSequence(alloc, 100, 6))
+	}
+
+	generateConcurrently(t, gens, 32, 5000, 6)
+
+	if calls := alloc.calls.Load(); calls > 32*5000/100+8 {
+		t.Fatalf("expected about one allocation per range, got %d", calls)
+	}
+}
+
+func TestSequenceScrambleIsBijective(t *testing.T) {
+	t.Parallel()
+	s := NewSequence(newMemAllocator(), 1, 4)
+	seen := make([]bool, s.space)
+	for id := range s.space {
+		v := s.scramble(id)
+		if v >= s.space || seen[v] {
+			t.Fatalf("scramble isn't a bijection at %d", id)
+		}
+		seen[v] = true
+	}
+}
+
+func TestSequenceExhausted(t *testing.T) {
+	t.Parallel()
+	alloc := newMemAllocator()
+	alloc.next.Store(62*62*62*62 - 1)
+	s := NewSequence(alloc, 10, 4)
+
+	if _, err := s.Next(context.Background()); err != nil {
+		t.Fatalf("expected the last code, got: %v", err)
+	}
+	if _, err := s.Next(context.Background()); !errors.Is(err, models.ErrCodeSpaceExhausted) {
+		t.Fatalf("expected ErrCodeSpaceExhausted, got: %v", err)
+	}
+}
+
+func TestSnowflakeConcurrentNodes(t *testing.T) {
+	t.Parallel()
+	// A frozen clock makes every node overflow its counter many times.
+	frozen := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	var gens []Generator
+	for node := range int64(4) {
+		s := NewSnowflake(node, snowflakeLength)
+		s.now = func() time.Time { return frozen }
+		gens = append(gens, s)
+	}
+
+	generateConcurrently(t, gens, 16, 5000, snowflakeLength)
+}
+
+func TestSnowflakeClockGoesBack(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	s := NewSnowflake(1, snowflakeLength)
+	s.now = func() time.Time { return now }
+
+	first, _ := s.Next(context.Background())
+	now = now.Add(-time.Second)
+	second, _ := s.Next(context.Background())
+	if first >= second {
+		t.Fatalf("expected increasing codes, got %q then %q", first, second)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		n      uint64
+		length int
+		want   string
+	}{
+		{0, 4, "0000"},
+		{61, 4, "000z"},
+		{62, 4, "0010"},
+		{1<<63 - 1, 11, "AzL8n0Y58m7"},
+		{1<<63 - 1, 13, "00AzL8n0Y58m7"},
+	}
+	for _, tc := range testCases {
+		if got := encode(tc.n, tc.length); got != tc.want {
+			t.Errorf("encode(%d, %d) = %q, want %q", tc.n, tc.length, got, tc.want)
+		}
+	}
+}
+
+func TestConfigValid(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		cfg Config
+		ok  bool
+	}{
+		{Config{Kind: KindSequence, Length: 7, RangeSize: 1000}, true},
+		{Config{Kind: KindSequence, Length: 11, RangeSize: 1000}, false},
+		{Config{Kind: KindSequence, Length: 7, RangeSize: 0}, false},
+		{Config{Kind: KindSnowflake, Length: 11, NodeId: 1023}, true},
+		{Config{Kind: KindSnowflake, Length: 7}, false},
+		{Config{Kind: KindSnowflake, Length: 11, NodeId: 1024}, false},
+		{Config{Kind: KindRandom, Length: 8}, true},
+		{Config{Kind: "uuid", Length: 8}, false},
+	}
+	for _, tc := range testCases {
+		if err := tc.cfg.Valid(); (err == nil) != tc.ok {
+			t.Errorf("%+v: unexpected result: %v", tc.cfg, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package codegen
+
+import (
+	"context"
+	"math/rand/v2"
+)
+
+// Random picks codes uniformly, collisions are left to the unique constraint.
+type Random struct {
+	length int
+}
+
+func NewRandom(length int) *Random {
+	return &Random{length}
+}
+
+func (r *Random) Next(context.Context) (string, error) {
+	code := make([]byte, r.length)
+	for i := range code {
+		code[i] = alphabet[rand.IntN(len(alphabet))]
+	}
+	return string(code), nil
+}
@@ -0,0 +1,67 @@
+package codegen
+
+import (
+	"app/internal/models"
+	"context"
+	"math/bits"
+	"sync"
+)
+
+// 62^10 still fits in int64, so the scramble below never overflows.
+const maxSequenceLength = 10
+
+// Sequence encodes ids from ranges reserved in the database, so instances
+// never hand out the same id and only go to the database once per range.
+// Ids are multiplied by a constant coprime to 62^length, which is a bijection
+// on [0, 62^length): codes stay unique and fixed-length but aren't guessable
+// by incrementing.
+type Sequence struct {
+	alloc     RangeAllocator
+	rangeSize int64
+	length    int
+	space     uint64
+	mult      uint64
+
+	mu        sync.Mutex
+	next, end int64
+}
+
+func NewSequence(alloc RangeAllocator, rangeSize int64, length int) *Sequence {
+	space := uint64(1)
+	for range length {
+		space *= 62
+	}
+
+	mult := uint64(0x9E3779B97F4A7C15) % space
+	for mult%2 == 0 || mult%31 == 0 {
+		mult++
+	}
+
+	return &Sequence{alloc: alloc, rangeSize: rangeSize, length: length, space: space, mult: mult}
+}
+
+func (s *Sequence) Next(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next >= s.end {
+		start, err := s.alloc.AllocateRange(ctx, s.rangeSize)
+		if err != nil {
+			return "", err
+		}
+		s.next, s.end = start, start+s.rangeSize
+	}
+
+	id := uint64(s.next)
+	if id >= s.space {
+		return "", models.ErrCodeSpaceExhausted
+	}
+	s.next++
+
+	return encode(s.scramble(id), s.length), nil
+}
+
+func (s *Sequence) scramble(id uint64) uint64 {
+	hi, lo := bits.Mul64(id, s.mult)
+	return bits.Rem64(hi, lo, s.space)
+}
@@ -0,0 +1,57 @@
+package codegen
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	nodeBits  = 10
+	seqBits   = 12
+	maxNodeId = 1<<nodeBits - 1
+	seqMask   = 1<<seqBits - 1
+
+	// 63 bits take up to 11 base62 digits.
+	snowflakeLength = 11
+)
+
+// epoch is 2025-01-01 UTC, 41 bits of milliseconds last until 2094.
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+// Snowflake builds ids from a millisecond timestamp, the node id and a
+// per-millisecond counter, so it needs no coordination besides unique node ids.
+type Snowflake struct {
+	node   int64
+	length int
+	now    func() time.Time
+
+	mu     sync.Mutex
+	lastMs int64
+	seq    int64
+}
+
+func NewSnowflake(node int64, length int) *Snowflake {
+	return &Snowflake{node: node, length: length, now: time.Now}
+}
+
+func (s *Snowflake) Next(context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// If the clock goes back or the counter overflows, keep counting from
+	// the last used millisecond instead of waiting for the clock.
+	ms := max(s.now().UnixMilli()-epoch, s.lastMs)
+	if ms == s.lastMs {
+		s.seq = (s.seq + 1) & seqMask
+		if s.seq == 0 {
+			ms++
+		}
+	} else {
+		s.seq = 0
+	}
+	s.lastMs = ms
+
+	id := ms<<(nodeBits+seqBits) | s.node<<seqBits | s.seq
+	return encode(uint64(id), s.length), nil
+}
@@ -0,0 +1,74 @@
+package enrich
+
+import (
+	"app/internal/models"
+	"app/pkg/useragent"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const maxReferrerLen = 2048
+
+type EnrichConfig struct {
+	AnonymizeIP bool   `env:"ANALYTICS_ANONYMIZE_IP" env-default:"true"`
+	GeoIPDb     string `env:"ANALYTICS_GEOIP_DB" env-default:""`
+	// VisitorSalt keys visitor hashes, so they can't be matched to an ip
+	// without it. Changing it resets unique visitor counts.
+	VisitorSalt string `env:"ANALYTICS_VISITOR_SALT" env-default:""`
+}
+
+type GeoInterface interface {
+	Country(net.IP) string
+}
+
+// Enricher derives analytics fields from the raw visit of a click.
+type Enricher struct {
+	cfg EnrichConfig
+	geo GeoInterface
+}
+
+func New(cfg EnrichConfig, geo GeoInterface) *Enricher {
+	return &Enricher{cfg, geo}
+}
+
+func (e *Enricher) Enrich(c *models.Click) {
+	ip := net.ParseIP(c.IP)
+
+	mac := hmac.New(sha256.New, []byte(e.cfg.VisitorSalt))
+	mac.Write([]byte(c.IP))
+	mac.Write([]byte{0})
+	mac.Write([]byte(c.UserAgent))
+	c.VisitorHash = hex.EncodeToString(mac.Sum(nil)[:16])
+
+	c.Country = e.geo.Country(ip)
+	c.Browser, c.OS, c.Device = useragent.Parse(c.UserAgent)
+
+	if len(c.Referrer) > maxReferrerLen {
+		c.Referrer = c.Referrer[:maxReferrerLen]
+	}
+	if ref, err := url.Parse(c.Referrer); err == nil {
+		c.ReferrerHost = strings.TrimPrefix(strings.ToLower(ref.Hostname()), "www.")
+	}
+
+	switch {
+	case ip == nil:
+		c.IP = ""
+	case e.cfg.AnonymizeIP:
+		c.IP = Anonymize(ip).String()
+	default:
+		c.IP = ip.String()
+	}
+}
+
+// Anonymize zeroes the last octet of an IPv4 address and everything past
+// the /48 of an IPv6 one, the way most analytics tools do.
+func Anonymize(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32))
+	}
+	return ip.Mask(net.CIDRMask(48, 128))
+}
@@ -0,0 +1,69 @@
+package enrich
+
+import (
+	"app/internal/models"
+	"net"
+	"testing"
+)
+
+type fakeGeo map[string]string
+
+func (g fakeGeo) Country(ip net.IP) string {
+	return g[ip.String()]
+}
+
+func TestEnrich(t *testing.T) {
+	t.Parallel()
+	geo := fakeGeo{"203.0.113.77": "NL"}
+	ua := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1"
+
+	c := models.Click{Visit: models.Visit{UserAgent: ua, Referrer: "https://www.Example.com/post?id=1", IP: "203.0.113.77"}}
+	New(EnrichConfig{AnonymizeIP: true, VisitorSalt: "salt"}, geo).Enrich(&c)
+
+	if c.IP != "203.0.113.0" || c.Country != "NL" || c.ReferrerHost != "example.com" {
+		t.Fatalf("unexpected click: %+v", c)
+	}
+	if c.Browser != "Safari" || c.OS != "iOS" || c.Device != models.DeviceMobile {
+		t.Fatalf("unexpected user agent fields: %+v", c)
+	}
+
+	same := models.Click{Visit: models.Visit{UserAgent: ua, IP: "203.0.113.77"}}
+	other := models.Click{Visit: models.Visit{UserAgent: ua, IP: "203.0.113.78"}}
+	resalted := models.Click{Visit: models.Visit{UserAgent: ua, IP: "203.0.113.77"}}
+	New(EnrichConfig{AnonymizeIP: true, VisitorSalt: "salt"}, geo).Enrich(&same)
+	New(EnrichConfig{AnonymizeIP: true, VisitorSalt: "salt"}, geo).Enrich(&other)
+	New(EnrichConfig{AnonymizeIP: true, VisitorSalt: "pepper"}, geo).Enrich(&resalted)
+	if c.VisitorHash != same.VisitorHash || c.VisitorHash == other.VisitorHash || c.VisitorHash == resalted.VisitorHash {
+		t.Fatalf("visitor hash must depend on the full ip, user agent and salt: %s %s %s %s",
+			c.VisitorHash, same.VisitorHash, other.VisitorHash, resalted.VisitorHash)
+	}
+}
+
+func TestEnrichKeepsIPAndDropsGarbage(t *testing.T) {
+	t.Parallel()
+	c := models.Click{Visit: models.Visit{IP: "2001:db8:85a3::8a2e:370:7334", Referrer: "::not a url"}}
+	New(EnrichConfig{}, fakeGeo{}).Enrich(&c)
+	if c.IP != "2001:db8:85a3::8a2e:370:7334" || c.ReferrerHost != "" {
+		t.Fatalf("unexpected click: %+v", c)
+	}
+
+	c = models.Click{Visit: models.Visit{IP: "unknown"}}
+	New(EnrichConfig{AnonymizeIP: true}, fakeGeo{}).Enrich(&c)
+	if c.IP != "" || c.Device != models.DeviceBot {
+		t.Fatalf("unexpected click: %+v", c)
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	t.Parallel()
+	testCases := map[string]string{
+		"192.168.10.123":               "192.168.10.0",
+		"::ffff:192.168.10.123":        "192.168.10.0",
+		"2001:db8:85a3::8a2e:370:7334": "2001:db8:85a3::",
+	}
+	for in, want := range testCases {
+		if got := Anonymize(net.ParseIP(in)).String(); got != want {
+			t.Errorf("Anonymize(%s) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package geo
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type record struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// Anycast and satellite ranges only have a registered country.
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Geo looks countries up in a local GeoLite2/GeoIP2 Country or City
+// database (.mmdb). A Geo without a database knows no countries.
+type Geo struct {
+	db *maxminddb.Reader
+}
+
+func New(path string) *Geo {
+	if path == "" {
+		return &Geo{}
+	}
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open geoip database: %v", err))
+	}
+	return &Geo{db}
+}
+
+// Country returns the ISO 3166-1 alpha-2 code or "" if it's unknown.
+func (g *Geo) Country(ip net.IP) string {
+	if g.db == nil || ip == nil {
+		return ""
+	}
+	var rec record
+	if err := g.db.Lookup(ip, &rec); err != nil {
+		return ""
+	}
+	if rec.Country.IsoCode != "" {
+		return rec.Country.IsoCode
+	}
+	return rec.RegisteredCountry.IsoCode
+}
+
+func (g *Geo) Close() error {
+	if g.db == nil {
+		return nil
+	}
+	return g.db.Close()
+}
@@ -0,0 +1,226 @@
+package preview
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	maxBody        = 1 << 20
+	maxTitle       = 300
+	maxDescription = 1000
+)
+
+var errPrivateAddr = errors.New("destination resolves to a private address")
+
+type PreviewConfig struct {
+	Timeout int `env:"PREVIEW_TIMEOUT" env-default:"5"`
+	Workers int `env:"PREVIEW_WORKERS" env-default:"4"`
+	Queue   int `env:"PREVIEW_QUEUE" env-default:"1000"`
+}
+
+// Valid checks that the timeout, workers and queue are positive.
+func (cfg PreviewConfig) Valid() error {
+	if cfg.Timeout <= 0 || cfg.Workers <= 0 || cfg.Queue <= 0 {
+		return models.ErrBadPreviewCfg
+	}
+	return nil
+}
+
+type RepositoryInterface interface {
+	SavePreview(ctx context.Context, shortCode string, p models.Preview) error
+}
+
+type job struct {
+	shortCode string
+	url       string
+}
+
+// Previewer fetches the title and description of destinations in the
+// background, so creating a link doesn't wait for someone else's site. A fixed
+// number of workers take the links from a bounded queue, when the queue is
+// full the preview is skipped and the link is shown without one.
+type Previewer struct {
+	repo   RepositoryInterface
+	client *http.Client
+	ctx    context.Context
+	jobs   chan job
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func New(repo RepositoryInterface, cfg PreviewConfig, ctx context.Context) *Previewer {
+	dialer := &net.Dialer{Timeout: time.Duration(cfg.Timeout) * time.Second, Control: denyPrivate}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	p := &Previewer{
+		repo:   repo,
+		client: &http.Client{Transport: transport, Timeout: time.Duration(cfg.Timeout) * time.Second},
+		ctx:    ctx,
+		jobs:   make(chan job, cfg.Queue),
+	}
+	p.wg.Add(cfg.Workers)
+	for range cfg.Workers {
+		go p.work()
+	}
+	return p
+}
+
+// denyPrivate keeps the fetcher from being pointed at internal services,
+// it checks the resolved address, so DNS names and redirects are covered.
+func denyPrivate(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return errPrivateAddr
+	}
+	return nil
+}
+
+// Refresh queues fetching the preview of url for shortCode. It never blocks,
+// the preview is skipped when the queue is full or the previewer is closed.
+func (p *Previewer) Refresh(shortCode string, url string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.jobs <- job{shortCode: shortCode, url: url}:
+	default:
+		logger.LoggerFromCtx(p.ctx).Lg.Warn().Str("short_code", shortCode).Msg("preview queue is full, skipping preview")
+	}
+}
+
+func (p *Previewer) work() {
+	defer p.wg.Done()
+	lg := logger.LoggerFromCtx(p.ctx).Lg
+	for j := range p.jobs {
+		preview, err := p.Fetch(p.ctx, j.url)
+		if err != nil {
+			lg.Warn().Err(err).Str("short_code", j.shortCode).Msg("failed to fetch preview")
+			continue
+		}
+		if err = p.repo.SavePreview(p.ctx, j.shortCode, preview); err != nil {
+			lg.Error().Err(err).Str("short_code", j.shortCode).Msg("failed to save preview")
+		}
+	}
+}
+
+// Close stops taking new links and waits for the queued ones.
+func (p *Previewer) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *Previewer) Fetch(ctx context.Context, url string) (models.Preview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return models.Preview{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "ShortenerPreview/1.0")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return models.Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Preview{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return models.Preview{}, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+
+	return parse(io.LimitReader(resp.Body, maxBody)), nil
+}
+
+// parse reads the head of a page, og: tags win over <title> and
+// <meta name="description">.
+func parse(r io.Reader) models.Preview {
+	var res models.Preview
+	var title, description string
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return finish(res, title, description)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "body":
+				return finish(res, title, description)
+			case "title":
+				if z.Next() == html.TextToken && title == "" {
+					title = string(z.Text())
+				}
+			case "meta":
+				key, content := "", ""
+				for _, a := range tok.Attr {
+					switch a.Key {
+					case "property", "name":
+						key = strings.ToLower(a.Val)
+					case "content":
+						content = a.Val
+					}
+				}
+				switch key {
+				case "og:title":
+					res.Title = content
+				case "og:description":
+					res.Description = content
+				case "description":
+					description = content
+				}
+			}
+		}
+	}
+}
+
+func finish(res models.Preview, title, description string) models.Preview {
+	if res.Title == "" {
+		res.Title = title
+	}
+	if res.Description == "" {
+		res.Description = description
+	}
+	res.Title = truncate(strings.Join(strings.Fields(res.Title), " "), maxTitle)
+	res.Description = truncate(strings.Join(strings.Fields(res.Description), " "), maxDescription)
+	return res
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,142 @@
+package preview
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name string
+		page string
+		want models.Preview
+	}{
+		{
+			"og tags win",
+			`<html><head><title>Plain title</title>
+			<meta property="og:title" content="OG title">
+			<meta name="description" content="Plain description">
+			<meta property="og:description" content="OG &amp; description">
+			</head><body></body></html>`,
+			models.Preview{Title: "OG title", Description: "OG & description"},
+		},
+		{
+			"falls back to title and description",
+			"<title>\n  Spaced   &lt;title&gt;\n</title><meta name=\"Description\" content=\"About\">",
+			models.Preview{Title: "Spaced <title>", Description: "About"},
+		},
+		{
+			"ignores the body",
+			`<head></head><body><title>Not a title</title><meta name="description" content="nope"></body>`,
+			models.Preview{},
+		},
+	}
+	for _, tc := range testCases {
+		if got := parse(strings.NewReader(tc.page)); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	long := parse(strings.NewReader("<title>" + strings.Repeat("я", 500) + "</title>"))
+	if n := len([]rune(long.Title)); n != maxTitle {
+		t.Errorf("expected title truncated to %d runes, got %d", maxTitle, n)
+	}
+}
+
+type memRepo struct {
+	mu    sync.Mutex
+	saved map[string]models.Preview
+}
+
+func (r *memRepo) SavePreview(_ context.Context, code string, p models.Preview) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saved[code] = p
+	return nil
+}
+
+func testPreviewer(repo RepositoryInterface, client *http.Client, cfg PreviewConfig) *Previewer {
+	p := New(repo, cfg, context.WithValue(context.Background(), logger.LoggerKey, logger.New()))
+	if client != nil {
+		p.client = client
+	}
+	return p
+}
+
+func TestRefresh(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/article":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<title>Article</title><meta name="description" content="Read me">`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	repo := &memRepo{saved: map[string]models.Preview{}}
+	p := testPreviewer(repo, srv.Client(), PreviewConfig{Timeout: 1, Workers: 2, Queue: 10})
+	p.Refresh("a", srv.URL+"/article")
+	p.Refresh("b", srv.URL+"/image")
+	p.Refresh("c", srv.URL+"/missing")
+	p.Close()
+
+	if len(repo.saved) != 1 || repo.saved["a"] != (models.Preview{Title: "Article", Description: "Read me"}) {
+		t.Fatalf("unexpected previews: %+v", repo.saved)
+	}
+}
+
+func TestRefreshSkipsWhenQueueIsFull(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>" + r.URL.Path[1:] + "</title>"))
+	}))
+	defer srv.Close()
+
+	repo := &memRepo{saved: map[string]models.Preview{}}
+	p := testPreviewer(repo, srv.Client(), PreviewConfig{Timeout: 5, Workers: 1, Queue: 1})
+	p.Refresh("a", srv.URL+"/a")
+	// the only worker is busy with a, b waits in the queue and c doesn't fit
+	<-started
+	p.Refresh("b", srv.URL+"/b")
+	p.Refresh("c", srv.URL+"/c")
+	close(release)
+	p.Close()
+	// links shortened after the shutdown get no preview
+	p.Refresh("d", srv.URL+"/d")
+
+	if len(repo.saved) != 2 || repo.saved["a"].Title != "a" || repo.saved["b"].Title != "b" {
+		t.Fatalf("expected previews of a and b, got: %+v", repo.saved)
+	}
+}
+
+func TestFetchDeniesPrivateAddresses(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Internal admin</title>`))
+	}))
+	defer srv.Close()
+
+	_, err := testPreviewer(&memRepo{}, nil, PreviewConfig{Timeout: 1, Workers: 1, Queue: 1}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, errPrivateAddr) {
+		t.Fatalf("expected errPrivateAddr, got: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package safety
+
+import (
+	"app/internal/models"
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type SafetyConfig struct {
+	// BlocklistFile has a domain or an ip per line, a domain also blocks its
+	// subdomains. Empty lines and lines starting with # are skipped.
+	BlocklistFile string `env:"BLOCKLIST_FILE" env-default:""`
+}
+
+// Checker rejects destinations from the blocklist and links to the
+// shortener itself, which would redirect in a loop.
+type Checker struct {
+	blocked  map[string]bool
+	selfHost string
+}
+
+func New(cfg SafetyConfig, baseURL string) *Checker {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse base url: %v", err))
+	}
+	c := &Checker{blocked: map[string]bool{}, selfHost: normalize(base.Hostname())}
+
+	if cfg.BlocklistFile == "" {
+		return c
+	}
+	f, err := os.Open(cfg.BlocklistFile)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open blocklist: %v", err))
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		c.blocked[normalize(strings.TrimPrefix(line, "*."))] = true
+	}
+	if err := sc.Err(); err != nil {
+		panic(fmt.Sprintf("failed to read blocklist: %v", err))
+	}
+	return c
+}
+
+func normalize(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func (c *Checker) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return models.ErrBadURL
+	}
+	host := normalize(u.Hostname())
+
+	if host == c.selfHost {
+		return models.ErrLoopURL
+	}
+	if net.ParseIP(host) != nil {
+		if c.blocked[host] {
+			return models.ErrBlockedURL
+		}
+		return nil
+	}
+	for h := host; h != ""; {
+		if c.blocked[h] {
+			return models.ErrBlockedURL
+		}
+		_, parent, found := strings.Cut(h, ".")
+		if !found {
+			break
+		}
+		h = parent
+	}
+	return nil
+}
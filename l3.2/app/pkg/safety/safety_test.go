@@ -0,0 +1,53 @@
+package safety
+
+import (
+	"app/internal/models"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	blocklist := "# phishing\nevil.example\n\n*.malware.test\n  203.0.113.9  \nTRAILING.dot.\n"
+	if err := os.WriteFile(path, []byte(blocklist), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := New(SafetyConfig{BlocklistFile: path}, "https://Sho.rt:8443/")
+
+	testCases := []struct {
+		url string
+		err error
+	}{
+		{"https://example.com/page", nil},
+		{"https://evil.example/login", models.ErrBlockedURL},
+		{"https://login.EVIL.example./", models.ErrBlockedURL},
+		{"http://cdn.malware.test/x.exe", models.ErrBlockedURL},
+		{"http://malware.test/", models.ErrBlockedURL},
+		{"http://notevil.example/", nil},
+		{"http://203.0.113.9:8080/", models.ErrBlockedURL},
+		{"http://113.9/", nil},
+		{"https://trailing.dot/", models.ErrBlockedURL},
+		{"https://sho.rt/s/abc", models.ErrLoopURL},
+		{"http://SHO.RT:80/p/abc", models.ErrLoopURL},
+		{"https://blog.sho.rt/", nil},
+	}
+	for _, tc := range testCases {
+		if err := c.Check(tc.url); !errors.Is(err, tc.err) {
+			t.Errorf("Check(%q) = %v, want %v", tc.url, err, tc.err)
+		}
+	}
+}
+
+func TestCheckWithoutBlocklist(t *testing.T) {
+	t.Parallel()
+	c := New(SafetyConfig{}, "http://localhost:8080")
+	if err := c.Check("https://evil.example"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Check("http://localhost:8080/s/abc"); !errors.Is(err, models.ErrLoopURL) {
+		t.Fatalf("expected ErrLoopURL, got: %v", err)
+	}
+}
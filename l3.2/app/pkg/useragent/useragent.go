@@ -0,0 +1,78 @@
+package useragent
+
+import (
+	"app/internal/models"
+	"regexp"
+	"strings"
+)
+
+const unknown = "Other"
+
+type rule struct {
+	name  string
+	match func(string) bool
+}
+
+func contains(subs ...string) func(string) bool {
+	return func(ua string) bool {
+		for _, s := range subs {
+			if strings.Contains(ua, s) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+var botRegex = regexp.MustCompile(`(?i)bot\b|bot/|crawler|spider|slurp|curl/|wget/|python-requests|go-http-client|okhttp|httpclient|facebookexternalhit|headless`)
+
+// Browsers are checked in order: most browsers mention Chrome and Safari too,
+// so the ones built on top of them go first.
+var browsers = []rule{
+	{"Edge", contains("Edg/", "EdgA/", "EdgiOS/", "Edge/")},
+	{"Opera", contains("OPR/", "Opera")},
+	{"Yandex", contains("YaBrowser/")},
+	{"Samsung Internet", contains("SamsungBrowser/")},
+	{"Firefox", contains("Firefox/", "FxiOS/")},
+	{"Chrome", contains("Chrome/", "CriOS/")},
+	{"Safari", func(ua string) bool { return strings.Contains(ua, "Safari/") && strings.Contains(ua, "Version/") }},
+	{"Internet Explorer", contains("MSIE ", "Trident/")},
+}
+
+// iOS user agents say "like Mac OS X", and Android ones say "Linux".
+var systems = []rule{
+	{"Windows", contains("Windows")},
+	{"iOS", contains("iPhone", "iPad", "iPod")},
+	{"macOS", contains("Macintosh", "Mac OS X")},
+	{"Android", contains("Android")},
+	{"ChromeOS", contains("CrOS")},
+	{"Linux", contains("Linux", "X11")},
+}
+
+func first(rules []rule, ua string) string {
+	for _, r := range rules {
+		if r.match(ua) {
+			return r.name
+		}
+	}
+	return unknown
+}
+
+// Parse extracts the browser, OS and device class from a User-Agent header.
+// It knows only the common families, everything else is "Other".
+func Parse(ua string) (browser, os, device string) {
+	browser, os = first(browsers, ua), first(systems, ua)
+
+	switch {
+	case ua == "" || botRegex.MatchString(ua):
+		device = models.DeviceBot
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile")):
+		device = models.DeviceTablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		device = models.DeviceMobile
+	default:
+		device = models.DeviceDesktop
+	}
+	return browser, os, device
+}
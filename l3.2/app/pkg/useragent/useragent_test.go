@@ -0,0 +1,44 @@
+package useragent
+
+import (
+	"app/internal/models"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		ua                  string
+		browser, os, device string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			"Chrome", "Windows", models.DeviceDesktop},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			"Edge", "Windows", models.DeviceDesktop},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+			"Safari", "macOS", models.DeviceDesktop},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			"Safari", "iOS", models.DeviceMobile},
+		{"Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			"Chrome", "iOS", models.DeviceTablet},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			"Chrome", "Android", models.DeviceMobile},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Safari/537.36",
+			"Samsung Internet", "Android", models.DeviceTablet},
+		{"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			"Firefox", "Linux", models.DeviceDesktop},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 YaBrowser/23.11.0.0 Safari/537.36",
+			"Yandex", "Windows", models.DeviceDesktop},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			"Other", "Other", models.DeviceBot},
+		{"TelegramBot (like TwitterBot)", "Other", "Other", models.DeviceBot},
+		{"curl/8.4.0", "Other", "Other", models.DeviceBot},
+		{"", "Other", "Other", models.DeviceBot},
+	}
+	for _, tc := range testCases {
+		browser, os, device := Parse(tc.ua)
+		if browser != tc.browser || os != tc.os || device != tc.device {
+			t.Errorf("Parse(%q) = %q, %q, %q, want %q, %q, %q", tc.ua, browser, os, device, tc.browser, tc.os, tc.device)
+		}
+	}
+}
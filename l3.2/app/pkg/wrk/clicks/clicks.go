@@ -0,0 +1,167 @@
+package clicks
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	writeAttempts   = 3
+	retryDelay      = 100 * time.Millisecond
+	shutdownTimeout = 10 * time.Second
+)
+
+type ClicksConfig struct {
+	Buffer    int `env:"CLICKS_BUFFER" env-default:"10000"`
+	BatchSize int `env:"CLICKS_BATCH_SIZE" env-default:"500"`
+	// FlushInterval is in milliseconds.
+	FlushInterval int `env:"CLICKS_FLUSH_INTERVAL" env-default:"200"`
+}
+
+// Valid checks that all sizes and the interval are positive.
+func (cfg ClicksConfig) Valid() error {
+	if cfg.Buffer <= 0 || cfg.BatchSize <= 0 || cfg.FlushInterval <= 0 {
+		return models.ErrBadClicksCfg
+	}
+	return nil
+}
+
+type RepositoryInterface interface {
+	WriteClicks(context.Context, []models.Click) error
+}
+
+type EnricherInterface interface {
+	Enrich(*models.Click)
+}
+
+// Pipeline takes clicks off the redirect path: Push never blocks, clicks are
+// enriched and written in batches every cfg.FlushInterval or cfg.BatchSize clicks. When the
+// buffer is full the click is dropped and counted, so a slow database costs
+// analytics accuracy instead of redirect latency.
+type Pipeline struct {
+	repo     RepositoryInterface
+	enricher EnricherInterface
+	cfg      ClicksConfig
+	in       chan models.Click
+
+	accepted atomic.Int64
+	dropped  atomic.Int64
+	written  atomic.Int64
+	failed   atomic.Int64
+	batches  atomic.Int64
+}
+
+func New(repo RepositoryInterface, enricher EnricherInterface, cfg ClicksConfig) *Pipeline {
+	return &Pipeline{repo: repo, enricher: enricher, cfg: cfg, in: make(chan models.Click, cfg.Buffer)}
+}
+
+func (p *Pipeline) Push(click models.Click) bool {
+	select {
+	case p.in <- click:
+		p.accepted.Add(1)
+		return true
+	default:
+		p.dropped.Add(1)
+		return false
+	}
+}
+
+func (p *Pipeline) Stats() models.ClickStats {
+	return models.ClickStats{
+		Accepted: p.accepted.Load(),
+		Dropped:  p.dropped.Load(),
+		Written:  p.written.Load(),
+		Failed:   p.failed.Load(),
+		Batches:  p.batches.Load(),
+		Queued:   len(p.in),
+	}
+}
+
+// Start writes clicks until ctx is done, then flushes what is left in the
+// buffer. Stop pushing before cancelling ctx, later clicks are lost.
+func (p *Pipeline) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	t := time.NewTicker(time.Duration(p.cfg.FlushInterval) * time.Millisecond)
+	defer t.Stop()
+
+	batch := make([]models.Click, 0, p.cfg.BatchSize)
+	for {
+		select {
+		case click := <-p.in:
+			p.enricher.Enrich(&click)
+			batch = append(batch, click)
+			if len(batch) >= p.cfg.BatchSize {
+				batch = p.flush(ctx, batch)
+			}
+		case <-t.C:
+			batch = p.flush(ctx, batch)
+		case <-ctx.Done():
+			p.drain(ctx, batch)
+			return
+		}
+	}
+}
+
+func (p *Pipeline) drain(ctx context.Context, batch []models.Click) {
+	flushCtx, canc := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer canc()
+
+	for {
+		select {
+		case click := <-p.in:
+			p.enricher.Enrich(&click)
+			batch = append(batch, click)
+			if len(batch) >= p.cfg.BatchSize {
+				batch = p.flush(flushCtx, batch)
+			}
+		default:
+			if rest := p.flush(flushCtx, batch); len(rest) > 0 {
+				p.failed.Add(int64(len(rest)))
+				logger.LoggerFromCtx(ctx).Lg.Error().Err(flushCtx.Err()).Int("clicks", len(rest)).Msg("failed to write clicks on shutdown")
+			}
+			return
+		}
+	}
+}
+
+// flush writes the batch and returns it emptied for reuse. If ctx is done
+// the batch is returned as is, so the final flush on shutdown can write it.
+func (p *Pipeline) flush(ctx context.Context, batch []models.Click) []models.Click {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	err := p.write(ctx, batch)
+	if err == nil {
+		p.written.Add(int64(len(batch)))
+		p.batches.Add(1)
+		return batch[:0]
+	} else if ctx.Err() != nil {
+		return batch
+	}
+
+	p.failed.Add(int64(len(batch)))
+	logger.LoggerFromCtx(ctx).Lg.Error().Err(err).Int("clicks", len(batch)).Msg("failed to write clicks")
+	return batch[:0]
+}
+
+func (p *Pipeline) write(ctx context.Context, batch []models.Click) error {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err := p.repo.WriteClicks(ctx, batch)
+		if err == nil || attempt == writeAttempts {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package clicks
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeRepo struct {
+	mu      sync.Mutex
+	batches [][]models.Click
+	fails   int
+	block   chan struct{}
+}
+
+func (r *fakeRepo) WriteClicks(ctx context.Context, batch []models.Click) error {
+	if r.block != nil {
+		select {
+		case <-r.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fails > 0 {
+		r.fails--
+		return errors.New("connection reset")
+	}
+	r.batches = append(r.batches, append([]models.Click(nil), batch...))
+	return nil
+}
+
+func (r *fakeRepo) written() (batches, clicks int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, b := range r.batches {
+		clicks += len(b)
+	}
+	return len(r.batches), clicks
+}
+
+type nopEnricher struct{}
+
+func (nopEnricher) Enrich(*models.Click) {}
+
+func testCtx() context.Context {
+	return context.WithValue(context.Background(), logger.LoggerKey, logger.New())
+}
+
+func start(p *Pipeline) (stop func()) {
+	ctx, canc := context.WithCancel(testCtx())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go p.Start(ctx, wg)
+	return func() {
+		canc()
+		wg.Wait()
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPipelineBatchesBySize(t *testing.T) {
+	t.Parallel()
+	repo := &fakeRepo{}
+	p := New(repo, nopEnricher{}, ClicksConfig{Buffer: 100, BatchSize: 10, FlushInterval: 60000})
+	stop := start(p)
+	defer stop()
+
+	for i := range 25 {
+		p.Push(models.Click{UrlId: int64(i)})
+	}
+	waitFor(t, func() bool { b, _ := repo.written(); return b == 2 })
+	if _, n := repo.written(); n != 20 {
+		t.Fatalf("expected two full batches, got %d clicks", n)
+	}
+}
+
+func TestPipelineFlushesByInterval(t *testing.T) {
+	t.Parallel()
+	repo := &fakeRepo{}
+	p := New(repo, nopEnricher{}, ClicksConfig{Buffer: 100, BatchSize: 100, FlushInterval: 10})
+	stop := start(p)
+	defer stop()
+
+	p.Push(models.Click{UrlId: 1})
+	p.Push(models.Click{UrlId: 2})
+	waitFor(t, func() bool { _, n := repo.written(); return n == 2 })
+}
+
+func TestPipelineDropsWhenFull(t *testing.T) {
+	t.Parallel()
+	repo := &fakeRepo{block: make(chan struct{})}
+	p := New(repo, nopEnricher{}, ClicksConfig{Buffer: 5, BatchSize: 1, FlushInterval: 60000})
+	stop := start(p)
+
+	// The first click is taken by the blocked writer, five fill the buffer.
+	p.Push(models.Click{})
+	waitFor(t, func() bool { return p.Stats().Queued == 0 })
+	for range 10 {
+		p.Push(models.Click{})
+	}
+
+	stats := p.Stats()
+	if stats.Accepted != 6 || stats.Dropped != 5 || stats.Queued != 5 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	close(repo.block)
+	stop()
+	if stats := p.Stats(); stats.Written != 6 || stats.Failed != 0 {
+		t.Fatalf("unexpected stats after shutdown: %+v", stats)
+	}
+}
+
+func TestPipelineFlushesOnShutdown(t *testing.T) {
+	t.Parallel()
+	repo := &fakeRepo{}
+	p := New(repo, nopEnricher{}, ClicksConfig{Buffer: 1000, BatchSize: 300, FlushInterval: 60000})
+	stop := start(p)
+
+	for range 700 {
+		p.Push(models.Click{})
+	}
+	stop()
+
+	if _, n := repo.written(); n != 700 {
+		t.Fatalf("expected all clicks written on shutdown, got %d", n)
+	}
+}
+
+func TestPipelineRetriesWrite(t *testing.T) {
+	t.Parallel()
+	repo := &fakeRepo{fails: writeAttempts - 1}
+	p := New(repo, nopEnricher{}, ClicksConfig{Buffer: 10, BatchSize: 3, FlushInterval: 60000})
+	stop := start(p)
+	defer stop()
+
+	for range 3 {
+		p.Push(models.Click{})
+	}
+	waitFor(t, func() bool { return p.Stats().Written == 3 })
+
+	repo.mu.Lock()
+	repo.fails = writeAttempts
+	repo.mu.Unlock()
+	for range 3 {
+		p.Push(models.Click{})
+	}
+	waitFor(t, func() bool { return p.Stats().Failed == 3 })
+}
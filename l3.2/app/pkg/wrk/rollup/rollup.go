@@ -0,0 +1,78 @@
+package rollup
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"sync"
+	"time"
+)
+
+type RollupConfig struct {
+	Interval  int `env:"ROLLUP_INTERVAL" env-default:"60"`
+	BatchSize int `env:"ROLLUP_BATCH_SIZE" env-default:"10000"`
+}
+
+// Valid checks that the interval and batch size are positive.
+func (cfg RollupConfig) Valid() error {
+	if cfg.Interval <= 0 || cfg.BatchSize <= 0 {
+		return models.ErrBadRollupCfg
+	}
+	return nil
+}
+
+type RepositoryInterface interface {
+	// RollupClicks takes up to limit clicks not rolled up yet, recomputes the
+	// hourly and daily buckets they fall into and returns how many it took.
+	RollupClicks(ctx context.Context, limit int) (int, error)
+}
+
+// Aggregator keeps click_rollups_hourly and click_rollups_daily up to date.
+// Buckets are recomputed from clicks instead of incremented, so late clicks
+// and concurrent aggregators can't skew them.
+type Aggregator struct {
+	repo RepositoryInterface
+	cfg  RollupConfig
+}
+
+func New(repo RepositoryInterface, cfg RollupConfig) *Aggregator {
+	return &Aggregator{repo, cfg}
+}
+
+func (a *Aggregator) Start(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	t := time.NewTicker(time.Duration(a.cfg.Interval) * time.Second)
+	defer t.Stop()
+	for {
+		a.process(ctx)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// process rolls up clicks until there's less than a batch left.
+func (a *Aggregator) process(ctx context.Context) {
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	total := 0
+	for ctx.Err() == nil {
+		n, err := a.repo.RollupClicks(ctx, a.cfg.BatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				lg.Error().Err(err).Msg("failed to roll up clicks")
+			}
+			return
+		}
+		total += n
+		if n < a.cfg.BatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		lg.Debug().Int("clicks", total).Msg("rolled up clicks")
+	}
+}
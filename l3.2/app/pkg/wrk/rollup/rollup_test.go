@@ -0,0 +1,56 @@
+package rollup
+
+import (
+	"app/pkg/logger"
+	"context"
+	"errors"
+	"testing"
+)
+
+type fakeRepo struct {
+	pending int
+	calls   int
+	err     error
+}
+
+func (r *fakeRepo) RollupClicks(_ context.Context, limit int) (int, error) {
+	r.calls++
+	if r.err != nil {
+		return 0, r.err
+	}
+	n := min(limit, r.pending)
+	r.pending -= n
+	return n, nil
+}
+
+func testCtx() context.Context {
+	return context.WithValue(context.Background(), logger.LoggerKey, logger.New())
+}
+
+func TestProcessDrainsBacklog(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		pending, calls int
+	}{
+		{0, 1},
+		{99, 1},
+		{100, 2},
+		{250, 3},
+	}
+	for _, tc := range testCases {
+		repo := &fakeRepo{pending: tc.pending}
+		New(repo, RollupConfig{Interval: 1, BatchSize: 100}).process(testCtx())
+		if repo.pending != 0 || repo.calls != tc.calls {
+			t.Errorf("pending %d: expected %d calls, got %d with %d left", tc.pending, tc.calls, repo.calls, repo.pending)
+		}
+	}
+}
+
+func TestProcessStopsOnError(t *testing.T) {
+	t.Parallel()
+	repo := &fakeRepo{pending: 1000, err: errors.New("connection refused")}
+	New(repo, RollupConfig{Interval: 1, BatchSize: 100}).process(testCtx())
+	if repo.calls != 1 {
+		t.Fatalf("expected to give up until the next tick, got %d calls", repo.calls)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"app/internal/repository"
 	"app/internal/service"
 	"app/internal/transport"
+	"app/pkg/auth"
 	"app/pkg/data"
+	"app/pkg/filter"
 	"app/pkg/logger"
 	"context"
 	"os"
@@ -20,14 +22,22 @@ func main() {
 	cfg := config.New()
 	data := data.New(cfg.DataConfig)
 	repo := repository.New(data)
-	service := service.New(repo)
-	server := transport.New(service, &cfg.ServerConfig, ctx)
+	filter := filter.New(cfg.FilterConfig)
+	hub := service.NewHub(repo, cfg.StreamConfig)
+	service := service.New(repo, filter, cfg.ServiceConfig)
+	auth := auth.New(cfg.AuthConfig)
+	server := transport.New(service, hub, auth, &cfg.ServerConfig, ctx)
 
 	graceCh := make(chan os.Signal, 1)
 	signal.Notify(graceCh, syscall.SIGINT, syscall.SIGTERM)
 
+	hubCtx, stopHub := context.WithCancel(ctx)
+	go hub.Run(hubCtx)
 	go server.Start()
 
 	<-graceCh
+	// ending the streams first lets the server shut down without waiting
+	// for them
+	stopHub()
 	server.Stop()
 }
@@ -2,8 +2,11 @@ package config
 
 import (
 	"app/internal/models"
+	"app/internal/service"
 	"app/internal/transport"
+	"app/pkg/auth"
 	"app/pkg/data"
+	"app/pkg/filter"
 	"fmt"
 	"strconv"
 
@@ -12,8 +15,12 @@ import (
 )
 
 type Config struct {
-	ServerConfig transport.ServerConfig
-	DataConfig   data.DataConfig
+	ServerConfig  transport.ServerConfig
+	DataConfig    data.DataConfig
+	ServiceConfig service.ServiceConfig
+	StreamConfig  service.StreamConfig
+	FilterConfig  filter.FilterConfig
+	AuthConfig    auth.AuthConfig
 }
 
 func (c *Config) valid() error {
@@ -27,6 +34,18 @@ func (c *Config) valid() error {
 	if err := validReleaseMode(c.ServerConfig.ReleaseMode); err != nil {
 		resultErr = multierr.Append(resultErr, err)
 	}
+	if err := c.ServiceConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.StreamConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.FilterConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.AuthConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
 	return resultErr
 }
 
@@ -6,28 +6,115 @@ import (
 )
 
 type Comment struct {
-	ID        string     `json:"id" db:"id"`
-	ParentID  *string    `json:"parent_id,omitempty" db:"parent_id"`
-	Content   string     `json:"content" db:"content"`
-	Path      string     `json:"path,omitempty" db:"path"`
-	Depth     int        `json:"depth" db:"depth"`
-	CreatedAt time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
-	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	ID         string     `json:"id" db:"id"`
+	ParentID   *string    `json:"parent_id,omitempty" db:"parent_id"`
+	ThreadKey  string     `json:"thread_key" db:"thread_key"`
+	AuthorID   *string    `json:"author_id,omitempty" db:"author_id"`
+	AuthorName string     `json:"author_name,omitempty" db:"author_name"`
+	Content    string     `json:"content" db:"content"`
+	Path       string     `json:"path,omitempty" db:"path"`
+	Depth      int        `json:"depth" db:"depth"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	EditedAt   *time.Time `json:"edited_at,omitempty" db:"edited_at"`
+	HiddenAt   *time.Time `json:"hidden_at,omitempty" db:"hidden_at"`
+	Likes      int        `json:"likes" db:"likes"`
+	Dislikes   int        `json:"dislikes" db:"dislikes"`
+}
+
+// Author is the user a signed token was issued to.
+type Author struct {
+	ID   string
+	Name string
 }
 
 type CreateCommentRequest struct {
-	ParentID *string `json:"parent_id,omitempty" validate:"omitempty,uuid4"`
-	Content  string  `json:"content" validate:"required,min=1,max=5000"`
+	ParentID  *string `json:"parent_id,omitempty" validate:"omitempty,uuid4"`
+	ThreadKey string  `json:"thread_key,omitempty"`
+	Content   string  `json:"content" validate:"required,min=1,max=5000"`
+	Author    Author  `json:"-"`
+}
+
+type UpdateCommentRequest struct {
+	Content string `json:"content"`
+	Author  Author `json:"-"`
+}
+
+type ReactionRequest struct {
+	// Value is 1 for a like, -1 for a dislike and 0 to take the reaction back.
+	Value  int    `json:"value"`
+	Author Author `json:"-"`
+}
+
+type ReactionResponse struct {
+	Likes    int `json:"likes"`
+	Dislikes int `json:"dislikes"`
+	Value    int `json:"value"`
+}
+
+// CommentEvent is a change to a comment pushed to stream subscribers. ID is
+// the position in the event log that reconnecting clients resume after.
+type CommentEvent struct {
+	ID      int64    `json:"id"`
+	Type    string   `json:"type"`
+	Comment *Comment `json:"comment,omitempty"`
+}
+
+type StreamRequest struct {
+	ThreadKey   string
+	ParentID    *string
+	LastEventID int64
+}
+
+type CommentEdit struct {
+	Content  string    `json:"content"`
+	EditedAt time.Time `json:"edited_at"`
 }
 
+type ReportRequest struct {
+	Reason string `json:"reason"`
+	// Reporter is the author who files the report or, without a token, the
+	// client address.
+	Reporter string `json:"-"`
+}
+
+type HideRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ModerationItem struct {
+	Comment
+	HiddenReason string   `json:"hidden_reason,omitempty"`
+	ReportCount  int      `json:"report_count"`
+	Reasons      []string `json:"reasons"`
+}
+
+type ModerationQueueResponse struct {
+	Items      []ModerationItem `json:"items"`
+	Total      int64            `json:"total"`
+	Page       int              `json:"page"`
+	Limit      int              `json:"limit"`
+	TotalPages int              `json:"total_pages"`
+}
+
+// Verdict is what a content filter decides about a new or edited comment.
+type Verdict int
+
+const (
+	VerdictAllow Verdict = iota
+	VerdictReview
+	VerdictReject
+)
+
 type GetCommentsRequest struct {
-	ParentID *string `form:"parent_id"`
-	Page     int     `form:"page" validate:"min=1"`
-	Limit    int     `form:"limit" validate:"min=1,max=100"`
-	Query    string  `form:"query"`
-	SortBy   string  `form:"sort_by" validate:"oneof=created_at updated_at depth"`
-	Order    string  `form:"order" validate:"oneof=asc desc"`
+	ParentID  *string `form:"parent_id"`
+	ThreadKey string  `form:"thread_key"`
+	Page      int     `form:"page" validate:"min=1"`
+	Limit     int     `form:"limit" validate:"min=1,max=100"`
+	Query     string  `form:"query"`
+	SortBy    string  `form:"sort_by" validate:"oneof=created_at updated_at depth top hot"`
+	Order     string  `form:"order" validate:"oneof=asc desc"`
 }
 
 type GetCommentsResponse struct {
@@ -38,6 +125,54 @@ type GetCommentsResponse struct {
 	TotalPages int       `json:"total_pages"`
 }
 
+type CommentNode struct {
+	Comment
+	ReplyCount int            `json:"reply_count"`
+	Children   []*CommentNode `json:"children,omitempty"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type GetTreeRequest struct {
+	ID     string
+	Depth  int
+	Limit  int
+	Cursor string
+}
+
+type GetThreadsRequest struct {
+	ThreadKey string
+	Limit     int
+	Cursor    string
+}
+
+type GetThreadsResponse struct {
+	Threads    []*CommentNode `json:"threads"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type SearchRequest struct {
+	ThreadKey string
+	Query     string
+	Lang      string
+	Page      int
+	Limit     int
+}
+
+type SearchHit struct {
+	Comment
+	Rank     float64      `json:"rank"`
+	Headline string       `json:"headline"`
+	Thread   *CommentNode `json:"thread"`
+}
+
+type SearchResponse struct {
+	Hits       []SearchHit `json:"hits"`
+	Total      int64       `json:"total"`
+	Page       int         `json:"page"`
+	Limit      int         `json:"limit"`
+	TotalPages int         `json:"total_pages"`
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -47,6 +182,20 @@ var (
 	ErrCommentNotFound  = errors.New("comment not found")
 	ErrParentNotFound   = errors.New("parent comment not found")
 	ErrMaxDepthExceeded = errors.New("maximum nesting depth exceeded")
+	ErrBadCursor        = errors.New("bad cursor")
+	ErrShortSearchQuery = errors.New("search query is too short")
+	ErrBadSearchLang    = errors.New("unsupported search language")
+	ErrRejectedContent  = errors.New("comment rejected by content filter")
+	ErrCommentHidden    = errors.New("comment is hidden by moderation")
+	ErrBadModerationCfg = errors.New("bad moderation config")
+	ErrBadFilterCfg     = errors.New("bad filter config")
+	ErrBadAuthCfg       = errors.New("bad auth config")
+	ErrUnauthorized     = errors.New("valid author token required")
+	ErrForbidden        = errors.New("comment belongs to another author")
+	ErrBadThreadKey     = errors.New("bad thread key")
+	ErrBadReaction      = errors.New("reaction must be 1, -1 or 0")
+	ErrBadStreamCfg     = errors.New("bad stream config")
+	ErrStreamFull       = errors.New("too many stream subscribers")
 	ErrBadPort          = errors.New("bad port")
 	ErrBadReleaseMode   = errors.New("bad release mode")
 )
@@ -57,4 +206,38 @@ const (
 	DefaultPageSize      = 20
 	MaxPageSize          = 100
 	MinSearchQueryLength = 2
+	DefaultTreeDepth     = 3
+	DefaultRepliesLimit  = 10
+	MaxReasonLength      = 500
+)
+
+const (
+	SearchLangRussian = "ru"
+	SearchLangEnglish = "en"
+)
+
+// SearchConfigs maps the supported search languages to postgres text search
+// configurations. Every configuration here needs its own GIN index.
+var SearchConfigs = map[string]string{
+	SearchLangRussian: "russian",
+	SearchLangEnglish: "english",
+}
+
+const (
+	SortTop = "top"
+	SortHot = "hot"
+)
+
+// Comment event types. A reset event tells the client that part of what it
+// missed is no longer in the event log and it has to refetch.
+const (
+	EventCreated = "created"
+	EventEdited  = "edited"
+	EventDeleted = "deleted"
+	EventReset   = "reset"
+)
+
+const (
+	HiddenByFilter  = "filter"
+	HiddenByReports = "reports"
 )
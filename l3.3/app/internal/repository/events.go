@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"app/internal/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// eventsChannel is where the comments trigger announces new events.
+const eventsChannel = "comment_events"
+
+// Listen delivers the ids of comment events as they are committed by any
+// instance. A zero id means the connection was re-established and events
+// may have been missed in between. The channel is closed when ctx is done.
+func (r *Repository) Listen(ctx context.Context) (<-chan int64, error) {
+	listener := pq.NewListener(r.data.DSN, time.Second, time.Minute, nil)
+	if err := listener.Listen(eventsChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", eventsChannel, err)
+	}
+
+	ids := make(chan int64)
+	go func() {
+		defer close(ids)
+		defer listener.Close()
+
+		for {
+			var id int64
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				if n != nil {
+					parsed, err := strconv.ParseInt(n.Extra, 10, 64)
+					if err != nil {
+						continue
+					}
+					id = parsed
+				}
+			case <-time.After(90 * time.Second):
+				// an idle connection can die silently, pinging notices it
+				go listener.Ping()
+				continue
+			}
+
+			select {
+			case ids <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ids, nil
+}
+
+// GetEvent returns the logged event by its id.
+func (r *Repository) GetEvent(ctx context.Context, id int64) (*models.CommentEvent, error) {
+	var event models.CommentEvent
+	var comment []byte
+	err := r.data.DB.QueryRowContext(ctx, `
+		SELECT id, type, comment FROM comment_events WHERE id = $1
+	`, id).Scan(&event.ID, &event.Type, &comment)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	}
+
+	if err := json.Unmarshal(comment, &event.Comment); err != nil {
+		return nil, fmt.Errorf("failed to decode event comment: %w", err)
+	}
+
+	return &event, nil
+}
+
+// GetEventsAfter returns up to limit events logged after the given id, in
+// log order. An empty threadKey matches every thread, a non-nil parentID
+// only the parent and the comments below it.
+func (r *Repository) GetEventsAfter(ctx context.Context, after int64, threadKey string, parentID *string, limit int) ([]models.CommentEvent, error) {
+	rows, err := r.data.DB.QueryContext(ctx, `
+		SELECT id, type, comment FROM comment_events
+		WHERE id > $1
+			AND ($2 = '' OR thread_key = $2)
+			AND ($3::text IS NULL OR '/' || path || '/' LIKE '%/' || $3 || '/%')
+		ORDER BY id
+		LIMIT $4
+	`, after, threadKey, parentID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.CommentEvent{}
+	for rows.Next() {
+		var event models.CommentEvent
+		var comment []byte
+		if err := rows.Scan(&event.ID, &event.Type, &comment); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		if err := json.Unmarshal(comment, &event.Comment); err != nil {
+			return nil, fmt.Errorf("failed to decode event comment: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return events, nil
+}
+
+// EventBounds returns the newest pruned id and the newest logged id, zeros
+// for a log that was never written.
+func (r *Repository) EventBounds(ctx context.Context) (pruned, last int64, err error) {
+	err = r.data.DB.QueryRowContext(ctx, `
+		SELECT through, GREATEST(through, (SELECT COALESCE(MAX(id), 0) FROM comment_events))
+		FROM comment_events_pruned
+	`).Scan(&pruned, &last)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get event bounds: %w", err)
+	}
+	return pruned, last, nil
+}
+
+// PruneEvents drops the events logged before the given time and remembers
+// the newest id it dropped.
+func (r *Repository) PruneEvents(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	err := r.data.DB.QueryRowContext(ctx, `
+		WITH pruned AS (
+			DELETE FROM comment_events WHERE created_at < $1 RETURNING id
+		), marked AS (
+			UPDATE comment_events_pruned SET through = GREATEST(through, (SELECT MAX(id) FROM pruned))
+		)
+		SELECT COUNT(*) FROM pruned
+	`, before).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPruneEventsMarksNewestPrunedID(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	before := time.Now()
+
+	mock.ExpectQuery(`WITH pruned AS \(\s+DELETE FROM comment_events WHERE created_at < \$1 RETURNING id\s+\), marked AS \(\s+UPDATE comment_events_pruned SET through = GREATEST\(through, \(SELECT MAX\(id\) FROM pruned\)\)`).
+		WithArgs(before).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	n, err := repo.PruneEvents(context.Background(), before)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 pruned events, got %d", n)
+	}
+}
+
+func TestEventBounds(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectQuery(`SELECT through, GREATEST\(through, \(SELECT COALESCE\(MAX\(id\), 0\) FROM comment_events\)\)\s+FROM comment_events_pruned`).
+		WillReturnRows(sqlmock.NewRows([]string{"through", "last"}).AddRow(9, 12))
+
+	pruned, last, err := repo.EventBounds(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pruned != 9 || last != 12 {
+		t.Fatalf("expected 9 and 12, got %d and %d", pruned, last)
+	}
+}
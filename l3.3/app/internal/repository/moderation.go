@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"app/internal/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// rawColumns is commentColumns with the content as is, for the author and
+// moderators.
+func rawColumns(prefix string) string {
+	return strings.ReplaceAll(`@id, @parent_id, @thread_key, @author_id, COALESCE(@author_name, ''),
+		@content, @path, @depth, @created_at, @updated_at, @deleted_at, @edited_at, @hidden_at, @likes, @dislikes`, "@", prefix)
+}
+
+// Update replaces the content of a visible comment of authorID and keeps the
+// previous version in its edit history. A non-empty hiddenReason hides the
+// comment until a moderator restores it.
+func (r *Repository) Update(ctx context.Context, id string, authorID string, content string, hiddenReason string) (*models.Comment, error) {
+	tx, err := r.data.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var old string
+	var ownerID *string
+	var hiddenAt *time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT content, author_id, hidden_at FROM comments
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, id).Scan(&old, &ownerID, &hiddenAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comment: %w", err)
+	}
+	if ownerID == nil || *ownerID != authorID {
+		return nil, models.ErrForbidden
+	}
+	if hiddenAt != nil {
+		return nil, models.ErrCommentHidden
+	}
+
+	now := time.Now()
+	if old != content {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO comment_edits (comment_id, content, edited_at)
+			VALUES ($1, $2, $3)
+		`, id, old, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save edit: %w", err)
+		}
+	}
+
+	comment := &models.Comment{}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE comments
+		SET content = $2,
+			edited_at = CASE WHEN content = $2 THEN edited_at ELSE $3 END,
+			updated_at = $3,
+			hidden_at = CASE WHEN $4 = '' THEN NULL ELSE $3::timestamptz END,
+			hidden_reason = NULLIF($4, '')
+		WHERE id = $1
+		RETURNING `+rawColumns("")+`
+	`, id, content, now, hiddenReason).Scan(commentDest(comment)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update comment: %w", err)
+	}
+
+	if err = r.commit(tx); err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+// History returns the previous versions of a visible comment, latest first.
+func (r *Repository) History(ctx context.Context, id string) ([]models.CommentEdit, error) {
+	var exists bool
+	err := r.data.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM comments
+			WHERE id = $1 AND deleted_at IS NULL AND hidden_at IS NULL
+		)
+	`, id).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comment: %w", err)
+	}
+	if !exists {
+		return nil, models.ErrCommentNotFound
+	}
+
+	rows, err := r.data.DB.QueryContext(ctx, `
+		SELECT content, edited_at FROM comment_edits
+		WHERE comment_id = $1
+		ORDER BY edited_at DESC, id DESC
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query edits: %w", err)
+	}
+	defer rows.Close()
+
+	edits := []models.CommentEdit{}
+	for rows.Next() {
+		var edit models.CommentEdit
+		if err := rows.Scan(&edit.Content, &edit.EditedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan edit: %w", err)
+		}
+		edits = append(edits, edit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return edits, nil
+}
+
+// Report files a complaint about the comment. Each reporter counts once, a
+// repeated report is ignored while the earlier one is open, after a restore
+// the reporter can report again. Once threshold reporters have open reports
+// the comment is hidden until a moderator looks at it.
+func (r *Repository) Report(ctx context.Context, id string, reporter string, reason string, threshold int) error {
+	tx, err := r.data.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT TRUE FROM comments WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, id).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrCommentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock comment: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO comment_reports (comment_id, reporter, reason)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (comment_id, reporter) WHERE resolved_at IS NULL DO NOTHING
+	`, id, reporter, reason)
+	if err != nil {
+		return fmt.Errorf("failed to save report: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE comments c
+		SET report_count = open.reporters,
+			hidden_at = CASE
+				WHEN c.hidden_at IS NULL AND open.reporters >= $2 THEN $3
+				ELSE c.hidden_at
+			END,
+			hidden_reason = CASE
+				WHEN c.hidden_at IS NULL AND open.reporters >= $2 THEN $4
+				ELSE c.hidden_reason
+			END
+		FROM (
+			SELECT COUNT(DISTINCT reporter) AS reporters FROM comment_reports
+			WHERE comment_id = $1 AND resolved_at IS NULL
+		) open
+		WHERE c.id = $1
+	`, id, threshold, time.Now(), models.HiddenByReports)
+	if err != nil {
+		return fmt.Errorf("failed to report comment: %w", err)
+	}
+
+	return r.commit(tx)
+}
+
+// Hide takes the comment out of public view, its replies stay visible.
+func (r *Repository) Hide(ctx context.Context, id string, reason string) error {
+	res, err := r.data.DB.ExecContext(ctx, `
+		UPDATE comments
+		SET hidden_at = COALESCE(hidden_at, $2), hidden_reason = $3
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id, time.Now(), reason)
+	if err != nil {
+		return fmt.Errorf("failed to hide comment: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.ErrCommentNotFound
+	}
+	return nil
+}
+
+// Restore shows the comment again and resolves its open reports.
+func (r *Repository) Restore(ctx context.Context, id string) error {
+	tx, err := r.data.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE comments
+		SET hidden_at = NULL, hidden_reason = NULL, report_count = 0
+		WHERE id = $1 AND deleted_at IS NULL
+	`, id)
+	if err != nil {
+		return fmt.Errorf("failed to restore comment: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.ErrCommentNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE comment_reports SET resolved_at = $2
+		WHERE comment_id = $1 AND resolved_at IS NULL
+	`, id, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to resolve reports: %w", err)
+	}
+
+	return r.commit(tx)
+}
+
+// ModerationQueue lists hidden and reported comments with their open report
+// reasons, most reported first.
+func (r *Repository) ModerationQueue(ctx context.Context, page, limit int) (*models.ModerationQueueResponse, error) {
+	const cond = `deleted_at IS NULL AND (hidden_at IS NOT NULL OR report_count > 0)`
+
+	var total int64
+	err := r.data.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM comments WHERE `+cond).Scan(&total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count comments: %w", err)
+	}
+
+	resp := &models.ModerationQueueResponse{
+		Items: []models.ModerationItem{},
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}
+	if total > 0 {
+		resp.TotalPages = int((total + int64(limit) - 1) / int64(limit))
+	}
+	if total == 0 || page > resp.TotalPages {
+		return resp, nil
+	}
+
+	rows, err := r.data.DB.QueryContext(ctx, `
+		SELECT `+rawColumns("c.")+`, COALESCE(c.hidden_reason, ''), c.report_count,
+			COALESCE((
+				SELECT json_agg(rep.reason ORDER BY rep.created_at DESC)
+				FROM comment_reports rep
+				WHERE rep.comment_id = c.id AND rep.resolved_at IS NULL
+			), '[]')
+		FROM comments c
+		WHERE `+cond+`
+		ORDER BY c.report_count DESC, c.created_at, c.id
+		LIMIT $1 OFFSET $2
+	`, limit, (page-1)*limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query comments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.ModerationItem
+		var reasons []byte
+		dest := append(commentDest(&item.Comment), &item.HiddenReason, &item.ReportCount, &reasons)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan comment: %w", err)
+		}
+		if err := json.Unmarshal(reasons, &item.Reasons); err != nil {
+			return nil, fmt.Errorf("failed to decode reasons: %w", err)
+		}
+		resp.Items = append(resp.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return resp, nil
+}
+
+// React sets the reaction of userID to the visible comment, 0 takes it back,
+// and keeps the like and dislike counters of the comment in step.
+func (r *Repository) React(ctx context.Context, id string, userID string, value int) (*models.ReactionResponse, error) {
+	tx, err := r.data.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	resp := &models.ReactionResponse{Value: value}
+	err = tx.QueryRowContext(ctx, `
+		SELECT likes, dislikes FROM comments
+		WHERE id = $1 AND deleted_at IS NULL AND hidden_at IS NULL
+		FOR UPDATE
+	`, id).Scan(&resp.Likes, &resp.Dislikes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comment: %w", err)
+	}
+
+	var old int
+	err = tx.QueryRowContext(ctx, `
+		SELECT value FROM comment_reactions
+		WHERE comment_id = $1 AND user_id = $2
+	`, id, userID).Scan(&old)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get reaction: %w", err)
+	}
+	if old == value {
+		return resp, nil
+	}
+
+	if value == 0 {
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM comment_reactions
+			WHERE comment_id = $1 AND user_id = $2
+		`, id, userID)
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO comment_reactions (comment_id, user_id, value)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (comment_id, user_id) DO UPDATE SET value = EXCLUDED.value, created_at = NOW()
+		`, id, userID, value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save reaction: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE comments SET likes = likes + $2, dislikes = dislikes + $3
+		WHERE id = $1
+		RETURNING likes, dislikes
+	`, id, count(value, 1)-count(old, 1), count(value, -1)-count(old, -1)).Scan(&resp.Likes, &resp.Dislikes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update counters: %w", err)
+	}
+
+	if err = r.commit(tx); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func count(value, want int) int {
+	if value == want {
+		return 1
+	}
+	return 0
+}
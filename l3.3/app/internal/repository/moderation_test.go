@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"app/internal/models"
+	"app/pkg/data"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+const reportedID = "123e4567-e89b-12d3-a456-426614174000"
+
+func newMockRepo(t *testing.T) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return New(&data.Data{DB: &dbpg.DB{Master: db}}), mock
+}
+
+func TestReportCountsReporters(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT TRUE FROM comments WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(reportedID).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	mock.ExpectExec(`INSERT INTO comment_reports \(comment_id, reporter, reason\)\s+VALUES \(\$1, \$2, \$3\)\s+ON CONFLICT \(comment_id, reporter\) WHERE resolved_at IS NULL DO NOTHING`).
+		WithArgs(reportedID, "author:alice", "spam").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE comments c\s+SET report_count = open.reporters,.*SELECT COUNT\(DISTINCT reporter\) AS reporters FROM comment_reports\s+WHERE comment_id = \$1 AND resolved_at IS NULL`).
+		WithArgs(reportedID, 3, sqlmock.AnyArg(), models.HiddenByReports).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := repo.Report(context.Background(), reportedID, "author:alice", "spam", 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReportIgnoresRepeatedReporter(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT TRUE FROM comments`).
+		WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+	mock.ExpectExec(`INSERT INTO comment_reports`).
+		WithArgs(reportedID, "ip:10.0.0.1", "spam again").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// the count isn't touched
+	mock.ExpectRollback()
+
+	if err := repo.Report(context.Background(), reportedID, "ip:10.0.0.1", "spam again", 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReportAgainAfterRestore(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	ctx := context.Background()
+
+	expectReport := func() {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT TRUE FROM comments`).
+			WillReturnRows(sqlmock.NewRows([]string{"bool"}).AddRow(true))
+		// only an open report of the same reporter conflicts
+		mock.ExpectExec(`INSERT INTO comment_reports .* ON CONFLICT \(comment_id, reporter\) WHERE resolved_at IS NULL DO NOTHING`).
+			WithArgs(reportedID, "author:alice", "spam").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE comments c\s+SET report_count = open.reporters`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	expectReport()
+	if err := repo.Report(ctx, reportedID, "author:alice", "spam", 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE comments\s+SET hidden_at = NULL, hidden_reason = NULL, report_count = 0`).
+		WithArgs(reportedID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE comment_reports SET resolved_at = \$2\s+WHERE comment_id = \$1 AND resolved_at IS NULL`).
+		WithArgs(reportedID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := repo.Restore(ctx, reportedID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the resolved report doesn't stand in the way, the comment is counted again
+	expectReport()
+	if err := repo.Report(ctx, reportedID, "author:alice", "spam", 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestReportMissingComment(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT TRUE FROM comments`).WillReturnRows(sqlmock.NewRows([]string{"bool"}))
+	mock.ExpectRollback()
+
+	if err := repo.Report(context.Background(), reportedID, "ip:10.0.0.1", "spam", 3); !errors.Is(err, models.ErrCommentNotFound) {
+		t.Fatalf("expected ErrCommentNotFound, got %v", err)
+	}
+}
+
+func TestDeleteAuthorlessComment(t *testing.T) {
+	tests := []struct {
+		name     string
+		authorID string
+		err      error
+	}{
+		{"moderator", "", nil},
+		{"author", "alice", models.ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newMockRepo(t)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT parent_id, author_id, EXISTS .* FOR UPDATE`).
+				WithArgs(reportedID).
+				WillReturnRows(sqlmock.NewRows([]string{"parent_id", "author_id", "exists"}).AddRow(nil, nil, false))
+			if tt.err == nil {
+				mock.ExpectExec(`DELETE FROM comments WHERE id = \$1`).
+					WithArgs(reportedID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err := repo.Delete(context.Background(), reportedID, tt.authorID)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -2,8 +2,11 @@ package repository
 
 import (
 	"app/internal/models"
+	"app/pkg/cursor"
 	"app/pkg/data"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -11,6 +14,61 @@ import (
 	"github.com/google/uuid"
 )
 
+// nodeSelect reads the comments collected in the "page" CTE together with the
+// number of their direct replies, counted by one grouped query for the whole
+// page. Deleted comments stay in the tree as tombstones while they have
+// replies.
+var nodeSelect = `
+	SELECT ` + commentColumns("p.") + `, COALESCE(r.cnt, 0)
+	FROM page p
+	LEFT JOIN (
+		SELECT parent_id, COUNT(*) AS cnt
+		FROM comments
+		WHERE parent_id IN (SELECT id FROM page)
+		GROUP BY parent_id
+	) r ON r.parent_id = p.id
+`
+
+const (
+	// wilsonScore is the lower bound of the 95% Wilson interval for the share
+	// of likes, so a few votes rank below many mostly positive ones.
+	wilsonScore = `(CASE WHEN likes + dislikes = 0 THEN 0 ELSE
+		((likes + 1.9208) / (likes + dislikes)
+			- 1.96 * SQRT((likes * dislikes)::float8 / (likes + dislikes) + 0.9604) / (likes + dislikes))
+		/ (1 + 3.8416 / (likes + dislikes)) END)`
+	// hotScore decays the vote balance with age in hours, as on Hacker News.
+	hotScore = `((likes - dislikes) / POWER(EXTRACT(EPOCH FROM NOW() - created_at) / 3600 + 2, 1.8))`
+)
+
+// commentColumns is what public reads return for the comments aliased by
+// prefix, "" for none. The content of hidden comments is not shown. The
+// columns are scanned by commentDest.
+func commentColumns(prefix string) string {
+	return strings.ReplaceAll(`@id, @parent_id, @thread_key, @author_id, COALESCE(@author_name, ''),
+		CASE WHEN @hidden_at IS NULL THEN @content ELSE '' END, @path, @depth,
+		@created_at, @updated_at, @deleted_at, @edited_at, @hidden_at, @likes, @dislikes`, "@", prefix)
+}
+
+func commentDest(c *models.Comment) []interface{} {
+	return []interface{}{
+		&c.ID,
+		&c.ParentID,
+		&c.ThreadKey,
+		&c.AuthorID,
+		&c.AuthorName,
+		&c.Content,
+		&c.Path,
+		&c.Depth,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+		&c.DeletedAt,
+		&c.EditedAt,
+		&c.HiddenAt,
+		&c.Likes,
+		&c.Dislikes,
+	}
+}
+
 type Repository struct {
 	data *data.Data
 }
@@ -19,7 +77,11 @@ func New(data *data.Data) *Repository {
 	return &Repository{data: data}
 }
 
-func (r *Repository) Create(ctx context.Context, parentID *string, content string) (*models.Comment, error) {
+// Create inserts a comment under req.ParentID, or a new thread root under
+// req.ThreadKey when it is nil. Replies belong to the thread of their parent. A
+// non-empty hiddenReason creates the comment already hidden, waiting for
+// moderation.
+func (r *Repository) Create(ctx context.Context, req models.CreateCommentRequest, hiddenReason string) (*models.Comment, error) {
 	tx, err := r.data.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
@@ -27,19 +89,21 @@ func (r *Repository) Create(ctx context.Context, parentID *string, content strin
 	defer tx.Rollback()
 
 	id := uuid.New().String()
+	threadKey := req.ThreadKey
 	var path string
 	var depth int
 
-	if parentID == nil || *parentID == "" {
+	if req.ParentID == nil || *req.ParentID == "" {
 		path = id
 		depth = 0
 	} else {
-		var parentPath string
+		var parentPath, parentThreadKey string
 		var parentDepth int
 		err = tx.QueryRowContext(ctx, `
-			SELECT path, depth FROM comments 
-			WHERE id = $1 AND deleted_at IS NULL
-		`, *parentID).Scan(&parentPath, &parentDepth)
+			SELECT path, depth, thread_key FROM comments 
+			WHERE id = $1 AND deleted_at IS NULL AND hidden_at IS NULL
+			FOR SHARE
+		`, *req.ParentID).Scan(&parentPath, &parentDepth, &parentThreadKey)
 		if err != nil {
 			return nil, models.ErrParentNotFound
 		}
@@ -47,27 +111,39 @@ func (r *Repository) Create(ctx context.Context, parentID *string, content strin
 		if parentDepth >= models.MaxNestingDepth {
 			return nil, models.ErrMaxDepthExceeded
 		}
+		if threadKey != "" && threadKey != parentThreadKey {
+			return nil, models.ErrBadThreadKey
+		}
 
+		threadKey = parentThreadKey
 		path = parentPath + "/" + id
 		depth = parentDepth + 1
 	}
 
 	now := time.Now()
 	comment := &models.Comment{
-		ID:        id,
-		ParentID:  parentID,
-		Content:   strings.TrimSpace(content),
-		Path:      path,
-		Depth:     depth,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:         id,
+		ParentID:   req.ParentID,
+		ThreadKey:  threadKey,
+		AuthorID:   &req.Author.ID,
+		AuthorName: req.Author.Name,
+		Content:    strings.TrimSpace(req.Content),
+		Path:       path,
+		Depth:      depth,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if hiddenReason != "" {
+		comment.HiddenAt = &now
 	}
 
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO comments (id, parent_id, content, path, depth, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-	`, comment.ID, comment.ParentID, comment.Content, comment.Path, comment.Depth,
-		comment.CreatedAt, comment.UpdatedAt)
+		INSERT INTO comments (id, parent_id, thread_key, author_id, author_name, content, path, depth,
+			created_at, updated_at, hidden_at, hidden_reason)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, NULLIF($12, ''))
+	`, comment.ID, comment.ParentID, comment.ThreadKey, comment.AuthorID, comment.AuthorName,
+		comment.Content, comment.Path, comment.Depth, comment.CreatedAt, comment.UpdatedAt,
+		comment.HiddenAt, hiddenReason)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert comment: %w", err)
 	}
@@ -79,11 +155,13 @@ func (r *Repository) Create(ctx context.Context, parentID *string, content strin
 	return comment, nil
 }
 
+// GetPaginated lists root comments, or the subtree of params.ParentID, with
+// tombstones and hidden comments blanked. Search results leave them out.
 func (r *Repository) GetPaginated(ctx context.Context, params models.GetCommentsRequest) (*models.GetCommentsResponse, error) {
 	query := `
-        SELECT id, parent_id, content, path, depth, created_at, updated_at
+        SELECT ` + commentColumns("") + `
         FROM comments 
-        WHERE deleted_at IS NULL
+        WHERE TRUE
     `
 
 	var args []interface{}
@@ -92,7 +170,7 @@ func (r *Repository) GetPaginated(ctx context.Context, params models.GetComments
 	if params.ParentID != nil && *params.ParentID != "" {
 		var parentPath string
 		err := r.data.DB.QueryRowContext(ctx,
-			`SELECT path FROM comments WHERE id = $1 AND deleted_at IS NULL`,
+			`SELECT path FROM comments WHERE id = $1`,
 			*params.ParentID).Scan(&parentPath)
 
 		if err != nil {
@@ -103,17 +181,20 @@ func (r *Repository) GetPaginated(ctx context.Context, params models.GetComments
 		args = append(args, parentPath)
 		argIdx++
 	} else {
-		query += ` AND parent_id IS NULL`
+		query += fmt.Sprintf(` AND parent_id IS NULL AND thread_key = $%d`, argIdx)
+		args = append(args, params.ThreadKey)
+		argIdx++
 	}
 
 	if params.Query != "" {
-		query += fmt.Sprintf(` AND content ILIKE $%d`, argIdx)
-		args = append(args, "%"+params.Query+"%")
+		query += fmt.Sprintf(` AND deleted_at IS NULL AND hidden_at IS NULL
+			AND to_tsvector('russian', content) @@ websearch_to_tsquery('russian', $%d)`, argIdx)
+		args = append(args, params.Query)
 		argIdx++
 	}
 
 	countQuery := strings.Replace(query,
-		"SELECT id, parent_id, content, path, depth, created_at, updated_at",
+		"SELECT "+commentColumns(""),
 		"SELECT COUNT(*)", 1)
 
 	var total int64
@@ -123,8 +204,13 @@ func (r *Repository) GetPaginated(ctx context.Context, params models.GetComments
 	}
 
 	sortBy := "created_at"
-	if params.SortBy != "" && (params.SortBy == "created_at" || params.SortBy == "updated_at" || params.SortBy == "depth") {
+	switch params.SortBy {
+	case "created_at", "updated_at", "depth":
 		sortBy = params.SortBy
+	case models.SortTop:
+		sortBy = wilsonScore
+	case models.SortHot:
+		sortBy = hotScore
 	}
 
 	order := "DESC"
@@ -146,7 +232,7 @@ func (r *Repository) GetPaginated(ctx context.Context, params models.GetComments
 	}
 	offset := (page - 1) * limit
 
-	query += fmt.Sprintf(` ORDER BY %s %s LIMIT $%d OFFSET $%d`, sortBy, order, argIdx, argIdx+1)
+	query += fmt.Sprintf(` ORDER BY %s %s, id LIMIT $%d OFFSET $%d`, sortBy, order, argIdx, argIdx+1)
 	args = append(args, limit, offset)
 
 	rows, err := r.data.DB.QueryContext(ctx, query, args...)
@@ -158,15 +244,7 @@ func (r *Repository) GetPaginated(ctx context.Context, params models.GetComments
 	var comments []models.Comment
 	for rows.Next() {
 		var comment models.Comment
-		if err := rows.Scan(
-			&comment.ID,
-			&comment.ParentID,
-			&comment.Content,
-			&comment.Path,
-			&comment.Depth,
-			&comment.CreatedAt,
-			&comment.UpdatedAt,
-		); err != nil {
+		if err := rows.Scan(commentDest(&comment)...); err != nil {
 			return nil, fmt.Errorf("failed to scan comment: %w", err)
 		}
 		comments = append(comments, comment)
@@ -190,24 +268,271 @@ func (r *Repository) GetPaginated(ctx context.Context, params models.GetComments
 	}, nil
 }
 
-func (r *Repository) Delete(ctx context.Context, id string) error {
-	var path string
-	err := r.data.DB.QueryRowContext(ctx, `
-		SELECT path FROM comments 
-		WHERE id = $1 AND deleted_at IS NULL
-	`, id).Scan(&path)
+// Search finds live comments matching the web search style query in the
+// text search configuration of req.Lang, best ranked first, within
+// req.ThreadKey when it is set. Each hit carries a highlighted snippet and the
+// root comment of its thread.
+func (r *Repository) Search(ctx context.Context, req models.SearchRequest) (*models.SearchResponse, error) {
+	cfg, ok := models.SearchConfigs[req.Lang]
+	if !ok {
+		return nil, models.ErrBadSearchLang
+	}
+
+	args := []interface{}{req.Query}
+	threadCond := ""
+	if req.ThreadKey != "" {
+		threadCond = `AND thread_key = $2`
+		args = append(args, req.ThreadKey)
+	}
+
+	var total int64
+	err := r.data.DB.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT COUNT(*) FROM comments
+		WHERE deleted_at IS NULL AND hidden_at IS NULL
+			AND to_tsvector('%[1]s', content) @@ websearch_to_tsquery('%[1]s', $1)
+			%[2]s
+	`, cfg, threadCond), args...).Scan(&total)
 	if err != nil {
-		return models.ErrCommentNotFound
+		return nil, fmt.Errorf("failed to count comments: %w", err)
+	}
+
+	resp := &models.SearchResponse{
+		Hits:  []models.SearchHit{},
+		Total: total,
+		Page:  req.Page,
+		Limit: req.Limit,
+	}
+	if total > 0 {
+		resp.TotalPages = int((total + int64(req.Limit) - 1) / int64(req.Limit))
+	}
+	if total == 0 || req.Page > resp.TotalPages {
+		return resp, nil
 	}
 
-	_, err = r.data.DB.ExecContext(ctx, `
-		UPDATE comments 
-		SET deleted_at = $1 
-		WHERE path LIKE $2 || '%'
-	`, time.Now(), path)
+	threadCond = strings.ReplaceAll(threadCond, "thread_key", "c.thread_key")
+	args = append(args, req.Limit, (req.Page-1)*req.Limit)
+	rows, err := r.data.DB.QueryContext(ctx, fmt.Sprintf(`
+		WITH q AS (
+			SELECT websearch_to_tsquery('%[1]s', $1) AS query
+		), page AS (
+			SELECT c.*,
+				ts_rank(to_tsvector('%[1]s', c.content), q.query) AS rank,
+				split_part(c.path, '/', 1)::uuid AS root_id
+			FROM comments c, q
+			WHERE c.deleted_at IS NULL AND c.hidden_at IS NULL
+				AND to_tsvector('%[1]s', c.content) @@ q.query
+				%[2]s
+			ORDER BY rank DESC, c.created_at DESC, c.id
+			LIMIT $%[3]d OFFSET $%[4]d
+		)
+		SELECT %[5]s, p.rank,
+			ts_headline('%[1]s', p.content, q.query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2'),
+			%[6]s, COALESCE(rc.cnt, 0)
+		FROM page p
+		CROSS JOIN q
+		JOIN comments t ON t.id = p.root_id
+		LEFT JOIN (
+			SELECT parent_id, COUNT(*) AS cnt
+			FROM comments
+			WHERE parent_id IN (SELECT root_id FROM page)
+			GROUP BY parent_id
+		) rc ON rc.parent_id = t.id
+		ORDER BY p.rank DESC, p.created_at DESC, p.id
+	`, cfg, threadCond, len(args)-1, len(args), commentColumns("p."), commentColumns("t.")), args...)
 	if err != nil {
-		return fmt.Errorf("failed to delete comments: %w", err)
+		return nil, fmt.Errorf("failed to search comments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hit := models.SearchHit{Thread: &models.CommentNode{}}
+		dest := append(commentDest(&hit.Comment), &hit.Rank, &hit.Headline)
+		dest = append(dest, commentDest(&hit.Thread.Comment)...)
+		if err := rows.Scan(append(dest, &hit.Thread.ReplyCount)...); err != nil {
+			return nil, fmt.Errorf("failed to scan comment: %w", err)
+		}
+		resp.Hits = append(resp.Hits, hit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return resp, nil
+}
+
+// Delete removes the comment of authorID, an empty authorID is a moderator who
+// may remove any comment, including those left without an author. A comment
+// with replies is blanked and left as a tombstone so the discussion under it
+// stays in place; one without is removed for good, together with the
+// tombstones above it that had only it left.
+func (r *Repository) Delete(ctx context.Context, id string, authorID string) error {
+	tx, err := r.data.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var parentID, ownerID *string
+	var hasReplies bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT parent_id, author_id, EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id)
+		FROM comments c
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE
+	`, id).Scan(&parentID, &ownerID, &hasReplies)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.ErrCommentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get comment: %w", err)
+	}
+	if authorID != "" && (ownerID == nil || *ownerID != authorID) {
+		return models.ErrForbidden
 	}
 
+	if hasReplies {
+		now := time.Now()
+		_, err = tx.ExecContext(ctx, `
+			UPDATE comments
+			SET content = '', author_id = NULL, author_name = NULL, deleted_at = $1, updated_at = $1
+			WHERE id = $2
+		`, now, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete comment: %w", err)
+		}
+		return r.commit(tx)
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM comments WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+
+	for parentID != nil {
+		var grandID *string
+		err = tx.QueryRowContext(ctx, `
+			SELECT parent_id FROM comments
+			WHERE id = $1 AND deleted_at IS NOT NULL
+			FOR UPDATE
+		`, *parentID).Scan(&grandID)
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get tombstone: %w", err)
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			DELETE FROM comments c
+			WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id)
+		`, *parentID)
+		if err != nil {
+			return fmt.Errorf("failed to delete tombstone: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			break
+		}
+		parentID = grandID
+	}
+
+	return r.commit(tx)
+}
+
+func (r *Repository) commit(tx *sql.Tx) error {
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return nil
 }
+
+// GetSubtree returns the comment id and its descendants up to depth levels
+// below it, at most perParent replies per comment, ordered by depth and then by
+// (created_at, id). When after is set, the direct replies of id start right
+// after it. Descendants of replies cut by perParent are returned too and have
+// to be skipped by the caller.
+func (r *Repository) GetSubtree(ctx context.Context, id string, depth, perParent int, after *cursor.Cursor) ([]*models.CommentNode, error) {
+	var rootPath string
+	var rootDepth int
+	err := r.data.DB.QueryRowContext(ctx, `
+		SELECT path, depth FROM comments
+		WHERE id = $1
+	`, id).Scan(&rootPath, &rootDepth)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, models.ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comment: %w", err)
+	}
+
+	args := []interface{}{rootPath, rootPath + "/%", rootDepth + depth, perParent}
+	afterCond := ""
+	if after != nil {
+		afterCond = `AND (parent_id IS DISTINCT FROM $5 OR (created_at, id) > ($6, $7))`
+		args = append(args, id, after.CreatedAt, after.ID)
+	}
+
+	query := fmt.Sprintf(`
+		WITH sub AS (
+			SELECT c.*, ROW_NUMBER() OVER (PARTITION BY parent_id ORDER BY created_at, id) AS rn
+			FROM comments c
+			WHERE (path = $1 OR path LIKE $2)
+				AND depth <= $3
+				%s
+		), page AS (
+			SELECT * FROM sub WHERE rn <= $4
+		)
+		%s
+		ORDER BY p.depth, p.created_at, p.id
+	`, afterCond, nodeSelect)
+
+	return r.queryNodes(ctx, query, args...)
+}
+
+// GetThreads returns up to limit root comments of threadKey, newest first,
+// starting right after before when it is set.
+func (r *Repository) GetThreads(ctx context.Context, threadKey string, limit int, before *cursor.Cursor) ([]*models.CommentNode, error) {
+	args := []interface{}{threadKey, limit}
+	beforeCond := ""
+	if before != nil {
+		beforeCond = `AND (created_at, id) < ($3, $4)`
+		args = append(args, before.CreatedAt, before.ID)
+	}
+
+	query := fmt.Sprintf(`
+		WITH page AS (
+			SELECT *
+			FROM comments
+			WHERE parent_id IS NULL AND thread_key = $1
+				%s
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		)
+		%s
+		ORDER BY p.created_at DESC, p.id DESC
+	`, beforeCond, nodeSelect)
+
+	return r.queryNodes(ctx, query, args...)
+}
+
+func (r *Repository) queryNodes(ctx context.Context, query string, args ...interface{}) ([]*models.CommentNode, error) {
+	rows, err := r.data.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query comments: %w", err)
+	}
+	defer rows.Close()
+
+	var nodes []*models.CommentNode
+	for rows.Next() {
+		node := &models.CommentNode{}
+		if err := rows.Scan(append(commentDest(&node.Comment), &node.ReplyCount)...); err != nil {
+			return nil, fmt.Errorf("failed to scan comment: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+
+	return nodes, nil
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"app/internal/models"
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+func (s *CommentService) UpdateComment(ctx context.Context, id string, req models.UpdateCommentRequest) (*models.Comment, error) {
+	if req.Author.ID == "" {
+		return nil, models.ErrUnauthorized
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, models.ErrCommentNotFound
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if err := validContent(req.Content); err != nil {
+		return nil, err
+	}
+
+	hiddenReason, err := s.screen(ctx, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.Update(ctx, id, req.Author.ID, req.Content, hiddenReason)
+}
+
+func (s *CommentService) ReactToComment(ctx context.Context, id string, req models.ReactionRequest) (*models.ReactionResponse, error) {
+	if req.Author.ID == "" {
+		return nil, models.ErrUnauthorized
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, models.ErrCommentNotFound
+	}
+	if req.Value < -1 || req.Value > 1 {
+		return nil, models.ErrBadReaction
+	}
+	return s.repo.React(ctx, id, req.Author.ID, req.Value)
+}
+
+func (s *CommentService) GetHistory(ctx context.Context, id string) ([]models.CommentEdit, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, models.ErrCommentNotFound
+	}
+	return s.repo.History(ctx, id)
+}
+
+func (s *CommentService) ReportComment(ctx context.Context, id string, req models.ReportRequest) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.ErrCommentNotFound
+	}
+	reason, err := validReason(req.Reason)
+	if err != nil {
+		return err
+	}
+	if req.Reporter == "" {
+		return models.ErrInvalidInput
+	}
+	return s.repo.Report(ctx, id, req.Reporter, reason, s.cfg.ReportThreshold)
+}
+
+func (s *CommentService) HideComment(ctx context.Context, id string, req models.HideRequest) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.ErrCommentNotFound
+	}
+	reason, err := validReason(req.Reason)
+	if err != nil {
+		return err
+	}
+	return s.repo.Hide(ctx, id, reason)
+}
+
+func (s *CommentService) RestoreComment(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.ErrCommentNotFound
+	}
+	return s.repo.Restore(ctx, id)
+}
+
+// RemoveComment deletes a comment for a moderator, whoever wrote it.
+func (s *CommentService) RemoveComment(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return models.ErrCommentNotFound
+	}
+	return s.repo.Delete(ctx, id, "")
+}
+
+func (s *CommentService) GetModerationQueue(ctx context.Context, page, limit int) (*models.ModerationQueueResponse, error) {
+	if page <= 0 {
+		page = 1
+	}
+	return s.repo.ModerationQueue(ctx, page, clampLimit(limit, models.DefaultPageSize))
+}
+
+// screen runs the content filter and returns why the comment has to wait for
+// moderation, or an empty reason when it can be shown right away.
+func (s *CommentService) screen(ctx context.Context, content string) (string, error) {
+	verdict, err := s.filter.Check(ctx, content)
+	if err != nil {
+		return "", fmt.Errorf("failed to check content: %w", err)
+	}
+
+	switch verdict {
+	case models.VerdictReject:
+		return "", models.ErrRejectedContent
+	case models.VerdictReview:
+		return models.HiddenByFilter, nil
+	default:
+		return "", nil
+	}
+}
+
+func validContent(content string) error {
+	if content == "" || len(content) > models.MaxContentLength {
+		return models.ErrInvalidInput
+	}
+	return nil
+}
+
+func validReason(reason string) (string, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" || utf8.RuneCountInString(reason) > models.MaxReasonLength {
+		return "", models.ErrInvalidInput
+	}
+	return reason, nil
+}
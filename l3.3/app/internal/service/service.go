@@ -2,39 +2,84 @@ package service
 
 import (
 	"app/internal/models"
+	"app/pkg/cursor"
 	"context"
+	"regexp"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
 )
 
 type RepoInterface interface {
-	Create(ctx context.Context, parentID *string, content string) (*models.Comment, error)
+	Create(ctx context.Context, req models.CreateCommentRequest, hiddenReason string) (*models.Comment, error)
 	GetPaginated(ctx context.Context, params models.GetCommentsRequest) (*models.GetCommentsResponse, error)
-	Delete(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string, authorID string) error
+	GetSubtree(ctx context.Context, id string, depth, perParent int, after *cursor.Cursor) ([]*models.CommentNode, error)
+	GetThreads(ctx context.Context, threadKey string, limit int, before *cursor.Cursor) ([]*models.CommentNode, error)
+	Search(ctx context.Context, req models.SearchRequest) (*models.SearchResponse, error)
+	Update(ctx context.Context, id string, authorID string, content string, hiddenReason string) (*models.Comment, error)
+	React(ctx context.Context, id string, userID string, value int) (*models.ReactionResponse, error)
+	History(ctx context.Context, id string) ([]models.CommentEdit, error)
+	Report(ctx context.Context, id string, reporter string, reason string, threshold int) error
+	Hide(ctx context.Context, id string, reason string) error
+	Restore(ctx context.Context, id string) error
+	ModerationQueue(ctx context.Context, page, limit int) (*models.ModerationQueueResponse, error)
+}
+
+// ContentFilter screens the content of new and edited comments.
+type ContentFilter interface {
+	Check(ctx context.Context, content string) (models.Verdict, error)
 }
 
+type ServiceConfig struct {
+	ReportThreshold int `env:"MODERATION_REPORT_THRESHOLD" env-default:"3"`
+}
+
+// Valid checks that the report threshold is positive.
+func (cfg ServiceConfig) Valid() error {
+	if cfg.ReportThreshold <= 0 {
+		return models.ErrBadModerationCfg
+	}
+	return nil
+}
+
+// threadKeyRegex allows ids and slugs like "product:42" or "blog/2024/post".
+var threadKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_.:/-]{1,128}$`)
+
 type CommentService struct {
-	repo RepoInterface
+	repo   RepoInterface
+	filter ContentFilter
+	cfg    ServiceConfig
 }
 
-func New(repo RepoInterface) *CommentService {
-	return &CommentService{repo: repo}
+func New(repo RepoInterface, filter ContentFilter, cfg ServiceConfig) *CommentService {
+	return &CommentService{repo: repo, filter: filter, cfg: cfg}
 }
 
 func (s *CommentService) CreateComment(ctx context.Context, req models.CreateCommentRequest) (*models.Comment, error) {
-	req.Content = strings.TrimSpace(req.Content)
-	if req.Content == "" {
-		return nil, models.ErrInvalidInput
+	if req.Author.ID == "" {
+		return nil, models.ErrUnauthorized
 	}
 
-	if len(req.Content) > models.MaxContentLength {
-		return nil, models.ErrInvalidInput
+	req.Content = strings.TrimSpace(req.Content)
+	if err := validContent(req.Content); err != nil {
+		return nil, err
 	}
 
 	if req.ParentID != nil && *req.ParentID == "" {
 		req.ParentID = nil
 	}
+	if err := validThreadKey(req.ThreadKey, req.ParentID == nil); err != nil {
+		return nil, err
+	}
 
-	comment, err := s.repo.Create(ctx, req.ParentID, req.Content)
+	hiddenReason, err := s.screen(ctx, req.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	comment, err := s.repo.Create(ctx, req, hiddenReason)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +98,17 @@ func (s *CommentService) GetComments(ctx context.Context, req models.GetComments
 		req.Limit = 100
 	}
 
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query != "" && utf8.RuneCountInString(req.Query) < models.MinSearchQueryLength {
+		return nil, models.ErrShortSearchQuery
+	}
+	if req.ParentID != nil && *req.ParentID == "" {
+		req.ParentID = nil
+	}
+	if err := validThreadKey(req.ThreadKey, req.ParentID == nil); err != nil {
+		return nil, err
+	}
+
 	result, err := s.repo.GetPaginated(ctx, req)
 	if err != nil {
 		return nil, err
@@ -61,6 +117,146 @@ func (s *CommentService) GetComments(ctx context.Context, req models.GetComments
 	return result, nil
 }
 
-func (s *CommentService) DeleteComment(ctx context.Context, id string) error {
-	return s.repo.Delete(ctx, id)
+func (s *CommentService) DeleteComment(ctx context.Context, id string, author models.Author) error {
+	if author.ID == "" {
+		return models.ErrUnauthorized
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return models.ErrCommentNotFound
+	}
+	return s.repo.Delete(ctx, id, author.ID)
+}
+
+// GetTree returns the comment with its replies nested up to req.Depth levels
+// below it. Every comment carries at most req.Limit replies; when there are
+// more, NextCursor is set and passing it back together with the comment id
+// loads the next replies of that comment.
+func (s *CommentService) GetTree(ctx context.Context, req models.GetTreeRequest) (*models.CommentNode, error) {
+	if _, err := uuid.Parse(req.ID); err != nil {
+		return nil, models.ErrInvalidInput
+	}
+	if req.Depth < 0 {
+		req.Depth = models.DefaultTreeDepth
+	}
+	if req.Depth > models.MaxNestingDepth {
+		req.Depth = models.MaxNestingDepth
+	}
+	req.Limit = clampLimit(req.Limit, models.DefaultRepliesLimit)
+
+	after, err := cursor.Decode(req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := s.repo.GetSubtree(ctx, req.ID, req.Depth, req.Limit+1, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildTree(nodes, req.Limit), nil
+}
+
+// GetThreads returns a page of root comments of req.ThreadKey, newest first.
+func (s *CommentService) GetThreads(ctx context.Context, req models.GetThreadsRequest) (*models.GetThreadsResponse, error) {
+	if err := validThreadKey(req.ThreadKey, true); err != nil {
+		return nil, err
+	}
+	req.Limit = clampLimit(req.Limit, models.DefaultPageSize)
+
+	before, err := cursor.Decode(req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	threads, err := s.repo.GetThreads(ctx, req.ThreadKey, req.Limit+1, before)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &models.GetThreadsResponse{Threads: threads}
+	if len(threads) > req.Limit {
+		resp.Threads = threads[:req.Limit]
+		resp.NextCursor = cursor.Encode(cursor.Of(resp.Threads[req.Limit-1].Comment))
+	}
+	if resp.Threads == nil {
+		resp.Threads = []*models.CommentNode{}
+	}
+
+	return resp, nil
+}
+
+// Search runs a full-text search over the live comments of req.ThreadKey, or
+// of all threads when it is empty.
+func (s *CommentService) Search(ctx context.Context, req models.SearchRequest) (*models.SearchResponse, error) {
+	if err := validThreadKey(req.ThreadKey, false); err != nil {
+		return nil, err
+	}
+	req.Query = strings.TrimSpace(req.Query)
+	if utf8.RuneCountInString(req.Query) < models.MinSearchQueryLength {
+		return nil, models.ErrShortSearchQuery
+	}
+	if req.Lang == "" {
+		req.Lang = models.SearchLangRussian
+	}
+	if _, ok := models.SearchConfigs[req.Lang]; !ok {
+		return nil, models.ErrBadSearchLang
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	req.Limit = clampLimit(req.Limit, models.DefaultPageSize)
+
+	return s.repo.Search(ctx, req)
+}
+
+// buildTree nests nodes ordered parents first, as GetSubtree returns them,
+// under the first one. Up to limit replies are kept per comment; the one past
+// the limit only marks that the comment has more, and its subtree is dropped.
+func buildTree(nodes []*models.CommentNode, limit int) *models.CommentNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	root := nodes[0]
+	byID := map[string]*models.CommentNode{root.ID: root}
+	for _, node := range nodes[1:] {
+		if node.ParentID == nil {
+			continue
+		}
+		parent, ok := byID[*node.ParentID]
+		if !ok {
+			continue
+		}
+		if len(parent.Children) == limit {
+			if parent.NextCursor == "" {
+				parent.NextCursor = cursor.Encode(cursor.Of(parent.Children[limit-1].Comment))
+			}
+			continue
+		}
+		parent.Children = append(parent.Children, node)
+		byID[node.ID] = node
+	}
+
+	return root
+}
+
+// validThreadKey checks the thread key, an empty one passes unless required.
+func validThreadKey(key string, required bool) error {
+	if key == "" && !required {
+		return nil
+	}
+	if !threadKeyRegex.MatchString(key) {
+		return models.ErrBadThreadKey
+	}
+	return nil
+}
+
+func clampLimit(limit, def int) int {
+	if limit <= 0 {
+		return def
+	}
+	if limit > models.MaxPageSize {
+		return models.MaxPageSize
+	}
+	return limit
 }
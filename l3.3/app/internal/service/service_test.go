@@ -0,0 +1,621 @@
+package service
+
+import (
+	"app/internal/models"
+	"app/pkg/cursor"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type treeRepo struct {
+	RepoInterface
+	nodes     []*models.CommentNode
+	perParent int
+	after     *cursor.Cursor
+}
+
+func (r *treeRepo) GetSubtree(ctx context.Context, id string, depth, perParent int, after *cursor.Cursor) ([]*models.CommentNode, error) {
+	r.perParent = perParent
+	r.after = after
+	return r.nodes, nil
+}
+
+func (r *treeRepo) GetThreads(ctx context.Context, threadKey string, limit int, before *cursor.Cursor) ([]*models.CommentNode, error) {
+	if limit < len(r.nodes) {
+		return r.nodes[:limit], nil
+	}
+	return r.nodes, nil
+}
+
+type searchRepo struct {
+	RepoInterface
+	got models.SearchRequest
+}
+
+func (r *searchRepo) Search(ctx context.Context, req models.SearchRequest) (*models.SearchResponse, error) {
+	r.got = req
+	return &models.SearchResponse{}, nil
+}
+
+type verdictFilter models.Verdict
+
+func (f verdictFilter) Check(ctx context.Context, content string) (models.Verdict, error) {
+	return models.Verdict(f), nil
+}
+
+func newTestService(repo RepoInterface) *CommentService {
+	return New(repo, verdictFilter(models.VerdictAllow), ServiceConfig{ReportThreshold: 3})
+}
+
+var testAuthor = models.Author{ID: "user-1", Name: "Аня"}
+
+func newComment(content string) models.CreateCommentRequest {
+	return models.CreateCommentRequest{ThreadKey: "product:42", Content: content, Author: testAuthor}
+}
+
+const bID = "00000000-0000-4000-8000-00000000000b"
+
+var baseTime = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func node(id, parent string, depth, minute int) *models.CommentNode {
+	n := &models.CommentNode{Comment: models.Comment{
+		ID:        id,
+		Depth:     depth,
+		CreatedAt: baseTime.Add(time.Duration(minute) * time.Minute),
+	}}
+	if parent != "" {
+		n.ParentID = &parent
+	}
+	return n
+}
+
+func ids(nodes []*models.CommentNode) []string {
+	var res []string
+	for _, n := range nodes {
+		res = append(res, n.ID)
+	}
+	return res
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBuildTreeNestsInOrder(t *testing.T) {
+	t.Parallel()
+	tree := buildTree([]*models.CommentNode{
+		node("root", "", 0, 0),
+		node("a", "root", 1, 1),
+		node("b", "root", 1, 2),
+		node("a1", "a", 2, 3),
+		node("b1", "b", 2, 4),
+		node("a2", "a", 2, 5),
+		node("a1x", "a1", 3, 6),
+	}, 10)
+
+	if got := ids(tree.Children); !equal(got, []string{"a", "b"}) {
+		t.Fatalf("unexpected root children %v", got)
+	}
+	a, b := tree.Children[0], tree.Children[1]
+	if got := ids(a.Children); !equal(got, []string{"a1", "a2"}) {
+		t.Fatalf("unexpected children of a %v", got)
+	}
+	if got := ids(b.Children); !equal(got, []string{"b1"}) {
+		t.Fatalf("unexpected children of b %v", got)
+	}
+	if got := ids(a.Children[0].Children); !equal(got, []string{"a1x"}) {
+		t.Fatalf("unexpected children of a1 %v", got)
+	}
+	if tree.NextCursor != "" || a.NextCursor != "" {
+		t.Fatal("expected no cursors when every reply fits")
+	}
+}
+
+func TestBuildTreeCutsRepliesWithCursor(t *testing.T) {
+	t.Parallel()
+	tree := buildTree([]*models.CommentNode{
+		node("root", "", 0, 0),
+		node("a", "root", 1, 1),
+		node(bID, "root", 1, 2),
+		node("c", "root", 1, 3),
+		node("c1", "c", 2, 4),
+	}, 2)
+
+	if got := ids(tree.Children); !equal(got, []string{"a", bID}) {
+		t.Fatalf("unexpected root children %v", got)
+	}
+	after, err := cursor.Decode(tree.NextCursor)
+	if err != nil || after == nil {
+		t.Fatalf("expected a cursor, got %q, %v", tree.NextCursor, err)
+	}
+	if after.ID != bID || !after.CreatedAt.Equal(tree.Children[1].CreatedAt) {
+		t.Fatalf("expected cursor after b, got %+v", *after)
+	}
+	for _, child := range tree.Children {
+		if len(child.Children) != 0 {
+			t.Fatalf("subtree of the cut reply leaked under %s", child.ID)
+		}
+	}
+}
+
+func TestGetTreeAsksForOneExtraReply(t *testing.T) {
+	t.Parallel()
+	repo := &treeRepo{nodes: []*models.CommentNode{node("root", "", 0, 0)}}
+	s := newTestService(repo)
+
+	_, err := s.GetTree(context.Background(), models.GetTreeRequest{
+		ID:    "123e4567-e89b-12d3-a456-426614174000",
+		Depth: 2,
+		Limit: 5,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repo.perParent != 6 {
+		t.Fatalf("expected 6 replies per parent, got %d", repo.perParent)
+	}
+}
+
+func TestGetTreeRejectsBadInput(t *testing.T) {
+	t.Parallel()
+	s := newTestService(&treeRepo{})
+
+	if _, err := s.GetTree(context.Background(), models.GetTreeRequest{ID: "nope"}); !errors.Is(err, models.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+	_, err := s.GetTree(context.Background(), models.GetTreeRequest{
+		ID:     "123e4567-e89b-12d3-a456-426614174000",
+		Cursor: "garbage",
+	})
+	if !errors.Is(err, models.ErrBadCursor) {
+		t.Fatalf("expected ErrBadCursor, got %v", err)
+	}
+}
+
+func TestGetThreadsPagesWithCursor(t *testing.T) {
+	t.Parallel()
+	repo := &treeRepo{nodes: []*models.CommentNode{
+		node("c", "", 0, 3),
+		node(bID, "", 0, 2),
+		node("a", "", 0, 1),
+	}}
+	s := newTestService(repo)
+
+	resp, err := s.GetThreads(context.Background(), models.GetThreadsRequest{ThreadKey: "product:42", Limit: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ids(resp.Threads); !equal(got, []string{"c", bID}) {
+		t.Fatalf("unexpected threads %v", got)
+	}
+	before, err := cursor.Decode(resp.NextCursor)
+	if err != nil || before == nil || before.ID != bID {
+		t.Fatalf("expected cursor after b, got %q, %v", resp.NextCursor, err)
+	}
+
+	resp, err = s.GetThreads(context.Background(), models.GetThreadsRequest{ThreadKey: "product:42", Limit: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.NextCursor != "" {
+		t.Fatalf("expected the last page, got cursor %q", resp.NextCursor)
+	}
+}
+
+func TestSearchValidatesQuery(t *testing.T) {
+	t.Parallel()
+	s := newTestService(&searchRepo{})
+
+	for _, req := range []models.SearchRequest{
+		{Query: ""},
+		{Query: "  я  "},
+	} {
+		if _, err := s.Search(context.Background(), req); !errors.Is(err, models.ErrShortSearchQuery) {
+			t.Fatalf("query %q: expected ErrShortSearchQuery, got %v", req.Query, err)
+		}
+	}
+	if _, err := s.Search(context.Background(), models.SearchRequest{Query: "hello", Lang: "de"}); !errors.Is(err, models.ErrBadSearchLang) {
+		t.Fatalf("expected ErrBadSearchLang, got %v", err)
+	}
+}
+
+func TestSearchDefaults(t *testing.T) {
+	t.Parallel()
+	repo := &searchRepo{}
+	s := newTestService(repo)
+
+	if _, err := s.Search(context.Background(), models.SearchRequest{Query: " ёж ", Limit: 1000}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := models.SearchRequest{Query: "ёж", Lang: models.SearchLangRussian, Page: 1, Limit: models.MaxPageSize}
+	if repo.got != want {
+		t.Fatalf("expected %+v, got %+v", want, repo.got)
+	}
+}
+
+func TestGetCommentsRejectsShortQuery(t *testing.T) {
+	t.Parallel()
+	s := newTestService(&searchRepo{})
+
+	if _, err := s.GetComments(context.Background(), models.GetCommentsRequest{Query: "a"}); !errors.Is(err, models.ErrShortSearchQuery) {
+		t.Fatalf("expected ErrShortSearchQuery, got %v", err)
+	}
+}
+
+type modRepo struct {
+	RepoInterface
+	hiddenReason string
+	threshold    int
+	reason       string
+	reporter     string
+}
+
+func (r *modRepo) Create(ctx context.Context, req models.CreateCommentRequest, hiddenReason string) (*models.Comment, error) {
+	r.hiddenReason = hiddenReason
+	return &models.Comment{Content: req.Content, ThreadKey: req.ThreadKey}, nil
+}
+
+func (r *modRepo) Update(ctx context.Context, id string, authorID string, content string, hiddenReason string) (*models.Comment, error) {
+	r.hiddenReason = hiddenReason
+	return &models.Comment{ID: id, Content: content}, nil
+}
+
+func (r *modRepo) Report(ctx context.Context, id string, reporter string, reason string, threshold int) error {
+	r.reporter = reporter
+	r.reason = reason
+	r.threshold = threshold
+	return nil
+}
+
+func TestCreateCommentScreensContent(t *testing.T) {
+	t.Parallel()
+	for verdict, want := range map[models.Verdict]string{
+		models.VerdictAllow:  "",
+		models.VerdictReview: models.HiddenByFilter,
+	} {
+		repo := &modRepo{}
+		s := New(repo, verdictFilter(verdict), ServiceConfig{ReportThreshold: 3})
+		if _, err := s.CreateComment(context.Background(), newComment("hello")); err != nil {
+			t.Fatalf("verdict %v: unexpected error: %v", verdict, err)
+		}
+		if repo.hiddenReason != want {
+			t.Fatalf("verdict %v: expected hidden reason %q, got %q", verdict, want, repo.hiddenReason)
+		}
+	}
+
+	s := New(&modRepo{}, verdictFilter(models.VerdictReject), ServiceConfig{ReportThreshold: 3})
+	if _, err := s.CreateComment(context.Background(), newComment("hello")); !errors.Is(err, models.ErrRejectedContent) {
+		t.Fatalf("expected ErrRejectedContent, got %v", err)
+	}
+	if _, err := s.UpdateComment(context.Background(), bID, models.UpdateCommentRequest{Content: "hello", Author: testAuthor}); !errors.Is(err, models.ErrRejectedContent) {
+		t.Fatalf("expected ErrRejectedContent on edit, got %v", err)
+	}
+}
+
+func TestUpdateCommentValidates(t *testing.T) {
+	t.Parallel()
+	s := newTestService(&modRepo{})
+
+	if _, err := s.UpdateComment(context.Background(), "nope", models.UpdateCommentRequest{Content: "hello", Author: testAuthor}); !errors.Is(err, models.ErrCommentNotFound) {
+		t.Fatalf("expected ErrCommentNotFound, got %v", err)
+	}
+	if _, err := s.UpdateComment(context.Background(), bID, models.UpdateCommentRequest{Content: "   ", Author: testAuthor}); !errors.Is(err, models.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+	comment, err := s.UpdateComment(context.Background(), bID, models.UpdateCommentRequest{Content: " fixed typo ", Author: testAuthor})
+	if err != nil || comment.Content != "fixed typo" {
+		t.Fatalf("expected trimmed content, got %+v, %v", comment, err)
+	}
+}
+
+func TestReportComment(t *testing.T) {
+	t.Parallel()
+	repo := &modRepo{}
+	s := New(repo, verdictFilter(models.VerdictAllow), ServiceConfig{ReportThreshold: 5})
+
+	if err := s.ReportComment(context.Background(), bID, models.ReportRequest{Reason: "  "}); !errors.Is(err, models.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+	if err := s.ReportComment(context.Background(), bID, models.ReportRequest{Reason: "spam"}); !errors.Is(err, models.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput for a report without a reporter, got %v", err)
+	}
+	if err := s.ReportComment(context.Background(), bID, models.ReportRequest{Reason: " spam ", Reporter: "ip:10.0.0.1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repo.reason != "spam" || repo.reporter != "ip:10.0.0.1" || repo.threshold != 5 {
+		t.Fatalf("expected reason spam from ip:10.0.0.1 and threshold 5, got %q, %q, %d", repo.reason, repo.reporter, repo.threshold)
+	}
+}
+
+func TestCreateCommentNeedsAuthorAndThread(t *testing.T) {
+	t.Parallel()
+	s := newTestService(&modRepo{})
+
+	anonymous := newComment("hello")
+	anonymous.Author = models.Author{}
+	if _, err := s.CreateComment(context.Background(), anonymous); !errors.Is(err, models.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+
+	for _, key := range []string{"", "has space", strings.Repeat("k", 129)} {
+		req := newComment("hello")
+		req.ThreadKey = key
+		if _, err := s.CreateComment(context.Background(), req); !errors.Is(err, models.ErrBadThreadKey) {
+			t.Fatalf("key %q: expected ErrBadThreadKey, got %v", key, err)
+		}
+	}
+
+	parent := bID
+	reply := newComment("hello")
+	reply.ThreadKey = ""
+	reply.ParentID = &parent
+	if _, err := s.CreateComment(context.Background(), reply); err != nil {
+		t.Fatalf("reply without thread key: unexpected error: %v", err)
+	}
+}
+
+func TestListingsNeedThreadKey(t *testing.T) {
+	t.Parallel()
+	s := newTestService(&treeRepo{})
+
+	if _, err := s.GetThreads(context.Background(), models.GetThreadsRequest{}); !errors.Is(err, models.ErrBadThreadKey) {
+		t.Fatalf("expected ErrBadThreadKey for threads, got %v", err)
+	}
+	if _, err := s.GetComments(context.Background(), models.GetCommentsRequest{}); !errors.Is(err, models.ErrBadThreadKey) {
+		t.Fatalf("expected ErrBadThreadKey for comments, got %v", err)
+	}
+}
+
+type reactRepo struct {
+	RepoInterface
+	userID string
+	value  int
+}
+
+func (r *reactRepo) React(ctx context.Context, id string, userID string, value int) (*models.ReactionResponse, error) {
+	r.userID, r.value = userID, value
+	return &models.ReactionResponse{Value: value}, nil
+}
+
+func TestReactToComment(t *testing.T) {
+	t.Parallel()
+	repo := &reactRepo{}
+	s := newTestService(repo)
+
+	if _, err := s.ReactToComment(context.Background(), bID, models.ReactionRequest{Value: 1}); !errors.Is(err, models.ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized, got %v", err)
+	}
+	if _, err := s.ReactToComment(context.Background(), bID, models.ReactionRequest{Value: 2, Author: testAuthor}); !errors.Is(err, models.ErrBadReaction) {
+		t.Fatalf("expected ErrBadReaction, got %v", err)
+	}
+	if _, err := s.ReactToComment(context.Background(), bID, models.ReactionRequest{Value: -1, Author: testAuthor}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repo.userID != testAuthor.ID || repo.value != -1 {
+		t.Fatalf("expected dislike from %s, got %d from %s", testAuthor.ID, repo.value, repo.userID)
+	}
+}
+
+type eventRepo struct {
+	StreamRepo
+	pruned, last int64
+	logged       []models.CommentEvent
+}
+
+func (r *eventRepo) EventBounds(ctx context.Context) (int64, int64, error) {
+	return r.pruned, r.last, nil
+}
+
+func (r *eventRepo) GetEvent(ctx context.Context, id int64) (*models.CommentEvent, error) {
+	for _, event := range r.logged {
+		if event.ID == id {
+			return &event, nil
+		}
+	}
+	return nil, models.ErrCommentNotFound
+}
+
+func (r *eventRepo) GetEventsAfter(ctx context.Context, after int64, threadKey string, parentID *string, limit int) ([]models.CommentEvent, error) {
+	events := []models.CommentEvent{}
+	for _, event := range r.logged {
+		if event.ID > after && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func commentEvent(id int64, threadKey, path string) models.CommentEvent {
+	return models.CommentEvent{ID: id, Type: models.EventCreated, Comment: &models.Comment{ThreadKey: threadKey, Path: path}}
+}
+
+func receive(t *testing.T, events <-chan models.CommentEvent) models.CommentEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return models.CommentEvent{}
+	}
+}
+
+func TestSubscribeValidation(t *testing.T) {
+	t.Parallel()
+	h := NewHub(&eventRepo{}, StreamConfig{Retention: time.Hour, ReplayLimit: 10, MaxSubscribers: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := h.Subscribe(ctx, models.StreamRequest{}); !errors.Is(err, models.ErrBadThreadKey) {
+		t.Fatalf("expected ErrBadThreadKey, got %v", err)
+	}
+	bad := "nope"
+	if _, err := h.Subscribe(ctx, models.StreamRequest{ParentID: &bad}); !errors.Is(err, models.ErrInvalidInput) {
+		t.Fatalf("expected ErrInvalidInput, got %v", err)
+	}
+	parent := bID
+	if _, err := h.Subscribe(ctx, models.StreamRequest{ParentID: &parent}); err != nil {
+		t.Fatalf("subscribe to a comment: unexpected error: %v", err)
+	}
+}
+
+func TestHubFiltersEvents(t *testing.T) {
+	t.Parallel()
+	h := NewHub(&eventRepo{}, StreamConfig{Retention: time.Hour, ReplayLimit: 10, MaxSubscribers: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	parent := bID
+	thread, err := h.Subscribe(ctx, models.StreamRequest{ThreadKey: "product:42"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	replies, err := h.Subscribe(ctx, models.StreamRequest{ParentID: &parent})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	h.publish(commentEvent(1, "product:7", "a"))
+	h.publish(commentEvent(2, "product:42", "a/c"))
+	h.publish(commentEvent(3, "product:42", "a/"+bID+"/c"))
+
+	if event := receive(t, thread); event.ID != 2 {
+		t.Fatalf("thread: expected event 2, got %d", event.ID)
+	}
+	if event := receive(t, thread); event.ID != 3 {
+		t.Fatalf("thread: expected event 3, got %d", event.ID)
+	}
+	if event := receive(t, replies); event.ID != 3 {
+		t.Fatalf("replies: expected event 3, got %d", event.ID)
+	}
+}
+
+func TestSubscribeReplaysMissedEvents(t *testing.T) {
+	t.Parallel()
+	repo := &eventRepo{last: 7, logged: []models.CommentEvent{
+		commentEvent(6, "product:42", "a"),
+		commentEvent(7, "product:42", "b"),
+	}}
+	h := NewHub(repo, StreamConfig{Retention: time.Hour, ReplayLimit: 10, MaxSubscribers: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := h.Subscribe(ctx, models.StreamRequest{ThreadKey: "product:42", LastEventID: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// event 7 was logged while subscribing and arrives live as well
+	h.publish(commentEvent(7, "product:42", "b"))
+	h.publish(commentEvent(8, "product:42", "c"))
+
+	for _, want := range []int64{6, 7, 8} {
+		if event := receive(t, events); event.ID != want {
+			t.Fatalf("expected event %d, got %d", want, event.ID)
+		}
+	}
+}
+
+func TestSubscribeResetsWhenLogIsPruned(t *testing.T) {
+	t.Parallel()
+	repo := &eventRepo{pruned: 9, last: 12, logged: []models.CommentEvent{
+		commentEvent(10, "product:42", "a"),
+	}}
+	h := NewHub(repo, StreamConfig{Retention: time.Hour, ReplayLimit: 10, MaxSubscribers: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := h.Subscribe(ctx, models.StreamRequest{ThreadKey: "product:42", LastEventID: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if event := receive(t, events); event.Type != models.EventReset || event.ID != 12 {
+		t.Fatalf("expected reset at 12, got %s at %d", event.Type, event.ID)
+	}
+}
+
+func TestSubscribeReplaysLateCommits(t *testing.T) {
+	t.Parallel()
+	// event 4 was committed after the client got 5 and 6, and 7 was rolled back
+	repo := &eventRepo{last: 8, logged: []models.CommentEvent{
+		commentEvent(4, "product:42", "a"),
+		commentEvent(5, "product:42", "b"),
+		commentEvent(6, "product:42", "c"),
+		commentEvent(8, "product:42", "d"),
+	}}
+	h := NewHub(repo, StreamConfig{Retention: time.Hour, ReplayLimit: 10, ReplayWindow: 3, MaxSubscribers: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := h.Subscribe(ctx, models.StreamRequest{ThreadKey: "product:42", LastEventID: 6})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []int64{4, 5, 6, 8} {
+		if event := receive(t, events); event.Type == models.EventReset || event.ID != want {
+			t.Fatalf("expected event %d, got %s at %d", want, event.Type, event.ID)
+		}
+	}
+}
+
+func TestHubSkipsDeliveredEventsAfterReconnect(t *testing.T) {
+	t.Parallel()
+	repo := &eventRepo{logged: []models.CommentEvent{
+		commentEvent(1, "product:42", "a"),
+		commentEvent(2, "product:42", "b"),
+		commentEvent(3, "product:42", "c"),
+	}}
+	h := NewHub(repo, StreamConfig{Retention: time.Hour, ReplayLimit: 10, ReplayWindow: 5, MaxSubscribers: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := h.Subscribe(ctx, models.StreamRequest{ThreadKey: "product:42"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 3 arrives live, the listener reconnects and reads the window again
+	for _, id := range []int64{3, 0} {
+		if err := h.deliver(ctx, id); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for _, want := range []int64{3, 1, 2} {
+		if event := receive(t, events); event.ID != want {
+			t.Fatalf("expected event %d, got %d", want, event.ID)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("expected no more events, got %d", event.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribeLimitsSubscribers(t *testing.T) {
+	t.Parallel()
+	h := NewHub(&eventRepo{}, StreamConfig{Retention: time.Hour, ReplayLimit: 10, MaxSubscribers: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := h.Subscribe(ctx, models.StreamRequest{ThreadKey: "product:42"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := h.Subscribe(ctx, models.StreamRequest{ThreadKey: "product:42"}); !errors.Is(err, models.ErrStreamFull) {
+		t.Fatalf("expected ErrStreamFull, got %v", err)
+	}
+}
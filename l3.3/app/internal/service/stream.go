@@ -0,0 +1,302 @@
+package service
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// subscriberBuffer is how many events a subscriber may lag behind before it
+// is dropped. A dropped client reconnects and replays from its last event id.
+const subscriberBuffer = 64
+
+type StreamRepo interface {
+	Listen(ctx context.Context) (<-chan int64, error)
+	GetEvent(ctx context.Context, id int64) (*models.CommentEvent, error)
+	GetEventsAfter(ctx context.Context, after int64, threadKey string, parentID *string, limit int) ([]models.CommentEvent, error)
+	EventBounds(ctx context.Context) (pruned, last int64, err error)
+	PruneEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+type StreamConfig struct {
+	Retention   time.Duration `env:"STREAM_RETENTION" env-default:"1h"`
+	ReplayLimit int           `env:"STREAM_REPLAY_LIMIT" env-default:"500"`
+	// ReplayWindow is how many ids before the last delivered one are read
+	// again on a replay. Ids are taken before the commit, so an event can be
+	// logged after one with a greater id and would be skipped otherwise.
+	ReplayWindow   int64 `env:"STREAM_REPLAY_WINDOW" env-default:"100"`
+	MaxSubscribers int   `env:"STREAM_MAX_SUBSCRIBERS" env-default:"1000"`
+}
+
+// Valid checks that events are kept for a while, some can be replayed and
+// someone can subscribe.
+func (cfg StreamConfig) Valid() error {
+	if cfg.Retention <= 0 || cfg.ReplayLimit <= 0 || cfg.ReplayWindow < 0 || cfg.MaxSubscribers <= 0 {
+		return models.ErrBadStreamCfg
+	}
+	return nil
+}
+
+type subscriber struct {
+	threadKey string
+	parentID  *string
+	ch        chan models.CommentEvent
+}
+
+func (s *subscriber) match(event models.CommentEvent) bool {
+	if event.Comment == nil {
+		return true
+	}
+	if s.threadKey != "" && event.Comment.ThreadKey != s.threadKey {
+		return false
+	}
+	if s.parentID != nil && !strings.Contains("/"+event.Comment.Path+"/", "/"+*s.parentID+"/") {
+		return false
+	}
+	return true
+}
+
+// Hub fans comment events out to stream subscribers. Events come from the
+// postgres event log, so every instance sees the changes made by the others.
+type Hub struct {
+	repo   StreamRepo
+	cfg    StreamConfig
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	lastID int64
+	// delivered holds the ids within the replay window of lastID, so events
+	// read again after a reconnect go out once.
+	delivered map[int64]struct{}
+}
+
+func NewHub(repo StreamRepo, cfg StreamConfig) *Hub {
+	return &Hub{repo: repo, cfg: cfg, subs: make(map[*subscriber]struct{}), delivered: make(map[int64]struct{})}
+}
+
+// Run delivers logged events to subscribers and prunes the log until ctx is
+// done, then ends every subscription.
+func (h *Hub) Run(ctx context.Context) {
+	lg := logger.LoggerFromCtx(ctx).Lg
+	defer h.closeAll()
+
+	ids, err := h.repo.Listen(ctx)
+	if err != nil {
+		panic(fmt.Sprintf("failed to listen for comment events: %v", err))
+	}
+
+	prune := time.NewTicker(h.cfg.Retention / 4)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id, ok := <-ids:
+			if !ok {
+				return
+			}
+			if err := h.deliver(ctx, id); err != nil {
+				lg.Error().Err(err).Msg("failed to deliver comment event")
+			}
+		case <-prune.C:
+			if _, err := h.repo.PruneEvents(ctx, time.Now().Add(-h.cfg.Retention)); err != nil {
+				lg.Error().Err(err).Msg("failed to prune comment events")
+			}
+		}
+	}
+}
+
+// deliver publishes the event with the given id, or after a reconnect of the
+// listener (id 0) whatever was logged since the last delivered event, along
+// with the replay window before it.
+func (h *Hub) deliver(ctx context.Context, id int64) error {
+	var events []models.CommentEvent
+	if id == 0 {
+		if h.lastID == 0 {
+			return nil
+		}
+		missed, err := h.repo.GetEventsAfter(ctx, max(h.lastID-h.cfg.ReplayWindow, 0), "", nil, h.cfg.ReplayLimit)
+		if err != nil {
+			return err
+		}
+		events = missed
+	} else {
+		event, err := h.repo.GetEvent(ctx, id)
+		if err != nil {
+			return err
+		}
+		events = append(events, *event)
+	}
+
+	for _, event := range events {
+		if _, ok := h.delivered[event.ID]; ok {
+			continue
+		}
+		h.delivered[event.ID] = struct{}{}
+		h.publish(event)
+		h.lastID = max(h.lastID, event.ID)
+	}
+
+	if len(h.delivered) > 2*int(h.cfg.ReplayWindow)+1 {
+		for id := range h.delivered {
+			if id <= h.lastID-h.cfg.ReplayWindow {
+				delete(h.delivered, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Hub) publish(event models.CommentEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		if !sub.match(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe streams the events of a thread, or of a comment and its replies
+// when req.ParentID is set. With req.LastEventID the events logged after it
+// come first. The channel is closed when ctx is done or the subscriber falls
+// too far behind. At most MaxSubscribers streams are open at once.
+func (h *Hub) Subscribe(ctx context.Context, req models.StreamRequest) (<-chan models.CommentEvent, error) {
+	if req.ParentID != nil && *req.ParentID == "" {
+		req.ParentID = nil
+	}
+	if req.ParentID != nil {
+		if _, err := uuid.Parse(*req.ParentID); err != nil {
+			return nil, models.ErrInvalidInput
+		}
+	}
+	if err := validThreadKey(req.ThreadKey, req.ParentID == nil); err != nil {
+		return nil, err
+	}
+	if req.LastEventID < 0 {
+		return nil, models.ErrInvalidInput
+	}
+
+	// subscribe before reading the log so nothing falls in between, the
+	// overlap is skipped when forwarding
+	sub := &subscriber{threadKey: req.ThreadKey, parentID: req.ParentID, ch: make(chan models.CommentEvent, subscriberBuffer)}
+	if err := h.add(sub); err != nil {
+		return nil, err
+	}
+
+	replay, err := h.replay(ctx, req)
+	if err != nil {
+		h.remove(sub)
+		return nil, err
+	}
+
+	out := make(chan models.CommentEvent)
+	go h.forward(ctx, sub, replay, out)
+
+	return out, nil
+}
+
+// replay returns the events the client may have missed since
+// req.LastEventID, or a single reset event when some of them are no longer in
+// the log. The replay starts ReplayWindow ids before req.LastEventID to catch
+// the events committed after it, so the client gets some events again and
+// skips the ids it has seen.
+func (h *Hub) replay(ctx context.Context, req models.StreamRequest) ([]models.CommentEvent, error) {
+	if req.LastEventID == 0 {
+		return nil, nil
+	}
+
+	pruned, last, err := h.repo.EventBounds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reset := []models.CommentEvent{{ID: last, Type: models.EventReset}}
+	if pruned > req.LastEventID {
+		return reset, nil
+	}
+
+	events, err := h.repo.GetEventsAfter(ctx, max(req.LastEventID-h.cfg.ReplayWindow, 0), req.ThreadKey, req.ParentID, h.cfg.ReplayLimit+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) > h.cfg.ReplayLimit {
+		return reset, nil
+	}
+
+	return events, nil
+}
+
+func (h *Hub) forward(ctx context.Context, sub *subscriber, replay []models.CommentEvent, out chan<- models.CommentEvent) {
+	defer close(out)
+	defer h.remove(sub)
+
+	seen := make(map[int64]struct{}, len(replay))
+	for _, event := range replay {
+		seen[event.ID] = struct{}{}
+		select {
+		case out <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if _, ok := seen[event.ID]; ok {
+				continue
+			}
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *Hub) add(sub *subscriber) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs) >= h.cfg.MaxSubscribers {
+		return models.ErrStreamFull
+	}
+	h.subs[sub] = struct{}{}
+	return nil
+}
+
+func (h *Hub) remove(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
@@ -4,16 +4,35 @@ import (
 	"app/internal/models"
 	"app/pkg/logger"
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/wb-go/wbf/ginext"
 )
 
 type handlers struct {
-	ctx     context.Context
-	service ServiceInterface
+	ctx            context.Context
+	service        ServiceInterface
+	stream         StreamInterface
+	auth           AuthInterface
+	moderatorToken string
+}
+
+// author returns who signed the bearer token of the request, or the zero
+// author for a request without one.
+func (h *handlers) author(c *ginext.Context) (models.Author, error) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return models.Author{}, nil
+	}
+	author, err := h.auth.Verify(token)
+	if err != nil {
+		return models.Author{}, err
+	}
+	return *author, nil
 }
 
 func (h *handlers) middleware(c *ginext.Context) {
@@ -38,10 +57,20 @@ func (h *handlers) CreateComment(c *ginext.Context) {
 		return
 	}
 
+	author, err := h.author(c)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	req.Author = author
+
 	comment, err := h.service.CreateComment(c.Request.Context(), req)
 	if err != nil {
 		lg.Error().Err(err).Send()
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+		c.JSON(errorStatus(err), models.ErrorResponse{
 			Error: err.Error(),
 		})
 		return
@@ -61,11 +90,12 @@ func (h *handlers) GetComments(c *ginext.Context) {
 	order := c.DefaultQuery("order", "desc")
 
 	req := models.GetCommentsRequest{
-		Page:   page,
-		Limit:  limit,
-		Query:  query,
-		SortBy: sortBy,
-		Order:  order,
+		ThreadKey: c.Query("thread_key"),
+		Page:      page,
+		Limit:     limit,
+		Query:     query,
+		SortBy:    sortBy,
+		Order:     order,
 	}
 
 	if parentID != "" {
@@ -75,6 +105,12 @@ func (h *handlers) GetComments(c *ginext.Context) {
 	result, err := h.service.GetComments(c.Request.Context(), req)
 	if err != nil {
 		lg.Error().Err(err).Send()
+		if status := errorStatus(err); status != http.StatusInternalServerError {
+			c.JSON(status, models.ErrorResponse{
+				Error: err.Error(),
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
 			Error: "Failed to get comments",
 		})
@@ -95,10 +131,19 @@ func (h *handlers) DeleteComment(c *ginext.Context) {
 		return
 	}
 
-	err := h.service.DeleteComment(c.Request.Context(), id)
+	author, err := h.author(c)
 	if err != nil {
 		lg.Error().Err(err).Send()
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	err = h.service.DeleteComment(c.Request.Context(), id, author)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
 			Error: err.Error(),
 		})
 		return
@@ -106,3 +151,186 @@ func (h *handlers) DeleteComment(c *ginext.Context) {
 
 	c.Status(http.StatusNoContent)
 }
+
+func (h *handlers) UpdateComment(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	var req models.UpdateCommentRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	author, err := h.author(c)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	req.Author = author
+
+	comment, err := h.service.UpdateComment(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, comment)
+}
+
+func (h *handlers) ReactToComment(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	var req models.ReactionRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	author, err := h.author(c)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	req.Author = author
+
+	result, err := h.service.ReactToComment(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *handlers) GetHistory(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	edits, err := h.service.GetHistory(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, ginext.H{"edits": edits})
+}
+
+func (h *handlers) GetTree(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", strconv.Itoa(models.DefaultTreeDepth)))
+	if err != nil {
+		depth = models.DefaultTreeDepth
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(models.DefaultRepliesLimit)))
+
+	req := models.GetTreeRequest{
+		ID:     c.Param("id"),
+		Depth:  depth,
+		Limit:  limit,
+		Cursor: c.Query("cursor"),
+	}
+
+	tree, err := h.service.GetTree(c.Request.Context(), req)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, tree)
+}
+
+func (h *handlers) GetThreads(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(models.DefaultPageSize)))
+
+	req := models.GetThreadsRequest{
+		ThreadKey: c.Query("thread_key"),
+		Limit:     limit,
+		Cursor:    c.Query("cursor"),
+	}
+
+	result, err := h.service.GetThreads(c.Request.Context(), req)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *handlers) Search(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(models.DefaultPageSize)))
+
+	req := models.SearchRequest{
+		ThreadKey: c.Query("thread_key"),
+		Query:     c.Query("q"),
+		Lang:      c.DefaultQuery("lang", models.SearchLangRussian),
+		Page:      page,
+		Limit:     limit,
+	}
+
+	result, err := h.service.Search(c.Request.Context(), req)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrCommentNotFound), errors.Is(err, models.ErrParentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrInvalidInput), errors.Is(err, models.ErrBadCursor),
+		errors.Is(err, models.ErrMaxDepthExceeded), errors.Is(err, models.ErrShortSearchQuery),
+		errors.Is(err, models.ErrBadSearchLang):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrBadThreadKey), errors.Is(err, models.ErrBadReaction):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrCommentHidden):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrRejectedContent):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, models.ErrStreamFull):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+// moderator lets through requests bearing the moderator token. Moderation is
+// closed when no token is configured.
+func (h *handlers) moderator(c *ginext.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if h.moderatorToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.moderatorToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, models.ErrorResponse{
+			Error: "Moderator token required",
+		})
+		return
+	}
+	c.Next()
+}
+
+func (h *handlers) ReportComment(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	var req models.ReportRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	author, err := h.author(c)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	// anonymous reports are told apart by address, which is only as good as
+	// the TRUSTED_PROXIES setting
+	req.Reporter = "ip:" + c.ClientIP()
+	if author.ID != "" {
+		req.Reporter = "author:" + author.ID
+	}
+
+	if err := h.service.ReportComment(c.Request.Context(), c.Param("id"), req); err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+func (h *handlers) HideComment(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	var req models.HideRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: "Invalid request body",
+		})
+		return
+	}
+
+	if err := h.service.HideComment(c.Request.Context(), c.Param("id"), req); err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handlers) RestoreComment(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	if err := h.service.RestoreComment(c.Request.Context(), c.Param("id")); err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handlers) RemoveComment(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	if err := h.service.RemoveComment(c.Request.Context(), c.Param("id")); err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *handlers) GetModerationQueue(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(models.DefaultPageSize)))
+
+	result, err := h.service.GetModerationQueue(c.Request.Context(), page, limit)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
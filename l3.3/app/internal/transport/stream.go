@@ -0,0 +1,162 @@
+package transport
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/wb-go/wbf/ginext"
+)
+
+const (
+	// heartbeat keeps idle streams from being closed by proxies.
+	heartbeat = 15 * time.Second
+	writeWait = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// Stream pushes comment events as server-sent events, or over a WebSocket
+// when the client asks for an upgrade. A reconnecting client passes the id of
+// the last event it got in the Last-Event-ID header or the last_event_id
+// query parameter.
+func (h *handlers) Stream(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	req := models.StreamRequest{ThreadKey: c.Query("thread_key")}
+	if parentID := c.Query("parent_id"); parentID != "" {
+		req.ParentID = &parentID
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Error: "Invalid last event id",
+			})
+			return
+		}
+		req.LastEventID = id
+	}
+
+	// a hijacked websocket connection outlives the request context, the
+	// subscription ends when either side goes away
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	events, err := h.stream.Subscribe(ctx, req)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		c.JSON(errorStatus(err), models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		h.streamWebSocket(c, events, cancel)
+		return
+	}
+	h.streamSSE(c, events)
+}
+
+func (h *handlers) streamSSE(c *ginext.Context, events <-chan models.CommentEvent) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if err := writeEvent(w, event); err != nil {
+				lg.Error().Err(err).Send()
+				return false
+			}
+			return true
+		case <-ticker.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		}
+	})
+}
+
+// writeEvent writes the event in the text/event-stream format. The id line is
+// what the browser sends back as Last-Event-ID.
+func writeEvent(w io.Writer, event models.CommentEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.ID > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+func (h *handlers) streamWebSocket(c *ginext.Context, events <-chan models.CommentEvent, cancel context.CancelFunc) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		return
+	}
+	defer conn.Close()
+
+	// the stream is one way, reading only handles pongs and notices the close
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(writeWait))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		}
+	}
+}
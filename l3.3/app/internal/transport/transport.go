@@ -18,13 +18,38 @@ const (
 type ServiceInterface interface {
 	CreateComment(ctx context.Context, req models.CreateCommentRequest) (*models.Comment, error)
 	GetComments(ctx context.Context, req models.GetCommentsRequest) (*models.GetCommentsResponse, error)
-	DeleteComment(ctx context.Context, id string) error
+	DeleteComment(ctx context.Context, id string, author models.Author) error
+	GetTree(ctx context.Context, req models.GetTreeRequest) (*models.CommentNode, error)
+	GetThreads(ctx context.Context, req models.GetThreadsRequest) (*models.GetThreadsResponse, error)
+	Search(ctx context.Context, req models.SearchRequest) (*models.SearchResponse, error)
+	UpdateComment(ctx context.Context, id string, req models.UpdateCommentRequest) (*models.Comment, error)
+	GetHistory(ctx context.Context, id string) ([]models.CommentEdit, error)
+	ReactToComment(ctx context.Context, id string, req models.ReactionRequest) (*models.ReactionResponse, error)
+	ReportComment(ctx context.Context, id string, req models.ReportRequest) error
+	HideComment(ctx context.Context, id string, req models.HideRequest) error
+	RestoreComment(ctx context.Context, id string) error
+	RemoveComment(ctx context.Context, id string) error
+	GetModerationQueue(ctx context.Context, page, limit int) (*models.ModerationQueueResponse, error)
+}
+
+type StreamInterface interface {
+	Subscribe(ctx context.Context, req models.StreamRequest) (<-chan models.CommentEvent, error)
+}
+
+type AuthInterface interface {
+	Verify(token string) (*models.Author, error)
 }
 
 type ServerConfig struct {
 	Host        string `env:"SERVER_HOST" env-default:"localhost"`
 	Port        string `env:"SERVER_PORT" env-default:"8080"`
 	ReleaseMode string `env:"RELEASE_MODE" env-default:""`
+	// ModeratorToken guards the /moderation routes, they are closed when it
+	// is empty.
+	ModeratorToken string `env:"MODERATOR_TOKEN" env-default:""`
+	// TrustedProxies may set X-Forwarded-For, the client address of anyone
+	// else is the one the request came from.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" env-separator:"," env-default:""`
 }
 
 type Server struct {
@@ -58,10 +83,13 @@ func (s *Server) Stop() {
 
 }
 
-func New(service ServiceInterface, serverCfg *ServerConfig, ctx context.Context) *Server {
-	hers := &handlers{ctx, service}
+func New(service ServiceInterface, stream StreamInterface, auth AuthInterface, serverCfg *ServerConfig, ctx context.Context) *Server {
+	hers := &handlers{ctx, service, stream, auth, serverCfg.ModeratorToken}
 
 	mux := ginext.New(serverCfg.ReleaseMode)
+	if err := mux.SetTrustedProxies(serverCfg.TrustedProxies); err != nil {
+		panic(fmt.Sprintf("failed to set trusted proxies: %v", err))
+	}
 
 	mux.Static("/static", "./web")
 
@@ -74,7 +102,23 @@ func New(service ServiceInterface, serverCfg *ServerConfig, ctx context.Context)
 	api.Use(hers.middleware)
 	api.POST("", hers.CreateComment)
 	api.GET("", hers.GetComments)
+	api.GET("/threads", hers.GetThreads)
+	api.GET("/search", hers.Search)
+	api.GET("/stream", hers.Stream)
+	api.GET("/:id/tree", hers.GetTree)
+	api.PATCH("/:id", hers.UpdateComment)
 	api.DELETE("/:id", hers.DeleteComment)
+	api.GET("/:id/history", hers.GetHistory)
+	api.POST("/:id/report", hers.ReportComment)
+	api.PUT("/:id/reaction", hers.ReactToComment)
+
+	moderation := mux.Group("/moderation")
+
+	moderation.Use(hers.middleware, hers.moderator)
+	moderation.GET("/queue", hers.GetModerationQueue)
+	moderation.POST("/comments/:id/hide", hers.HideComment)
+	moderation.POST("/comments/:id/restore", hers.RestoreComment)
+	moderation.DELETE("/comments/:id", hers.RemoveComment)
 
 	return &Server{&http.Server{Addr: fmt.Sprintf("%s:%s", serverCfg.Host, serverCfg.Port), Handler: mux}, ctx}
 }
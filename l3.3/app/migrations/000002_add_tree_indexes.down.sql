@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_comments_path_prefix;
+DROP INDEX IF EXISTS idx_comments_replies;
+DROP INDEX IF EXISTS idx_comments_threads;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_comments_threads ON comments(created_at, id) WHERE parent_id IS NULL AND deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_replies ON comments(parent_id, created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_path_prefix ON comments(path text_pattern_ops) WHERE deleted_at IS NULL;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_comments_content_search_en ON comments USING GIN(to_tsvector('english', content));
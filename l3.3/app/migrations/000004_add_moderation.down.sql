@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS comment_reports;
+DROP TABLE IF EXISTS comment_edits;
+
+DROP INDEX IF EXISTS idx_comments_moderation;
+DROP INDEX IF EXISTS idx_comments_path_prefix;
+DROP INDEX IF EXISTS idx_comments_replies;
+DROP INDEX IF EXISTS idx_comments_threads;
+CREATE INDEX IF NOT EXISTS idx_comments_threads ON comments(created_at, id) WHERE parent_id IS NULL AND deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_replies ON comments(parent_id, created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_path_prefix ON comments(path text_pattern_ops) WHERE deleted_at IS NULL;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS report_count;
+ALTER TABLE comments DROP COLUMN IF EXISTS hidden_reason;
+ALTER TABLE comments DROP COLUMN IF EXISTS hidden_at;
+ALTER TABLE comments DROP COLUMN IF EXISTS edited_at;
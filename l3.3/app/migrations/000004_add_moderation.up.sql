@@ -0,0 +1,46 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hidden_at TIMESTAMP WITH TIME ZONE NULL;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hidden_reason TEXT NULL;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS report_count INTEGER NOT NULL DEFAULT 0;
+
+-- deleted comments used to take their whole subtree with them; they are
+-- tombstones now, so bring back everything that was deleted only as a
+-- descendant and blank the rest
+UPDATE comments c SET deleted_at = NULL
+WHERE c.deleted_at IS NOT NULL
+    AND EXISTS (
+        SELECT 1 FROM comments p
+        WHERE p.id = c.parent_id AND p.deleted_at = c.deleted_at
+    );
+UPDATE comments SET content = '' WHERE deleted_at IS NOT NULL;
+DELETE FROM comments c
+WHERE c.deleted_at IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM comments ch WHERE ch.parent_id = c.id);
+
+DROP INDEX IF EXISTS idx_comments_threads;
+DROP INDEX IF EXISTS idx_comments_replies;
+DROP INDEX IF EXISTS idx_comments_path_prefix;
+CREATE INDEX IF NOT EXISTS idx_comments_threads ON comments(created_at, id) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_replies ON comments(parent_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_comments_path_prefix ON comments(path text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_comments_moderation ON comments(report_count DESC, created_at)
+    WHERE deleted_at IS NULL AND (hidden_at IS NOT NULL OR report_count > 0);
+
+CREATE TABLE IF NOT EXISTS comment_edits (
+    id BIGSERIAL PRIMARY KEY,
+    comment_id UUID NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    edited_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_edits_comment_id ON comment_edits(comment_id, edited_at);
+
+CREATE TABLE IF NOT EXISTS comment_reports (
+    id BIGSERIAL PRIMARY KEY,
+    comment_id UUID NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP WITH TIME ZONE NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_reports_open ON comment_reports(comment_id) WHERE resolved_at IS NULL;
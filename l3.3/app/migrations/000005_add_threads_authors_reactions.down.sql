@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS comment_reactions;
+
+DROP INDEX IF EXISTS idx_comments_author_id;
+DROP INDEX IF EXISTS idx_comments_threads;
+CREATE INDEX IF NOT EXISTS idx_comments_threads ON comments(created_at, id) WHERE parent_id IS NULL;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS dislikes;
+ALTER TABLE comments DROP COLUMN IF EXISTS likes;
+ALTER TABLE comments DROP COLUMN IF EXISTS author_name;
+ALTER TABLE comments DROP COLUMN IF EXISTS author_id;
+ALTER TABLE comments DROP COLUMN IF EXISTS thread_key;
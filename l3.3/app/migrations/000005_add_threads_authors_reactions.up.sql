@@ -0,0 +1,18 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS thread_key TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE comments ALTER COLUMN thread_key DROP DEFAULT;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS author_id TEXT NULL;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS author_name TEXT NULL;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS likes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS dislikes INTEGER NOT NULL DEFAULT 0;
+
+DROP INDEX IF EXISTS idx_comments_threads;
+CREATE INDEX IF NOT EXISTS idx_comments_threads ON comments(thread_key, created_at, id) WHERE parent_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_comments_author_id ON comments(author_id) WHERE author_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS comment_reactions (
+    comment_id UUID NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (comment_id, user_id)
+);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS comments_events ON comments;
+DROP FUNCTION IF EXISTS log_comment_event();
+DROP TABLE IF EXISTS comment_events;
@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS comment_events (
+    id BIGSERIAL PRIMARY KEY,
+    type TEXT NOT NULL,
+    thread_key TEXT NOT NULL,
+    path TEXT NOT NULL,
+    comment JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_events_thread ON comment_events(thread_key, id);
+CREATE INDEX IF NOT EXISTS idx_comment_events_created_at ON comment_events(created_at);
+
+-- Every visible change to a comment is logged and announced on the
+-- comment_events channel. The notification carries only the event id since
+-- a payload is limited to 8000 bytes, listeners read the event from the log.
+CREATE OR REPLACE FUNCTION log_comment_event() RETURNS trigger AS $$
+DECLARE
+    rec comments;
+    event_type TEXT;
+    event_id BIGINT;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        rec := NEW;
+        IF NEW.hidden_at IS NULL THEN
+            event_type := 'created';
+        END IF;
+    ELSIF TG_OP = 'DELETE' THEN
+        rec := OLD;
+        event_type := 'deleted';
+    ELSE
+        rec := NEW;
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+            event_type := 'deleted';
+        ELSIF OLD.hidden_at IS NULL AND NEW.hidden_at IS NOT NULL THEN
+            event_type := 'deleted';
+        ELSIF OLD.hidden_at IS NOT NULL AND NEW.hidden_at IS NULL THEN
+            event_type := 'created';
+        ELSIF OLD.edited_at IS DISTINCT FROM NEW.edited_at AND NEW.hidden_at IS NULL THEN
+            event_type := 'edited';
+        END IF;
+    END IF;
+
+    IF event_type IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO comment_events (type, thread_key, path, comment)
+    VALUES (event_type, rec.thread_key, rec.path,
+        to_jsonb(rec) - 'hidden_reason' - 'report_count'
+            || CASE WHEN event_type = 'deleted' THEN '{"content": ""}'::jsonb ELSE '{}'::jsonb END)
+    RETURNING id INTO event_id;
+
+    PERFORM pg_notify('comment_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS comments_events ON comments;
+CREATE TRIGGER comments_events
+    AFTER INSERT OR UPDATE OR DELETE ON comments
+    FOR EACH ROW EXECUTE FUNCTION log_comment_event();
@@ -0,0 +1,2 @@
+ALTER TABLE comment_reports DROP CONSTRAINT IF EXISTS comment_reports_reporter_key;
+ALTER TABLE comment_reports DROP COLUMN IF EXISTS reporter;
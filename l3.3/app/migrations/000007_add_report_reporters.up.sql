@@ -0,0 +1,6 @@
+-- a report belongs to whoever filed it, so one reporter counts once. Reports
+-- from before that are kept apart from each other
+ALTER TABLE comment_reports ADD COLUMN IF NOT EXISTS reporter TEXT NULL;
+UPDATE comment_reports SET reporter = 'legacy:' || id WHERE reporter IS NULL;
+ALTER TABLE comment_reports ALTER COLUMN reporter SET NOT NULL;
+ALTER TABLE comment_reports ADD CONSTRAINT comment_reports_reporter_key UNIQUE (comment_id, reporter);
@@ -0,0 +1,9 @@
+-- event ids are taken before the commit, so a later id can be logged first
+-- and the oldest id left in the log says nothing about what was pruned. The
+-- newest pruned id is kept instead.
+CREATE TABLE IF NOT EXISTS comment_events_pruned (
+    single BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (single),
+    through BIGINT NOT NULL
+);
+
+INSERT INTO comment_events_pruned (through) VALUES (0) ON CONFLICT DO NOTHING;
@@ -0,0 +1,8 @@
+-- only the latest report of each reporter fits the old constraint
+DELETE FROM comment_reports r
+WHERE EXISTS (
+    SELECT 1 FROM comment_reports newer
+    WHERE newer.comment_id = r.comment_id AND newer.reporter = r.reporter AND newer.id > r.id
+);
+DROP INDEX IF EXISTS idx_comment_reports_open_reporter;
+ALTER TABLE comment_reports ADD CONSTRAINT comment_reports_reporter_key UNIQUE (comment_id, reporter);
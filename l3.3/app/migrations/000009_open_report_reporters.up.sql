@@ -0,0 +1,5 @@
+-- a reporter counts once among the open reports only. Once a moderator
+-- restores the comment its reports are resolved, and the same reporters can
+-- report it again
+ALTER TABLE comment_reports DROP CONSTRAINT IF EXISTS comment_reports_reporter_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_comment_reports_open_reporter ON comment_reports(comment_id, reporter) WHERE resolved_at IS NULL;
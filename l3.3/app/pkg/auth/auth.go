@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"app/internal/models"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type AuthConfig struct {
+	// Secret signs author tokens, it is shared with whoever issues them.
+	Secret string `env:"AUTH_SECRET" env-default:""`
+}
+
+// Valid checks that the secret is at least 16 bytes long.
+func (cfg AuthConfig) Valid() error {
+	if len(cfg.Secret) < 16 {
+		return models.ErrBadAuthCfg
+	}
+	return nil
+}
+
+type claims struct {
+	Name string `json:"name,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verifier checks HS256 signed JWTs: the author id is the sub claim, the
+// display name the name claim, and an exp claim is required.
+type Verifier struct {
+	secret []byte
+}
+
+func New(cfg AuthConfig) *Verifier {
+	return &Verifier{secret: []byte(cfg.Secret)}
+}
+
+func (v *Verifier) Verify(token string) (*models.Author, error) {
+	var c claims
+	_, err := jwt.ParseWithClaims(token, &c, func(*jwt.Token) (interface{}, error) {
+		return v.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || c.Subject == "" {
+		return nil, models.ErrUnauthorized
+	}
+	return &models.Author{ID: c.Subject, Name: c.Name}, nil
+}
+
+// Issue signs a token for the author valid for ttl.
+func (v *Verifier) Issue(author models.Author, ttl time.Duration) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		Name: author.Name,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   author.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+	return token.SignedString(v.secret)
+}
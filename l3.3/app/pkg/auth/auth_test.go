@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"app/internal/models"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestIssueAndVerify(t *testing.T) {
+	t.Parallel()
+	v := New(AuthConfig{Secret: testSecret})
+
+	token, err := v.Issue(models.Author{ID: "user-1", Name: "Аня"}, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	author, err := v.Verify(token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if author.ID != "user-1" || author.Name != "Аня" {
+		t.Fatalf("unexpected author %+v", *author)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	t.Parallel()
+	v := New(AuthConfig{Secret: testSecret})
+
+	expired, _ := v.Issue(models.Author{ID: "user-1"}, -time.Minute)
+	foreign, _ := New(AuthConfig{Secret: "another-secret-of-enough-length"}).Issue(models.Author{ID: "user-1"}, time.Hour)
+	noSubject, _ := v.Issue(models.Author{}, time.Hour)
+	noExpiry, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "user-1"}).SignedString([]byte(testSecret))
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.RegisteredClaims{
+		Subject:   "user-1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	for name, token := range map[string]string{
+		"garbage":    "not.a.token",
+		"expired":    expired,
+		"foreign":    foreign,
+		"no subject": noSubject,
+		"no expiry":  noExpiry,
+		"alg none":   none,
+	} {
+		if _, err := v.Verify(token); !errors.Is(err, models.ErrUnauthorized) {
+			t.Fatalf("%s: expected ErrUnauthorized, got %v", name, err)
+		}
+	}
+}
+
+func TestConfigValid(t *testing.T) {
+	t.Parallel()
+	if err := (AuthConfig{Secret: testSecret}).Valid(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (AuthConfig{Secret: "short"}).Valid(); !errors.Is(err, models.ErrBadAuthCfg) {
+		t.Fatalf("expected ErrBadAuthCfg, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package cursor
+
+import (
+	"app/internal/models"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Cursor is a keyset position: the (created_at, id) pair of the last comment
+// the client has already seen.
+type Cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+}
+
+// Of returns the cursor pointing right after the comment.
+func Of(c models.Comment) Cursor {
+	return Cursor{CreatedAt: c.CreatedAt, ID: c.ID}
+}
+
+// Encode packs the cursor into an opaque url-safe token.
+func Encode(c Cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// Decode unpacks a token made by Encode. An empty token means "from the start"
+// and yields a nil cursor.
+func Decode(token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, models.ErrBadCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, models.ErrBadCursor
+	}
+	if c.CreatedAt.IsZero() {
+		return nil, models.ErrBadCursor
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return nil, models.ErrBadCursor
+	}
+	return &c, nil
+}
@@ -0,0 +1,45 @@
+package cursor
+
+import (
+	"app/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	t.Parallel()
+	want := Cursor{
+		CreatedAt: time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC),
+		ID:        "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	got, err := Decode(Encode(want))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+		t.Fatalf("expected %+v, got %+v", want, *got)
+	}
+}
+
+func TestDecodeEmpty(t *testing.T) {
+	t.Parallel()
+	c, err := Decode("")
+	if err != nil || c != nil {
+		t.Fatalf("expected nil cursor, got %+v, %v", c, err)
+	}
+}
+
+func TestDecodeRejectsGarbage(t *testing.T) {
+	t.Parallel()
+	for _, token := range []string{
+		"not base64!",
+		Encode(Cursor{ID: "123e4567-e89b-12d3-a456-426614174000"}),
+		Encode(Cursor{CreatedAt: time.Now(), ID: "1; DROP TABLE comments"}),
+	} {
+		if _, err := Decode(token); !errors.Is(err, models.ErrBadCursor) {
+			t.Fatalf("token %q: expected ErrBadCursor, got %v", token, err)
+		}
+	}
+}
@@ -12,6 +12,8 @@ import (
 type Data struct {
 	Mu sync.Mutex
 	DB *dbpg.DB
+	// DSN is kept for connections outside the pool, like LISTEN.
+	DSN string
 }
 
 type DataConfig struct {
@@ -38,7 +40,8 @@ func New(cfg DataConfig) *Data {
 		panic(fmt.Sprintf("failed to ping db: %v", err))
 	}
 	return &Data{
-		DB: db,
+		DB:  db,
+		DSN: dsn,
 	}
 
 }
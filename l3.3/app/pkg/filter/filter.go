@@ -0,0 +1,185 @@
+package filter
+
+import (
+	"app/internal/models"
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+type FilterConfig struct {
+	// WordsFile has a banned word per line. Empty lines and lines starting
+	// with # are skipped.
+	WordsFile   string  `env:"FILTER_WORDS_FILE" env-default:""`
+	ReviewScore float64 `env:"FILTER_REVIEW_SCORE" env-default:"0.5"`
+	RejectScore float64 `env:"FILTER_REJECT_SCORE" env-default:"0.9"`
+}
+
+// Valid checks that 0 < review score <= reject score <= 1.
+func (cfg FilterConfig) Valid() error {
+	if cfg.ReviewScore <= 0 || cfg.ReviewScore > cfg.RejectScore || cfg.RejectScore > 1 {
+		return models.ErrBadFilterCfg
+	}
+	return nil
+}
+
+// Scorer rates content from 0, clean, to 1, certainly unwanted.
+type Scorer interface {
+	Score(content string) float64
+}
+
+// Filter turns the highest score of its scorers into a verdict: comments
+// scored at least ReviewScore go to moderation, at least RejectScore are
+// refused.
+type Filter struct {
+	scorers []Scorer
+	review  float64
+	reject  float64
+}
+
+// New builds a filter from the banned words file and the spam heuristics,
+// extra scorers are consulted as well.
+func New(cfg FilterConfig, extra ...Scorer) *Filter {
+	f := &Filter{review: cfg.ReviewScore, reject: cfg.RejectScore}
+
+	if cfg.WordsFile != "" {
+		words, err := loadWords(cfg.WordsFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to load banned words: %v", err))
+		}
+		f.scorers = append(f.scorers, NewWordList(words))
+	}
+	f.scorers = append(f.scorers, Spam{})
+	f.scorers = append(f.scorers, extra...)
+
+	return f
+}
+
+func (f *Filter) Check(ctx context.Context, content string) (models.Verdict, error) {
+	var score float64
+	for _, s := range f.scorers {
+		score = max(score, s.Score(content))
+	}
+
+	switch {
+	case score >= f.reject:
+		return models.VerdictReject, nil
+	case score >= f.review:
+		return models.VerdictReview, nil
+	default:
+		return models.VerdictAllow, nil
+	}
+}
+
+func loadWords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, sc.Err()
+}
+
+// WordList scores 1 any content containing one of its words. Matching is by
+// whole word, case-insensitive, with ё treated as е.
+type WordList struct {
+	words map[string]bool
+}
+
+func NewWordList(words []string) *WordList {
+	l := &WordList{words: make(map[string]bool, len(words))}
+	for _, w := range words {
+		l.words[normalize(w)] = true
+	}
+	return l
+}
+
+func (l *WordList) Score(content string) float64 {
+	for _, w := range tokenize(content) {
+		if l.words[w] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Spam adds up simple signs of spam: links, shouting, long runs of one
+// character and one word repeated over and over.
+type Spam struct{}
+
+func (Spam) Score(content string) float64 {
+	var score float64
+
+	lower := strings.ToLower(content)
+	links := strings.Count(lower, "http://") + strings.Count(lower, "https://") + strings.Count(lower, "www.")
+	score += 0.2 * float64(links)
+
+	var letters, upper int
+	for _, r := range content {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters >= 10 && float64(upper)/float64(letters) > 0.7 {
+		score += 0.3
+	}
+
+	if longestRun(content) >= 6 {
+		score += 0.2
+	}
+
+	words := tokenize(content)
+	if len(words) >= 6 {
+		counts := map[string]int{}
+		for _, w := range words {
+			counts[w]++
+			if counts[w]*2 > len(words) {
+				score += 0.3
+				break
+			}
+		}
+	}
+
+	return min(score, 1)
+}
+
+func longestRun(s string) int {
+	var best, run int
+	var prev rune
+	for i, r := range s {
+		if i > 0 && r == prev && !unicode.IsSpace(r) {
+			run++
+		} else {
+			run = 1
+		}
+		prev = r
+		best = max(best, run)
+	}
+	return best
+}
+
+func tokenize(content string) []string {
+	return strings.FieldsFunc(normalize(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func normalize(s string) string {
+	return strings.ReplaceAll(strings.ToLower(s), "ё", "е")
+}
@@ -0,0 +1,95 @@
+package filter
+
+import (
+	"app/internal/models"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fixed float64
+
+func (f fixed) Score(string) float64 { return float64(f) }
+
+func testCfg() FilterConfig {
+	return FilterConfig{ReviewScore: 0.5, RejectScore: 0.9}
+}
+
+func TestWordList(t *testing.T) {
+	t.Parallel()
+	l := NewWordList([]string{"Ёлка", "spam"})
+
+	for content, want := range map[string]float64{
+		"нарядная елка":        1,
+		"buy SPAM now":         1,
+		"spammer is not spam!": 1,
+		"spammer":              0,
+		"обычный комментарий":  0,
+	} {
+		if got := l.Score(content); got != want {
+			t.Fatalf("%q: expected %v, got %v", content, want, got)
+		}
+	}
+}
+
+func TestSpam(t *testing.T) {
+	t.Parallel()
+	if got := (Spam{}).Score("Хорошая статья, спасибо автору"); got != 0 {
+		t.Fatalf("expected clean text to score 0, got %v", got)
+	}
+	if got := (Spam{}).Score("BUY NOW!!!!!!! http://a.example https://b.example www.c.example www.d.example"); got < 0.9 {
+		t.Fatalf("expected obvious spam to score at least 0.9, got %v", got)
+	}
+	if got := (Spam{}).Score(strings.Repeat("buy ", 8) + "please"); got < 0.3 {
+		t.Fatalf("expected repeated words to be scored, got %v", got)
+	}
+}
+
+func TestCheckVerdicts(t *testing.T) {
+	t.Parallel()
+	for score, want := range map[float64]models.Verdict{
+		0.1: models.VerdictAllow,
+		0.5: models.VerdictReview,
+		0.9: models.VerdictReject,
+	} {
+		f := New(testCfg(), fixed(score))
+		got, err := f.Check(context.Background(), "hello")
+		if err != nil || got != want {
+			t.Fatalf("score %v: expected %v, got %v, %v", score, want, got, err)
+		}
+	}
+}
+
+func TestNewLoadsWords(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# banned\n\nbadword\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testCfg()
+	cfg.WordsFile = path
+	f := New(cfg)
+
+	got, err := f.Check(context.Background(), "what a badword")
+	if err != nil || got != models.VerdictReject {
+		t.Fatalf("expected reject, got %v, %v", got, err)
+	}
+}
+
+func TestConfigValid(t *testing.T) {
+	t.Parallel()
+	if err := testCfg().Valid(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, cfg := range []FilterConfig{
+		{ReviewScore: 0, RejectScore: 0.9},
+		{ReviewScore: 0.9, RejectScore: 0.5},
+		{ReviewScore: 0.5, RejectScore: 1.5},
+	} {
+		if err := cfg.Valid(); err != models.ErrBadFilterCfg {
+			t.Fatalf("%+v: expected ErrBadFilterCfg, got %v", cfg, err)
+		}
+	}
+}
@@ -4,16 +4,65 @@ let totalComments = 0;
 let limit = 10;
 let currentTreeCommentId = null; 
 let currentSearch = ''; 
+let treeDepth = 3;
+let repliesLimit = 10;
+let threadKey = new URLSearchParams(location.search).get('thread') || localStorage.getItem('threadKey') || 'main';
+let authToken = localStorage.getItem('authToken') || '';
+let eventSource = null;
+let refreshTimer = null;
 
 document.addEventListener('DOMContentLoaded', () => {
+    document.getElementById('threadKey').value = threadKey;
+    document.getElementById('authToken').value = authToken;
     loadComments();
+    subscribe();
 });
 
+function subscribe() {
+    if (eventSource) {
+        eventSource.close();
+    }
+    
+    // EventSource reconnects by itself and resumes with Last-Event-ID
+    eventSource = new EventSource(`/comments/stream?thread_key=${encodeURIComponent(threadKey)}`);
+    
+    ['created', 'edited', 'deleted', 'reset'].forEach(type => {
+        eventSource.addEventListener(type, scheduleRefresh);
+    });
+}
+
+function scheduleRefresh() {
+    if (currentSearch) {
+        return;
+    }
+    
+    clearTimeout(refreshTimer);
+    refreshTimer = setTimeout(() => loadComments(currentPage), 300);
+}
+
+function saveSettings() {
+    threadKey = document.getElementById('threadKey').value.trim() || 'main';
+    authToken = document.getElementById('authToken').value.trim();
+    localStorage.setItem('threadKey', threadKey);
+    localStorage.setItem('authToken', authToken);
+    currentTreeCommentId = null;
+    loadComments();
+    subscribe();
+}
+
+function authHeaders(headers = {}) {
+    if (authToken) {
+        headers['Authorization'] = `Bearer ${authToken}`;
+    }
+    return headers;
+}
+
 async function loadComments(page = 1) {
     try {
         showMessage('Загрузка...');
         
-        let url = `/comments?page=${page}&limit=${limit}`;
+        const sortBy = document.getElementById('sortBy').value;
+        let url = `/comments?thread_key=${encodeURIComponent(threadKey)}&page=${page}&limit=${limit}&sort_by=${sortBy}`;
         
         if (currentSearch) {
             url += `&query=${encodeURIComponent(currentSearch)}`;
@@ -46,20 +95,25 @@ async function loadComments(page = 1) {
     }
 }
 
-async function loadCommentTree(commentId) {
+async function loadCommentTree(commentId, cursor = '') {
     try {
-        const response = await fetch(`/comments?parent=${commentId}&limit=1000`);
+        let url = `/comments/${commentId}/tree?depth=${treeDepth}&limit=${repliesLimit}`;
+        
+        if (cursor) {
+            url += `&cursor=${encodeURIComponent(cursor)}`;
+        }
+        
+        const response = await fetch(url);
         
         if (!response.ok) {
-            return [];
+            return null;
         }
         
-        const data = await response.json();
-        return data.comments || [];
+        return await response.json();
         
     } catch (error) {
         console.error('Ошибка при загрузке дерева:', error);
-        return [];
+        return null;
     }
 }
 
@@ -78,13 +132,13 @@ async function displayCommentsWithTree(rootComments) {
         return;
     }
     
-    const allTreeComments = await loadCommentTree(currentTreeCommentId);
+    const tree = await loadCommentTree(currentTreeCommentId);
     
     let html = '';
     
     rootComments.forEach(comment => {
-        if (comment.id === currentTreeCommentId) {
-            html += renderCommentWithFullTree(comment, allTreeComments);
+        if (comment.id === currentTreeCommentId && tree) {
+            html += renderTree(tree, 0);
         } else {
             html += renderComment(comment, 0, false);
         }
@@ -113,17 +167,28 @@ function displayComments(comments) {
 function renderComment(comment, depth = 0, isChild = false) {
     const marginLeft = depth * 30;
     const isExpanded = comment.id === currentTreeCommentId;
+    const isGone = comment.deleted_at || comment.hidden_at;
+    
+    let content = escapeHtml(comment.content);
+    if (comment.deleted_at) {
+        content = '<span class="tombstone">Комментарий удалён</span>';
+    } else if (comment.hidden_at) {
+        content = '<span class="tombstone">Комментарий скрыт модератором</span>';
+    }
     
     return `
         <div class="comment ${isChild ? 'child-comment' : ''}" 
              style="margin-left: ${marginLeft}px" 
              data-id="${comment.id}">
             <div class="comment-content">
-                ${escapeHtml(comment.content)}
+                ${content}
             </div>
             <div class="comment-meta">
+                ${comment.author_name && !isGone ? `<span class="author">${escapeHtml(comment.author_name)}</span>` : ''}
                 <span>${formatDate(comment.created_at)}</span>
                 <span>#${comment.id.substring(0, 8)}</span>
+                ${comment.reply_count !== undefined ? `<span>Ответов: ${comment.reply_count}</span>` : ''}
+                ${comment.edited_at && !isGone ? `<span title="${formatDate(comment.edited_at)}">изменён</span>` : ''}
             </div>
             <div class="comment-actions">
                 ${!isChild ? `
@@ -131,48 +196,66 @@ function renderComment(comment, depth = 0, isChild = false) {
                         ${isExpanded ? 'Скрыть дерево' : 'Показать дерево'}
                     </button>
                 ` : ''}
-                <button class="reply-btn" onclick="replyToComment('${comment.id}')">
-                    Ответить
-                </button>
-                <button class="delete-btn" onclick="deleteComment('${comment.id}')">
-                    Удалить
-                </button>
+                ${!isGone ? `
+                    <button class="like-btn" onclick="reactToComment('${comment.id}', 1)">
+                        👍 ${comment.likes || 0}
+                    </button>
+                    <button class="dislike-btn" onclick="reactToComment('${comment.id}', -1)">
+                        👎 ${comment.dislikes || 0}
+                    </button>
+                    <button class="reply-btn" onclick="replyToComment('${comment.id}')">
+                        Ответить
+                    </button>
+                    <button class="edit-btn" onclick="editComment('${comment.id}')">
+                        Изменить
+                    </button>
+                    <button class="report-btn" onclick="reportComment('${comment.id}')">
+                        Пожаловаться
+                    </button>
+                    <button class="delete-btn" onclick="deleteComment('${comment.id}')">
+                        Удалить
+                    </button>
+                ` : ''}
             </div>
         </div>
     `;
 }
 
-function renderCommentWithFullTree(comment, allTreeComments) {
+function renderTree(node, depth) {
+    return renderComment(node, depth, depth > 0) + renderReplies(node, depth);
+}
+
+function renderReplies(node, depth) {
     let html = '';
     
-    const sortedTreeComments = [...allTreeComments].sort((a, b) => a.path.localeCompare(b.path));
-    
-    const commentsById = {};
-    sortedTreeComments.forEach(c => {
-        commentsById[c.id] = c;
+    (node.children || []).forEach(child => {
+        html += renderTree(child, depth + 1);
     });
     
-    function renderTree(currentComment, currentDepth = 0) {
-        let result = renderComment(currentComment, currentDepth, currentDepth > 0);
-        
-        const children = sortedTreeComments.filter(c => 
-            c.parent_id === currentComment.id && c.id !== currentComment.id
-        );
-        
-        children.sort((a, b) => new Date(a.created_at) - new Date(b.created_at));
-        
-        children.forEach(child => {
-            result += renderTree(child, currentDepth + 1);
-        });
-        
-        return result;
+    const loaded = (node.children || []).length;
+    if (node.next_cursor || (loaded === 0 && node.reply_count > 0)) {
+        html += `
+            <button class="more-btn" style="margin-left: ${(depth + 1) * 30}px"
+                    onclick="loadMoreReplies('${node.id}', '${node.next_cursor || ''}', ${depth}, this)">
+                Показать ещё ответы
+            </button>
+        `;
     }
     
-    html = renderTree(comment, 0);
-    
     return html;
 }
 
+async function loadMoreReplies(commentId, cursor, depth, button) {
+    const node = await loadCommentTree(commentId, cursor);
+    
+    if (!node) {
+        showError('Ошибка загрузки ответов');
+        return;
+    }
+    
+    button.outerHTML = renderReplies(node, depth);
+}
+
 async function toggleCommentTree(commentId) {
     if (currentTreeCommentId === commentId) {
         currentTreeCommentId = null;
@@ -195,7 +278,8 @@ async function addComment() {
     }
     
     const requestBody = {
-        content: content
+        content: content,
+        thread_key: threadKey
     };
     
     if (parentId) {
@@ -207,9 +291,9 @@ async function addComment() {
         
         const response = await fetch('/comments', {
             method: 'POST',
-            headers: {
+            headers: authHeaders({
                 'Content-Type': 'application/json',
-            },
+            }),
             body: JSON.stringify(requestBody)
         });
         
@@ -219,7 +303,8 @@ async function addComment() {
             return;
         }
         
-        showSuccess('Комментарий добавлен');
+        const comment = await response.json();
+        showSuccess(comment.hidden_at ? 'Комментарий отправлен на модерацию' : 'Комментарий добавлен');
         document.getElementById('commentText').value = '';
         document.getElementById('parentId').value = '';
         loadComments(currentPage);
@@ -241,11 +326,13 @@ async function deleteComment(commentId) {
         showMessage('Удаление...');
         
         const response = await fetch(`/comments/${commentId}`, {
-            method: 'DELETE'
+            method: 'DELETE',
+            headers: authHeaders()
         });
         
         if (!response.ok) {
-            showError('Ошибка удаления');
+            const error = await response.json().catch(() => ({}));
+            showError(error.error || 'Ошибка удаления');
             return;
         }
         
@@ -258,8 +345,95 @@ async function deleteComment(commentId) {
     }
 }
 
+async function editComment(commentId) {
+    const current = document.querySelector(`.comment[data-id="${commentId}"] .comment-content`);
+    const content = prompt('Новый текст комментария', current ? current.textContent.trim() : '');
+    
+    if (content === null || !content.trim()) {
+        return;
+    }
+    
+    try {
+        const response = await fetch(`/comments/${commentId}`, {
+            method: 'PATCH',
+            headers: authHeaders({
+                'Content-Type': 'application/json',
+            }),
+            body: JSON.stringify({ content: content })
+        });
+        
+        if (!response.ok) {
+            const error = await response.json().catch(() => ({}));
+            showError(error.error || 'Ошибка изменения');
+            return;
+        }
+        
+        const comment = await response.json();
+        showSuccess(comment.hidden_at ? 'Комментарий отправлен на модерацию' : 'Комментарий изменён');
+        loadComments(currentPage);
+        
+    } catch (error) {
+        showError('Ошибка при изменении комментария');
+        console.error(error);
+    }
+}
+
+async function reactToComment(commentId, value) {
+    try {
+        const response = await fetch(`/comments/${commentId}/reaction`, {
+            method: 'PUT',
+            headers: authHeaders({
+                'Content-Type': 'application/json',
+            }),
+            body: JSON.stringify({ value: value })
+        });
+        
+        if (!response.ok) {
+            const error = await response.json().catch(() => ({}));
+            showError(error.error || 'Ошибка оценки');
+            return;
+        }
+        
+        loadComments(currentPage);
+        
+    } catch (error) {
+        showError('Ошибка при оценке комментария');
+        console.error(error);
+    }
+}
+
+async function reportComment(commentId) {
+    const reason = prompt('Причина жалобы');
+    
+    if (reason === null || !reason.trim()) {
+        return;
+    }
+    
+    try {
+        const response = await fetch(`/comments/${commentId}/report`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ reason: reason })
+        });
+        
+        if (!response.ok) {
+            showError('Ошибка отправки жалобы');
+            return;
+        }
+        
+        showSuccess('Жалоба отправлена');
+        
+    } catch (error) {
+        showError('Ошибка при отправке жалобы');
+        console.error(error);
+    }
+}
+
 async function searchComments() {
     const query = document.getElementById('searchInput').value.trim();
+    const lang = document.getElementById('searchLang').value;
     
     if (!query) {
         showError('Введите поисковый запрос');
@@ -272,10 +446,11 @@ async function searchComments() {
         currentTreeCommentId = null; 
         currentPage = 1;
         
-        const response = await fetch(`/comments?query=${encodeURIComponent(query)}&limit=100`);
+        const response = await fetch(`/comments/search?q=${encodeURIComponent(query)}&lang=${lang}&thread_key=${encodeURIComponent(threadKey)}&limit=100`);
         
         if (!response.ok) {
-            showError('Ошибка поиска');
+            const error = await response.json().catch(() => ({}));
+            showError(error.error || 'Ошибка поиска');
             return;
         }
         
@@ -285,11 +460,11 @@ async function searchComments() {
         totalPages = data.total_pages || 1;
         totalComments = data.total || 0;
         
-        displayComments(data.comments);
+        displaySearchHits(data.hits);
         
         updatePagination();
         
-        showSuccess(`Найдено: ${data.comments ? data.comments.length : 0} комментариев`);
+        showSuccess(`Найдено: ${data.total || 0} комментариев`);
         
     } catch (error) {
         showError('Ошибка поиска');
@@ -297,6 +472,62 @@ async function searchComments() {
     }
 }
 
+function displaySearchHits(hits) {
+    const container = document.getElementById('commentsTree');
+    
+    if (!hits || hits.length === 0) {
+        container.innerHTML = '<div class="empty">Ничего не найдено</div>';
+        return;
+    }
+    
+    let html = '';
+    
+    hits.forEach(hit => {
+        const inThread = hit.thread && hit.thread.id !== hit.id;
+        
+        html += `
+            <div class="comment" data-id="${hit.id}">
+                ${inThread ? `
+                    <div class="comment-thread">
+                        В ветке: ${escapeHtml(hit.thread.content.substring(0, 100))}
+                    </div>
+                ` : ''}
+                <div class="comment-content">
+                    ${highlight(hit.headline)}
+                </div>
+                <div class="comment-meta">
+                    <span>${formatDate(hit.created_at)}</span>
+                    <span>#${hit.id.substring(0, 8)}</span>
+                </div>
+                <div class="comment-actions">
+                    <button class="tree-btn" onclick="openThread('${hit.thread.id}')">
+                        Открыть ветку
+                    </button>
+                </div>
+            </div>
+        `;
+    });
+    
+    container.innerHTML = html;
+}
+
+async function openThread(threadId) {
+    const tree = await loadCommentTree(threadId);
+    
+    if (!tree) {
+        showError('Ошибка загрузки дерева');
+        return;
+    }
+    
+    document.getElementById('commentsTree').innerHTML = renderTree(tree, 0);
+}
+
+function highlight(headline) {
+    return escapeHtml(headline)
+        .replaceAll('&lt;mark&gt;', '<mark>')
+        .replaceAll('&lt;/mark&gt;', '</mark>');
+}
+
 function clearSearch() {
     document.getElementById('searchInput').value = '';
     currentSearch = '';
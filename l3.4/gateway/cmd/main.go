@@ -8,6 +8,7 @@ import (
 	"app/pkg/broker"
 	"app/pkg/data"
 	"app/pkg/logger"
+	"app/pkg/pipeline"
 	"app/pkg/storage"
 	"context"
 	"os"
@@ -23,8 +24,9 @@ func main() {
 	data := data.New(cfg.DataConfig)
 	brk := broker.New(cfg.BrokerConfig)
 	str := storage.New(cfg.StorageConfig)
-	repo := repository.New(data, brk, str)
-	service := service.New(repo)
+	repo := repository.New(data, brk.Producer, str)
+	pipe := pipeline.New(cfg.PipelineConfig)
+	service := service.New(repo, pipe, cfg.RetryConfig)
 	server := transport.New(service, &cfg.ServerConfig, ctx)
 
 	graceCh := make(chan os.Signal, 1)
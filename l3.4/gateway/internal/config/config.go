@@ -2,9 +2,11 @@ package config
 
 import (
 	"app/internal/models"
+	"app/internal/service"
 	"app/internal/transport"
 	"app/pkg/broker"
 	"app/pkg/data"
+	"app/pkg/pipeline"
 	"app/pkg/storage"
 	"fmt"
 	"strconv"
@@ -14,10 +16,12 @@ import (
 )
 
 type Config struct {
-	ServerConfig  transport.ServerConfig
-	DataConfig    data.DataConfig
-	BrokerConfig  broker.BrokerConfig
-	StorageConfig storage.StorageConfig
+	ServerConfig   transport.ServerConfig
+	DataConfig     data.DataConfig
+	BrokerConfig   broker.BrokerConfig
+	StorageConfig  storage.StorageConfig
+	PipelineConfig pipeline.PipelineConfig
+	RetryConfig    service.RetryConfig
 }
 
 func (c *Config) valid() error {
@@ -31,6 +35,21 @@ func (c *Config) valid() error {
 	if err := validReleaseMode(c.ServerConfig.ReleaseMode); err != nil {
 		resultErr = multierr.Append(resultErr, err)
 	}
+	if err := validUploadSize(c.ServerConfig.MaxUploadSize); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if c.ServerConfig.ImageCacheMaxAge < 0 {
+		resultErr = multierr.Append(resultErr, models.ErrInvalidCacheMaxAge)
+	}
+	if err := c.StorageConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.PipelineConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.RetryConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
 	return resultErr
 }
 
@@ -52,6 +71,13 @@ func validReleaseMode(mode string) error {
 	return nil
 }
 
+func validUploadSize(size int64) error {
+	if size <= 0 {
+		return models.ErrInvalidUploadSize
+	}
+	return nil
+}
+
 func New() *Config {
 	cfg := &Config{}
 
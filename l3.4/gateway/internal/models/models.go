@@ -2,26 +2,93 @@ package models
 
 import (
 	"errors"
+	"io"
+	"time"
 )
 
 const (
-	StatusPending   = "pending"
-	StatusCompleted = "completed"
-	StatusFailed    = "failed"
-	OriginalKey     = "original"
-	WatermarkedKey  = "watermarked"
-	ThumbnailKey    = "thumbnail"
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	OriginalKey      = "original"
+	WatermarkedKey   = "watermarked"
+	ThumbnailKey     = "thumbnail"
+	// CacheKey prefixes the resized copies served by the gateway.
+	CacheKey = "cache"
 )
 
+const (
+	OpResize     = "resize"
+	OpRotate     = "rotate"
+	OpAutoOrient = "auto_orient"
+	OpBlur       = "blur"
+	OpGrayscale  = "grayscale"
+	OpWatermark  = "watermark"
+	OpFormat     = "format"
+)
+
+const (
+	MaxVariants        = 10
+	MaxOperations      = 20
+	MaxDimension       = 10000
+	MaxBlurSigma       = 50
+	MaxWatermarkText   = 100
+	DefaultJpegQuality = 85
+	// ImageHeaderLen is how much of an upload is read to check its format
+	// and dimensions before it is stored.
+	ImageHeaderLen = 64 << 10
+)
+
+// Frames of an animated gif a variant keeps.
+const (
+	FramesAll   = "all"
+	FramesFirst = "first"
+)
+
+// Image is an upload being streamed to storage with the variants to make of
+// it: named presets from the config and variants described by the client.
 type Image struct {
-	Raw  []byte `json:"raw"`
-	Ext  string `json:"extension"`
-	Size int    `json:"size"`
+	Body     io.Reader
+	Presets  []string
+	Variants map[string][]Operation
+}
+
+// Operation is one step of a variant pipeline. Op selects the step, the other
+// fields are its parameters:
+//
+//	resize      width, height, mode fit (default), fill or crop
+//	rotate      angle in degrees clockwise
+//	auto_orient turns the image upright by its EXIF orientation
+//	blur        sigma
+//	grayscale
+//	watermark   text or the configured image, position, opacity
+//	format      format jpeg, png, gif, webp, bmp or tiff, quality for jpeg,
+//	            frames all (default) or first for an animated gif
+type Operation struct {
+	Op       string  `json:"op"`
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Mode     string  `json:"mode,omitempty"`
+	Angle    float64 `json:"angle,omitempty"`
+	Sigma    float64 `json:"sigma,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	Position string  `json:"position,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty"`
+	Format   string  `json:"format,omitempty"`
+	Quality  int     `json:"quality,omitempty"`
+	Frames   string  `json:"frames,omitempty"`
 }
 
+// KafkaTask is a claim check: the original is already in storage under Key
+// and the processor fetches it from there. Every variant is stored under
+// its name next to the original.
 type KafkaTask struct {
-	ID string `json:"id"`
-	Image
+	ID          string                 `json:"id"`
+	Key         string                 `json:"key"`
+	ContentType string                 `json:"content_type"`
+	Size        int64                  `json:"size"`
+	Variants    map[string][]Operation `json:"variants"`
 }
 
 type PostgresTask struct {
@@ -31,9 +98,43 @@ type PostgresTask struct {
 }
 
 type GetImageResponse struct {
-	OriginalUrl    string `json:"original_url,omitempty"`
-	WatermarkedUrl string `json:"watermarked_url,omitempty"`
-	ThumbnailUrl   string `json:"thumbnail_url,omitempty"`
+	Status      string            `json:"status"`
+	OriginalUrl string            `json:"original_url,omitempty"`
+	Variants    map[string]string `json:"variants,omitempty"`
+}
+
+// TaskInfo is the processing state of a task for the admin api.
+type TaskInfo struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ServeImageRequest asks for a stored image, resized to fit Width x Height
+// when either is set.
+type ServeImageRequest struct {
+	ID      string
+	Variant string
+	Width   int
+	Height  int
+}
+
+// ImageObject is a stored image being served. Body can seek, so it can be
+// served in ranges.
+type ImageObject struct {
+	Body        io.ReadSeekCloser
+	ContentType string
+	ETag        string
+	Size        int64
+	ModTime     time.Time
+}
+
+// ResizedImage is an encoded resized copy of an image.
+type ResizedImage struct {
+	Raw         []byte
+	ContentType string
 }
 
 type UploadImageResponse struct {
@@ -43,13 +144,29 @@ type UploadImageResponse struct {
 var (
 	ErrInvalidReleaseMode = errors.New("invalid release mode")
 	ErrInvalidPort        = errors.New("invalid port")
+	ErrInvalidPartSize    = errors.New("invalid multipart part size")
+	ErrInvalidUploadSize  = errors.New("invalid max upload size")
+	ErrInvalidPresignTTL  = errors.New("invalid presign ttl")
+	ErrInvalidCacheMaxAge = errors.New("invalid image cache max age")
+	ErrInvalidSize        = errors.New("invalid image size")
+	ErrInvalidPresets     = errors.New("invalid presets config")
+	ErrInvalidMaxPixels   = errors.New("invalid max pixels")
+	ErrInvalidResizeStep  = errors.New("invalid resize step")
+	ErrInvalidRetryCfg    = errors.New("invalid task retry config")
+	ErrUnknownPreset      = errors.New("unknown preset")
+	ErrInvalidVariant     = errors.New("invalid variant")
+	ErrInvalidOperation   = errors.New("invalid operation")
 	ErrInvalidId          = errors.New("invalid id")
 	ErrImageNotFound      = errors.New("image not found")
 	ErrImagePending       = errors.New("image is still pending")
 	ErrImageFailed        = errors.New("image processing failed")
+	ErrImageNotFailed     = errors.New("image processing has not failed or stalled")
+	ErrCannotRetry        = errors.New("image was uploaded before retries")
 	// ErrInvalidGenre        = errors.New("invalid genre")
 	ErrInvalidImage        = errors.New("invalid image")
 	ErrInvalidImageType    = errors.New("invalid image type")
+	ErrAVIFUnsupported     = errors.New("avif images are not supported")
+	ErrTooManyPixels       = errors.New("image has too many pixels")
 	ErrInvalidStatus       = errors.New("invalid status")
 	ErrImageUploadFailed   = errors.New("failed to upload image")
 	ErrImageDeleteFailed   = errors.New("failed to delete image")
@@ -2,7 +2,6 @@ package repository
 
 import (
 	"app/internal/models"
-	"app/pkg/broker"
 	"app/pkg/data"
 	"app/pkg/storage"
 	"context"
@@ -10,32 +9,58 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/wb-go/wbf/retry"
 )
 
-type Repository struct {
-	data *data.Data
-	brk  *broker.Broker
-	str  *storage.StorageClient
+// ProducerInterface is the part of the kafka producer tasks are queued with.
+type ProducerInterface interface {
+	SendWithRetry(ctx context.Context, strategy retry.Strategy, key, value []byte) error
 }
 
-func New(data *data.Data, broker *broker.Broker, str *storage.StorageClient) *Repository {
-	return &Repository{data: data, brk: broker, str: str}
+type Repository struct {
+	data     *data.Data
+	producer ProducerInterface
+	str      *storage.StorageClient
 }
 
-func (r *Repository) UploadImage(task *models.KafkaTask) (*models.UploadImageResponse, error) {
-	ctx, canc := context.WithTimeout(context.Background(), 20*time.Second)
-	defer canc()
+func New(data *data.Data, producer ProducerInterface, str *storage.StorageClient) *Repository {
+	return &Repository{data: data, producer: producer, str: str}
+}
 
-	tx, err := r.data.DB.BeginTxWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: 2 * time.Second, Backoff: 1.5}, nil)
+// UploadImage streams the original to storage under task.Key and only then
+// queues the task, so the message carries a reference instead of the image.
+// The row is committed before the task is queued, a processor that reads the
+// message right away has to find it. The row and the original are removed
+// again if the task can not be queued. The upload is bound to ctx, a client
+// that goes away aborts it.
+func (r *Repository) UploadImage(ctx context.Context, task *models.KafkaTask, body io.Reader) (res *models.UploadImageResponse, err error) {
+	size, err := r.str.UploadStream(ctx, task.Key, body, task.ContentType)
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback()
+	task.Size = size
+
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, r.str.DeleteFile(task.Key))
+		}
+	}()
+
+	ctx, canc := context.WithTimeout(context.Background(), 20*time.Second)
+	defer canc()
 
-	if _, err = tx.ExecContext(ctx, `INSERT INTO tasks (id, status, path) VALUES ($1, 'pending', '')`, task.ID); err != nil {
+	names := make([]string, 0, len(task.Variants))
+	for name := range task.Variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	variants, err := json.Marshal(names)
+	if err != nil {
 		return nil, err
 	}
 
@@ -44,13 +69,17 @@ func (r *Repository) UploadImage(task *models.KafkaTask) (*models.UploadImageRes
 		return nil, err
 	}
 
-	err = r.brk.Producer.SendWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: 2 * time.Second, Backoff: 1.5}, []byte("tasks"), data)
-	if err != nil {
+	// path is the task id from the start, so a pending task can be deleted
+	// together with its original. The task is kept to queue it again on retry.
+	if _, err = r.data.DB.ExecWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: 2 * time.Second, Backoff: 1.5},
+		`INSERT INTO tasks (id, status, path, variants, task) VALUES ($1, 'pending', $1, $2, $3)`, task.ID, variants, data); err != nil {
 		return nil, err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return nil, err
+	err = r.producer.SendWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: 2 * time.Second, Backoff: 1.5}, []byte("tasks"), data)
+	if err != nil {
+		_, delErr := r.data.DB.ExecContext(ctx, `DELETE FROM tasks WHERE id = $1`, task.ID)
+		return nil, errors.Join(err, delErr)
 	}
 
 	return &models.UploadImageResponse{ID: task.ID}, nil
@@ -61,7 +90,8 @@ func (r *Repository) GetImage(id string) (*models.GetImageResponse, error) {
 	defer cancel()
 
 	var status, path string
-	if err := r.data.DB.QueryRowContext(ctx, `SELECT status, path FROM tasks WHERE id = $1`, id).Scan(&status, &path); err != nil {
+	var variants []string
+	if err := r.data.DB.QueryRowContext(ctx, `SELECT status, path, variants FROM tasks WHERE id = $1`, id).Scan(&status, &path, jsonValue{&variants}); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, models.ErrImageNotFound
 		}
@@ -69,34 +99,143 @@ func (r *Repository) GetImage(id string) (*models.GetImageResponse, error) {
 	}
 
 	switch status {
-	case models.StatusPending:
+	case models.StatusPending, models.StatusProcessing:
 		return nil, models.ErrImagePending
 	case models.StatusFailed:
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+		// the task stays for a retry, deleting it is up to the client. The
+		// reason may carry internal details, only GetTask shows it
+		return nil, models.ErrImageFailed
+	case models.StatusCompleted:
+		res := &models.GetImageResponse{
+			Status:   status,
+			Variants: make(map[string]string, len(variants)),
+		}
+		url, err := r.str.PresignedURL(ctx, fmt.Sprintf("%s/%s", models.OriginalKey, path))
+		if err != nil {
+			return nil, err
+		}
+		res.OriginalUrl = url
+		for _, name := range variants {
+			if res.Variants[name], err = r.str.PresignedURL(ctx, fmt.Sprintf("%s/%s", name, path)); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	default:
+		return nil, models.ErrInvalidStatus
+	}
+}
 
-		if _, err := r.data.DB.Master.ExecContext(ctx, `DELETE FROM tasks WHERE id = $1`, id); err != nil {
-			return nil, errors.Join(models.ErrImageFailed, err)
+// GetVariantPath returns the storage key of a variant of a completed image,
+// the original included.
+func (r *Repository) GetVariantPath(id, variant string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var status, path string
+	var variants []string
+	if err := r.data.DB.QueryRowContext(ctx, `SELECT status, path, variants FROM tasks WHERE id = $1`, id).Scan(&status, &path, jsonValue{&variants}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrImageNotFound
 		}
+		return "", err
+	}
 
-		return nil, models.ErrImageFailed
+	switch status {
+	case models.StatusPending, models.StatusProcessing:
+		return "", models.ErrImagePending
+	case models.StatusFailed:
+		return "", models.ErrImageFailed
 	case models.StatusCompleted:
-		return &models.GetImageResponse{
-			OriginalUrl:    fmt.Sprintf("http://%s/%s/%s/%s", r.str.ClientEndpoint, r.str.BucketName, models.OriginalKey, path),
-			WatermarkedUrl: fmt.Sprintf("http://%s/%s/%s/%s", r.str.ClientEndpoint, r.str.BucketName, models.WatermarkedKey, path),
-			ThumbnailUrl:   fmt.Sprintf("http://%s/%s/%s/%s", r.str.ClientEndpoint, r.str.BucketName, models.ThumbnailKey, path),
-		}, nil
+		if variant != models.OriginalKey && !slices.Contains(variants, variant) {
+			return "", fmt.Errorf("%w: no variant %s", models.ErrImageNotFound, variant)
+		}
+		return fmt.Sprintf("%s/%s", variant, path), nil
 	default:
-		return nil, models.ErrInvalidStatus
+		return "", models.ErrInvalidStatus
 	}
 }
 
+// OpenImage opens a stored object, models.ErrImageNotFound when there is none.
+func (r *Repository) OpenImage(ctx context.Context, key string) (*models.ImageObject, error) {
+	return r.str.OpenFile(ctx, key)
+}
+
+// SaveImage stores a generated image under key.
+func (r *Repository) SaveImage(ctx context.Context, key string, img *models.ResizedImage) error {
+	return r.str.UploadFile(ctx, key, img.Raw, img.ContentType)
+}
+
+// GetTask reads the processing state of a task, including the last error.
+func (r *Repository) GetTask(id string) (*models.TaskInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	info := &models.TaskInfo{ID: id}
+	if err := r.data.DB.QueryRowContext(ctx, `SELECT status, attempts, error, updated_at FROM tasks WHERE id = $1`, id).
+		Scan(&info.Status, &info.Attempts, &info.Error, &info.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrImageNotFound
+		}
+		return nil, err
+	}
+	return info, nil
+}
+
+// RetryImage queues a failed task, or one whose processor lease ran out, again
+// with its attempts reset. The task is pending before it is queued, so the
+// processor can take it, and is failed again if it can not be queued.
+func (r *Repository) RetryImage(id string, lease time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	tx, err := r.data.DB.BeginTxWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: 2 * time.Second, Backoff: 1.5}, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var task []byte
+	var stalled bool
+	if err := tx.QueryRowContext(ctx, `SELECT status, task, updated_at < NOW() - make_interval(secs => $2) FROM tasks WHERE id = $1 FOR UPDATE`,
+		id, lease.Seconds()).Scan(&status, &task, &stalled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrImageNotFound
+		}
+		return err
+	}
+	// a processor that crashed leaves the task processing, its lease has to run out first
+	if status != models.StatusFailed && (status != models.StatusProcessing || !stalled) {
+		return models.ErrImageNotFailed
+	}
+	if task == nil {
+		return models.ErrCannotRetry
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET status = 'pending', attempts = 0, error = '', updated_at = NOW() WHERE id = $1`, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := r.producer.SendWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: 2 * time.Second, Backoff: 1.5}, []byte("tasks"), task); err != nil {
+		_, failErr := r.data.DB.ExecContext(ctx, `UPDATE tasks SET status = 'failed', error = $2, updated_at = NOW() WHERE id = $1 AND status = 'pending'`,
+			id, "failed to queue the task: "+err.Error())
+		return errors.Join(err, failErr)
+	}
+	return nil
+}
+
 func (r *Repository) DeleteImage(id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var status, path string
-	if err := r.data.DB.QueryRowContext(ctx, `SELECT status, path FROM tasks WHERE id = $1`, id).Scan(&status, &path); err != nil {
+	var variants []string
+	if err := r.data.DB.QueryRowContext(ctx, `SELECT status, path, variants FROM tasks WHERE id = $1`, id).Scan(&status, &path, jsonValue{&variants}); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.ErrImageNotFound
 		}
@@ -115,11 +254,14 @@ func (r *Repository) DeleteImage(id string) error {
 		return err
 	}
 
-	for _, v := range []string{models.OriginalKey, models.WatermarkedKey, models.ThumbnailKey} {
+	for _, v := range append([]string{models.OriginalKey}, variants...) {
 		if err := r.DeleteImageByType(path, v); err != nil {
 			return err
 		}
 	}
+	if err := r.str.DeletePrefix(fmt.Sprintf("%s/%s/", models.CacheKey, path)); err != nil {
+		return err
+	}
 
 	err = retry.DoContext(ctx, retry.Strategy{Attempts: 3, Delay: 1, Backoff: 1}, tx.Commit)
 	if err != nil {
@@ -140,3 +282,21 @@ func (r *Repository) DeleteImageByType(path string, genre string) error {
 	}
 	return nil
 }
+
+// jsonValue scans a json column into v.
+type jsonValue struct {
+	v interface{}
+}
+
+func (j jsonValue) Scan(src interface{}) error {
+	var data []byte
+	switch src := src.(type) {
+	case []byte:
+		data = src
+	case string:
+		data = []byte(src)
+	default:
+		return fmt.Errorf("unexpected json column type %T", src)
+	}
+	return json.Unmarshal(data, j.v)
+}
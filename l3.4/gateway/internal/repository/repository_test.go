@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"app/internal/models"
+	"app/pkg/data"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/retry"
+)
+
+// fakeProducer records the queued tasks. onSend stands in for a processor that
+// reads the task as soon as it is queued.
+type fakeProducer struct {
+	sent   [][]byte
+	err    error
+	onSend func()
+}
+
+func (p *fakeProducer) SendWithRetry(_ context.Context, _ retry.Strategy, _, value []byte) error {
+	if p.onSend != nil {
+		p.onSend()
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.sent = append(p.sent, value)
+	return nil
+}
+
+func newMockRepo(t *testing.T, producer *fakeProducer) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return New(&data.Data{DB: &dbpg.DB{Master: db}}, producer, nil), mock
+}
+
+func TestRetryImage(t *testing.T) {
+	task := []byte(`{"id":"id"}`)
+	errBroker := errors.New("broker down")
+
+	tests := []struct {
+		name    string
+		status  string
+		task    []byte
+		stalled bool
+		sendErr error
+		err     error
+	}{
+		{"failed", models.StatusFailed, task, false, nil, nil},
+		{"processing past the lease", models.StatusProcessing, task, true, nil, nil},
+		{"processing within the lease", models.StatusProcessing, task, false, nil, models.ErrImageNotFailed},
+		{"completed", models.StatusCompleted, task, true, nil, models.ErrImageNotFailed},
+		{"uploaded before retries", models.StatusFailed, nil, false, nil, models.ErrCannotRetry},
+		{"broker down", models.StatusFailed, task, false, errBroker, errBroker},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer := &fakeProducer{err: tt.sendErr}
+			repo, mock := newMockRepo(t, producer)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT status, task, updated_at < NOW\(\) - make_interval\(secs => \$2\) FROM tasks WHERE id = \$1 FOR UPDATE`).
+				WithArgs("id", float64(300)).
+				WillReturnRows(sqlmock.NewRows([]string{"status", "task", "stalled"}).AddRow(tt.status, tt.task, tt.stalled))
+			if tt.err == nil || tt.sendErr != nil {
+				mock.ExpectExec(`UPDATE tasks SET status = 'pending', attempts = 0, error = ''`).
+					WithArgs("id").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				// the processor has to see the pending task when it reads the message
+				producer.onSend = func() {
+					if err := mock.ExpectationsWereMet(); err != nil {
+						t.Errorf("expected the task to be committed before it is queued: %v", err)
+					}
+					// a task that can't be queued is failed again, so it can be retried
+					if tt.sendErr != nil {
+						mock.ExpectExec(`UPDATE tasks SET status = 'failed', error = \$2, updated_at = NOW\(\) WHERE id = \$1 AND status = 'pending'`).
+							WithArgs("id", "failed to queue the task: "+tt.sendErr.Error()).
+							WillReturnResult(sqlmock.NewResult(0, 1))
+					}
+				}
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err := repo.RetryImage("id", 5*time.Minute)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got: %v", tt.err, err)
+				}
+				if len(producer.sent) != 0 {
+					t.Fatal("expected a rejected retry not to queue the task")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(producer.sent) != 1 || string(producer.sent[0]) != string(task) {
+				t.Fatalf("expected the stored task to be queued, got: %q", producer.sent)
+			}
+		})
+	}
+}
+
+func TestRetryImageNotFound(t *testing.T) {
+	repo, mock := newMockRepo(t, &fakeProducer{})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT status, task`).WillReturnRows(sqlmock.NewRows([]string{"status", "task", "stalled"}))
+	mock.ExpectRollback()
+
+	if err := repo.RetryImage("id", time.Minute); !errors.Is(err, models.ErrImageNotFound) {
+		t.Fatalf("expected ErrImageNotFound, got: %v", err)
+	}
+}
+
+func TestGetImageHidesFailureReason(t *testing.T) {
+	repo, mock := newMockRepo(t, &fakeProducer{})
+
+	mock.ExpectQuery(`SELECT status, path, variants FROM tasks WHERE id = \$1`).
+		WithArgs("id").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "path", "variants"}).AddRow(models.StatusFailed, "id.jpg", []byte(`["thumbnail"]`)))
+
+	_, err := repo.GetImage("id")
+	if err != models.ErrImageFailed {
+		t.Fatalf("expected only ErrImageFailed, got: %v", err)
+	}
+}
+
+func TestGetTask(t *testing.T) {
+	repo, mock := newMockRepo(t, &fakeProducer{})
+	updatedAt := time.Now().Truncate(time.Second)
+
+	mock.ExpectQuery(`SELECT status, attempts, error, updated_at FROM tasks WHERE id = \$1`).
+		WithArgs("id").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "attempts", "error", "updated_at"}).
+			AddRow(models.StatusFailed, 5, "minio: connection refused", updatedAt))
+
+	info, err := repo.GetTask("id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Status != models.StatusFailed || info.Attempts != 5 || info.Error != "minio: connection refused" || !info.UpdatedAt.Equal(updatedAt) {
+		t.Fatalf("expected the failed task with its error, got: %+v", info)
+	}
+}
@@ -2,38 +2,88 @@ package service
 
 import (
 	"app/internal/models"
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 )
 
-var genres = map[string]bool{
-	models.OriginalKey:    true,
-	models.WatermarkedKey: true,
-	models.ThumbnailKey:   true,
-}
-
 type RepositoryInterface interface {
-	UploadImage(*models.KafkaTask) (*models.UploadImageResponse, error)
+	UploadImage(context.Context, *models.KafkaTask, io.Reader) (*models.UploadImageResponse, error)
 	GetImage(string) (*models.GetImageResponse, error)
+	GetTask(string) (*models.TaskInfo, error)
 	DeleteImage(string) error
+	RetryImage(id string, lease time.Duration) error
+	GetVariantPath(id, variant string) (string, error)
+	OpenImage(ctx context.Context, key string) (*models.ImageObject, error)
+	SaveImage(ctx context.Context, key string, img *models.ResizedImage) error
+}
+
+type PipelineInterface interface {
+	Resolve(presets []string, variants map[string][]models.Operation) (map[string][]models.Operation, error)
+	Inspect(head []byte, whole bool) (string, error)
+	Size(r io.Reader, width, height int) (int, int, error)
+	Resize(raw []byte, width, height int) (*models.ResizedImage, error)
+}
+
+// RetryConfig mirrors the task lease of the processor.
+type RetryConfig struct {
+	// Lease is how long a processor keeps a task it took. A task processing
+	// for longer is abandoned and an admin may retry it.
+	Lease time.Duration `env:"TASK_LEASE" env-default:"5m"`
+}
+
+func (cfg RetryConfig) Valid() error {
+	if cfg.Lease <= 0 {
+		return models.ErrInvalidRetryCfg
+	}
+	return nil
 }
 
 type Service struct {
-	repo RepositoryInterface
-	vld  *validator.Validate
+	repo     RepositoryInterface
+	pipeline PipelineInterface
+	vld      *validator.Validate
+	cfg      RetryConfig
 }
 
-func New(repo RepositoryInterface) *Service {
-	return &Service{repo, validator.New(validator.WithRequiredStructEnabled())}
+func New(repo RepositoryInterface, pipeline PipelineInterface, cfg RetryConfig) *Service {
+	return &Service{repo, pipeline, validator.New(validator.WithRequiredStructEnabled()), cfg}
 }
 
-func (s Service) UploadImage(img *models.Image) (*models.UploadImageResponse, error) {
-	return s.repo.UploadImage(&models.KafkaTask{
-		ID:    uuid.NewString(),
-		Image: *img,
-	})
+// UploadImage checks the requested variants and the image header before
+// anything is stored, so a bad pipeline or image is rejected without
+// uploading it.
+func (s Service) UploadImage(ctx context.Context, img *models.Image) (*models.UploadImageResponse, error) {
+	variants, err := s.pipeline.Resolve(img.Presets, img.Variants)
+	if err != nil {
+		return nil, err
+	}
+
+	body := bufio.NewReaderSize(img.Body, models.ImageHeaderLen)
+	head, err := body.Peek(models.ImageHeaderLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	contentType, err := s.pipeline.Inspect(head, err == io.EOF)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.NewString()
+	return s.repo.UploadImage(ctx, &models.KafkaTask{
+		ID:          id,
+		Key:         fmt.Sprintf("%s/%s", models.OriginalKey, id),
+		ContentType: contentType,
+		Variants:    variants,
+	}, body)
 }
 
 func (s Service) GetImage(id string) (*models.GetImageResponse, error) {
@@ -52,8 +102,84 @@ func (s Service) DeleteImage(id string) error {
 	return s.repo.DeleteImage(id)
 }
 
-func isValidGenre(genre string) bool {
-	return genres[genre]
+// GetTask shows the processing state of an image with the error it failed with.
+func (s Service) GetTask(id string) (*models.TaskInfo, error) {
+	if err := isValidId(id); err != nil {
+		return nil, errors.Join(models.ErrInvalidId, err)
+	}
+
+	return s.repo.GetTask(id)
+}
+
+// RetryImage queues a failed image, or one stuck in processing past the
+// lease, for processing again.
+func (s Service) RetryImage(id string) error {
+	if err := isValidId(id); err != nil {
+		return errors.Join(models.ErrInvalidId, err)
+	}
+
+	return s.repo.RetryImage(id, s.cfg.Lease)
+}
+
+// ServeImage opens a variant of an image for serving. A resized copy is made
+// on the first request and kept in storage under the cache prefix, so later
+// requests are served from there. The size is rounded and clamped to the
+// image before it names the copy, so there are only so many copies of it.
+func (s Service) ServeImage(ctx context.Context, req models.ServeImageRequest) (*models.ImageObject, error) {
+	if err := isValidId(req.ID); err != nil {
+		return nil, errors.Join(models.ErrInvalidId, err)
+	}
+	if req.Width < 0 || req.Height < 0 || req.Width > models.MaxDimension || req.Height > models.MaxDimension {
+		return nil, fmt.Errorf("%w: width and height must be within 0..%d", models.ErrInvalidSize, models.MaxDimension)
+	}
+
+	key, err := s.repo.GetVariantPath(req.ID, req.Variant)
+	if err != nil {
+		return nil, err
+	}
+	if req.Width == 0 && req.Height == 0 {
+		return s.repo.OpenImage(ctx, key)
+	}
+
+	src, err := s.repo.OpenImage(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	// the header read for the size is kept for the resize on a miss
+	head := new(bytes.Buffer)
+	width, height, err := s.pipeline.Size(io.TeeReader(src.Body, head), req.Width, req.Height)
+	if err != nil {
+		src.Body.Close()
+		return nil, err
+	}
+	if width == 0 {
+		// the copy would be the image itself
+		src.Body.Close()
+		return s.repo.OpenImage(ctx, key)
+	}
+
+	cacheKey := fmt.Sprintf("%s/%s/%s-%dx%d", models.CacheKey, path.Base(key), req.Variant, width, height)
+	img, err := s.repo.OpenImage(ctx, cacheKey)
+	if !errors.Is(err, models.ErrImageNotFound) {
+		src.Body.Close()
+		return img, err
+	}
+
+	raw, err := io.ReadAll(io.MultiReader(head, src.Body))
+	src.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	resized, err := s.pipeline.Resize(raw, width, height)
+	if err != nil {
+		return nil, err
+	}
+	// concurrent first requests store the same copy, the last one wins
+	if err := s.repo.SaveImage(ctx, cacheKey, resized); err != nil {
+		return nil, err
+	}
+	return s.repo.OpenImage(ctx, cacheKey)
 }
 
 func isValidId(id string) error {
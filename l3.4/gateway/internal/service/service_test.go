@@ -0,0 +1,153 @@
+package service
+
+import (
+	"app/internal/models"
+	"app/pkg/pipeline"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// fakeRepo keeps stored objects in memory and serves the variants of a single
+// completed image.
+type fakeRepo struct {
+	RepositoryInterface
+
+	variants map[string]bool
+	objects  map[string][]byte
+	saved    []string
+}
+
+func (r *fakeRepo) GetVariantPath(id, variant string) (string, error) {
+	if variant != models.OriginalKey && !r.variants[variant] {
+		return "", fmt.Errorf("%w: no variant %s", models.ErrImageNotFound, variant)
+	}
+	return fmt.Sprintf("%s/%s", variant, id), nil
+}
+
+func (r *fakeRepo) OpenImage(_ context.Context, key string) (*models.ImageObject, error) {
+	raw, ok := r.objects[key]
+	if !ok {
+		return nil, models.ErrImageNotFound
+	}
+	return &models.ImageObject{Body: nopCloser{bytes.NewReader(raw)}, ContentType: "image/png", ETag: key, Size: int64(len(raw))}, nil
+}
+
+func (r *fakeRepo) SaveImage(_ context.Context, key string, img *models.ResizedImage) error {
+	r.objects[key] = img.Raw
+	r.saved = append(r.saved, key)
+	return nil
+}
+
+// countingPipeline counts the resizes the service asks for.
+type countingPipeline struct {
+	*pipeline.Pipeline
+	resized int
+}
+
+func (p *countingPipeline) Resize(raw []byte, width, height int) (*models.ResizedImage, error) {
+	p.resized++
+	return p.Pipeline.Resize(raw, width, height)
+}
+
+func newTestService(t *testing.T) (*Service, *fakeRepo, *countingPipeline, string) {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewNRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	id := uuid.NewString()
+	repo := &fakeRepo{
+		variants: map[string]bool{models.ThumbnailKey: true},
+		objects:  map[string][]byte{fmt.Sprintf("%s/%s", models.OriginalKey, id): buf.Bytes()},
+	}
+	pipe := &countingPipeline{Pipeline: pipeline.New(pipeline.PipelineConfig{
+		DefaultPresets: []string{models.ThumbnailKey},
+		MaxPixels:      1000000,
+		ResizeStep:     100,
+	})}
+	return New(repo, pipe, RetryConfig{}), repo, pipe, id
+}
+
+func TestServeImageCachesResizedCopy(t *testing.T) {
+	s, repo, pipe, id := newTestService(t)
+	want := fmt.Sprintf("%s/%s/%s-200x200", models.CacheKey, id, models.OriginalKey)
+
+	// both sizes round to the same copy
+	for _, width := range []int{120, 180} {
+		img, err := s.ServeImage(context.Background(), models.ServeImageRequest{ID: id, Variant: models.OriginalKey, Width: width})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if img.ETag != want {
+			t.Fatalf("expected the copy %s, got %s", want, img.ETag)
+		}
+		cfg, err := png.DecodeConfig(img.Body)
+		if err != nil {
+			t.Fatalf("failed to decode the copy: %v", err)
+		}
+		if cfg.Width != 200 || cfg.Height != 100 {
+			t.Fatalf("expected a 200x100 copy, got %dx%d", cfg.Width, cfg.Height)
+		}
+	}
+
+	if pipe.resized != 1 || len(repo.saved) != 1 {
+		t.Fatalf("expected one resize and one stored copy, got %d and %v", pipe.resized, repo.saved)
+	}
+}
+
+func TestServeImageClampsToTheImage(t *testing.T) {
+	s, repo, pipe, id := newTestService(t)
+
+	img, err := s.ServeImage(context.Background(), models.ServeImageRequest{ID: id, Variant: models.OriginalKey, Width: 9999, Height: 9999})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if img.ETag != fmt.Sprintf("%s/%s", models.OriginalKey, id) {
+		t.Fatalf("expected the original itself, got %s", img.ETag)
+	}
+	if pipe.resized != 0 || len(repo.saved) != 0 {
+		t.Fatalf("expected no copy, got %d resizes and %v", pipe.resized, repo.saved)
+	}
+}
+
+func TestServeImageErrors(t *testing.T) {
+	s, _, _, id := newTestService(t)
+
+	tests := []struct {
+		name string
+		req  models.ServeImageRequest
+		err  error
+	}{
+		{"unknown variant", models.ServeImageRequest{ID: id, Variant: "huge"}, models.ErrImageNotFound},
+		{"bad id", models.ServeImageRequest{ID: "42", Variant: models.OriginalKey}, models.ErrInvalidId},
+		{"too wide", models.ServeImageRequest{ID: id, Variant: models.OriginalKey, Width: models.MaxDimension + 1}, models.ErrInvalidSize},
+		// the thumbnail is known but isn't stored yet
+		{"missing object", models.ServeImageRequest{ID: id, Variant: models.ThumbnailKey, Width: 10}, models.ErrImageNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := s.ServeImage(context.Background(), tt.req)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if img != nil {
+				t.Fatal("expected no image")
+			}
+		})
+	}
+}
@@ -4,25 +4,31 @@ import (
 	"app/internal/models"
 	"app/pkg/logger"
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/wb-go/wbf/ginext"
 )
 
-var validTypes = map[string]bool{
-	"image/jpeg": true,
-	// "image/png":  true,
-	// "image/gif":  true,
-	// "image/webp": true,
-}
+// maxFieldSize limits the plain form fields, like the variants json.
+const maxFieldSize = 64 << 10
 
 type handlers struct {
-	ctx     context.Context
-	service ServiceInterface
+	ctx           context.Context
+	service       ServiceInterface
+	maxUploadSize int64
+	adminToken    string
+	cacheMaxAge   time.Duration
 }
 
 func (h *handlers) middleware(c *ginext.Context) {
@@ -35,35 +41,72 @@ func (h *handlers) middleware(c *ginext.Context) {
 	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), logger.LoggerKey, lgWithReqId))
 }
 
+// admin lets through requests with the admin token as a bearer token. The
+// admin api is off without a configured token.
+func (h *handlers) admin(c *ginext.Context) {
+	if h.adminToken == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, ginext.H{"error": "admin api is disabled"})
+		return
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, ginext.H{"error": "invalid admin token"})
+		return
+	}
+}
+
 func (h *handlers) UploadImage(c *ginext.Context) {
 	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
 
-	file, _, err := c.Request.FormFile("image")
+	// the form is read part by part instead of being parsed up front, so the
+	// file goes to storage as it arrives
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadSize)
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
 		lg.Error().Err(err).Send()
 		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file from form"})
 		return
 	}
-	defer file.Close()
 
-	data, err := io.ReadAll(file)
+	file, fields, err := formFile(reader, "image")
 	if err != nil {
 		lg.Error().Err(err).Send()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file from form"})
 		return
 	}
+	defer file.Close()
 
-	ext := http.DetectContentType(data)
-
-	if !isValidImageType(ext) {
-		lg.Error().Err(models.ErrInvalidImageType).Send()
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid image type"})
-		return
+	img := &models.Image{}
+	for _, name := range strings.Split(fields["presets"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			img.Presets = append(img.Presets, name)
+		}
+	}
+	if variants := fields["variants"]; variants != "" {
+		if err := json.Unmarshal([]byte(variants), &img.Variants); err != nil {
+			lg.Error().Err(err).Send()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid variants"})
+			return
+		}
 	}
 
-	res, err := h.service.UploadImage(&models.Image{Raw: data, Ext: ext, Size: len(data)})
+	img.Body = file
+	res, err := h.service.UploadImage(c.Request.Context(), img)
 	if err != nil {
 		lg.Error().Err(err).Send()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, ginext.H{"error": "image is too large"})
+			return
+		}
+		if errors.Is(err, models.ErrTooManyPixels) {
+			c.JSON(http.StatusRequestEntityTooLarge, ginext.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrAVIFUnsupported) {
+			c.JSON(http.StatusUnsupportedMediaType, ginext.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, ginext.H{"error": err.Error()})
 		return
 	}
@@ -80,13 +123,17 @@ func (h *handlers) GetImage(c *ginext.Context) {
 	data, err := h.service.GetImage(id)
 	if err != nil {
 		lg.Error().Err(err).Send()
-		if errors.Is(err, models.ErrImagePending) {
+		switch {
+		case errors.Is(err, models.ErrImagePending):
 			c.JSON(http.StatusNotFound, ginext.H{"error": "pending"})
-			return
-		} else {
-			c.JSON(http.StatusNotFound, ginext.H{"error": err.Error()})
-			return
+		case errors.Is(err, models.ErrInvalidId), errors.Is(err, models.ErrImageNotFound):
+			c.JSON(http.StatusNotFound, ginext.H{"error": models.ErrImageNotFound.Error()})
+		case errors.Is(err, models.ErrImageFailed):
+			c.JSON(http.StatusNotFound, ginext.H{"error": models.ErrImageFailed.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, ginext.H{"error": "internal error"})
 		}
+		return
 	}
 
 	c.JSON(http.StatusOK, data)
@@ -109,6 +156,113 @@ func (h *handlers) DeleteImage(c *ginext.Context) {
 	lg.Debug().Str("id", id).Msg("image deleted successfully")
 }
 
-func isValidImageType(contentType string) bool {
-	return validTypes[contentType]
+// GetTask shows an admin the processing state of an image, including the
+// error that is hidden from GetImage.
+func (h *handlers) GetTask(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	id := c.Param("id")
+
+	info, err := h.service.GetTask(id)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, models.ErrInvalidId):
+			status = http.StatusBadRequest
+		case errors.Is(err, models.ErrImageNotFound):
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
+
+func (h *handlers) RetryImage(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	id := c.Param("id")
+
+	if err := h.service.RetryImage(id); err != nil {
+		lg.Error().Err(err).Send()
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, models.ErrInvalidId):
+			status = http.StatusBadRequest
+		case errors.Is(err, models.ErrImageNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, models.ErrImageNotFailed), errors.Is(err, models.ErrCannotRetry):
+			status = http.StatusConflict
+		}
+		c.JSON(status, ginext.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, models.UploadImageResponse{ID: id})
+	lg.Debug().Str("id", id).Msg("image queued for retry")
+}
+
+// ServeImage streams a variant of an image, resized to fit the w and h query
+// parameters when given. Stored objects never change, so they are cached by
+// clients and Range and conditional requests are answered from the ETag.
+func (h *handlers) ServeImage(c *ginext.Context) {
+	lg := logger.LoggerFromCtx(c.Request.Context()).Lg
+
+	req := models.ServeImageRequest{ID: c.Param("id"), Variant: c.Param("variant")}
+	for param, dst := range map[string]*int{"w": &req.Width, "h": &req.Height} {
+		if value := c.Query(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, ginext.H{"error": fmt.Sprintf("invalid %s", param)})
+				return
+			}
+			*dst = n
+		}
+	}
+
+	img, err := h.service.ServeImage(c.Request.Context(), req)
+	if err != nil {
+		lg.Error().Err(err).Send()
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, models.ErrInvalidId), errors.Is(err, models.ErrInvalidSize):
+			status = http.StatusBadRequest
+		case errors.Is(err, models.ErrImageNotFound), errors.Is(err, models.ErrImagePending), errors.Is(err, models.ErrImageFailed):
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ginext.H{"error": err.Error()})
+		return
+	}
+	defer img.Body.Close()
+
+	c.Header("Content-Type", img.ContentType)
+	c.Header("ETag", strconv.Quote(img.ETag))
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(h.cacheMaxAge.Seconds())))
+	http.ServeContent(c.Writer, c.Request, "", img.ModTime, img.Body)
+	lg.Debug().Str("id", req.ID).Str("variant", req.Variant).Msg("image streamed")
+}
+
+// formFile skips to the file part with the given form name and returns it
+// with the plain fields sent before it. Fields after the file are not read.
+func formFile(reader *multipart.Reader, name string) (*multipart.Part, map[string]string, error) {
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, nil, err
+		}
+		if part.FormName() == name && part.FileName() != "" {
+			return part, fields, nil
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldSize))
+			if err != nil {
+				return nil, nil, err
+			}
+			fields[part.FormName()] = string(value)
+		}
+		part.Close()
+	}
 }
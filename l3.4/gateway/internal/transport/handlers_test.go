@@ -0,0 +1,97 @@
+package transport
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// fakeService serves body as every variant but the unknown one.
+type fakeService struct {
+	ServiceInterface
+
+	body []byte
+}
+
+func (s *fakeService) ServeImage(_ context.Context, req models.ServeImageRequest) (*models.ImageObject, error) {
+	if req.Variant == "unknown" {
+		return nil, fmt.Errorf("%w: no variant %s", models.ErrImageNotFound, req.Variant)
+	}
+	return &models.ImageObject{
+		Body:        nopCloser{bytes.NewReader(s.body)},
+		ContentType: "image/png",
+		ETag:        "abc",
+		Size:        int64(len(s.body)),
+		ModTime:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func newTestHandler(proxy bool) http.Handler {
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, logger.New())
+	cfg := &ServerConfig{MaxUploadSize: 1 << 20, ImageProxy: proxy, ImageCacheMaxAge: time.Hour}
+	return New(&fakeService{body: []byte("0123456789abcdef")}, cfg, ctx).httpServer.Handler
+}
+
+func TestServeImage(t *testing.T) {
+	url := fmt.Sprintf("/api/v1/image/%s/thumbnail", uuid.NewString())
+
+	tests := []struct {
+		name    string
+		url     string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{"whole image", url, nil, http.StatusOK, "0123456789abcdef"},
+		{"range", url, map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, "2345"},
+		{"not modified", url, map[string]string{"If-None-Match": `"abc"`}, http.StatusNotModified, ""},
+		{"changed", url, map[string]string{"If-None-Match": `"old"`}, http.StatusOK, "0123456789abcdef"},
+		{"unknown variant", fmt.Sprintf("/api/v1/image/%s/unknown", uuid.NewString()), nil, http.StatusNotFound, ""},
+		{"bad width", url + "?w=wide", nil, http.StatusBadRequest, ""},
+	}
+
+	handler := newTestHandler(true)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Fatalf("expected body %q, got %q", tt.body, rec.Body)
+			}
+			if rec.Code == http.StatusOK && (rec.Header().Get("ETag") != `"abc"` || rec.Header().Get("Cache-Control") != "public, max-age=3600, immutable") {
+				t.Fatalf("expected the etag and cache headers, got %v", rec.Header())
+			}
+		})
+	}
+}
+
+func TestServeImageOffByDefault(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestHandler(false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/v1/image/%s/thumbnail", uuid.NewString()), nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without the proxy, got %d", rec.Code)
+	}
+}
@@ -16,9 +16,12 @@ const (
 )
 
 type ServiceInterface interface {
-	UploadImage(*models.Image) (*models.UploadImageResponse, error)
+	UploadImage(context.Context, *models.Image) (*models.UploadImageResponse, error)
 	DeleteImage(string) error
 	GetImage(string) (*models.GetImageResponse, error)
+	GetTask(string) (*models.TaskInfo, error)
+	RetryImage(string) error
+	ServeImage(context.Context, models.ServeImageRequest) (*models.ImageObject, error)
 }
 
 type ServerConfig struct {
@@ -26,6 +29,14 @@ type ServerConfig struct {
 	Port        string `env:"SERVER_PORT" env-default:"8080"`
 	ReleaseMode string `env:"RELEASE_MODE" env-default:""`
 	LogLevel    int    `env:"LOG_LEVEL" env-default:"1"`
+	// MaxUploadSize limits the whole upload request in bytes.
+	MaxUploadSize int64 `env:"MAX_UPLOAD_SIZE" env-default:"104857600"`
+	// AdminToken guards the admin api, it is off when empty.
+	AdminToken string `env:"ADMIN_TOKEN" env-default:""`
+	// ImageProxy serves the stored images through the gateway as well.
+	ImageProxy bool `env:"IMAGE_PROXY" env-default:"false"`
+	// ImageCacheMaxAge is how long clients may cache a served image.
+	ImageCacheMaxAge time.Duration `env:"IMAGE_CACHE_MAX_AGE" env-default:"24h"`
 }
 
 type Server struct {
@@ -62,7 +73,7 @@ func (s *Server) Stop() {
 func New(service ServiceInterface, serverCfg *ServerConfig, ctx context.Context) *Server {
 	// ctx = context.WithValue(ctx, logger.LoggerKey, logger.LoggerFromCtx(ctx).LoggerLevel(serverCfg.LogLevel))
 
-	hers := &handlers{ctx, service}
+	hers := &handlers{ctx, service, serverCfg.MaxUploadSize, serverCfg.AdminToken, serverCfg.ImageCacheMaxAge}
 
 	mux := ginext.New(serverCfg.ReleaseMode)
 
@@ -76,6 +87,11 @@ func New(service ServiceInterface, serverCfg *ServerConfig, ctx context.Context)
 	mux.POST("/api/v1/upload", hers.UploadImage)
 	mux.GET("/api/v1/image/:id", hers.GetImage)
 	mux.DELETE("/api/v1/image/:id", hers.DeleteImage)
+	mux.GET("/api/v1/task/:id", hers.admin, hers.GetTask)
+	mux.POST("/api/v1/image/:id/retry", hers.admin, hers.RetryImage)
+	if serverCfg.ImageProxy {
+		mux.GET("/api/v1/image/:id/:variant", hers.ServeImage)
+	}
 
 	return &Server{&http.Server{Addr: fmt.Sprintf("%s:%s", serverCfg.Host, serverCfg.Port), Handler: mux}, ctx}
 }
@@ -0,0 +1,184 @@
+package transport
+
+import (
+	"app/internal/models"
+	"app/internal/repository"
+	"app/internal/service"
+	"app/pkg/data"
+	"app/pkg/logger"
+	"app/pkg/pipeline"
+	"app/pkg/storage"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/retry"
+)
+
+// The upload fixtures are shared with the processor, whose test takes the
+// queued task from where this one leaves it.
+const (
+	uploadImage = "../../../testdata/upload.png"
+	uploadTask  = "../../../testdata/upload_task.json"
+	// uploadID stands in for the random task id in uploadTask.
+	uploadID = "00000000-0000-4000-8000-000000000000"
+)
+
+// fakeProducer records the queued tasks. onSend stands in for a processor that
+// reads the task as soon as it is queued.
+type fakeProducer struct {
+	sent   [][]byte
+	err    error
+	onSend func()
+}
+
+func (p *fakeProducer) SendWithRetry(_ context.Context, _ retry.Strategy, _, value []byte) error {
+	if p.onSend != nil {
+		p.onSend()
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.sent = append(p.sent, value)
+	return nil
+}
+
+// newUploadHandler serves the gateway over a mocked database and an in-memory
+// storage.
+func newUploadHandler(t *testing.T, producer *fakeProducer) (http.Handler, sqlmock.Sqlmock, *storage.StorageClient) {
+	t.Helper()
+	server := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(server.Close)
+	endpoint := strings.TrimPrefix(server.URL, "http://")
+	str := storage.New(storage.StorageConfig{
+		Endpoint: endpoint, ClientEndpoint: endpoint, AccessKey: "minioadmin", SecretKey: "minioadmin",
+		BucketName: "images", Region: "us-east-1", PartSize: 5 << 20, PresignTTL: time.Minute,
+	})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := repository.New(&data.Data{DB: &dbpg.DB{Master: db}}, producer, str)
+	pipe := pipeline.New(pipeline.PipelineConfig{DefaultPresets: []string{models.WatermarkedKey, models.ThumbnailKey}, MaxPixels: 1000000, ResizeStep: 100})
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, logger.New())
+	return New(service.New(repo, pipe, service.RetryConfig{Lease: time.Minute}), &ServerConfig{MaxUploadSize: 1 << 20}, ctx).httpServer.Handler, mock, str
+}
+
+func postUpload(t *testing.T, handler http.Handler, raw []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	body := new(bytes.Buffer)
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("image", "upload.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(raw)
+	form.Close()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/upload", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadQueuesTaskForProcessor(t *testing.T) {
+	raw, err := os.ReadFile(uploadImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	producer := &fakeProducer{}
+	handler, mock, str := newUploadHandler(t, producer)
+	mock.ExpectExec(`INSERT INTO tasks \(id, status, path, variants, task\) VALUES \(\$1, 'pending', \$1, \$2, \$3\)`).
+		WithArgs(sqlmock.AnyArg(), []byte(`["thumbnail","watermarked"]`), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// the processor has to find the row when it reads the task
+	producer.onSend = func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected the task to be stored before it is queued: %v", err)
+		}
+	}
+
+	rec := postUpload(t, handler, raw)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var res models.UploadImageResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the original is stored under the key the task points to
+	obj, err := str.Client.GetObject(context.Background(), "images", models.OriginalKey+"/"+res.ID, minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil || !bytes.Equal(stored, raw) {
+		t.Fatalf("expected the original to be stored as uploaded, got %d bytes and %v", len(stored), err)
+	}
+
+	if len(producer.sent) != 1 {
+		t.Fatalf("expected one queued task, got %d", len(producer.sent))
+	}
+	want, err := os.ReadFile(uploadTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := bytes.ReplaceAll(producer.sent[0], []byte(res.ID), []byte(uploadID))
+	var gotTask, wantTask any
+	if err := json.Unmarshal(got, &gotTask); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(want, &wantTask); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotTask, wantTask) {
+		t.Fatalf("expected the task in %s, got %s", uploadTask, got)
+	}
+}
+
+func TestUploadRemovesTaskWhenQueueFails(t *testing.T) {
+	raw, err := os.ReadFile(uploadImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, mock, str := newUploadHandler(t, &fakeProducer{err: errors.New("broker down")})
+	mock.ExpectExec(`INSERT INTO tasks`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM tasks WHERE id = \$1`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := postUpload(t, handler, raw)
+	if rec.Code == http.StatusAccepted {
+		t.Fatal("expected the upload to fail when the task can't be queued")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	// no original is left without a task
+	for obj := range str.Client.ListObjects(context.Background(), "images", minio.ListObjectsOptions{Recursive: true}) {
+		t.Fatalf("expected the original to be removed, found %s", obj.Key)
+	}
+}
@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"app/internal/models"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// inputFormat is an image format uploads may be in, told apart by the magic
+// bytes at the start of the file.
+type inputFormat struct {
+	contentType  string
+	magic        func(head []byte) bool
+	decodeConfig func(io.Reader) (image.Config, error)
+	decode       func(io.Reader) (image.Image, error)
+}
+
+var inputFormats = []inputFormat{
+	{"image/jpeg", prefix("\xff\xd8\xff"), jpeg.DecodeConfig, jpeg.Decode},
+	{"image/png", prefix("\x89PNG\r\n\x1a\n"), png.DecodeConfig, png.Decode},
+	{"image/gif", prefix("GIF87a", "GIF89a"), gif.DecodeConfig, gif.Decode},
+	{"image/webp", riff("WEBP"), webp.DecodeConfig, webp.Decode},
+	{"image/bmp", prefix("BM"), bmp.DecodeConfig, bmp.Decode},
+	{"image/tiff", prefix("II*\x00", "MM\x00*"), tiff.DecodeConfig, tiff.Decode},
+}
+
+func prefix(magics ...string) func([]byte) bool {
+	return func(head []byte) bool {
+		for _, magic := range magics {
+			if bytes.HasPrefix(head, []byte(magic)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func riff(kind string) func([]byte) bool {
+	return func(head []byte) bool {
+		return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == kind
+	}
+}
+
+// avif tells an AVIF file by the brand of its ftyp box. There is no pure Go
+// codec for it, so it is told apart only to say why it is refused.
+func avif(head []byte) bool {
+	return len(head) >= 12 && string(head[4:8]) == "ftyp" && (string(head[8:12]) == "avif" || string(head[8:12]) == "avis")
+}
+
+// Inspect tells the content type of an upload from head, its first bytes, and
+// checks that its dimensions are within the pixel limit. whole is set when
+// head is the entire upload. A jpeg whose header doesn't fit into head is
+// left for the processor to check.
+func (p *Pipeline) Inspect(head []byte, whole bool) (string, error) {
+	format := sniff(head)
+	if format == nil {
+		if avif(head) {
+			return "", models.ErrAVIFUnsupported
+		}
+		return "", models.ErrInvalidImageType
+	}
+
+	cfg, err := format.decodeConfig(bytes.NewReader(head))
+	if err != nil {
+		if !whole && errors.Is(err, io.ErrUnexpectedEOF) {
+			return format.contentType, nil
+		}
+		return "", errors.Join(models.ErrInvalidImage, err)
+	}
+	if err := p.validPixels(cfg); err != nil {
+		return "", err
+	}
+	return format.contentType, nil
+}
+
+func sniff(head []byte) *inputFormat {
+	for i := range inputFormats {
+		if inputFormats[i].magic(head) {
+			return &inputFormats[i]
+		}
+	}
+	return nil
+}
+
+// Size reads the dimensions of a stored image from the start of r and returns
+// the size a copy fitting width x height is made at. A side is rounded up to
+// the resize step and never goes past the image, a missing side is the
+// image's. It returns zeros when the copy would be the image itself.
+func (p *Pipeline) Size(r io.Reader, width, height int) (int, int, error) {
+	var head [12]byte
+	n, err := io.ReadFull(r, head[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, 0, errors.Join(models.ErrInvalidImage, err)
+	}
+	format := sniff(head[:n])
+	if format == nil {
+		return 0, 0, models.ErrInvalidImageType
+	}
+
+	cfg, err := format.decodeConfig(io.MultiReader(bytes.NewReader(head[:n]), r))
+	if err != nil {
+		return 0, 0, errors.Join(models.ErrInvalidImage, err)
+	}
+	if err := p.validPixels(cfg); err != nil {
+		return 0, 0, err
+	}
+
+	width, height = p.step(width, cfg.Width), p.step(height, cfg.Height)
+	if width == cfg.Width && height == cfg.Height {
+		return 0, 0, nil
+	}
+	return width, height, nil
+}
+
+func (p *Pipeline) step(side, limit int) int {
+	if side <= 0 {
+		return limit
+	}
+	return min((side+p.resizeStep-1)/p.resizeStep*p.resizeStep, limit)
+}
+
+// Resize scales a stored image down to fit width x height, keeping its aspect
+// ratio. A missing or larger side leaves that dimension as it is, an image is
+// never enlarged. Jpegs stay jpegs, everything else becomes a png, of an
+// animation only the first frame is kept.
+func (p *Pipeline) Resize(raw []byte, width, height int) (*models.ResizedImage, error) {
+	format := sniff(raw)
+	if format == nil {
+		return nil, models.ErrInvalidImageType
+	}
+
+	cfg, err := format.decodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Join(models.ErrInvalidImage, err)
+	}
+	if err := p.validPixels(cfg); err != nil {
+		return nil, err
+	}
+	img, err := format.decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Join(models.ErrInvalidImage, err)
+	}
+
+	if width <= 0 || width > cfg.Width {
+		width = cfg.Width
+	}
+	if height <= 0 || height > cfg.Height {
+		height = cfg.Height
+	}
+	img = imaging.Fit(img, width, height, imaging.Lanczos)
+
+	var buf bytes.Buffer
+	res := &models.ResizedImage{ContentType: "image/png"}
+	if format.contentType == "image/jpeg" {
+		res.ContentType = format.contentType
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: models.DefaultJpegQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode resized image: %w", err)
+	}
+	res.Raw = buf.Bytes()
+	return res, nil
+}
+
+func (p *Pipeline) validPixels(cfg image.Config) error {
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return fmt.Errorf("%w: %dx%d", models.ErrInvalidImage, cfg.Width, cfg.Height)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > p.maxPixels {
+		return fmt.Errorf("%w: %dx%d is more than %d", models.ErrTooManyPixels, cfg.Width, cfg.Height, p.maxPixels)
+	}
+	return nil
+}
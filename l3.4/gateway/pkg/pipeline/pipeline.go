@@ -0,0 +1,201 @@
+package pipeline
+
+import (
+	"app/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"unicode/utf8"
+)
+
+// variantNameRegex keeps variant names usable as storage key prefixes.
+var variantNameRegex = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+var (
+	resizeModes = map[string]bool{"": true, "fit": true, "fill": true, "crop": true}
+	positions   = map[string]bool{"": true, "top-left": true, "top-right": true, "bottom-left": true, "bottom-right": true, "center": true}
+	formats     = map[string]bool{"jpeg": true, "png": true, "gif": true, "webp": true, "bmp": true, "tiff": true}
+	frameModes  = map[string]bool{"": true, models.FramesAll: true, models.FramesFirst: true}
+)
+
+// builtinPresets keep the variants every upload used to get.
+var builtinPresets = map[string][]models.Operation{
+	models.WatermarkedKey: {
+		{Op: models.OpAutoOrient},
+		{Op: models.OpWatermark},
+	},
+	models.ThumbnailKey: {
+		{Op: models.OpAutoOrient},
+		{Op: models.OpResize, Width: 150, Height: 150, Mode: "fill"},
+	},
+}
+
+type PipelineConfig struct {
+	// PresetsFile is a JSON object of preset names to operations, added to
+	// and overriding the built-in presets.
+	PresetsFile string `env:"PRESETS_FILE" env-default:""`
+	// DefaultPresets are made when an upload asks for no variants.
+	DefaultPresets []string `env:"DEFAULT_PRESETS" env-default:"watermarked,thumbnail"`
+	// MaxPixels limits width times height of an upload, so a small file
+	// can't decompress into a huge image.
+	MaxPixels int64 `env:"MAX_PIXELS" env-default:"50000000"`
+	// ResizeStep rounds the sizes of served copies up to a multiple of it,
+	// so clients can't make a stored copy for every width and height.
+	ResizeStep int `env:"IMAGE_RESIZE_STEP" env-default:"100"`
+}
+
+// Valid checks that some pixels are allowed.
+func (cfg PipelineConfig) Valid() error {
+	if cfg.MaxPixels <= 0 {
+		return models.ErrInvalidMaxPixels
+	}
+	if cfg.ResizeStep <= 0 {
+		return models.ErrInvalidResizeStep
+	}
+	return nil
+}
+
+type Pipeline struct {
+	presets    map[string][]models.Operation
+	defaults   []string
+	maxPixels  int64
+	resizeStep int
+}
+
+func New(cfg PipelineConfig) *Pipeline {
+	presets := make(map[string][]models.Operation, len(builtinPresets))
+	for name, ops := range builtinPresets {
+		presets[name] = ops
+	}
+
+	if cfg.PresetsFile != "" {
+		data, err := os.ReadFile(cfg.PresetsFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to read presets: %v", err))
+		}
+		var custom map[string][]models.Operation
+		if err := json.Unmarshal(data, &custom); err != nil {
+			panic(fmt.Sprintf("failed to parse presets: %v", err))
+		}
+		for name, ops := range custom {
+			presets[name] = ops
+		}
+	}
+
+	p := &Pipeline{presets: presets, defaults: cfg.DefaultPresets, maxPixels: cfg.MaxPixels, resizeStep: cfg.ResizeStep}
+	for name, ops := range presets {
+		if err := p.validVariant(name, ops); err != nil {
+			panic(fmt.Sprintf("failed to valid preset %s: %v", name, err))
+		}
+	}
+	for _, name := range cfg.DefaultPresets {
+		if _, ok := presets[name]; !ok {
+			panic(fmt.Sprintf("failed to valid default presets: %v: %s", models.ErrInvalidPresets, name))
+		}
+	}
+
+	return p
+}
+
+// Resolve expands the requested presets and checks the client's own variants,
+// falling back to the default presets when nothing is requested.
+func (p *Pipeline) Resolve(presets []string, variants map[string][]models.Operation) (map[string][]models.Operation, error) {
+	if len(presets) == 0 && len(variants) == 0 {
+		presets = p.defaults
+	}
+
+	resolved := make(map[string][]models.Operation, len(presets)+len(variants))
+	for _, name := range presets {
+		ops, ok := p.presets[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", models.ErrUnknownPreset, name)
+		}
+		resolved[name] = ops
+	}
+	for name, ops := range variants {
+		if _, ok := resolved[name]; ok {
+			return nil, fmt.Errorf("%w: %s is both a preset and a variant", models.ErrInvalidVariant, name)
+		}
+		if err := p.validVariant(name, ops); err != nil {
+			return nil, err
+		}
+		resolved[name] = ops
+	}
+
+	if len(resolved) > models.MaxVariants {
+		return nil, fmt.Errorf("%w: at most %d variants", models.ErrInvalidVariant, models.MaxVariants)
+	}
+	return resolved, nil
+}
+
+// validVariant checks the name and operations of a variant. A resize to both
+// sides is held to the pixel limit here, the processor checks the others once
+// it knows the size of the image.
+func (p *Pipeline) validVariant(name string, ops []models.Operation) error {
+	if !variantNameRegex.MatchString(name) || name == models.OriginalKey || name == models.CacheKey {
+		return fmt.Errorf("%w: bad name %q", models.ErrInvalidVariant, name)
+	}
+	if len(ops) > models.MaxOperations {
+		return fmt.Errorf("%w: %s has more than %d operations", models.ErrInvalidVariant, name, models.MaxOperations)
+	}
+	for i, op := range ops {
+		if err := Validate(op); err != nil {
+			return fmt.Errorf("%s, operation %d: %w", name, i+1, err)
+		}
+		if op.Op == models.OpResize && int64(op.Width)*int64(op.Height) > p.maxPixels {
+			return fmt.Errorf("%w: %s, operation %d: %dx%d is more than %d pixels", models.ErrInvalidVariant, name, i+1, op.Width, op.Height, p.maxPixels)
+		}
+	}
+	return nil
+}
+
+// Validate checks the parameters of a single operation.
+func Validate(op models.Operation) error {
+	var err error
+	switch op.Op {
+	case models.OpResize:
+		if op.Width < 0 || op.Height < 0 || op.Width > models.MaxDimension || op.Height > models.MaxDimension {
+			err = fmt.Errorf("width and height must be within 0..%d", models.MaxDimension)
+		} else if op.Width == 0 && op.Height == 0 {
+			err = errors.New("width or height is required")
+		} else if !resizeModes[op.Mode] {
+			err = fmt.Errorf("unknown resize mode %q", op.Mode)
+		} else if op.Mode != "" && op.Mode != "fit" && (op.Width == 0 || op.Height == 0) {
+			err = fmt.Errorf("%s needs both width and height", op.Mode)
+		}
+	case models.OpRotate:
+		if op.Angle <= -360 || op.Angle >= 360 {
+			err = errors.New("angle must be within -360..360")
+		}
+	case models.OpBlur:
+		if op.Sigma <= 0 || op.Sigma > models.MaxBlurSigma {
+			err = fmt.Errorf("sigma must be within 0..%d", models.MaxBlurSigma)
+		}
+	case models.OpWatermark:
+		if utf8.RuneCountInString(op.Text) > models.MaxWatermarkText {
+			err = fmt.Errorf("text is longer than %d", models.MaxWatermarkText)
+		} else if !positions[op.Position] {
+			err = fmt.Errorf("unknown position %q", op.Position)
+		} else if op.Opacity < 0 || op.Opacity > 1 {
+			err = errors.New("opacity must be within 0..1")
+		}
+	case models.OpFormat:
+		if !formats[op.Format] {
+			err = fmt.Errorf("unknown format %q", op.Format)
+		} else if op.Quality < 0 || op.Quality > 100 {
+			err = errors.New("quality must be within 1..100")
+		} else if !frameModes[op.Frames] {
+			err = fmt.Errorf("unknown frames %q", op.Frames)
+		}
+	case models.OpAutoOrient, models.OpGrayscale:
+	default:
+		err = fmt.Errorf("unknown operation %q", op.Op)
+	}
+
+	if err != nil {
+		return errors.Join(models.ErrInvalidOperation, err)
+	}
+	return nil
+}
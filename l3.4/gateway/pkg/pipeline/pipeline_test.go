@@ -0,0 +1,266 @@
+package pipeline
+
+import (
+	"app/internal/models"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+func TestNewLoadsPresetsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "presets.json")
+	presets := `{"small": [{"op": "resize", "width": 320}], "thumbnail": [{"op": "resize", "width": 64, "height": 64, "mode": "fill"}]}`
+	if err := os.WriteFile(file, []byte(presets), 0o600); err != nil {
+		t.Fatalf("failed to write presets: %v", err)
+	}
+
+	p := New(PipelineConfig{PresetsFile: file, DefaultPresets: []string{"small"}, MaxPixels: 1000000})
+
+	resolved, err := p.Resolve(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resolved) != 1 || resolved["small"][0].Width != 320 {
+		t.Fatalf("expected the small default preset, got %v", resolved)
+	}
+
+	resolved, err = p.Resolve([]string{models.ThumbnailKey, models.WatermarkedKey}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resolved[models.ThumbnailKey][0].Width != 64 {
+		t.Fatalf("expected the file to override the thumbnail preset, got %v", resolved[models.ThumbnailKey])
+	}
+	if _, ok := resolved[models.WatermarkedKey]; !ok {
+		t.Fatal("expected the built-in watermarked preset")
+	}
+}
+
+func TestNewPanicsOnBadPresets(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PipelineConfig
+	}{
+		{"unknown default", PipelineConfig{DefaultPresets: []string{"missing"}, MaxPixels: 1000000}},
+		{"missing file", PipelineConfig{PresetsFile: filepath.Join(t.TempDir(), "missing.json"), MaxPixels: 1000000}},
+		// the 150x150 thumbnail is more than the limit
+		{"preset over the pixel limit", PipelineConfig{MaxPixels: 10000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected a panic")
+				}
+			}()
+			New(tt.cfg)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	p := New(PipelineConfig{DefaultPresets: []string{models.WatermarkedKey, models.ThumbnailKey}, MaxPixels: 1000000})
+
+	resize := []models.Operation{{Op: models.OpResize, Width: 100}}
+	tooMany := make(map[string][]models.Operation)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"} {
+		tooMany[name] = resize
+	}
+
+	tests := []struct {
+		name     string
+		presets  []string
+		variants map[string][]models.Operation
+		want     []string
+		err      error
+	}{
+		{"defaults", nil, nil, []string{models.WatermarkedKey, models.ThumbnailKey}, nil},
+		{"only presets", []string{models.ThumbnailKey}, nil, []string{models.ThumbnailKey}, nil},
+		{"only variants", nil, map[string][]models.Operation{"small": resize}, []string{"small"}, nil},
+		{"both", []string{models.ThumbnailKey}, map[string][]models.Operation{"small": resize}, []string{models.ThumbnailKey, "small"}, nil},
+		{"unknown preset", []string{"huge"}, nil, nil, models.ErrUnknownPreset},
+		{"variant named as a preset", []string{models.ThumbnailKey}, map[string][]models.Operation{models.ThumbnailKey: resize}, nil, models.ErrInvalidVariant},
+		{"original", nil, map[string][]models.Operation{models.OriginalKey: resize}, nil, models.ErrInvalidVariant},
+		{"bad name", nil, map[string][]models.Operation{"../x": resize}, nil, models.ErrInvalidVariant},
+		{"bad operation", nil, map[string][]models.Operation{"small": {{Op: "sharpen"}}}, nil, models.ErrInvalidOperation},
+		{"too many variants", nil, tooMany, nil, models.ErrInvalidVariant},
+		{"resize over the pixel limit", nil, map[string][]models.Operation{"huge": {{Op: models.OpResize, Width: models.MaxDimension, Height: models.MaxDimension}}}, nil, models.ErrInvalidVariant},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := p.Resolve(tt.presets, tt.variants)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(resolved) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, resolved)
+			}
+			for _, name := range tt.want {
+				if _, ok := resolved[name]; !ok {
+					t.Fatalf("expected variant %s in %v", name, resolved)
+				}
+			}
+		})
+	}
+}
+
+func encodeImage(t *testing.T, encode func(io.Writer, image.Image) error, width, height int) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	if err := encode(buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// losslessWebp is the header of a VP8L webp, enough for DecodeConfig.
+func losslessWebp(width, height int) []byte {
+	bits := uint32(width-1) | uint32(height-1)<<14 | 1<<28
+	chunk := binary.LittleEndian.AppendUint32([]byte{0x2f}, bits)
+
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+8+len(chunk)))
+	out = append(out, "WEBPVP8L"...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(chunk)))
+	return append(out, chunk...)
+}
+
+func TestInspect(t *testing.T) {
+	p := New(PipelineConfig{DefaultPresets: []string{models.ThumbnailKey}, MaxPixels: 40000})
+
+	jpegImage := encodeImage(t, func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, nil) }, 100, 100)
+	gifImage := encodeImage(t, func(w io.Writer, img image.Image) error { return gif.Encode(w, img, nil) }, 100, 100)
+	tiffImage := encodeImage(t, func(w io.Writer, img image.Image) error { return tiff.Encode(w, img, nil) }, 100, 100)
+
+	tests := []struct {
+		name        string
+		head        []byte
+		whole       bool
+		contentType string
+		err         error
+	}{
+		{"jpeg", jpegImage, true, "image/jpeg", nil},
+		{"png", encodeImage(t, png.Encode, 100, 100), true, "image/png", nil},
+		{"gif", gifImage, true, "image/gif", nil},
+		{"webp", losslessWebp(100, 100), false, "image/webp", nil},
+		{"bmp", encodeImage(t, bmp.Encode, 100, 100), true, "image/bmp", nil},
+		{"tiff", tiffImage, true, "image/tiff", nil},
+		{"jpeg header past the head", jpegImage[:20], false, "image/jpeg", nil},
+		{"truncated jpeg", jpegImage[:20], true, "", models.ErrInvalidImage},
+		{"too many pixels", encodeImage(t, png.Encode, 201, 200), true, "", models.ErrTooManyPixels},
+		{"webp bomb", losslessWebp(16384, 16384), false, "", models.ErrTooManyPixels},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), true, "", models.ErrInvalidImageType},
+		{"avif", []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00"), true, "", models.ErrAVIFUnsupported},
+		{"png magic only", []byte("\x89PNG\r\n\x1a\n"), true, "", models.ErrInvalidImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType, err := p.Inspect(tt.head, tt.whole)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if contentType != tt.contentType {
+				t.Fatalf("expected %s, got %s", tt.contentType, contentType)
+			}
+		})
+	}
+}
+
+func TestSize(t *testing.T) {
+	p := New(PipelineConfig{DefaultPresets: []string{models.ThumbnailKey}, MaxPixels: 1000000, ResizeStep: 100})
+	raw := encodeImage(t, png.Encode, 450, 200)
+
+	tests := []struct {
+		name          string
+		width, height int
+		size          image.Point
+	}{
+		{"rounded up to the step", 120, 0, image.Pt(200, 200)},
+		{"on the step", 300, 100, image.Pt(300, 100)},
+		{"clamped to the image", 10000, 50, image.Pt(450, 100)},
+		{"the image itself", 10000, 10000, image.Pt(0, 0)},
+		{"missing sides", 0, 0, image.Pt(0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height, err := p.Size(bytes.NewReader(raw), tt.width, tt.height)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if size := image.Pt(width, height); size != tt.size {
+				t.Fatalf("expected %v, got %v", tt.size, size)
+			}
+		})
+	}
+
+	if _, _, err := p.Size(bytes.NewReader([]byte("not an image")), 100, 100); !errors.Is(err, models.ErrInvalidImageType) {
+		t.Fatalf("expected %v, got %v", models.ErrInvalidImageType, err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	p := New(PipelineConfig{DefaultPresets: []string{models.ThumbnailKey}, MaxPixels: 1000000})
+
+	tests := []struct {
+		name          string
+		raw           []byte
+		width, height int
+		contentType   string
+		size          image.Point
+	}{
+		{"jpeg stays jpeg", encodeImage(t, func(w io.Writer, img image.Image) error { return jpeg.Encode(w, img, nil) }, 400, 200), 100, 100, "image/jpeg", image.Pt(100, 50)},
+		{"bmp becomes png", encodeImage(t, bmp.Encode, 400, 200), 0, 20, "image/png", image.Pt(40, 20)},
+		{"never enlarged", encodeImage(t, png.Encode, 400, 200), 1000, 1000, "image/png", image.Pt(400, 200)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := p.Resize(tt.raw, tt.width, tt.height)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.ContentType != tt.contentType {
+				t.Fatalf("expected %s, got %s", tt.contentType, res.ContentType)
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(res.Raw))
+			if err != nil {
+				t.Fatalf("failed to decode the result: %v", err)
+			}
+			if size := image.Pt(cfg.Width, cfg.Height); size != tt.size {
+				t.Fatalf("expected %v, got %v", tt.size, size)
+			}
+		})
+	}
+
+	if _, err := p.Resize(encodeImage(t, png.Encode, 1001, 1000), 10, 10); !errors.Is(err, models.ErrTooManyPixels) {
+		t.Fatalf("expected %v, got %v", models.ErrTooManyPixels, err)
+	}
+}
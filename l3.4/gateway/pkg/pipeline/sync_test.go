@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"testing"
+)
+
+// The processor is a module of its own, so it keeps a copy of the operations
+// instead of importing them. The copies are compared here to keep an upload
+// the gateway accepts from failing in the processor, and the other way round.
+const processorDir = "../../../processor/"
+
+func TestOperationsMatchProcessor(t *testing.T) {
+	tests := []struct {
+		gateway   string
+		processor string
+		names     []string
+	}{
+		{
+			"pipeline.go", processorDir + "pkg/pipeline/pipeline.go",
+			[]string{"resizeModes", "positions", "formats", "frameModes", "Validate"},
+		},
+		{
+			"../../internal/models/models.go", processorDir + "internal/models/models.go",
+			[]string{
+				"OpResize", "OpRotate", "OpAutoOrient", "OpBlur", "OpGrayscale", "OpWatermark", "OpFormat",
+				"MaxOperations", "MaxDimension", "MaxBlurSigma", "MaxWatermarkText", "DefaultJpegQuality",
+				"FramesAll", "FramesFirst", "Operation",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		gateway := declarations(t, tt.gateway)
+		processor := declarations(t, tt.processor)
+		for _, name := range tt.names {
+			if gateway[name] == "" || processor[name] == "" {
+				t.Errorf("expected %s in both %s and %s", name, tt.gateway, tt.processor)
+				continue
+			}
+			if gateway[name] != processor[name] {
+				t.Errorf("expected %s to be the same, got in the gateway:\n%s\nin the processor:\n%s", name, gateway[name], processor[name])
+			}
+		}
+	}
+}
+
+// declarations prints the top-level declarations of file by name, without
+// their comments.
+func declarations(t *testing.T, file string) map[string]string {
+	t.Helper()
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", file, err)
+	}
+
+	print := func(node any) string {
+		buf := new(bytes.Buffer)
+		if err := printer.Fprint(buf, fset, node); err != nil {
+			t.Fatalf("failed to print %s: %v", file, err)
+		}
+		return buf.String()
+	}
+
+	decls := make(map[string]string)
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				decls[decl.Name.Name] = print(decl.Type) + " " + print(decl.Body)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					decls[spec.Name.Name] = print(spec.Type)
+				case *ast.ValueSpec:
+					for i, name := range spec.Names {
+						if i < len(spec.Values) {
+							decls[name.Name] = print(spec.Values[i])
+						}
+					}
+				}
+			}
+		}
+	}
+	return decls
+}
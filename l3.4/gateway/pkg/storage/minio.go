@@ -2,10 +2,12 @@ package storage
 
 import (
 	"app/internal/models"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"time"
 
 	"github.com/minio/minio-go/v7"
@@ -18,6 +20,11 @@ type StorageClient struct {
 	Endpoint       string
 	ClientEndpoint string
 	UseSSL         bool
+	partSize       uint64
+	// signer presigns urls for ClientEndpoint, the host is part of the
+	// signature.
+	signer     *minio.Client
+	presignTTL time.Duration
 }
 
 type StorageConfig struct {
@@ -28,8 +35,29 @@ type StorageConfig struct {
 	BucketName     string `env:"MINIO_BUCKET_NAME" env-default:"images"`
 	UseSSL         bool   `env:"MINIO_USE_SSL" env-default:"false"`
 	Region         string `env:"MINIO_REGION" env-default:"us-east-1"`
+	// PartSize is the size of one part of a multipart upload, S3 requires at
+	// least 5MiB. Smaller uploads are sent in a single request.
+	PartSize uint64 `env:"MINIO_PART_SIZE" env-default:"16777216"`
+	// PresignTTL is how long the image urls handed out stay valid, S3 allows
+	// up to a week.
+	PresignTTL time.Duration `env:"MINIO_PRESIGN_TTL" env-default:"15m"`
 }
 
+func (cfg StorageConfig) Valid() error {
+	if cfg.PartSize < minPartSize {
+		return models.ErrInvalidPartSize
+	}
+	if cfg.PresignTTL < time.Second || cfg.PresignTTL > maxPresignTTL {
+		return models.ErrInvalidPresignTTL
+	}
+	return nil
+}
+
+const (
+	minPartSize   = 5 << 20
+	maxPresignTTL = 7 * 24 * time.Hour
+)
+
 func New(cfg StorageConfig) *StorageClient {
 	client, err := minio.New(cfg.Endpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
@@ -51,12 +79,17 @@ func New(cfg StorageConfig) *StorageClient {
 		if err != nil && err.Error() != minio.BucketAlreadyExists {
 			panic(fmt.Sprintf("failed to create bucket: %s", err.Error()))
 		}
+	}
 
-		policy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::` + cfg.BucketName + `/*"]}]}`
-		err = client.SetBucketPolicy(ctx, cfg.BucketName, policy)
-		if err != nil {
-			panic(fmt.Sprintf("failed to set bucket policy: %s", err.Error()))
-		}
+	// with the region set presigning needs no request to the client endpoint,
+	// which may not be reachable from here
+	signer, err := minio.New(cfg.ClientEndpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		panic(fmt.Sprintf("failed to create MinIO signer: %s", err.Error()))
 	}
 
 	return &StorageClient{
@@ -65,9 +98,94 @@ func New(cfg StorageConfig) *StorageClient {
 		Endpoint:       cfg.Endpoint,
 		UseSSL:         cfg.UseSSL,
 		ClientEndpoint: cfg.ClientEndpoint,
+		partSize:       cfg.PartSize,
+		signer:         signer,
+		presignTTL:     cfg.PresignTTL,
 	}
 }
 
+// PresignedURL returns a url the client can download path from until the
+// presign TTL runs out, the bucket itself stays private.
+func (m *StorageClient) PresignedURL(ctx context.Context, path string) (string, error) {
+	u, err := m.signer.PresignedGetObject(ctx, m.BucketName, path, m.presignTTL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign %s: %w", path, err)
+	}
+	return u.String(), nil
+}
+
+// OpenFile opens path for reading. The object supports seeking, so it can be
+// served in ranges.
+func (m *StorageClient) OpenFile(ctx context.Context, path string) (*models.ImageObject, error) {
+	obj, err := m.Client.GetObject(ctx, m.BucketName, path, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %w", path, err)
+	}
+
+	stat, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+			return nil, models.ErrImageNotFound
+		}
+		return nil, fmt.Errorf("failed to stat object %s: %w", path, err)
+	}
+
+	return &models.ImageObject{
+		Body:        obj,
+		ContentType: stat.ContentType,
+		ETag:        stat.ETag,
+		Size:        stat.Size,
+		ModTime:     stat.LastModified,
+	}, nil
+}
+
+// UploadFile stores data under path.
+func (m *StorageClient) UploadFile(ctx context.Context, path string, data []byte, contentType string) error {
+	_, err := m.Client.PutObject(ctx, m.BucketName, path, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: contentType,
+		UserMetadata: map[string]string{
+			"Uploaded": time.Now().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return errors.Join(models.ErrImageUploadFailed, fmt.Errorf("%s: %w", path, err))
+	}
+	return nil
+}
+
+// UploadStream stores everything read from r under path and returns its
+// size. Up to one part is buffered: a smaller object is sent in a single
+// request, a larger one as a multipart upload part by part, so the whole
+// file is never held in memory.
+func (m *StorageClient) UploadStream(ctx context.Context, path string, r io.Reader, contentType string) (int64, error) {
+	var head bytes.Buffer
+	n, err := io.CopyN(&head, r, int64(m.partSize))
+	if err != nil && err != io.EOF {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	opts := minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    m.partSize,
+		UserMetadata: map[string]string{
+			"Uploaded": time.Now().Format(time.RFC3339),
+		},
+	}
+
+	var info minio.UploadInfo
+	if err == io.EOF {
+		info, err = m.Client.PutObject(ctx, m.BucketName, path, &head, n, opts)
+	} else {
+		info, err = m.Client.PutObject(ctx, m.BucketName, path, io.MultiReader(&head, r), -1, opts)
+	}
+	if err != nil {
+		return 0, errors.Join(models.ErrImageUploadFailed, fmt.Errorf("%s: %w", path, err))
+	}
+
+	return info.Size, nil
+}
+
 func (m *StorageClient) DownloadFile(path string) ([]byte, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -101,3 +219,19 @@ func (m *StorageClient) DeleteFile(path string) error {
 	}
 	return nil
 }
+
+// DeletePrefix removes every object whose key starts with prefix.
+func (m *StorageClient) DeletePrefix(prefix string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for obj := range m.Client.ListObjects(ctx, m.BucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return errors.Join(models.ErrImageDeleteFailed, fmt.Errorf("%s: %w", prefix, obj.Err))
+		}
+		if err := m.Client.RemoveObject(ctx, m.BucketName, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return errors.Join(models.ErrImageDeleteFailed, fmt.Errorf("%s: %w", obj.Key, err))
+		}
+	}
+	return nil
+}
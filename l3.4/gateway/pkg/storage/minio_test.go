@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"app/internal/models"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// newFakeStorage serves an in-memory S3 for the test. It runs over TLS since
+// on plain http minio signs every part in aws-chunked encoding, which the
+// fake only decodes for single puts.
+func newFakeStorage(t *testing.T, partSize uint64) *StorageClient {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("images"); err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+	server := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	client, err := minio.New(strings.TrimPrefix(server.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("minioadmin", "minioadmin", ""),
+		Secure:    true,
+		Region:    "us-east-1",
+		Transport: server.Client().Transport,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	return &StorageClient{Client: client, BucketName: "images", partSize: partSize, signer: client, presignTTL: time.Minute}
+}
+
+func readObject(t *testing.T, str *StorageClient, path string) ([]byte, string) {
+	t.Helper()
+
+	obj, err := str.Client.GetObject(context.Background(), str.BucketName, path, minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", path, err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	stat, err := obj.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", path, err)
+	}
+	return data, stat.ContentType
+}
+
+func TestUploadStream(t *testing.T) {
+	str := newFakeStorage(t, minPartSize)
+
+	small := []byte("\xff\xd8\xff small jpeg")
+	large := make([]byte, 2*minPartSize+1024)
+	if _, err := rand.Read(large); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"single": small, "multipart": large} {
+		path := "original/" + name
+
+		size, err := str.UploadStream(context.Background(), path, bytes.NewReader(data), "image/jpeg")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if size != int64(len(data)) {
+			t.Fatalf("%s: expected size %d, got %d", name, len(data), size)
+		}
+
+		stored, contentType := readObject(t, str, path)
+		if !bytes.Equal(stored, data) {
+			t.Fatalf("%s: stored object differs from the upload", name)
+		}
+		if contentType != "image/jpeg" {
+			t.Fatalf("%s: expected image/jpeg, got %s", name, contentType)
+		}
+	}
+}
+
+func TestUploadStreamReadError(t *testing.T) {
+	str := newFakeStorage(t, minPartSize)
+
+	// a client that drops after the first part
+	body := io.MultiReader(bytes.NewReader(make([]byte, minPartSize+1)), iotest.ErrReader(errors.New("connection reset by peer")))
+	if _, err := str.UploadStream(context.Background(), "original/broken", body, "image/jpeg"); err == nil {
+		t.Fatal("expected the read error to fail the upload")
+	}
+	if _, err := str.Client.StatObject(context.Background(), str.BucketName, "original/broken", minio.StatObjectOptions{}); err == nil {
+		t.Fatal("expected no object after a failed upload")
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	str := newFakeStorage(t, minPartSize)
+
+	data := []byte("\x89PNG\r\n\x1a\n png")
+	if err := str.UploadFile(context.Background(), "cache/id/thumbnail-10x10", data, "image/png"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	obj, err := str.OpenFile(context.Background(), "cache/id/thumbnail-10x10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer obj.Body.Close()
+	if obj.ContentType != "image/png" || obj.Size != int64(len(data)) || obj.ETag == "" {
+		t.Fatalf("unexpected object info %+v", obj)
+	}
+
+	// ranges are served by seeking
+	if _, err := obj.Body.Seek(4, io.SeekStart); err != nil {
+		t.Fatalf("failed to seek: %v", err)
+	}
+	rest, err := io.ReadAll(obj.Body)
+	if err != nil {
+		t.Fatalf("failed to read: %v", err)
+	}
+	if !bytes.Equal(rest, data[4:]) {
+		t.Fatalf("expected %q, got %q", data[4:], rest)
+	}
+
+	if _, err := str.OpenFile(context.Background(), "cache/id/missing"); !errors.Is(err, models.ErrImageNotFound) {
+		t.Fatalf("expected %v, got %v", models.ErrImageNotFound, err)
+	}
+}
+
+func TestPresignedURL(t *testing.T) {
+	str := newFakeStorage(t, minPartSize)
+
+	if err := str.UploadFile(context.Background(), "original/id", []byte("image"), "image/jpeg"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	url, err := str.PresignedURL(context.Background(), "original/id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(url, "X-Amz-Signature=") || !strings.Contains(url, "X-Amz-Expires=60") {
+		t.Fatalf("expected a presigned url valid for a minute, got %s", url)
+	}
+
+	// the fake serves a self-signed certificate
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	str := newFakeStorage(t, minPartSize)
+
+	for _, path := range []string{"cache/id/a-10x10", "cache/id/b-0x20", "cache/other/a-10x10"} {
+		if err := str.UploadFile(context.Background(), path, []byte("image"), "image/png"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := str.DeletePrefix("cache/id/"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for path, exists := range map[string]bool{"cache/id/a-10x10": false, "cache/id/b-0x20": false, "cache/other/a-10x10": true} {
+		_, err := str.Client.StatObject(context.Background(), str.BucketName, path, minio.StatObjectOptions{})
+		if (err == nil) != exists {
+			t.Fatalf("%s: expected exists=%v, got err %v", path, exists, err)
+		}
+	}
+}
@@ -14,6 +14,8 @@ class ImageProcessor {
         this.uploadForm = document.getElementById('uploadForm');
         this.imageInput = document.getElementById('imageInput');
         this.fileName = document.getElementById('fileName');
+        this.presetsInput = document.getElementById('presetsInput');
+        this.variantsInput = document.getElementById('variantsInput');
         this.uploadBtn = document.getElementById('uploadBtn');
         this.uploadResult = document.getElementById('uploadResult');
         this.taskId = document.getElementById('taskId');
@@ -48,8 +50,8 @@ class ImageProcessor {
             this.fileName.textContent = file.name;
             this.uploadBtn.disabled = false;
             
-            if (file.size > 10 * 1024 * 1024) {
-                this.showError('Файл слишком большой. Максимальный размер 10MB');
+            if (file.size > 100 * 1024 * 1024) {
+                this.showError('Файл слишком большой. Максимальный размер 100MB');
                 this.uploadBtn.disabled = true;
             }
             
@@ -72,7 +74,21 @@ class ImageProcessor {
             return;
         }
 
+        const variants = this.variantsInput.value.trim();
+        if (variants) {
+            try {
+                JSON.parse(variants);
+            } catch {
+                this.showError('Версии должны быть JSON объектом');
+                return;
+            }
+        }
+
+        // the gateway streams the image, so the other fields have to come first
         const formData = new FormData();
+        const presets = this.presetsInput.value.trim();
+        if (presets) formData.append('presets', presets);
+        if (variants) formData.append('variants', variants);
         formData.append('image', file);
 
         this.showLoading();
@@ -88,7 +104,7 @@ class ImageProcessor {
             const data = await response.json();
 
             if (!response.ok) {
-                throw new Error(data.message || 'Ошибка загрузки');
+                throw new Error(data.error || data.message || 'Ошибка загрузки');
             }
 
             this.showUploadResult(data.id);
@@ -152,7 +168,7 @@ class ImageProcessor {
                 if (data.error == 'pending') {
                     throw new Error('Изображения еще не готовы. Пожалуйста, попробуйте позже.');
                 } else {
-                    throw new Error('Ошибка получения данных');
+                    throw new Error(data.error || 'Ошибка получения данных');
                 }
             }
 
@@ -182,11 +198,11 @@ class ImageProcessor {
         
         this.imagesGrid.innerHTML = '';
         
-        const images = [
-            { type: 'original', title: 'Оригинал', url: data.original_url },
-            { type: 'processed', title: 'С водяным знаком', url: data.watermarked_url },
-            { type: 'thumbnail', title: 'Миниатюра', url: data.thumbnail_url }
-        ];
+        const titles = { watermarked: 'С водяным знаком', thumbnail: 'Миниатюра' };
+        const images = [{ title: 'Оригинал', url: data.original_url }];
+        Object.keys(data.variants || {}).sort().forEach(name => {
+            images.push({ title: titles[name] || name, url: data.variants[name] });
+        });
 
         images.forEach(img => {
             if (img.url) {
@@ -8,6 +8,7 @@ import (
 	"app/pkg/broker"
 	"app/pkg/data"
 	"app/pkg/logger"
+	"app/pkg/pipeline"
 	"app/pkg/storage"
 	"context"
 	"os"
@@ -23,7 +24,8 @@ func main() {
 	data := data.New(cfg.DataConfig)
 	str := storage.New(cfg.StorageConfig)
 	repo := repository.New(data, str)
-	service := service.New(repo)
+	pipeline := pipeline.New(cfg.PipelineConfig)
+	service := service.New(repo, pipeline, cfg.RetryConfig)
 	brk := broker.New(cfg.BrokerConfig)
 	cons := transport.New(service, &cfg.ConsumerConfig, ctx, brk)
 
@@ -2,9 +2,11 @@ package config
 
 import (
 	"app/internal/models"
+	"app/internal/service"
 	"app/internal/transport"
 	"app/pkg/broker"
 	"app/pkg/data"
+	"app/pkg/pipeline"
 	"app/pkg/storage"
 	"fmt"
 	"strconv"
@@ -18,6 +20,8 @@ type Config struct {
 	DataConfig     data.DataConfig
 	BrokerConfig   broker.BrokerConfig
 	StorageConfig  storage.StorageConfig
+	PipelineConfig pipeline.PipelineConfig
+	RetryConfig    service.RetryConfig
 }
 
 func (c *Config) valid() error {
@@ -31,6 +35,12 @@ func (c *Config) valid() error {
 	if err := validLogLevel(c.ConsumerConfig.LogLevel); err != nil {
 		resultErr = multierr.Append(resultErr, err)
 	}
+	if err := c.PipelineConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
+	if err := c.RetryConfig.Valid(); err != nil {
+		resultErr = multierr.Append(resultErr, err)
+	}
 	return resultErr
 }
 
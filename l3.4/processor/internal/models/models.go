@@ -1,14 +1,42 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
+
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusCompleted  = "completed"
+	StatusFailed     = "failed"
+	OriginalKey      = "original"
+	WatermarkedKey   = "watermarked"
+	ThumbnailKey     = "thumbnail"
+)
+
+const (
+	OpResize     = "resize"
+	OpRotate     = "rotate"
+	OpAutoOrient = "auto_orient"
+	OpBlur       = "blur"
+	OpGrayscale  = "grayscale"
+	OpWatermark  = "watermark"
+	OpFormat     = "format"
+)
 
 const (
-	StatusPending   = "pending"
-	StatusCompleted = "completed"
-	StatusFailed    = "failed"
-	OriginalKey     = "original"
-	WatermarkedKey  = "watermarked"
-	ThumbnailKey    = "thumbnail"
+	MaxOperations      = 20
+	MaxDimension       = 10000
+	MaxBlurSigma       = 50
+	MaxWatermarkText   = 100
+	DefaultJpegQuality = 85
+)
+
+// Frames of an animated gif a variant keeps.
+const (
+	FramesAll   = "all"
+	FramesFirst = "first"
 )
 
 type Image struct {
@@ -17,9 +45,45 @@ type Image struct {
 	Size int    `json:"size"`
 }
 
+// Operation is one step of a variant pipeline, see the gateway for the
+// parameters of each op.
+type Operation struct {
+	Op       string  `json:"op"`
+	Width    int     `json:"width,omitempty"`
+	Height   int     `json:"height,omitempty"`
+	Mode     string  `json:"mode,omitempty"`
+	Angle    float64 `json:"angle,omitempty"`
+	Sigma    float64 `json:"sigma,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	Position string  `json:"position,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty"`
+	Format   string  `json:"format,omitempty"`
+	Quality  int     `json:"quality,omitempty"`
+	Frames   string  `json:"frames,omitempty"`
+}
+
+// KafkaTask is a claim check: the gateway has already stored the original
+// under Key. Every variant is made from the original by its operations.
+// Attempt counts the failed attempts, a task on the retry topic waits until
+// RetryAt.
 type KafkaTask struct {
-	ID string `json:"id"`
-	Image
+	ID          string                 `json:"id"`
+	Key         string                 `json:"key"`
+	ContentType string                 `json:"content_type"`
+	Size        int64                  `json:"size"`
+	Variants    map[string][]Operation `json:"variants"`
+	Attempt     int                    `json:"attempt,omitempty"`
+	RetryAt     time.Time              `json:"retry_at,omitzero"`
+}
+
+// FailedTask is what the dead letter topic gets for a task given up on. Raw
+// holds the message when it couldn't be read as a task.
+type FailedTask struct {
+	Task     *KafkaTask `json:"task,omitempty"`
+	Raw      string     `json:"raw,omitempty"`
+	Error    string     `json:"error"`
+	Attempts int        `json:"attempts"`
+	FailedAt time.Time  `json:"failed_at"`
 }
 
 type PostgresTask struct {
@@ -29,18 +93,26 @@ type PostgresTask struct {
 }
 
 type ProcessedTask struct {
-	ID          string `json:"id"`
-	Path        string `json:"path"`
-	Original    Image  `json:"original"`
-	Watermarked Image  `json:"watermarked"`
-	Thumbnail   Image  `json:"thumbnail"`
+	ID       string           `json:"id"`
+	Path     string           `json:"path"`
+	Variants map[string]Image `json:"variants"`
 }
 
 var (
-	ErrInvalidReleaseMode = errors.New("invalid release mode")
-	ErrInvalidPort        = errors.New("invalid port")
-	ErrInvalidWorkerCount = errors.New("invalid worker count")
-	ErrInvalidLogLevel    = errors.New("invalid log level")
-	ErrInvalidImageExt    = errors.New("invalid image extension")
-	ErrImageUploadFailed  = errors.New("failed to upload image")
+	ErrInvalidReleaseMode  = errors.New("invalid release mode")
+	ErrInvalidPort         = errors.New("invalid port")
+	ErrInvalidWorkerCount  = errors.New("invalid worker count")
+	ErrInvalidLogLevel     = errors.New("invalid log level")
+	ErrInvalidMaxPixels    = errors.New("invalid max pixels")
+	ErrInvalidImageExt     = errors.New("invalid image extension")
+	ErrTooManyPixels       = errors.New("image has too many pixels")
+	ErrImageUploadFailed   = errors.New("failed to upload image")
+	ErrImageDownloadFailed = errors.New("failed to download image")
+	ErrInvalidOperation    = errors.New("invalid operation")
+	ErrInvalidWatermark    = errors.New("invalid watermark")
+	ErrInvalidRetryCfg     = errors.New("invalid task retry config")
+	ErrTaskRetry           = errors.New("task will be retried")
+	ErrTaskFailed          = errors.New("task failed")
+	ErrTaskLeased          = errors.New("task is leased by another worker")
+	ErrTaskNotFound        = errors.New("task not found")
 )
@@ -5,6 +5,7 @@ import (
 	"app/pkg/data"
 	"app/pkg/storage"
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"time"
@@ -21,21 +22,69 @@ func New(data *data.Data, str *storage.StorageClient) *Repository {
 	return &Repository{data: data, str: str}
 }
 
-func (r *Repository) UploadImages(task *models.ProcessedTask) error {
-	if err := r.str.UploadFile(fmt.Sprintf("%s/%s", models.OriginalKey, task.Path), task.Original.Raw, task.Original.Ext); err != nil {
-		return errors.Join(models.ErrImageUploadFailed, err)
+// StartTask takes a pending task for processing and counts the attempt. It
+// reports false when the task is done, failed or being processed by someone
+// else whose lease hasn't run out yet. In the last case it also returns when
+// that lease runs out. A task with no row at all is models.ErrTaskNotFound.
+func (r *Repository) StartTask(id string, lease time.Duration) (bool, time.Time, error) {
+	ctx, canc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer canc()
+
+	// the outer select sees the task as it was before the update
+	row, err := r.data.DB.QueryRowWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: time.Second, Backoff: 1}, `WITH started AS (
+			UPDATE tasks
+			SET status = 'processing', attempts = attempts + 1, updated_at = NOW()
+			WHERE id = $1 AND (status = 'pending' OR (status = 'processing' AND updated_at < NOW() - make_interval(secs => $2)))
+			RETURNING id
+		)
+		SELECT EXISTS (SELECT 1 FROM started), (SELECT updated_at + make_interval(secs => $2) FROM tasks WHERE id = $1 AND status = 'processing'),
+			EXISTS (SELECT 1 FROM tasks WHERE id = $1)`,
+		id, lease.Seconds())
+	if err != nil {
+		return false, time.Time{}, err
 	}
-	if err := r.str.UploadFile(fmt.Sprintf("%s/%s", models.WatermarkedKey, task.Path), task.Watermarked.Raw, task.Watermarked.Ext); err != nil {
-		return errors.Join(models.ErrImageUploadFailed, err)
+
+	var started, exists bool
+	var leasedUntil sql.NullTime
+	if err := row.Scan(&started, &leasedUntil, &exists); err != nil {
+		return false, time.Time{}, err
+	}
+	if !exists {
+		return false, time.Time{}, models.ErrTaskNotFound
 	}
-	if err := r.str.UploadFile(fmt.Sprintf("%s/%s", models.ThumbnailKey, task.Path), task.Thumbnail.Raw, task.Thumbnail.Ext); err != nil {
-		return errors.Join(models.ErrImageUploadFailed, err)
+	if started {
+		return true, time.Time{}, nil
+	}
+	return false, leasedUntil.Time, nil
+}
+
+// FinishTask records the outcome of a failed attempt, status is pending for a
+// task to be retried or failed.
+func (r *Repository) FinishTask(id, status, message string) error {
+	ctx, canc := context.WithTimeout(context.Background(), 5*time.Second)
+	defer canc()
+
+	_, err := r.data.DB.ExecWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: time.Second, Backoff: 1},
+		`UPDATE tasks SET status = $2, error = $3, updated_at = NOW() WHERE id = $1 AND status <> 'completed'`, id, status, message)
+	return err
+}
+
+// GetOriginal fetches the original the gateway stored for the task.
+func (r *Repository) GetOriginal(task *models.KafkaTask) ([]byte, error) {
+	return r.str.DownloadFile(task.Key)
+}
+
+func (r *Repository) UploadImages(task *models.ProcessedTask) error {
+	for name, img := range task.Variants {
+		if err := r.str.UploadFile(fmt.Sprintf("%s/%s", name, task.Path), img.Raw, img.Ext); err != nil {
+			return errors.Join(models.ErrImageUploadFailed, err)
+		}
 	}
 
 	ctx, canc := context.WithTimeout(context.Background(), 5*time.Second)
 	defer canc()
 
-	_, err := r.data.DB.ExecWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: time.Second, Backoff: 1}, `UPDATE tasks SET status = 'completed', path = $1 WHERE id = $2`, task.Path, task.ID)
+	_, err := r.data.DB.ExecWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: time.Second, Backoff: 1}, `UPDATE tasks SET status = 'completed', path = $1, error = '', updated_at = NOW() WHERE id = $2`, task.Path, task.ID)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"app/internal/models"
+	"app/pkg/data"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+func newMockRepo(t *testing.T) (*Repository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return New(&data.Data{DB: &dbpg.DB{Master: db}}, nil), mock
+}
+
+func TestStartTask(t *testing.T) {
+	leasedUntil := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	tests := []struct {
+		name        string
+		started     bool
+		leasedUntil any
+		exists      bool
+		err         error
+	}{
+		{"pending", true, leasedUntil, true, nil},
+		{"held by another worker", false, leasedUntil, true, nil},
+		{"done or failed", false, nil, true, nil},
+		// the gateway hasn't committed the row yet, or it was deleted
+		{"missing", false, nil, false, models.ErrTaskNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newMockRepo(t)
+			mock.ExpectQuery(`WITH started AS \(\s*UPDATE tasks\s+SET status = 'processing'.*\)\s+SELECT EXISTS \(SELECT 1 FROM started\), \(SELECT updated_at \+ make_interval\(secs => \$2\) FROM tasks WHERE id = \$1 AND status = 'processing'\),\s+EXISTS \(SELECT 1 FROM tasks WHERE id = \$1\)`).
+				WithArgs("task", float64(300)).
+				WillReturnRows(sqlmock.NewRows([]string{"started", "leased_until", "exists"}).AddRow(tt.started, tt.leasedUntil, tt.exists))
+
+			started, until, err := repo.StartTask("task", 5*time.Minute)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got: %v", tt.err, err)
+			}
+			if started != tt.started {
+				t.Fatalf("expected started %v, got %v", tt.started, started)
+			}
+			// only a task someone else holds has a lease to wait for
+			if wait := !tt.started && tt.leasedUntil != nil; wait != !until.IsZero() || (wait && !until.Equal(leasedUntil)) {
+				t.Fatalf("unexpected lease end %v", until)
+			}
+		})
+	}
+}
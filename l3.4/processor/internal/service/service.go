@@ -2,90 +2,138 @@ package service
 
 import (
 	"app/internal/models"
-	"bytes"
-	"image"
-	"image/draw"
-	"image/jpeg"
-	"os"
+	"errors"
 	"time"
 
-	"github.com/disintegration/imaging"
 	"github.com/go-playground/validator/v10"
-	"github.com/google/uuid"
 )
 
 type RepositoryInterface interface {
+	StartTask(id string, lease time.Duration) (bool, time.Time, error)
+	FinishTask(id, status, message string) error
+	GetOriginal(*models.KafkaTask) ([]byte, error)
 	UploadImages(*models.ProcessedTask) error
 }
 
-type Service struct {
-	repo RepositoryInterface
-	vld  *validator.Validate
+type PipelineInterface interface {
+	Process(raw []byte, contentType string, variants map[string][]models.Operation) (map[string]models.Image, error)
 }
 
-func New(repo RepositoryInterface) *Service {
-	return &Service{repo, validator.New(validator.WithRequiredStructEnabled())}
+type RetryConfig struct {
+	MaxAttempts   int           `env:"TASK_MAX_ATTEMPTS" env-default:"5"`
+	RetryDelay    time.Duration `env:"TASK_RETRY_DELAY" env-default:"5s"`
+	MaxRetryDelay time.Duration `env:"TASK_MAX_RETRY_DELAY" env-default:"5m"`
+	// Lease is how long a task stays with the worker that took it. A task
+	// processing for longer is taken to be abandoned and can be started again.
+	Lease time.Duration `env:"TASK_LEASE" env-default:"5m"`
 }
 
-func (s Service) ProcessTask(task *models.KafkaTask) error {
-	time.Sleep(5 * time.Second)
-
-	path := uuid.NewString()
-
-	switch task.Ext {
-	case "image/jpeg":
-		img, err := jpeg.Decode(bytes.NewReader(task.Raw))
-		if err != nil {
-			return err
-		}
-
-		thumbnail := imaging.Resize(img, 150, 150, imaging.Lanczos)
-
-		watermarked := imaging.Clone(img)
-
-		if err = addWatermark(watermarked); err != nil {
-			return err
-		}
+// Valid checks that a task is attempted at least once and the delays make sense.
+func (cfg RetryConfig) Valid() error {
+	if cfg.MaxAttempts < 1 || cfg.RetryDelay <= 0 || cfg.MaxRetryDelay < cfg.RetryDelay || cfg.Lease <= 0 {
+		return models.ErrInvalidRetryCfg
+	}
+	return nil
+}
 
-		result := &models.ProcessedTask{ID: task.ID, Path: path, Original: task.Image}
+type Service struct {
+	repo     RepositoryInterface
+	pipeline PipelineInterface
+	cfg      RetryConfig
+	vld      *validator.Validate
+}
 
-		watermarkedBuf := new(bytes.Buffer)
-		if err := jpeg.Encode(watermarkedBuf, watermarked, &jpeg.Options{Quality: 95}); err != nil {
-			return err
-		}
-		result.Watermarked = models.Image{Raw: watermarkedBuf.Bytes(), Ext: "jpeg", Size: watermarkedBuf.Len()}
+func New(repo RepositoryInterface, pipeline PipelineInterface, cfg RetryConfig) *Service {
+	return &Service{repo, pipeline, cfg, validator.New(validator.WithRequiredStructEnabled())}
+}
 
-		thumbnailBuf := new(bytes.Buffer)
-		if err := jpeg.Encode(thumbnailBuf, thumbnail, &jpeg.Options{Quality: 85}); err != nil {
-			return err
+// ProcessTask makes the variants of a task. A task that is done is skipped,
+// so a redelivered message does no harm. A task another worker holds is put
+// off until its lease runs out: that worker may have crashed, and then this
+// message is the only one left for the task. A task with no row is retried
+// too, the message may have outrun the row, and dropped with ErrTaskNotFound
+// once it runs out of attempts. A failure is recorded on the task and the
+// returned error wraps either ErrTaskRetry, with task set up for its next
+// attempt, or ErrTaskFailed when the task is given up on.
+func (s Service) ProcessTask(task *models.KafkaTask) error {
+	started, leasedUntil, err := s.repo.StartTask(task.ID, s.cfg.Lease)
+	if errors.Is(err, models.ErrTaskNotFound) {
+		return s.putOff(task)
+	}
+	if err != nil {
+		return s.fail(task, err)
+	}
+	if !started {
+		if leasedUntil.IsZero() {
+			return nil
 		}
-		result.Thumbnail = models.Image{Raw: thumbnailBuf.Bytes(), Ext: "jpeg", Size: thumbnailBuf.Len()}
+		task.RetryAt = leasedUntil
+		return errors.Join(models.ErrTaskRetry, models.ErrTaskLeased)
+	}
 
-		return s.repo.UploadImages(result)
-	default:
-		return models.ErrInvalidImageExt
+	if err := s.process(task); err != nil {
+		return s.fail(task, err)
 	}
+	return nil
 }
 
-func addWatermark(img draw.Image) error {
-	watermarkFile, err := os.Open("./watermark.png")
+func (s Service) process(task *models.KafkaTask) error {
+	raw, err := s.repo.GetOriginal(task)
 	if err != nil {
 		return err
 	}
-	defer watermarkFile.Close()
 
-	watermark, _, err := image.Decode(watermarkFile)
+	variants, err := s.pipeline.Process(raw, task.ContentType, task.Variants)
 	if err != nil {
 		return err
 	}
 
-	bounds := img.Bounds()
-	watermarkBounds := watermark.Bounds()
+	// variants live next to the original under the task id
+	return s.repo.UploadImages(&models.ProcessedTask{ID: task.ID, Path: task.ID, Variants: variants})
+}
 
-	x := bounds.Max.X - watermarkBounds.Max.X - 10
-	y := bounds.Max.Y - watermarkBounds.Max.Y - 10
+// fail records a failed attempt. A task is retried until it runs out of
+// attempts, one that can never succeed fails right away.
+func (s Service) fail(task *models.KafkaTask, cause error) error {
+	task.Attempt++
 
-	draw.Draw(img, bounds, watermark, image.Point{-x, -y}, draw.Over)
+	if task.Attempt >= s.cfg.MaxAttempts || permanent(cause) {
+		err := s.repo.FinishTask(task.ID, models.StatusFailed, cause.Error())
+		return errors.Join(models.ErrTaskFailed, cause, err)
+	}
 
-	return nil
+	task.RetryAt = time.Now().Add(s.backoff(task.Attempt))
+	err := s.repo.FinishTask(task.ID, models.StatusPending, cause.Error())
+	return errors.Join(models.ErrTaskRetry, cause, err)
+}
+
+// putOff retries a task whose row isn't there. There is nothing to record
+// the attempt on, so it is only counted in the message.
+func (s Service) putOff(task *models.KafkaTask) error {
+	task.Attempt++
+	if task.Attempt >= s.cfg.MaxAttempts {
+		return models.ErrTaskNotFound
+	}
+	task.RetryAt = time.Now().Add(s.backoff(task.Attempt))
+	return errors.Join(models.ErrTaskRetry, models.ErrTaskNotFound)
+}
+
+// backoff doubles the delay with every failed attempt up to MaxRetryDelay.
+func (s Service) backoff(attempt int) time.Duration {
+	delay := s.cfg.RetryDelay
+	for i := 1; i < attempt && delay < s.cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, s.cfg.MaxRetryDelay)
+}
+
+// permanent tells the errors of the image or its operations, which fail the
+// same way on every attempt.
+func permanent(err error) bool {
+	for _, target := range []error{models.ErrInvalidImageExt, models.ErrTooManyPixels, models.ErrInvalidOperation, models.ErrInvalidWatermark} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"app/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeRepo struct {
+	// missing is how many times the row is not there yet
+	missing     int
+	started     bool
+	leasedUntil time.Time
+	startErr    error
+	originErr   error
+	uploaded    *models.ProcessedTask
+	finished    []string
+	lastReason  string
+}
+
+func (r *fakeRepo) StartTask(id string, lease time.Duration) (bool, time.Time, error) {
+	if r.missing > 0 {
+		r.missing--
+		return false, time.Time{}, models.ErrTaskNotFound
+	}
+	return r.started, r.leasedUntil, r.startErr
+}
+
+func (r *fakeRepo) FinishTask(id, status, message string) error {
+	r.finished = append(r.finished, status)
+	r.lastReason = message
+	return nil
+}
+
+func (r *fakeRepo) GetOriginal(task *models.KafkaTask) ([]byte, error) {
+	return []byte("original"), r.originErr
+}
+
+func (r *fakeRepo) UploadImages(task *models.ProcessedTask) error {
+	r.uploaded = task
+	return nil
+}
+
+type fakePipeline struct {
+	err error
+}
+
+func (p *fakePipeline) Process(raw []byte, contentType string, variants map[string][]models.Operation) (map[string]models.Image, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	result := make(map[string]models.Image, len(variants))
+	for name := range variants {
+		result[name] = models.Image{Raw: raw, Ext: contentType, Size: len(raw)}
+	}
+	return result, nil
+}
+
+var testRetryConfig = RetryConfig{MaxAttempts: 3, RetryDelay: time.Second, MaxRetryDelay: 3 * time.Second, Lease: time.Minute}
+
+func newTask(attempt int) *models.KafkaTask {
+	return &models.KafkaTask{
+		ID:          "task",
+		Key:         "original/task",
+		ContentType: "image/jpeg",
+		Variants:    map[string][]models.Operation{models.ThumbnailKey: nil},
+		Attempt:     attempt,
+	}
+}
+
+func TestProcessTask(t *testing.T) {
+	repo := &fakeRepo{started: true}
+	s := New(repo, &fakePipeline{}, testRetryConfig)
+
+	if err := s.ProcessTask(newTask(0)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repo.uploaded == nil || repo.uploaded.Path != "task" || len(repo.uploaded.Variants) != 1 {
+		t.Fatalf("expected the thumbnail to be uploaded under the task id, got %+v", repo.uploaded)
+	}
+	if len(repo.finished) != 0 {
+		t.Fatalf("expected no failure to be recorded, got %v", repo.finished)
+	}
+}
+
+func TestProcessTaskSkipsTakenTask(t *testing.T) {
+	repo := &fakeRepo{started: false, originErr: errors.New("must not be fetched")}
+	s := New(repo, &fakePipeline{}, testRetryConfig)
+
+	if err := s.ProcessTask(newTask(0)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repo.uploaded != nil || len(repo.finished) != 0 {
+		t.Fatal("expected a task that is done or taken to be left alone")
+	}
+}
+
+func TestProcessTaskPutsOffLeasedTask(t *testing.T) {
+	// a redelivery while the worker that took the task still holds it, the
+	// worker may have crashed and this message is the only one left
+	leasedUntil := time.Now().Add(2 * time.Minute)
+	repo := &fakeRepo{started: false, leasedUntil: leasedUntil, originErr: errors.New("must not be fetched")}
+	s := New(repo, &fakePipeline{}, testRetryConfig)
+
+	task := newTask(1)
+	err := s.ProcessTask(task)
+	if !errors.Is(err, models.ErrTaskRetry) || !errors.Is(err, models.ErrTaskLeased) {
+		t.Fatalf("expected the task to be put off, got %v", err)
+	}
+	if !task.RetryAt.Equal(leasedUntil) {
+		t.Fatalf("expected a retry when the lease runs out at %v, got %v", leasedUntil, task.RetryAt)
+	}
+	if task.Attempt != 1 || repo.uploaded != nil || len(repo.finished) != 0 {
+		t.Fatal("expected a put off task not to count as an attempt")
+	}
+}
+
+func TestProcessTaskAheadOfCommit(t *testing.T) {
+	// the message is read before the gateway committed the row
+	repo := &fakeRepo{missing: 1, started: true}
+	s := New(repo, &fakePipeline{}, testRetryConfig)
+
+	task := newTask(0)
+	err := s.ProcessTask(task)
+	if !errors.Is(err, models.ErrTaskRetry) || !errors.Is(err, models.ErrTaskNotFound) {
+		t.Fatalf("expected the task to be retried, got %v", err)
+	}
+	if task.Attempt != 1 || task.RetryAt.IsZero() || repo.uploaded != nil || len(repo.finished) != 0 {
+		t.Fatalf("expected a retry without a recorded failure, got attempt %d at %v", task.Attempt, task.RetryAt)
+	}
+
+	// the retry comes after the commit
+	if err := s.ProcessTask(task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if repo.uploaded == nil {
+		t.Fatal("expected the task to be processed once the row is there")
+	}
+}
+
+func TestProcessTaskDropsMissingTask(t *testing.T) {
+	repo := &fakeRepo{missing: testRetryConfig.MaxAttempts}
+	s := New(repo, &fakePipeline{}, testRetryConfig)
+
+	task := newTask(0)
+	for range testRetryConfig.MaxAttempts - 1 {
+		if err := s.ProcessTask(task); !errors.Is(err, models.ErrTaskRetry) {
+			t.Fatalf("attempt %d: expected a retry, got %v", task.Attempt, err)
+		}
+	}
+	err := s.ProcessTask(task)
+	if !errors.Is(err, models.ErrTaskNotFound) || errors.Is(err, models.ErrTaskRetry) || errors.Is(err, models.ErrTaskFailed) {
+		t.Fatalf("expected the task to be dropped after %d attempts, got %v", testRetryConfig.MaxAttempts, err)
+	}
+	if len(repo.finished) != 0 {
+		t.Fatalf("expected nothing to be recorded for a missing task, got %v", repo.finished)
+	}
+}
+
+func TestProcessTaskFailures(t *testing.T) {
+	transient := errors.New("storage is down")
+
+	tests := []struct {
+		name     string
+		repo     *fakeRepo
+		pipeline *fakePipeline
+		attempt  int
+		err      error
+		status   string
+		delay    time.Duration
+	}{
+		{"transient", &fakeRepo{started: true, originErr: transient}, &fakePipeline{}, 0, models.ErrTaskRetry, models.StatusPending, time.Second},
+		{"transient again", &fakeRepo{started: true, originErr: transient}, &fakePipeline{}, 1, models.ErrTaskRetry, models.StatusPending, 2 * time.Second},
+		{"database down", &fakeRepo{startErr: transient}, &fakePipeline{}, 0, models.ErrTaskRetry, models.StatusPending, time.Second},
+		{"out of attempts", &fakeRepo{started: true, originErr: transient}, &fakePipeline{}, 2, models.ErrTaskFailed, models.StatusFailed, 0},
+		{"broken image", &fakeRepo{started: true}, &fakePipeline{err: models.ErrInvalidImageExt}, 0, models.ErrTaskFailed, models.StatusFailed, 0},
+		{"too large", &fakeRepo{started: true}, &fakePipeline{err: models.ErrTooManyPixels}, 0, models.ErrTaskFailed, models.StatusFailed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(tt.repo, tt.pipeline, testRetryConfig)
+			task := newTask(tt.attempt)
+
+			before := time.Now()
+			err := s.ProcessTask(task)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if task.Attempt != tt.attempt+1 {
+				t.Fatalf("expected attempt %d, got %d", tt.attempt+1, task.Attempt)
+			}
+			if len(tt.repo.finished) != 1 || tt.repo.finished[0] != tt.status {
+				t.Fatalf("expected the task to be %s, got %v", tt.status, tt.repo.finished)
+			}
+			if tt.repo.lastReason == "" {
+				t.Fatal("expected the error to be recorded")
+			}
+
+			if tt.delay == 0 {
+				if !task.RetryAt.IsZero() {
+					t.Fatalf("expected no retry, got one at %v", task.RetryAt)
+				}
+				return
+			}
+			if wait := task.RetryAt.Sub(before); wait < tt.delay || wait > tt.delay+time.Second {
+				t.Fatalf("expected a retry in %v, got %v", tt.delay, wait)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := New(&fakeRepo{}, &fakePipeline{}, testRetryConfig)
+
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	for i, delay := range want {
+		if got := s.backoff(i + 1); got != delay {
+			t.Fatalf("attempt %d: expected %v, got %v", i+1, delay, got)
+		}
+	}
+}
+
+func TestRetryConfigValid(t *testing.T) {
+	if err := testRetryConfig.Valid(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bad := []RetryConfig{
+		{MaxAttempts: 0, RetryDelay: time.Second, MaxRetryDelay: time.Second, Lease: time.Minute},
+		{MaxAttempts: 3, RetryDelay: 0, MaxRetryDelay: time.Second, Lease: time.Minute},
+		{MaxAttempts: 3, RetryDelay: time.Minute, MaxRetryDelay: time.Second, Lease: time.Minute},
+		{MaxAttempts: 3, RetryDelay: time.Second, MaxRetryDelay: time.Second},
+	}
+	for _, cfg := range bad {
+		if err := cfg.Valid(); !errors.Is(err, models.ErrInvalidRetryCfg) {
+			t.Fatalf("expected ErrInvalidRetryCfg for %+v, got %v", cfg, err)
+		}
+	}
+}
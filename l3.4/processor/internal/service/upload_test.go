@@ -0,0 +1,93 @@
+package service_test
+
+import (
+	"app/internal/models"
+	"app/internal/repository"
+	"app/internal/service"
+	"app/pkg/data"
+	"app/pkg/pipeline"
+	"app/pkg/storage"
+	"bytes"
+	"encoding/json"
+	"image"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+// The upload fixtures are shared with the gateway, whose test checks that an
+// upload of uploadImage queues exactly uploadTask.
+const (
+	uploadImage = "../../../testdata/upload.png"
+	uploadTask  = "../../../testdata/upload_task.json"
+)
+
+func TestProcessUploadedTask(t *testing.T) {
+	raw, err := os.ReadFile(uploadImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := os.ReadFile(uploadTask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var task models.KafkaTask
+	if err := json.Unmarshal(msg, &task); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(server.Close)
+	str := storage.New(storage.StorageConfig{
+		Endpoint: strings.TrimPrefix(server.URL, "http://"), AccessKey: "minioadmin", SecretKey: "minioadmin",
+		BucketName: "images", Region: "us-east-1",
+	})
+	// the gateway stored the original under the key
+	if err := str.UploadFile(task.Key, raw, task.ContentType); err != nil {
+		t.Fatal(err)
+	}
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	mock.ExpectQuery(`WITH started AS`).
+		WithArgs(task.ID, float64(60)).
+		WillReturnRows(sqlmock.NewRows([]string{"started", "leased_until", "exists"}).AddRow(true, time.Now().Add(time.Minute), true))
+	mock.ExpectExec(`UPDATE tasks SET status = 'completed', path = \$1`).
+		WithArgs(task.ID, task.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	repo := repository.New(&data.Data{DB: &dbpg.DB{Master: db}}, str)
+	pipe := pipeline.New(pipeline.PipelineConfig{WatermarkFile: "../../watermark.png", MaxPixels: 1000000})
+	s := service.New(repo, pipe, service.RetryConfig{MaxAttempts: 1, RetryDelay: time.Second, MaxRetryDelay: time.Second, Lease: time.Minute})
+
+	if err := s.ProcessTask(&task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, size := range map[string]image.Point{models.ThumbnailKey: image.Pt(150, 150), models.WatermarkedKey: image.Pt(64, 48)} {
+		variant, err := str.DownloadFile(name + "/" + task.ID)
+		if err != nil {
+			t.Fatalf("%s: failed to read the variant: %v", name, err)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(variant))
+		if err != nil {
+			t.Fatalf("%s: failed to decode the variant: %v", name, err)
+		}
+		if format != "png" || image.Pt(cfg.Width, cfg.Height) != size {
+			t.Fatalf("%s: expected a %v png, got a %dx%d %s", name, size, cfg.Width, cfg.Height, format)
+		}
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"app/pkg/logger"
 	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/segmentio/kafka-go"
+	wbkafka "github.com/wb-go/wbf/kafka"
 	"github.com/wb-go/wbf/retry"
 )
 
@@ -30,47 +32,114 @@ type Consumer struct {
 	ctx     context.Context
 	brk     *broker.Broker
 	ch      chan kafka.Message
+	retryCh chan kafka.Message
 }
 
 func New(service ServiceInterface, cfg *ConsumerConfig, ctx context.Context, brk *broker.Broker) *Consumer {
 	return &Consumer{
 		service: service,
 		// ctx:     context.WithValue(context.WithValue(ctx, WorkerCountKey, cfg.WorkerCount), logger.LoggerKey, logger.LoggerFromCtx(ctx).LoggerLevel(cfg.LogLevel)),
-		ctx: context.WithValue(ctx, WorkerCountKey, cfg.WorkerCount),
-		brk: brk,
-		ch:  make(chan kafka.Message),
+		ctx:     context.WithValue(ctx, WorkerCountKey, cfg.WorkerCount),
+		brk:     brk,
+		ch:      make(chan kafka.Message),
+		retryCh: make(chan kafka.Message),
 	}
 }
 
-func (c *Consumer) Consume() {
+// Consume handles the messages read by cons. Tasks from the retry topic wait
+// for their turn first.
+func (c *Consumer) Consume(cons *wbkafka.Consumer, ch <-chan kafka.Message) {
+	for msg := range ch {
+		c.handle(cons, msg)
+	}
+}
+
+// handle processes a task and routes a failed one to the retry or the dead
+// letter topic. The message is committed once the task is done with or
+// forwarded, a message left uncommitted comes back after a restart.
+func (c *Consumer) handle(cons *wbkafka.Consumer, msg kafka.Message) {
 	lg := logger.LoggerFromCtx(c.ctx).Lg
 
-	for msg := range c.ch {
-		var task models.KafkaTask
-		if err := json.Unmarshal(msg.Value, &task); err != nil {
-			lg.Error().Err(err).Msg("failed to unmarshal Kafka message")
-			continue
+	var task models.KafkaTask
+	if err := json.Unmarshal(msg.Value, &task); err != nil {
+		lg.Error().Err(err).Msg("failed to unmarshal Kafka message")
+		if err := c.send(c.brk.DLQProducer, msg.Key, &models.FailedTask{Raw: string(msg.Value), Error: err.Error(), FailedAt: time.Now()}); err != nil {
+			lg.Error().Err(err).Msg("failed to dead letter Kafka message")
+			return
 		}
+		c.commit(cons, msg, "")
+		return
+	}
 
-		if err := c.service.ProcessTask(&task); err != nil {
-			lg.Error().Err(err).Str("task_id", task.ID).Msg("failed to process task")
-			continue
+	if wait := time.Until(task.RetryAt); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-c.ctx.Done():
+			return
 		}
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	err := c.service.ProcessTask(&task)
+	switch {
+	case err == nil:
+	case errors.Is(err, models.ErrTaskLeased):
+		lg.Info().Str("task_id", task.ID).Time("retry_at", task.RetryAt).Msg("task is held by another worker, checking again when its lease runs out")
+		if err := c.send(c.brk.RetryProducer, msg.Key, &task); err != nil {
+			lg.Error().Err(err).Str("task_id", task.ID).Msg("failed to queue task for retry")
+			return
+		}
+	case errors.Is(err, models.ErrTaskRetry):
+		lg.Warn().Err(err).Str("task_id", task.ID).Int("attempt", task.Attempt).Time("retry_at", task.RetryAt).Msg("failed to process task, retrying")
+		if err := c.send(c.brk.RetryProducer, msg.Key, &task); err != nil {
+			lg.Error().Err(err).Str("task_id", task.ID).Msg("failed to queue task for retry")
+			return
+		}
+	case errors.Is(err, models.ErrTaskNotFound):
+		// the gateway never committed the task or it was deleted since
+		lg.Warn().Str("task_id", task.ID).Int("attempt", task.Attempt).Msg("task not found, dropping")
+	case errors.Is(err, models.ErrTaskFailed):
+		lg.Error().Err(err).Str("task_id", task.ID).Int("attempt", task.Attempt).Msg("failed to process task, giving up")
+		failed := &models.FailedTask{Task: &task, Error: err.Error(), Attempts: task.Attempt, FailedAt: time.Now()}
+		if err := c.send(c.brk.DLQProducer, msg.Key, failed); err != nil {
+			lg.Error().Err(err).Str("task_id", task.ID).Msg("failed to dead letter task")
+			return
+		}
+	default:
+		lg.Error().Err(err).Str("task_id", task.ID).Msg("failed to process task")
+		return
+	}
 
-		err := retry.DoContext(ctx, retry.Strategy{Attempts: 3, Delay: time.Second, Backoff: 1}, func() error {
-			ctxPerCommit, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-			defer cancel()
-			return c.brk.Consumer.Commit(ctxPerCommit, msg)
-		})
+	c.commit(cons, msg, task.ID)
+}
 
-		if err != nil {
-			lg.Error().Err(err).Str("task_id", task.ID).Msg("failed to commit Kafka message")
-		} else {
-			lg.Debug().Str("task_id", task.ID).Msg("task processed successfully")
-		}
+func (c *Consumer) send(producer *wbkafka.Producer, key []byte, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return producer.SendWithRetry(ctx, retry.Strategy{Attempts: 3, Delay: time.Second, Backoff: 2}, key, data)
+}
+
+func (c *Consumer) commit(cons *wbkafka.Consumer, msg kafka.Message, taskID string) {
+	lg := logger.LoggerFromCtx(c.ctx).Lg
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := retry.DoContext(ctx, retry.Strategy{Attempts: 3, Delay: time.Second, Backoff: 1}, func() error {
+		ctxPerCommit, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		return cons.Commit(ctxPerCommit, msg)
+	})
+
+	if err != nil {
+		lg.Error().Err(err).Str("task_id", taskID).Msg("failed to commit Kafka message")
+	} else {
+		lg.Debug().Str("task_id", taskID).Msg("task handled")
 	}
 }
 
@@ -79,11 +148,13 @@ func (c *Consumer) Start() {
 	lg := logger.LoggerFromCtx(c.ctx).Lg
 
 	for range c.ctx.Value(WorkerCountKey).(int) {
-		go c.Consume()
+		go c.Consume(c.brk.Consumer, c.ch)
+		go c.Consume(c.brk.RetryConsumer, c.retryCh)
 	}
 
 	lg.Info().Msg("consumer starting...")
 	c.brk.Consumer.StartConsuming(c.ctx, c.ch, retry.Strategy{Attempts: 3, Delay: 2, Backoff: 1.5})
+	c.brk.RetryConsumer.StartConsuming(c.ctx, c.retryCh, retry.Strategy{Attempts: 3, Delay: 2, Backoff: 1.5})
 }
 
 func (c *Consumer) Stop() {
@@ -92,10 +163,14 @@ func (c *Consumer) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	err := retry.DoContext(ctx, retry.Strategy{Attempts: 3, Delay: 2, Backoff: 1.5}, c.brk.Consumer.Close)
-	if err != nil {
-		lg.Error().Err(err).Msg("failed to close consumer")
-	} else {
+	closed := true
+	for _, closeFn := range []func() error{c.brk.Consumer.Close, c.brk.RetryConsumer.Close, c.brk.RetryProducer.Close, c.brk.DLQProducer.Close} {
+		if err := retry.DoContext(ctx, retry.Strategy{Attempts: 3, Delay: 2, Backoff: 1.5}, closeFn); err != nil {
+			lg.Error().Err(err).Msg("failed to close consumer")
+			closed = false
+		}
+	}
+	if closed {
 		lg.Info().Msg("consumer closed")
 	}
 }
@@ -0,0 +1,4 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '[]';
+
+-- tasks from before variants got the two fixed ones
+UPDATE tasks SET variants = '["thumbnail", "watermarked"]' WHERE variants = '[]';
@@ -0,0 +1,5 @@
+ALTER TABLE tasks
+    DROP COLUMN IF EXISTS attempts,
+    DROP COLUMN IF EXISTS error,
+    DROP COLUMN IF EXISTS task,
+    DROP COLUMN IF EXISTS updated_at;
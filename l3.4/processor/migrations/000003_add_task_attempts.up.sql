@@ -0,0 +1,7 @@
+-- task keeps the queued message so a failed task can be queued again, it is
+-- null for tasks from before retries
+ALTER TABLE tasks
+    ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS error TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS task JSONB,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
@@ -11,14 +11,25 @@ type BrokerConfig struct {
 	BrokerPort  string `env:"KAFKA_PORT" env-default:"9092"`
 	BrokerTopic string `env:"KAFKA_TOPIC" env-default:"tasks"`
 	BrokerGroup string `env:"KAFKA_GROUP" env-default:"processor-group"`
+	// RetryTopic holds failed tasks waiting for another attempt.
+	RetryTopic string `env:"KAFKA_RETRY_TOPIC" env-default:"tasks-retry"`
+	// DeadLetterTopic gets the tasks given up on.
+	DeadLetterTopic string `env:"KAFKA_DLQ_TOPIC" env-default:"tasks-dlq"`
 }
 
 type Broker struct {
-	Consumer *kafka.Consumer
+	Consumer      *kafka.Consumer
+	RetryConsumer *kafka.Consumer
+	RetryProducer *kafka.Producer
+	DLQProducer   *kafka.Producer
 }
 
 func New(cfg BrokerConfig) *Broker {
+	brokers := []string{fmt.Sprintf("%s:%s", cfg.BrokerHost, cfg.BrokerPort)}
 	return &Broker{
-		Consumer: kafka.NewConsumer([]string{fmt.Sprintf("%s:%s", cfg.BrokerHost, cfg.BrokerPort)}, cfg.BrokerTopic, cfg.BrokerGroup),
+		Consumer:      kafka.NewConsumer(brokers, cfg.BrokerTopic, cfg.BrokerGroup),
+		RetryConsumer: kafka.NewConsumer(brokers, cfg.RetryTopic, cfg.BrokerGroup),
+		RetryProducer: kafka.NewProducer(brokers, cfg.RetryTopic),
+		DLQProducer:   kafka.NewProducer(brokers, cfg.DeadLetterTopic),
 	}
 }
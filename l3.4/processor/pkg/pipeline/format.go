@@ -0,0 +1,309 @@
+package pipeline
+
+import (
+	"app/internal/models"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// inputFormat is an image format originals may be in, told apart by the
+// magic bytes at the start of the file. name is the output format variants
+// are encoded as unless they ask for another one.
+type inputFormat struct {
+	contentType  string
+	name         string
+	magic        func(head []byte) bool
+	decodeConfig func(io.Reader) (image.Config, error)
+	decode       func(io.Reader) (image.Image, error)
+}
+
+var inputFormats = []inputFormat{
+	{"image/jpeg", "jpeg", prefix("\xff\xd8\xff"), jpeg.DecodeConfig, jpeg.Decode},
+	{"image/png", "png", prefix("\x89PNG\r\n\x1a\n"), png.DecodeConfig, png.Decode},
+	{"image/gif", "gif", prefix("GIF87a", "GIF89a"), gif.DecodeConfig, gif.Decode},
+	{"image/webp", "webp", riff("WEBP"), webp.DecodeConfig, webp.Decode},
+	{"image/bmp", "bmp", prefix("BM"), bmp.DecodeConfig, bmp.Decode},
+	{"image/tiff", "tiff", prefix("II*\x00", "MM\x00*"), tiff.DecodeConfig, tiff.Decode},
+}
+
+// contentTypes are the output formats and what they are stored as.
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"bmp":  "image/bmp",
+	"tiff": "image/tiff",
+}
+
+func prefix(magics ...string) func([]byte) bool {
+	return func(head []byte) bool {
+		for _, magic := range magics {
+			if bytes.HasPrefix(head, []byte(magic)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func riff(kind string) func([]byte) bool {
+	return func(head []byte) bool {
+		return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == kind
+	}
+}
+
+// source is a decoded original. image is the first frame, anim is set for an
+// animated gif. orientation is the EXIF orientation used by auto_orient.
+type source struct {
+	image       image.Image
+	anim        *gif.GIF
+	format      string
+	orientation int
+}
+
+// decode checks that raw is what the gateway said it is and that it is within
+// the pixel limit before decoding it. Every frame of a gif is only decoded when
+// animated is set, the limit then holds for all of them.
+func (p *Pipeline) decode(raw []byte, contentType string, animated bool) (*source, error) {
+	var format *inputFormat
+	for i := range inputFormats {
+		if inputFormats[i].magic(raw) {
+			format = &inputFormats[i]
+			break
+		}
+	}
+	if format == nil || format.contentType != contentType {
+		return nil, fmt.Errorf("%w: expected %s", models.ErrInvalidImageExt, contentType)
+	}
+
+	cfg, err := format.decodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Join(models.ErrInvalidImageExt, err)
+	}
+	if err := p.validPixels(cfg.Width, cfg.Height, 1); err != nil {
+		return nil, err
+	}
+
+	if format.name == "gif" {
+		return p.decodeGif(raw, cfg, animated)
+	}
+
+	img, err := format.decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Join(models.ErrInvalidImageExt, err)
+	}
+	return &source{image: img, format: format.name, orientation: Orientation(raw)}, nil
+}
+
+// decodeGif counts the frames before decoding them, a small file may hold
+// thousands of frames that each decompress to the whole canvas.
+func (p *Pipeline) decodeGif(raw []byte, cfg image.Config, animated bool) (*source, error) {
+	n, err := gifFrames(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if !animated || n < 2 {
+		first, err := gif.Decode(bytes.NewReader(raw))
+		if err != nil {
+			return nil, errors.Join(models.ErrInvalidImageExt, err)
+		}
+		paletted, ok := first.(*image.Paletted)
+		if !ok {
+			return nil, fmt.Errorf("%w: unexpected gif frame %T", models.ErrInvalidImageExt, first)
+		}
+		anim := &gif.GIF{Image: []*image.Paletted{paletted}, Config: cfg}
+		return &source{image: coalesce(anim, 1)[0], format: "gif"}, nil
+	}
+
+	if err := p.validPixels(cfg.Width, cfg.Height, n); err != nil {
+		return nil, err
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(raw))
+	if err != nil {
+		return nil, errors.Join(models.ErrInvalidImageExt, err)
+	}
+	return &source{image: coalesce(anim, 1)[0], anim: anim, format: "gif"}, nil
+}
+
+// gifFrames counts the image descriptors of a gif by walking its blocks,
+// without decompressing any of them.
+func gifFrames(raw []byte) (int, error) {
+	truncated := fmt.Errorf("%w: truncated gif", models.ErrInvalidImageExt)
+	// header and logical screen descriptor
+	const screenEnd = 13
+	if len(raw) < screenEnd {
+		return 0, truncated
+	}
+	pos := screenEnd + colorTableSize(raw[10])
+
+	frames := 0
+	for pos < len(raw) {
+		switch raw[pos] {
+		case 0x21:
+			// extension introducer and label
+			pos += 2
+		case 0x2c:
+			// image descriptor, local color table and lzw code size
+			if pos+10 > len(raw) {
+				return 0, truncated
+			}
+			pos += 10 + colorTableSize(raw[pos+9]) + 1
+			frames++
+		case 0x3b:
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("%w: unknown gif block %#x", models.ErrInvalidImageExt, raw[pos])
+		}
+		// data sub-blocks up to the empty one
+		for pos < len(raw) && raw[pos] != 0 {
+			pos += int(raw[pos]) + 1
+		}
+		pos++
+	}
+	return 0, truncated
+}
+
+// colorTableSize is the size of the color table a gif flags byte announces.
+func colorTableSize(flags byte) int {
+	if flags&0x80 == 0 {
+		return 0
+	}
+	return 3 << (flags&0x07 + 1)
+}
+
+func (p *Pipeline) validPixels(width, height, frames int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("%w: %dx%d", models.ErrInvalidImageExt, width, height)
+	}
+	if pixels := int64(width) * int64(height) * int64(frames); pixels > p.maxPixels {
+		return fmt.Errorf("%w: %dx%d in %d frames is more than %d", models.ErrTooManyPixels, width, height, frames, p.maxPixels)
+	}
+	return nil
+}
+
+// frames returns every frame of the animation drawn in full, as long as
+// they are within the pixel limit together.
+func (p *Pipeline) frames(anim *gif.GIF) ([]image.Image, error) {
+	if err := p.validPixels(anim.Config.Width, anim.Config.Height, len(anim.Image)); err != nil {
+		return nil, err
+	}
+	return coalesce(anim, len(anim.Image)), nil
+}
+
+// coalesce draws the first n frames of a gif onto its canvas, since a frame
+// may only hold what changed since the previous one.
+func coalesce(anim *gif.GIF, n int) []image.Image {
+	canvas := image.NewNRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+	frames := make([]image.Image, 0, n)
+
+	for i, frame := range anim.Image[:n] {
+		var previous *image.NRGBA
+		disposal := byte(gif.DisposalNone)
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = imaging.Clone(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames = append(frames, imaging.Clone(canvas))
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+// output is how a variant is encoded.
+type output struct {
+	format  string
+	quality int
+	frames  string
+}
+
+func encode(frames []image.Image, anim *gif.GIF, out output) (*models.Image, error) {
+	contentType, ok := contentTypes[out.format]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown format %q", models.ErrInvalidOperation, out.format)
+	}
+
+	buf := new(bytes.Buffer)
+	img := frames[0]
+	var err error
+	switch out.format {
+	case "jpeg":
+		err = jpeg.Encode(buf, flatten(img), &jpeg.Options{Quality: out.quality})
+	case "png":
+		err = png.Encode(buf, img)
+	case "gif":
+		if len(frames) > 1 {
+			err = gif.EncodeAll(buf, animation(frames, anim))
+		} else {
+			err = gif.Encode(buf, paletted(img), nil)
+		}
+	case "webp":
+		err = nativewebp.Encode(buf, img, nil)
+	case "bmp":
+		err = bmp.Encode(buf, img)
+	case "tiff":
+		err = tiff.Encode(buf, img, &tiff.Options{Compression: tiff.Deflate})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", out.format, err)
+	}
+
+	return &models.Image{Raw: buf.Bytes(), Ext: contentType, Size: buf.Len()}, nil
+}
+
+// flatten puts an image with transparency on white, jpeg has no alpha.
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	bounds := img.Bounds()
+	return imaging.Overlay(imaging.New(bounds.Dx(), bounds.Dy(), color.White), img, image.Point{}, 1)
+}
+
+// gifPalette keeps a transparent color so transparency survives in a gif.
+var gifPalette = append(color.Palette{color.Transparent}, palette.Plan9[:255]...)
+
+func paletted(img image.Image) *image.Paletted {
+	bounds := img.Bounds()
+	out := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), gifPalette)
+	draw.FloydSteinberg.Draw(out, out.Bounds(), img, bounds.Min)
+	return out
+}
+
+// animation encodes full frames with the timing of the original, each frame
+// replaces the previous one.
+func animation(frames []image.Image, anim *gif.GIF) *gif.GIF {
+	out := &gif.GIF{LoopCount: anim.LoopCount}
+	for i, frame := range frames {
+		out.Image = append(out.Image, paletted(frame))
+		out.Delay = append(out.Delay, anim.Delay[i])
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+	}
+	return out
+}
@@ -0,0 +1,247 @@
+package pipeline
+
+import (
+	"app/internal/models"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"unicode/utf8"
+
+	"github.com/disintegration/imaging"
+)
+
+var (
+	resizeModes = map[string]bool{"": true, "fit": true, "fill": true, "crop": true}
+	positions   = map[string]bool{"": true, "top-left": true, "top-right": true, "bottom-left": true, "bottom-right": true, "center": true}
+	formats     = map[string]bool{"jpeg": true, "png": true, "gif": true, "webp": true, "bmp": true, "tiff": true}
+	frameModes  = map[string]bool{"": true, models.FramesAll: true, models.FramesFirst: true}
+)
+
+type PipelineConfig struct {
+	WatermarkFile string `env:"WATERMARK_FILE" env-default:"./watermark.png"`
+	// MaxPixels limits width times height of an original, summed over the
+	// frames of an animation, so a small file can't decompress into a huge
+	// image.
+	MaxPixels int64 `env:"MAX_PIXELS" env-default:"50000000"`
+}
+
+// Valid checks that some pixels are allowed.
+func (cfg PipelineConfig) Valid() error {
+	if cfg.MaxPixels <= 0 {
+		return models.ErrInvalidMaxPixels
+	}
+	return nil
+}
+
+type Pipeline struct {
+	watermark image.Image
+	maxPixels int64
+}
+
+func New(cfg PipelineConfig) *Pipeline {
+	watermark, err := imaging.Open(cfg.WatermarkFile)
+	if err != nil {
+		panic(fmt.Sprintf("failed to open watermark: %v", err))
+	}
+	return &Pipeline{watermark: watermark, maxPixels: cfg.MaxPixels}
+}
+
+// Process decodes the original and makes every variant of it.
+func (p *Pipeline) Process(raw []byte, contentType string, variants map[string][]models.Operation) (map[string]models.Image, error) {
+	animated := false
+	for _, ops := range variants {
+		if out := outputOf(ops, "gif"); out.format == "gif" && out.frames != models.FramesFirst {
+			animated = true
+		}
+	}
+	src, err := p.decode(raw, contentType, animated)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]models.Image, len(variants))
+	for name, ops := range variants {
+		img, err := p.variant(src, ops)
+		if err != nil {
+			return nil, fmt.Errorf("variant %s: %w", name, err)
+		}
+		result[name] = *img
+	}
+	return result, nil
+}
+
+// variant applies ops to src in order and encodes the result. A gif made from
+// an animation keeps all its frames unless asked for the first one.
+func (p *Pipeline) variant(src *source, ops []models.Operation) (*models.Image, error) {
+	if len(ops) > models.MaxOperations {
+		return nil, fmt.Errorf("%w: more than %d operations", models.ErrInvalidOperation, models.MaxOperations)
+	}
+
+	for i, op := range ops {
+		if err := Validate(op); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+	}
+	out := outputOf(ops, src.format)
+
+	images := []image.Image{src.image}
+	if src.anim != nil && out.format == "gif" && out.frames != models.FramesFirst {
+		all, err := p.frames(src.anim)
+		if err != nil {
+			return nil, err
+		}
+		images = all
+	}
+
+	size := src.image.Bounds().Size()
+	for i, op := range ops {
+		// the image can only grow by a resize or a rotation, so the next
+		// size is checked before any frame is made
+		size = targetSize(size, op)
+		if err := p.validPixels(size.X, size.Y, len(images)); err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i+1, err)
+		}
+
+		var mark image.Image
+		if op.Op == models.OpWatermark {
+			var err error
+			if mark, err = p.mark(op); err != nil {
+				return nil, err
+			}
+		}
+
+		for i, img := range images {
+			switch op.Op {
+			case models.OpResize:
+				img = resize(img, op)
+			case models.OpRotate:
+				// imaging rotates counter-clockwise
+				img = imaging.Rotate(img, -op.Angle, color.Transparent)
+			case models.OpAutoOrient:
+				img = orient(img, src.orientation)
+			case models.OpBlur:
+				img = imaging.Blur(img, op.Sigma)
+			case models.OpGrayscale:
+				img = imaging.Grayscale(img)
+			case models.OpWatermark:
+				img = watermark(img, mark, op)
+			}
+			images[i] = img
+		}
+	}
+
+	return encode(images, src.anim, out)
+}
+
+// outputOf is how ops encode an image in format, the last format operation wins.
+func outputOf(ops []models.Operation, format string) output {
+	out := output{format: format, quality: models.DefaultJpegQuality}
+	for _, op := range ops {
+		if op.Op == models.OpFormat {
+			out = output{format: op.Format, quality: models.DefaultJpegQuality, frames: op.Frames}
+			if op.Quality > 0 {
+				out.quality = op.Quality
+			}
+		}
+	}
+	return out
+}
+
+// targetSize is the size of an image of size after op.
+func targetSize(size image.Point, op models.Operation) image.Point {
+	switch op.Op {
+	case models.OpResize:
+		w, h := op.Width, op.Height
+		switch {
+		case op.Mode == "fill":
+			return image.Pt(w, h)
+		case op.Mode == "crop":
+			return image.Pt(min(w, size.X), min(h, size.Y))
+		case w == 0:
+			return image.Pt(max(1, size.X*h/size.Y), h)
+		case h == 0:
+			return image.Pt(w, max(1, size.Y*w/size.X))
+		case size.X <= w && size.Y <= h:
+			// fit never enlarges
+			return size
+		case size.X*h > size.Y*w:
+			return image.Pt(w, max(1, size.Y*w/size.X))
+		default:
+			return image.Pt(max(1, size.X*h/size.Y), h)
+		}
+	case models.OpRotate:
+		sin, cos := math.Sincos(op.Angle * math.Pi / 180)
+		w, h := float64(size.X), float64(size.Y)
+		// the epsilon keeps the rounding of right angles from adding a pixel
+		side := func(v float64) int { return int(math.Ceil(v - 1e-9)) }
+		return image.Pt(side(math.Abs(w*cos)+math.Abs(h*sin)), side(math.Abs(w*sin)+math.Abs(h*cos)))
+	}
+	return size
+}
+
+func resize(img image.Image, op models.Operation) image.Image {
+	switch op.Mode {
+	case "fill":
+		return imaging.Fill(img, op.Width, op.Height, imaging.Center, imaging.Lanczos)
+	case "crop":
+		return imaging.CropAnchor(img, op.Width, op.Height, imaging.Center)
+	default:
+		if op.Width == 0 || op.Height == 0 {
+			// a missing side keeps the aspect ratio
+			return imaging.Resize(img, op.Width, op.Height, imaging.Lanczos)
+		}
+		return imaging.Fit(img, op.Width, op.Height, imaging.Lanczos)
+	}
+}
+
+// Validate checks the parameters of a single operation. The gateway checks
+// them as well, this guards against tasks queued by anything else.
+func Validate(op models.Operation) error {
+	var err error
+	switch op.Op {
+	case models.OpResize:
+		if op.Width < 0 || op.Height < 0 || op.Width > models.MaxDimension || op.Height > models.MaxDimension {
+			err = fmt.Errorf("width and height must be within 0..%d", models.MaxDimension)
+		} else if op.Width == 0 && op.Height == 0 {
+			err = errors.New("width or height is required")
+		} else if !resizeModes[op.Mode] {
+			err = fmt.Errorf("unknown resize mode %q", op.Mode)
+		} else if op.Mode != "" && op.Mode != "fit" && (op.Width == 0 || op.Height == 0) {
+			err = fmt.Errorf("%s needs both width and height", op.Mode)
+		}
+	case models.OpRotate:
+		if op.Angle <= -360 || op.Angle >= 360 {
+			err = errors.New("angle must be within -360..360")
+		}
+	case models.OpBlur:
+		if op.Sigma <= 0 || op.Sigma > models.MaxBlurSigma {
+			err = fmt.Errorf("sigma must be within 0..%d", models.MaxBlurSigma)
+		}
+	case models.OpWatermark:
+		if utf8.RuneCountInString(op.Text) > models.MaxWatermarkText {
+			err = fmt.Errorf("text is longer than %d", models.MaxWatermarkText)
+		} else if !positions[op.Position] {
+			err = fmt.Errorf("unknown position %q", op.Position)
+		} else if op.Opacity < 0 || op.Opacity > 1 {
+			err = errors.New("opacity must be within 0..1")
+		}
+	case models.OpFormat:
+		if !formats[op.Format] {
+			err = fmt.Errorf("unknown format %q", op.Format)
+		} else if op.Quality < 0 || op.Quality > 100 {
+			err = errors.New("quality must be within 1..100")
+		} else if !frameModes[op.Frames] {
+			err = fmt.Errorf("unknown frames %q", op.Frames)
+		}
+	case models.OpAutoOrient, models.OpGrayscale:
+	default:
+		err = fmt.Errorf("unknown operation %q", op.Op)
+	}
+
+	if err != nil {
+		return errors.Join(models.ErrInvalidOperation, err)
+	}
+	return nil
+}
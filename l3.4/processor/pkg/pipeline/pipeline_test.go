@@ -0,0 +1,538 @@
+package pipeline
+
+import (
+	"app/internal/models"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"runtime"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+func newTestPipeline() *Pipeline {
+	mark := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for i := range mark.Pix {
+		mark.Pix[i] = 0xFF
+	}
+	return &Pipeline{watermark: mark, maxPixels: 1000000}
+}
+
+func newJpeg(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xFF})
+		}
+	}
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatalf("failed to encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withOrientation puts an APP1 Exif segment with the orientation tag right
+// after the SOI marker.
+func withOrientation(raw []byte, orientation uint16, order binary.ByteOrder) []byte {
+	tiff := new(bytes.Buffer)
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8))
+	binary.Write(tiff, order, uint16(1))
+	binary.Write(tiff, order, uint16(orientationTag))
+	binary.Write(tiff, order, uint16(3))
+	binary.Write(tiff, order, uint32(1))
+	binary.Write(tiff, order, orientation)
+	binary.Write(tiff, order, uint16(0))
+	binary.Write(tiff, order, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, raw[2:]...)
+}
+
+func TestOrientation(t *testing.T) {
+	raw := newJpeg(t, 4, 2)
+
+	tests := []struct {
+		name string
+		raw  []byte
+		want int
+	}{
+		{"no exif", raw, 1},
+		{"little endian", withOrientation(raw, 6, binary.LittleEndian), 6},
+		{"big endian", withOrientation(raw, 8, binary.BigEndian), 8},
+		{"out of range", withOrientation(raw, 9, binary.BigEndian), 1},
+		{"not a jpeg", []byte("GIF89a"), 1},
+		{"truncated", withOrientation(raw, 6, binary.LittleEndian)[:20], 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Orientation(tt.raw); got != tt.want {
+				t.Fatalf("expected orientation %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	p := newTestPipeline()
+	raw := withOrientation(newJpeg(t, 200, 100), 6, binary.LittleEndian)
+
+	variants, err := p.Process(raw, "image/jpeg", map[string][]models.Operation{
+		"thumbnail": {{Op: models.OpAutoOrient}, {Op: models.OpResize, Width: 50, Height: 50, Mode: "fill"}},
+		"fit":       {{Op: models.OpResize, Width: 100, Height: 100}},
+		"upright":   {{Op: models.OpAutoOrient}, {Op: models.OpFormat, Format: "png"}},
+		"rotated":   {{Op: models.OpRotate, Angle: 90}, {Op: models.OpGrayscale}, {Op: models.OpBlur, Sigma: 1}},
+		"marked":    {{Op: models.OpWatermark, Text: "wb", Position: "top-left", Opacity: 0.5}, {Op: models.OpWatermark}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]struct {
+		contentType   string
+		width, height int
+	}{
+		"thumbnail": {"image/jpeg", 50, 50},
+		"fit":       {"image/jpeg", 100, 50},
+		"upright":   {"image/png", 100, 200},
+		"rotated":   {"image/jpeg", 100, 200},
+		"marked":    {"image/jpeg", 200, 100},
+	}
+	if len(variants) != len(want) {
+		t.Fatalf("expected %d variants, got %d", len(want), len(variants))
+	}
+	for name, w := range want {
+		img, ok := variants[name]
+		if !ok {
+			t.Fatalf("variant %s is missing", name)
+		}
+		if img.Ext != w.contentType {
+			t.Fatalf("%s: expected %s, got %s", name, w.contentType, img.Ext)
+		}
+		if img.Size != len(img.Raw) {
+			t.Fatalf("%s: size %d does not match %d bytes", name, img.Size, len(img.Raw))
+		}
+
+		var cfg image.Config
+		if w.contentType == "image/png" {
+			cfg, err = png.DecodeConfig(bytes.NewReader(img.Raw))
+		} else {
+			cfg, err = jpeg.DecodeConfig(bytes.NewReader(img.Raw))
+		}
+		if err != nil {
+			t.Fatalf("%s: failed to decode: %v", name, err)
+		}
+		if cfg.Width != w.width || cfg.Height != w.height {
+			t.Fatalf("%s: expected %dx%d, got %dx%d", name, w.width, w.height, cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestProcessErrors(t *testing.T) {
+	p := newTestPipeline()
+	raw := newJpeg(t, 10, 10)
+
+	if _, err := p.Process([]byte("not an image"), "image/jpeg", nil); !errors.Is(err, models.ErrInvalidImageExt) {
+		t.Fatalf("expected ErrInvalidImageExt for a broken jpeg, got %v", err)
+	}
+	if _, err := p.Process(raw, "image/png", nil); !errors.Is(err, models.ErrInvalidImageExt) {
+		t.Fatalf("expected ErrInvalidImageExt when the bytes are not of the content type, got %v", err)
+	}
+	if _, err := p.Process([]byte("<svg></svg>"), "image/svg+xml", nil); !errors.Is(err, models.ErrInvalidImageExt) {
+		t.Fatalf("expected ErrInvalidImageExt for an unsupported type, got %v", err)
+	}
+
+	bad := map[string][]models.Operation{"bad": {{Op: models.OpResize, Width: -1}}}
+	if _, err := p.Process(raw, "image/jpeg", bad); !errors.Is(err, models.ErrInvalidOperation) {
+		t.Fatalf("expected ErrInvalidOperation, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		op    models.Operation
+		valid bool
+	}{
+		{"resize one side", models.Operation{Op: models.OpResize, Width: 100}, true},
+		{"resize fill", models.Operation{Op: models.OpResize, Width: 100, Height: 100, Mode: "fill"}, true},
+		{"resize no sides", models.Operation{Op: models.OpResize}, false},
+		{"resize too large", models.Operation{Op: models.OpResize, Width: models.MaxDimension + 1}, false},
+		{"resize crop one side", models.Operation{Op: models.OpResize, Width: 100, Mode: "crop"}, false},
+		{"resize unknown mode", models.Operation{Op: models.OpResize, Width: 100, Mode: "stretch"}, false},
+		{"rotate", models.Operation{Op: models.OpRotate, Angle: -90}, true},
+		{"rotate full turn", models.Operation{Op: models.OpRotate, Angle: 360}, false},
+		{"blur", models.Operation{Op: models.OpBlur, Sigma: 2.5}, true},
+		{"blur no sigma", models.Operation{Op: models.OpBlur}, false},
+		{"watermark", models.Operation{Op: models.OpWatermark, Position: "center", Opacity: 0.3}, true},
+		{"watermark bad position", models.Operation{Op: models.OpWatermark, Position: "middle"}, false},
+		{"watermark bad opacity", models.Operation{Op: models.OpWatermark, Opacity: 2}, false},
+		{"format", models.Operation{Op: models.OpFormat, Format: "jpeg", Quality: 70}, true},
+		{"format webp", models.Operation{Op: models.OpFormat, Format: "webp"}, true},
+		{"format first frame", models.Operation{Op: models.OpFormat, Format: "gif", Frames: models.FramesFirst}, true},
+		{"format unknown", models.Operation{Op: models.OpFormat, Format: "avif"}, false},
+		{"format unknown frames", models.Operation{Op: models.OpFormat, Format: "gif", Frames: "last"}, false},
+		{"format bad quality", models.Operation{Op: models.OpFormat, Format: "jpeg", Quality: 101}, false},
+		{"grayscale", models.Operation{Op: models.OpGrayscale}, true},
+		{"unknown", models.Operation{Op: "sharpen"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.op)
+			if tt.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, models.ErrInvalidOperation) {
+				t.Fatalf("expected ErrInvalidOperation, got %v", err)
+			}
+		})
+	}
+}
+
+// newTransparent is an image whose left half is transparent and right half
+// opaque red.
+func newTransparent(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := width / 2; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: 0xFF, A: 0xFF})
+		}
+	}
+	return img
+}
+
+func encodeImage(t *testing.T, encode func(io.Writer, image.Image) error, img image.Image) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	if err := encode(buf, img); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func alphaAt(img image.Image, x, y int) uint8 {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).A
+}
+
+func TestProcessFormats(t *testing.T) {
+	p := newTestPipeline()
+	transparent := newTransparent(40, 20)
+	pngImage := encodeImage(t, png.Encode, transparent)
+
+	inputs := []struct {
+		contentType string
+		raw         []byte
+	}{
+		{"image/png", pngImage},
+		{"image/bmp", encodeImage(t, bmp.Encode, transparent)},
+		{"image/tiff", encodeImage(t, func(w io.Writer, img image.Image) error { return tiff.Encode(w, img, nil) }, transparent)},
+		{"image/gif", encodeImage(t, func(w io.Writer, img image.Image) error { return gif.Encode(w, paletted(img), nil) }, transparent)},
+	}
+	for _, input := range inputs {
+		variants, err := p.Process(input.raw, input.contentType, map[string][]models.Operation{"same": nil})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", input.contentType, err)
+		}
+		if variants["same"].Ext != input.contentType {
+			t.Fatalf("%s: expected the variant to keep the format, got %s", input.contentType, variants["same"].Ext)
+		}
+	}
+
+	variants, err := p.Process(pngImage, "image/png", map[string][]models.Operation{
+		"png":  {{Op: models.OpResize, Width: 20}},
+		"webp": {{Op: models.OpFormat, Format: "webp"}},
+		"gif":  {{Op: models.OpFormat, Format: "gif"}},
+		"jpeg": {{Op: models.OpFormat, Format: "jpeg", Quality: 90}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	decoders := map[string]func(io.Reader) (image.Image, error){
+		"png": png.Decode, "webp": webp.Decode, "gif": gif.Decode, "jpeg": jpeg.Decode,
+	}
+	for name, decode := range decoders {
+		img, err := decode(bytes.NewReader(variants[name].Raw))
+		if err != nil {
+			t.Fatalf("%s: failed to decode: %v", name, err)
+		}
+		if variants[name].Ext != "image/"+name {
+			t.Fatalf("%s: expected image/%s, got %s", name, name, variants[name].Ext)
+		}
+
+		right := img.Bounds().Dx() - 1
+		if alphaAt(img, right, 0) != 0xFF {
+			t.Fatalf("%s: expected the right half to stay opaque", name)
+		}
+		if name == "jpeg" {
+			if r, g, b, _ := img.At(0, 0).RGBA(); r>>8 < 0xF0 || g>>8 < 0xF0 || b>>8 < 0xF0 {
+				t.Fatalf("jpeg: expected transparency to turn white, got %v", img.At(0, 0))
+			}
+			continue
+		}
+		if alphaAt(img, 0, 0) != 0 {
+			t.Fatalf("%s: expected the left half to stay transparent", name)
+		}
+	}
+}
+
+// newAnimation is a gif of frames red, green and blue squares, every frame
+// after the first only covers the changed middle.
+func newAnimation(t *testing.T, size int) []byte {
+	t.Helper()
+
+	anim := &gif.GIF{LoopCount: 0}
+	for i, c := range []color.Color{color.RGBA{R: 0xFF, A: 0xFF}, color.RGBA{G: 0xFF, A: 0xFF}, color.RGBA{B: 0xFF, A: 0xFF}} {
+		bounds := image.Rect(0, 0, size, size)
+		if i > 0 {
+			bounds = image.Rect(size/4, size/4, size*3/4, size*3/4)
+		}
+		frame := image.NewPaletted(bounds, palette.Plan9)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				frame.Set(x, y, c)
+			}
+		}
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10*(i+1))
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gif.EncodeAll(buf, anim); err != nil {
+		t.Fatalf("failed to encode gif: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessAnimatedGif(t *testing.T) {
+	p := newTestPipeline()
+	raw := newAnimation(t, 40)
+
+	variants, err := p.Process(raw, "image/gif", map[string][]models.Operation{
+		"all":   {{Op: models.OpResize, Width: 20}, {Op: models.OpWatermark, Text: "wb"}},
+		"first": {{Op: models.OpFormat, Format: "gif", Frames: models.FramesFirst}},
+		"png":   {{Op: models.OpFormat, Format: "png"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	all, err := gif.DecodeAll(bytes.NewReader(variants["all"].Raw))
+	if err != nil {
+		t.Fatalf("failed to decode the animation: %v", err)
+	}
+	if len(all.Image) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(all.Image))
+	}
+	for i, frame := range all.Image {
+		if frame.Bounds() != image.Rect(0, 0, 20, 20) {
+			t.Fatalf("frame %d: expected a full 20x20 frame, got %v", i, frame.Bounds())
+		}
+		if all.Delay[i] != 10*(i+1) {
+			t.Fatalf("frame %d: expected delay %d, got %d", i, 10*(i+1), all.Delay[i])
+		}
+	}
+	// the corner of the last frame comes from the first one
+	if r, _, b, _ := all.Image[2].At(1, 1).RGBA(); r>>8 < 0xC0 || b>>8 > 0x40 {
+		t.Fatalf("expected the last frame to keep the red corner, got %v", all.Image[2].At(1, 1))
+	}
+
+	first, err := gif.DecodeAll(bytes.NewReader(variants["first"].Raw))
+	if err != nil {
+		t.Fatalf("failed to decode the first frame: %v", err)
+	}
+	if len(first.Image) != 1 {
+		t.Fatalf("expected 1 frame, got %d", len(first.Image))
+	}
+	if _, err := png.Decode(bytes.NewReader(variants["png"].Raw)); err != nil {
+		t.Fatalf("failed to decode png: %v", err)
+	}
+}
+
+func TestProcessTooManyPixels(t *testing.T) {
+	p := newTestPipeline()
+	p.maxPixels = 40 * 40 * 2
+
+	big := encodeImage(t, png.Encode, newTransparent(100, 100))
+	if _, err := p.Process(big, "image/png", nil); !errors.Is(err, models.ErrTooManyPixels) {
+		t.Fatalf("expected ErrTooManyPixels, got %v", err)
+	}
+
+	// three frames are over the limit together, one is not
+	raw := newAnimation(t, 40)
+	if _, err := p.Process(raw, "image/gif", map[string][]models.Operation{"first": {{Op: models.OpFormat, Format: "png"}}}); err != nil {
+		t.Fatalf("unexpected error for the first frame: %v", err)
+	}
+	if _, err := p.Process(raw, "image/gif", map[string][]models.Operation{"all": nil}); !errors.Is(err, models.ErrTooManyPixels) {
+		t.Fatalf("expected ErrTooManyPixels for all frames, got %v", err)
+	}
+}
+
+func TestProcessTooManyPixelsAfterOperations(t *testing.T) {
+	p := newTestPipeline()
+	p.maxPixels = 100 * 100
+	raw := newJpeg(t, 40, 20)
+
+	tests := []struct {
+		name string
+		ops  []models.Operation
+		err  error
+	}{
+		{"fill", []models.Operation{{Op: models.OpResize, Width: 200, Height: 100, Mode: "fill"}}, models.ErrTooManyPixels},
+		{"width only", []models.Operation{{Op: models.OpResize, Width: 200}}, models.ErrTooManyPixels},
+		{"height only", []models.Operation{{Op: models.OpResize, Height: 50}}, nil},
+		{"fit", []models.Operation{{Op: models.OpResize, Width: 200, Height: 50}}, nil},
+		{"crop", []models.Operation{{Op: models.OpResize, Width: 1000, Height: 1000, Mode: "crop"}}, nil},
+		// every rotation by 45 degrees doubles the canvas
+		{"rotations", []models.Operation{
+			{Op: models.OpResize, Width: 100, Height: 50, Mode: "fill"},
+			{Op: models.OpRotate, Angle: 45},
+			{Op: models.OpRotate, Angle: 45},
+		}, models.ErrTooManyPixels},
+		{"shrunk first", []models.Operation{
+			{Op: models.OpResize, Width: 10, Height: 10, Mode: "fill"},
+			{Op: models.OpRotate, Angle: 45},
+			{Op: models.OpResize, Width: 100, Mode: "fit"},
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.Process(raw, "image/jpeg", map[string][]models.Operation{"v": tt.ops})
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTargetSize(t *testing.T) {
+	size := image.Pt(400, 200)
+
+	tests := []struct {
+		name string
+		op   models.Operation
+		want image.Point
+	}{
+		{"fill", models.Operation{Op: models.OpResize, Width: 50, Height: 50, Mode: "fill"}, image.Pt(50, 50)},
+		{"crop", models.Operation{Op: models.OpResize, Width: 500, Height: 100, Mode: "crop"}, image.Pt(400, 100)},
+		{"width only", models.Operation{Op: models.OpResize, Width: 100}, image.Pt(100, 50)},
+		{"height only", models.Operation{Op: models.OpResize, Height: 100}, image.Pt(200, 100)},
+		{"fit wide", models.Operation{Op: models.OpResize, Width: 100, Height: 100}, image.Pt(100, 50)},
+		{"fit tall", models.Operation{Op: models.OpResize, Width: 300, Height: 50}, image.Pt(100, 50)},
+		{"fit larger", models.Operation{Op: models.OpResize, Width: 1000, Height: 1000, Mode: "fit"}, size},
+		{"rotate 90", models.Operation{Op: models.OpRotate, Angle: 90}, image.Pt(200, 400)},
+		{"blur", models.Operation{Op: models.OpBlur, Sigma: 2}, size},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetSize(size, tt.op); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestFormatsMatchContentTypes keeps the formats operations may ask for
+// encodable.
+func TestFormatsMatchContentTypes(t *testing.T) {
+	if len(formats) != len(contentTypes) {
+		t.Fatalf("expected %d formats, got %d", len(contentTypes), len(formats))
+	}
+	for format := range formats {
+		if _, ok := contentTypes[format]; !ok {
+			t.Fatalf("expected a content type for %s", format)
+		}
+	}
+}
+
+// newGifBomb repeats a full canvas frame, each copy is a few kilobytes that
+// decode to a million pixels.
+func newGifBomb(t *testing.T, size, frames int) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	if err := gif.Encode(buf, image.NewPaletted(image.Rect(0, 0, size, size), palette.Plan9), nil); err != nil {
+		t.Fatalf("failed to encode gif: %v", err)
+	}
+	raw := buf.Bytes()
+	start := 13 + colorTableSize(raw[10])
+	frame := raw[start : len(raw)-1]
+
+	bomb := append([]byte{}, raw[:start]...)
+	for range frames {
+		bomb = append(bomb, frame...)
+	}
+	return append(bomb, raw[len(raw)-1])
+}
+
+func TestProcessGifBomb(t *testing.T) {
+	p := newTestPipeline()
+	raw := newGifBomb(t, 1000, 1000)
+
+	if n, err := gifFrames(raw); err != nil || n != 1000 {
+		t.Fatalf("expected 1000 frames, got %d and %v", n, err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := p.Process(raw, "image/gif", map[string][]models.Operation{"all": nil})
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, models.ErrTooManyPixels) {
+		t.Fatalf("expected ErrTooManyPixels, got %v", err)
+	}
+	// decoding every frame would take a gigabyte
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16<<20 {
+		t.Fatalf("expected the gif to be rejected before decoding, allocated %d bytes", allocated)
+	}
+
+	// a variant without the animation only decodes the first frame
+	variants, err := p.Process(raw, "image/gif", map[string][]models.Operation{"first": {{Op: models.OpResize, Width: 10}, {Op: models.OpFormat, Format: "png"}}})
+	if err != nil {
+		t.Fatalf("unexpected error for the first frame: %v", err)
+	}
+	if _, err := png.Decode(bytes.NewReader(variants["first"].Raw)); err != nil {
+		t.Fatalf("failed to decode png: %v", err)
+	}
+}
+
+func TestGifFramesTruncated(t *testing.T) {
+	raw := newAnimation(t, 40)
+	if n, err := gifFrames(raw); err != nil || n != 3 {
+		t.Fatalf("expected 3 frames, got %d and %v", n, err)
+	}
+	if _, err := gifFrames(raw[:len(raw)-1]); !errors.Is(err, models.ErrInvalidImageExt) {
+		t.Fatalf("expected ErrInvalidImageExt for a gif without its trailer, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"app/internal/models"
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// watermarkMargin is the gap between the watermark and the image edges.
+	watermarkMargin = 10
+	// textHeight is the share of the image height a text watermark takes.
+	textHeight = 0.05
+)
+
+// mark returns what the watermark operation puts on the image: its text, or
+// the configured watermark image.
+func (p *Pipeline) mark(op models.Operation) (image.Image, error) {
+	if op.Text == "" {
+		return p.watermark, nil
+	}
+
+	face := basicfont.Face7x13
+	drawer := &font.Drawer{Face: face}
+	width := drawer.MeasureString(op.Text).Ceil()
+	metrics := face.Metrics()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	if width == 0 {
+		return nil, models.ErrInvalidWatermark
+	}
+
+	// white text with a dark shadow stays readable on any background
+	text := image.NewNRGBA(image.Rect(0, 0, width+1, height+1))
+	for _, pass := range []struct {
+		color  color.Color
+		offset int
+	}{{color.Black, 1}, {color.White, 0}} {
+		drawer.Dst = text
+		drawer.Src = image.NewUniform(pass.color)
+		drawer.Dot = fixed.P(pass.offset, metrics.Ascent.Ceil()+pass.offset)
+		drawer.DrawString(op.Text)
+	}
+	return text, nil
+}
+
+// watermark puts mark over img at op.Position, bottom right by default. A
+// mark too large for the image is scaled down, text is scaled to the image.
+func watermark(img image.Image, mark image.Image, op models.Operation) image.Image {
+	bounds := img.Bounds()
+
+	if op.Text != "" {
+		height := max(int(float64(bounds.Dy())*textHeight), mark.Bounds().Dy())
+		mark = imaging.Resize(mark, 0, height, imaging.NearestNeighbor)
+	}
+	if limit := bounds.Dx() / 3; mark.Bounds().Dx() > limit && limit > 0 {
+		mark = imaging.Resize(mark, limit, 0, imaging.Lanczos)
+	}
+
+	opacity := op.Opacity
+	if opacity == 0 {
+		opacity = 1
+	}
+
+	return imaging.Overlay(img, mark, position(bounds, mark.Bounds(), op.Position), opacity)
+}
+
+func position(img, mark image.Rectangle, pos string) image.Point {
+	left := img.Min.X + watermarkMargin
+	top := img.Min.Y + watermarkMargin
+	right := img.Max.X - mark.Dx() - watermarkMargin
+	bottom := img.Max.Y - mark.Dy() - watermarkMargin
+
+	switch pos {
+	case "top-left":
+		return image.Pt(left, top)
+	case "top-right":
+		return image.Pt(right, top)
+	case "bottom-left":
+		return image.Pt(left, bottom)
+	case "center":
+		return image.Pt(img.Min.X+(img.Dx()-mark.Dx())/2, img.Min.Y+(img.Dy()-mark.Dy())/2)
+	default:
+		return image.Pt(right, bottom)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/minio/minio-go/v7"
@@ -49,12 +50,6 @@ func New(cfg StorageConfig) *StorageClient {
 		if err != nil && err.Error() != minio.BucketAlreadyExists {
 			panic(fmt.Sprintf("failed to create bucket: %s", err.Error()))
 		}
-
-		policy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::` + cfg.BucketName + `/*"]}]}`
-		err = client.SetBucketPolicy(ctx, cfg.BucketName, policy)
-		if err != nil {
-			panic(fmt.Sprintf("failed to set bucket policy: %s", err.Error()))
-		}
 	}
 
 	return &StorageClient{
@@ -65,6 +60,24 @@ func New(cfg StorageConfig) *StorageClient {
 	}
 }
 
+func (m *StorageClient) DownloadFile(path string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	obj, err := m.client.GetObject(ctx, m.bucketName, path, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Join(models.ErrImageDownloadFailed, fmt.Errorf("%s: %w", path, err))
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, errors.Join(models.ErrImageDownloadFailed, fmt.Errorf("%s: %w", path, err))
+	}
+
+	return data, nil
+}
+
 func (m *StorageClient) UploadFile(path string, data []byte, contentType string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
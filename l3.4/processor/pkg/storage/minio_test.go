@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"app/internal/models"
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// newFakeStorage serves an in-memory S3 for the test. The bucket is created
+// upfront since the fake does not support bucket policies.
+func newFakeStorage(t *testing.T) *StorageClient {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("images"); err != nil {
+		t.Fatalf("failed to create bucket: %v", err)
+	}
+	server := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	client, err := minio.New(strings.TrimPrefix(server.URL, "https://"), &minio.Options{
+		Creds:     credentials.NewStaticV4("minioadmin", "minioadmin", ""),
+		Secure:    true,
+		Region:    "us-east-1",
+		Transport: server.Client().Transport,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	return &StorageClient{client: client, bucketName: "images"}
+}
+
+func TestDownloadFile(t *testing.T) {
+	str := newFakeStorage(t)
+	original := []byte("\xff\xd8\xff original jpeg")
+
+	if err := str.UploadFile("original/task", original, "image/jpeg"); err != nil {
+		t.Fatalf("unexpected upload error: %v", err)
+	}
+
+	data, err := str.DownloadFile("original/task")
+	if err != nil {
+		t.Fatalf("unexpected download error: %v", err)
+	}
+	if !bytes.Equal(data, original) {
+		t.Fatal("downloaded object differs from the original")
+	}
+
+	if _, err := str.DownloadFile("original/missing"); !errors.Is(err, models.ErrImageDownloadFailed) {
+		t.Fatalf("expected ErrImageDownloadFailed, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"app/internal/config"
+	"app/internal/models"
 	"app/internal/repository"
 	"app/internal/service"
 	"app/internal/transport"
@@ -11,8 +12,10 @@ import (
 	"app/pkg/wrk/archiver"
 	"app/pkg/wrk/notifyer"
 	"context"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 )
 
@@ -24,12 +27,20 @@ func main() {
 
 	data := data.New(cfg.DataConfig)
 
+	snd := sender.New(cfg.SndConfig)
+	notifiers := map[string]notifyer.Notifier{models.ChannelEmail: snd}
+	if cfg.WebhookConfig.Url != "" {
+		notifiers[models.ChannelWebhook] = sender.NewWebhook(cfg.WebhookConfig)
+	}
+	if cfg.TelegramConfig.Token != "" {
+		notifiers[models.ChannelTelegram] = sender.NewTelegram(cfg.TelegramConfig, cfg.SndConfig.TemplatesDir, cfg.SndConfig.DefaultLocale)
+	}
+
 	repo := repository.New(data)
-	service := service.New(repo)
+	service := service.New(repo, slices.Collect(maps.Keys(notifiers)))
 
 	archiver := archiver.New(cfg.WrkConfig)
-	sender := sender.New(cfg.SndConfig)
-	notif := notifyer.New(sender)
+	notif := notifyer.New(data.DB, notifiers, cfg.NotifConfig)
 
 	server := transport.New(service, &cfg.ServerConfig, archiver, notif, ctx)
 
@@ -40,4 +51,5 @@ func main() {
 
 	<-graceCh
 	server.Stop()
+	snd.Close()
 }
@@ -6,6 +6,7 @@ import (
 	"app/pkg/data"
 	"app/pkg/sender"
 	"app/pkg/wrk/archiver"
+	"app/pkg/wrk/notifyer"
 	"fmt"
 
 	"github.com/ilyakaznacheev/cleanenv"
@@ -13,10 +14,13 @@ import (
 )
 
 type Config struct {
-	ServerConfig transport.ServerConfig
-	DataConfig   data.DataConfig
-	WrkConfig    archiver.WrkConfig
-	SndConfig    sender.SenderConfig
+	ServerConfig   transport.ServerConfig
+	DataConfig     data.DataConfig
+	WrkConfig      archiver.WrkConfig
+	SndConfig      sender.SenderConfig
+	NotifConfig    notifyer.NotifConfig
+	WebhookConfig  sender.WebhookConfig
+	TelegramConfig sender.TelegramConfig
 }
 
 func (c *Config) valid() error {
@@ -25,6 +29,9 @@ func (c *Config) valid() error {
 	resultErr = multierr.Append(resultErr, validPort(c.ServerConfig.Port))
 	resultErr = multierr.Append(resultErr, validPort(c.DataConfig.DbPort))
 	resultErr = multierr.Append(resultErr, validReleaseMode(c.ServerConfig.ReleaseMode))
+	resultErr = multierr.Append(resultErr, validNotifConfig(c.NotifConfig))
+	resultErr = multierr.Append(resultErr, c.SndConfig.Valid())
+	resultErr = multierr.Append(resultErr, c.WrkConfig.Valid())
 
 	return resultErr
 }
@@ -43,6 +50,13 @@ func validReleaseMode(mode string) error {
 	return nil
 }
 
+func validNotifConfig(cfg notifyer.NotifConfig) error {
+	if cfg.Interval <= 0 || cfg.BatchSize <= 0 || cfg.Lease <= 0 || cfg.MaxAttempts <= 0 || cfg.RetryDelay < 0 || cfg.MaxRetryDelay < cfg.RetryDelay {
+		return models.ErrInvalidNotifConfig
+	}
+	return nil
+}
+
 func New() *Config {
 	cfg := &Config{}
 
@@ -11,7 +11,9 @@ package mocks
 
 import (
 	models "app/internal/models"
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "go.uber.org/mock/gomock"
 )
@@ -41,58 +43,90 @@ func (m *MockRepositoryInterface) EXPECT() *MockRepositoryInterfaceMockRecorder
 }
 
 // CreateEvent mocks base method.
-func (m *MockRepositoryInterface) CreateEvent(arg0 *models.UserEvent) error {
+func (m *MockRepositoryInterface) CreateEvent(arg0 context.Context, arg1 models.UserEvent, arg2 []models.Reminder) (*models.Event, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateEvent", arg0)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "CreateEvent", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*models.Event)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // CreateEvent indicates an expected call of CreateEvent.
-func (mr *MockRepositoryInterfaceMockRecorder) CreateEvent(arg0 any) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) CreateEvent(arg0, arg1, arg2 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateEvent), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).CreateEvent), arg0, arg1, arg2)
 }
 
 // DeleteEvent mocks base method.
-func (m *MockRepositoryInterface) DeleteEvent(arg0 *models.UserEvent) error {
+func (m *MockRepositoryInterface) DeleteEvent(arg0 context.Context, arg1 models.UserEvent) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteEvent", arg0)
+	ret := m.ctrl.Call(m, "DeleteEvent", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // DeleteEvent indicates an expected call of DeleteEvent.
-func (mr *MockRepositoryInterfaceMockRecorder) DeleteEvent(arg0 any) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) DeleteEvent(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).DeleteEvent), arg0, arg1)
+}
+
+// ReadArchive mocks base method.
+func (m *MockRepositoryInterface) ReadArchive(arg0 context.Context, arg1 string, arg2, arg3 time.Time, arg4, arg5 int) (*models.ArchivePage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadArchive", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(*models.ArchivePage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadArchive indicates an expected call of ReadArchive.
+func (mr *MockRepositoryInterfaceMockRecorder) ReadArchive(arg0, arg1, arg2, arg3, arg4, arg5 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).DeleteEvent), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadArchive", reflect.TypeOf((*MockRepositoryInterface)(nil).ReadArchive), arg0, arg1, arg2, arg3, arg4, arg5)
 }
 
 // ReadEvents mocks base method.
-func (m *MockRepositoryInterface) ReadEvents(arg0 string, arg1, arg2 int64) ([]models.Event, error) {
+func (m *MockRepositoryInterface) ReadEvents(arg0 context.Context, arg1 string, arg2, arg3 time.Time) ([]models.Event, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ReadEvents", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "ReadEvents", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].([]models.Event)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ReadEvents indicates an expected call of ReadEvents.
-func (mr *MockRepositoryInterfaceMockRecorder) ReadEvents(arg0, arg1, arg2 any) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) ReadEvents(arg0, arg1, arg2, arg3 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadEvents", reflect.TypeOf((*MockRepositoryInterface)(nil).ReadEvents), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadEvents", reflect.TypeOf((*MockRepositoryInterface)(nil).ReadEvents), arg0, arg1, arg2, arg3)
+}
+
+// RestoreEvent mocks base method.
+func (m *MockRepositoryInterface) RestoreEvent(arg0 context.Context, arg1, arg2 string, arg3 time.Time) (*models.Event, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreEvent", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(*models.Event)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreEvent indicates an expected call of RestoreEvent.
+func (mr *MockRepositoryInterfaceMockRecorder) RestoreEvent(arg0, arg1, arg2, arg3 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).RestoreEvent), arg0, arg1, arg2, arg3)
 }
 
 // UpdateEvent mocks base method.
-func (m *MockRepositoryInterface) UpdateEvent(arg0 *models.UserEvent) error {
+func (m *MockRepositoryInterface) UpdateEvent(arg0 context.Context, arg1 models.UserEvent) (*models.Event, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateEvent", arg0)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "UpdateEvent", arg0, arg1)
+	ret0, _ := ret[0].(*models.Event)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // UpdateEvent indicates an expected call of UpdateEvent.
-func (mr *MockRepositoryInterfaceMockRecorder) UpdateEvent(arg0 any) *gomock.Call {
+func (mr *MockRepositoryInterfaceMockRecorder) UpdateEvent(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdateEvent), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateEvent", reflect.TypeOf((*MockRepositoryInterface)(nil).UpdateEvent), arg0, arg1)
 }
@@ -10,6 +10,7 @@ type Event struct {
 	EventId   string    `json:"event_id"`
 	Message   string    `json:"message" binding:"required"`
 	Date      time.Time `json:"date" binding:"required"`
+	Reminders []string  `json:"reminders,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -19,9 +20,65 @@ type UserEvent struct {
 	Event
 }
 
-type EventTask struct {
-	Event Event
-	Email string
+type ArchivedEvent struct {
+	UserId string `json:"user_id"`
+	Event
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+type ArchivePage struct {
+	Events     []ArchivedEvent `json:"events"`
+	Page       int             `json:"page"`
+	Limit      int             `json:"limit"`
+	Total      int64           `json:"total"`
+	TotalPages int             `json:"total_pages"`
+}
+
+type RestoreRequest struct {
+	UserId string    `json:"user_id"`
+	Date   time.Time `json:"date"`
+}
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+const (
+	ReminderPending    = "pending"
+	ReminderProcessing = "processing"
+	ReminderSent       = "sent"
+	ReminderFailed     = "failed"
+	ReminderCancelled  = "cancelled"
+)
+
+const (
+	ChannelEmail    = "email"
+	ChannelWebhook  = "webhook"
+	ChannelTelegram = "telegram"
+)
+
+// Recipients describes where reminders of a new event are delivered.
+type Recipients struct {
+	Channels       []string
+	Email          string
+	TelegramChatId string
+	Locale         string
+}
+
+type Reminder struct {
+	ReminderId string
+	EventId    string
+	UserId     string
+	Channel    string
+	Recipient  string
+	Locale     string
+	Message    string
+	EventDate  time.Time
+	SendAt     time.Time
+	Status     string
+	Attempts   int
+	LastError  string
 }
 
 type ErrorResponse struct {
@@ -38,14 +95,25 @@ type ToLog struct {
 var (
 	ErrInvalidPort        = errors.New("invalid port")
 	ErrInvalidReleaseMode = errors.New("invalid release mode")
+	ErrInvalidNotifConfig = errors.New("invalid notifyer config")
 	ErrInvalidRequestBody = errors.New("invalid request body")
 	ErrInvalidTimePeriod  = errors.New("invalid time period")
 	ErrInvalidUserId      = errors.New("invalid user id")
 	ErrInvalidEventId     = errors.New("invalid event id")
 	ErrInvalidDate        = errors.New("invalid date")
 	ErrInvalidEmail       = errors.New("invalid email")
+	ErrInvalidChannel     = errors.New("invalid channel")
+	ErrInvalidChatId      = errors.New("invalid telegram chat id")
+	ErrInvalidReminders   = errors.New("invalid reminders")
+	ErrInvalidLocale      = errors.New("invalid locale")
+	ErrInvalidSmtpConfig  = errors.New("invalid smtp config")
+	ErrNoTemplates        = errors.New("no email templates")
+	ErrUnsupportedChannel = errors.New("unsupported channel")
+	ErrOnDelivery         = errors.New("on delivery")
 	ErrOnDatabase         = errors.New("on database")
 	ErrNonExistEvent      = errors.New("non exist event")
+	ErrInvalidPage        = errors.New("invalid page")
+	ErrInvalidArchiveCfg  = errors.New("invalid archive config")
 	ErrUnsupportedLevel   = errors.New("unsupported level")
 	ErrUnexpected         = errors.New("unexpected")
 )
@@ -4,6 +4,7 @@ import (
 	"app/internal/models"
 	"app/pkg/data"
 	"context"
+	"database/sql"
 	"errors"
 	"time"
 )
@@ -12,9 +13,26 @@ type Repository struct {
 	data *data.Data
 }
 
-func (r *Repository) CreateEvent(ctx context.Context, userEvent models.UserEvent) (*models.Event, error) {
-	q := `INSERT INTO events (user_id, event_id, message, date) VALUES ($1, $2, $3, $4) RETURNING created_at, updated_at`
-	if err := r.data.DB.QueryRowContext(ctx, q, userEvent.UserId, userEvent.EventId, userEvent.Message, userEvent.Date).Scan(&userEvent.CreatedAt, &userEvent.UpdatedAt); err != nil {
+func (r *Repository) CreateEvent(ctx context.Context, userEvent models.UserEvent, reminders []models.Reminder) (*models.Event, error) {
+	tx, err := r.data.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
+
+	q1 := `INSERT INTO events (user_id, event_id, message, date) VALUES ($1, $2, $3, $4) RETURNING created_at, updated_at`
+	if err := tx.QueryRowContext(ctx, q1, userEvent.UserId, userEvent.EventId, userEvent.Message, userEvent.Date).Scan(&userEvent.CreatedAt, &userEvent.UpdatedAt); err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	q2 := `INSERT INTO reminders (reminder_id, event_id, user_id, channel, recipient, locale, message, event_date, send_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	for _, rem := range reminders {
+		if _, err := tx.ExecContext(ctx, q2, rem.ReminderId, rem.EventId, rem.UserId, rem.Channel, rem.Recipient, rem.Locale, rem.Message, rem.EventDate, rem.SendAt); err != nil {
+			return nil, errors.Join(models.ErrOnDatabase, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
 		return nil, errors.Join(models.ErrOnDatabase, err)
 	}
 
@@ -22,9 +40,27 @@ func (r *Repository) CreateEvent(ctx context.Context, userEvent models.UserEvent
 }
 
 func (r *Repository) UpdateEvent(ctx context.Context, userEvent models.UserEvent) (*models.Event, error) {
-	q := `UPDATE events SET message = $1, updated_at = NOW() WHERE user_id = $2 AND event_id = $3 RETURNING date, created_at, updated_at`
+	tx, err := r.data.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
 
-	if err := r.data.DB.QueryRowContext(ctx, q, userEvent.Message, userEvent.UserId, userEvent.EventId).Scan(&userEvent.Date, &userEvent.CreatedAt, &userEvent.UpdatedAt); err != nil {
+	q1 := `UPDATE events SET message = $1, date = $2, updated_at = NOW() WHERE user_id = $3 AND event_id = $4 RETURNING created_at, updated_at`
+	if err := tx.QueryRowContext(ctx, q1, userEvent.Message, userEvent.Date, userEvent.UserId, userEvent.EventId).Scan(&userEvent.CreatedAt, &userEvent.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNonExistEvent
+		}
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	// a claimed reminder is moved too, the notifyer reads it again before sending
+	q2 := `UPDATE reminders SET message = $1, send_at = $2::timestamptz + (send_at - event_date), event_date = $2, updated_at = NOW() WHERE event_id = $3 AND status IN ($4, $5)`
+	if _, err := tx.ExecContext(ctx, q2, userEvent.Message, userEvent.Date, userEvent.EventId, models.ReminderPending, models.ReminderProcessing); err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	if err := tx.Commit(); err != nil {
 		return nil, errors.Join(models.ErrOnDatabase, err)
 	}
 
@@ -32,9 +68,15 @@ func (r *Repository) UpdateEvent(ctx context.Context, userEvent models.UserEvent
 }
 
 func (r *Repository) DeleteEvent(ctx context.Context, userEvent models.UserEvent) error {
-	q := `DELETE FROM events WHERE user_id = $1 AND event_id = $2`
+	tx, err := r.data.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
+
+	q1 := `DELETE FROM events WHERE user_id = $1 AND event_id = $2`
 
-	res, err := r.data.DB.ExecContext(ctx, q, userEvent.UserId, userEvent.EventId)
+	res, err := tx.ExecContext(ctx, q1, userEvent.UserId, userEvent.EventId)
 	if err != nil {
 		return errors.Join(models.ErrOnDatabase, err)
 	}
@@ -46,6 +88,15 @@ func (r *Repository) DeleteEvent(ctx context.Context, userEvent models.UserEvent
 		return models.ErrNonExistEvent
 	}
 
+	q2 := `UPDATE reminders SET status = $1, updated_at = NOW() WHERE event_id = $2 AND status = $3`
+	if _, err := tx.ExecContext(ctx, q2, models.ReminderCancelled, userEvent.EventId, models.ReminderPending); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Join(models.ErrOnDatabase, err)
+	}
+
 	return nil
 }
 
@@ -76,6 +127,85 @@ func (r *Repository) ReadEvents(ctx context.Context, userId string, dateFrom, da
 	return events, nil
 }
 
+func (r *Repository) ReadArchive(ctx context.Context, userId string, dateFrom, dateTo time.Time, page, limit int) (*models.ArchivePage, error) {
+	q1 := `SELECT COUNT(*) FROM archive WHERE user_id = $1 AND date >= $2 AND date < $3`
+
+	var total int64
+	if err := r.data.DB.QueryRowContext(ctx, q1, userId, dateFrom, dateTo).Scan(&total); err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	q2 := `SELECT event_id, message, date, created_at, updated_at, archived_at FROM archive
+	WHERE user_id = $1 AND date >= $2 AND date < $3
+	ORDER BY date DESC, event_id
+	LIMIT $4 OFFSET $5`
+
+	rows, err := r.data.DB.QueryContext(ctx, q2, userId, dateFrom, dateTo, limit, (page-1)*limit)
+	if err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+	defer rows.Close()
+
+	events := make([]models.ArchivedEvent, 0, limit)
+	for rows.Next() {
+		ev := models.ArchivedEvent{UserId: userId}
+		if err := rows.Scan(&ev.EventId, &ev.Message, &ev.Date, &ev.CreatedAt, &ev.UpdatedAt, &ev.ArchivedAt); err != nil {
+			return nil, errors.Join(models.ErrOnDatabase, err)
+		}
+		events = append(events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	return &models.ArchivePage{
+		Events:     events,
+		Page:       page,
+		Limit:      limit,
+		Total:      total,
+		TotalPages: int((total + int64(limit) - 1) / int64(limit)),
+	}, nil
+}
+
+// RestoreEvent moves an archived event back to events with the given date and
+// reschedules its reminders relative to that date.
+func (r *Repository) RestoreEvent(ctx context.Context, userId, eventId string, date time.Time) (*models.Event, error) {
+	tx, err := r.data.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+	defer tx.Rollback()
+
+	q1 := `WITH restored AS (
+		DELETE FROM archive WHERE user_id = $1 AND event_id = $2
+		RETURNING event_id, user_id, message, created_at
+	)
+	INSERT INTO events (event_id, user_id, message, date, created_at, updated_at)
+	SELECT event_id, user_id, message, $3, created_at, NOW() FROM restored
+	RETURNING event_id, message, date, created_at, updated_at`
+
+	var ev models.Event
+	if err := tx.QueryRowContext(ctx, q1, userId, eventId, date).Scan(&ev.EventId, &ev.Message, &ev.Date, &ev.CreatedAt, &ev.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNonExistEvent
+		}
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	q2 := `UPDATE reminders SET status = $1, attempts = 0, last_error = '', locked_until = NULL,
+		send_at = $2::timestamptz + (send_at - event_date), event_date = $2, updated_at = NOW()
+	WHERE event_id = $3 AND status <> $4`
+	if _, err := tx.ExecContext(ctx, q2, models.ReminderPending, date, eventId, models.ReminderCancelled); err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Join(models.ErrOnDatabase, err)
+	}
+
+	return &ev, nil
+}
+
 func New(data *data.Data) *Repository {
 	return &Repository{data}
 }
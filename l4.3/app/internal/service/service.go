@@ -4,17 +4,23 @@ import (
 	"app/internal/models"
 	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 )
 
+const maxReminders = 10
+
 type RepositoryInterface interface {
-	CreateEvent(context.Context, models.UserEvent) (*models.Event, error)
+	CreateEvent(context.Context, models.UserEvent, []models.Reminder) (*models.Event, error)
 	UpdateEvent(context.Context, models.UserEvent) (*models.Event, error)
 	DeleteEvent(context.Context, models.UserEvent) error
 	ReadEvents(context.Context, string, time.Time, time.Time) ([]models.Event, error)
+	ReadArchive(context.Context, string, time.Time, time.Time, int, int) (*models.ArchivePage, error)
+	RestoreEvent(context.Context, string, string, time.Time) (*models.Event, error)
 }
 
 func validUserId(userId string) error {
@@ -42,19 +48,96 @@ func validEventId(eventId string) error {
 type Service struct {
 	repo RepositoryInterface
 	vld  validator.Validate
+	// channels are the channels with a configured notifier, reminders for
+	// the others would never be sent.
+	channels map[string]bool
 }
 
-func (s *Service) CreateEvent(ctx context.Context, userEvent models.UserEvent, email string) (*models.Event, error) {
+func (s *Service) CreateEvent(ctx context.Context, userEvent models.UserEvent, recipients models.Recipients) (*models.Event, error) {
 	if err := validUserId(userEvent.UserId); err != nil {
 		return nil, err
 	}
-	if err := s.vld.Var(email, "required,email"); err != nil {
-		return nil, errors.Join(models.ErrInvalidEmail, err)
+	offsets, err := parseReminders(userEvent.Reminders)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.vld.Var(recipients.Locale, "omitempty,bcp47_language_tag"); err != nil {
+		return nil, errors.Join(models.ErrInvalidLocale, err)
+	}
+	if len(recipients.Channels) == 0 {
+		recipients.Channels = []string{models.ChannelEmail}
 	}
 
 	userEvent.EventId = uuid.NewString()
 
-	return s.repo.CreateEvent(ctx, userEvent)
+	reminders := make([]models.Reminder, 0, len(offsets)*len(recipients.Channels))
+	for _, channel := range recipients.Channels {
+		recipient, err := s.recipientFor(channel, userEvent.UserId, recipients)
+		if err != nil {
+			return nil, err
+		}
+		for _, offset := range offsets {
+			reminders = append(reminders, models.Reminder{
+				ReminderId: uuid.NewString(),
+				EventId:    userEvent.EventId,
+				UserId:     userEvent.UserId,
+				Channel:    channel,
+				Recipient:  recipient,
+				Locale:     recipients.Locale,
+				Message:    userEvent.Message,
+				EventDate:  userEvent.Date,
+				SendAt:     userEvent.Date.Add(-offset),
+			})
+		}
+	}
+
+	return s.repo.CreateEvent(ctx, userEvent, reminders)
+}
+
+func (s *Service) recipientFor(channel, userId string, recipients models.Recipients) (string, error) {
+	if !s.channels[channel] {
+		return "", fmt.Errorf("%w: %s is not configured", models.ErrInvalidChannel, channel)
+	}
+	switch channel {
+	case models.ChannelEmail:
+		if err := s.vld.Var(recipients.Email, "required,email"); err != nil {
+			return "", errors.Join(models.ErrInvalidEmail, err)
+		}
+		return recipients.Email, nil
+	case models.ChannelTelegram:
+		if recipients.TelegramChatId == "" {
+			return "", models.ErrInvalidChatId
+		}
+		return recipients.TelegramChatId, nil
+	case models.ChannelWebhook:
+		return userId, nil
+	default:
+		return "", fmt.Errorf("%w: %s", models.ErrInvalidChannel, channel)
+	}
+}
+
+// parseReminders turns offsets like "24h" or "10m" into durations before the event.
+// An empty list means a single reminder at the event time.
+func parseReminders(raw []string) ([]time.Duration, error) {
+	if len(raw) == 0 {
+		return []time.Duration{0}, nil
+	}
+	if len(raw) > maxReminders {
+		return nil, fmt.Errorf("%w: at most %d reminders", models.ErrInvalidReminders, maxReminders)
+	}
+
+	offsets := make([]time.Duration, 0, len(raw))
+	for _, v := range raw {
+		offset, err := time.ParseDuration(v)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("%w: %q", models.ErrInvalidReminders, v)
+		}
+		if slices.Contains(offsets, offset) {
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets, nil
 }
 
 func (s *Service) UpdateEvent(ctx context.Context, userEvent models.UserEvent) (*models.Event, error) {
@@ -104,6 +187,59 @@ func (s *Service) ReadEvents(ctx context.Context, userId, rawDate, genre string)
 	}
 }
 
-func New(repo RepositoryInterface) *Service {
-	return &Service{repo, *validator.New()}
+// ReadArchive lists archived events of a user, newest first. Empty from and to
+// mean no lower and no upper bound.
+func (s *Service) ReadArchive(ctx context.Context, userId, rawFrom, rawTo string, page, limit int) (*models.ArchivePage, error) {
+	if err := validUserId(userId); err != nil {
+		return nil, err
+	}
+
+	dateFrom := time.Unix(0, 0)
+	if rawFrom != "" {
+		if err := validDate(rawFrom); err != nil {
+			return nil, err
+		}
+		dateFrom, _ = time.Parse(time.RFC3339, rawFrom)
+	}
+	dateTo := time.Now()
+	if rawTo != "" {
+		if err := validDate(rawTo); err != nil {
+			return nil, err
+		}
+		dateTo, _ = time.Parse(time.RFC3339, rawTo)
+	}
+
+	if page <= 0 {
+		return nil, models.ErrInvalidPage
+	}
+	if limit <= 0 {
+		limit = models.DefaultPageSize
+	}
+	limit = min(limit, models.MaxPageSize)
+
+	return s.repo.ReadArchive(ctx, userId, dateFrom, dateTo, page, limit)
+}
+
+// RestoreEvent brings an archived event back. Archived events are in the past, so
+// the restored one needs a new date, otherwise the archiver would move it right back.
+func (s *Service) RestoreEvent(ctx context.Context, eventId string, req models.RestoreRequest) (*models.Event, error) {
+	if err := validUserId(req.UserId); err != nil {
+		return nil, err
+	}
+	if err := validEventId(eventId); err != nil {
+		return nil, err
+	}
+	if !req.Date.After(time.Now()) {
+		return nil, fmt.Errorf("%w: restored event date must be in the future", models.ErrInvalidDate)
+	}
+
+	return s.repo.RestoreEvent(ctx, req.UserId, eventId, req.Date)
+}
+
+func New(repo RepositoryInterface, channels []string) *Service {
+	configured := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		configured[channel] = true
+	}
+	return &Service{repo, *validator.New(), configured}
 }
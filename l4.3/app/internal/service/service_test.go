@@ -0,0 +1,73 @@
+package service
+
+import (
+	"app/internal/models"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseReminders(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		input    []string
+		expected []time.Duration
+		err      error
+	}{
+		{input: nil, expected: []time.Duration{0}, err: nil},
+		{input: []string{"24h", "1h", "10m", "1h"}, expected: []time.Duration{24 * time.Hour, time.Hour, 10 * time.Minute}, err: nil},
+		{input: []string{"-1h"}, expected: nil, err: models.ErrInvalidReminders},
+		{input: []string{"tomorrow"}, expected: nil, err: models.ErrInvalidReminders},
+		{input: make([]string, maxReminders+1), expected: nil, err: models.ErrInvalidReminders},
+	}
+
+	for _, v := range testCases {
+		res, err := parseReminders(v.input)
+		if !errors.Is(err, v.err) || !slices.Equal(res, v.expected) {
+			t.Logf("input: %v, expected: %v %v, got: %v %v", v.input, v.expected, v.err, res, err)
+			t.Fail()
+		}
+	}
+}
+
+type createRepo struct {
+	RepositoryInterface
+	reminders []models.Reminder
+}
+
+func (r *createRepo) CreateEvent(_ context.Context, userEvent models.UserEvent, reminders []models.Reminder) (*models.Event, error) {
+	r.reminders = reminders
+	return &userEvent.Event, nil
+}
+
+func TestCreateEventChecksChannels(t *testing.T) {
+	t.Parallel()
+	event := models.UserEvent{UserId: uuid.NewString(), Event: models.Event{Message: "standup", Date: time.Now().Add(time.Hour)}}
+	testCases := []struct {
+		channels []string
+		err      error
+	}{
+		{channels: nil, err: nil},
+		{channels: []string{models.ChannelWebhook}, err: nil},
+		{channels: []string{models.ChannelTelegram}, err: models.ErrInvalidChannel},
+		{channels: []string{"sms"}, err: models.ErrInvalidChannel},
+	}
+
+	for _, v := range testCases {
+		repo := &createRepo{}
+		s := New(repo, []string{models.ChannelEmail, models.ChannelWebhook})
+		_, err := s.CreateEvent(context.Background(), event, models.Recipients{Channels: v.channels, Email: "user@example.com"})
+		if !errors.Is(err, v.err) {
+			t.Logf("channels: %v, expected: %v, got: %v", v.channels, v.err, err)
+			t.Fail()
+		}
+		if v.err != nil && repo.reminders != nil {
+			t.Logf("channels: %v, expected no reminders, got: %v", v.channels, repo.reminders)
+			t.Fail()
+		}
+	}
+}
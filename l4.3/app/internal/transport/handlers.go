@@ -5,6 +5,8 @@ import (
 	"app/pkg/logger"
 	"context"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/wb-go/wbf/ginext"
@@ -14,7 +16,6 @@ type handlers struct {
 	ctx     context.Context
 	service ServiceInterface
 	logCh   chan models.ToLog
-	notifCh chan models.EventTask
 }
 
 func (h *handlers) middleware(c *ginext.Context) {
@@ -29,7 +30,14 @@ func (h *handlers) middleware(c *ginext.Context) {
 func (h *handlers) createEvent(c *ginext.Context) {
 	var event models.UserEvent
 
-	em := c.Query("email")
+	recipients := models.Recipients{
+		Email:          c.Query("email"),
+		TelegramChatId: c.Query("telegram_chat_id"),
+		Locale:         c.Query("locale"),
+	}
+	if channels := c.Query("channels"); channels != "" {
+		recipients.Channels = strings.Split(channels, ",")
+	}
 
 	if err := c.ShouldBindJSON(&event); err != nil {
 		h.logCh <- models.ToLog{Level: logger.ErrLevelKey, Error: err, Ctx: c.Request.Context()}
@@ -39,7 +47,7 @@ func (h *handlers) createEvent(c *ginext.Context) {
 		return
 	}
 
-	res, err := h.service.CreateEvent(c.Request.Context(), event, em)
+	res, err := h.service.CreateEvent(c.Request.Context(), event, recipients)
 	if err != nil {
 		h.logCh <- models.ToLog{Level: logger.ErrLevelKey, Error: err, Ctx: c.Request.Context()}
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
@@ -48,8 +56,6 @@ func (h *handlers) createEvent(c *ginext.Context) {
 		return
 	}
 
-	h.notifCh <- models.EventTask{Event: *res, Email: em}
-
 	c.JSON(http.StatusCreated, res)
 }
 
@@ -146,3 +152,45 @@ func (h *handlers) eventsForMonth(c *ginext.Context) {
 
 	c.JSON(http.StatusOK, events)
 }
+
+func (h *handlers) readArchive(c *ginext.Context) {
+	userId := c.Query("user_id")
+	from := c.Query("from")
+	to := c.Query("to")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(models.DefaultPageSize)))
+
+	res, err := h.service.ReadArchive(c.Request.Context(), userId, from, to, page, limit)
+	if err != nil {
+		h.logCh <- models.ToLog{Level: logger.ErrLevelKey, Error: err, Ctx: c.Request.Context()}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *handlers) restoreEvent(c *ginext.Context) {
+	var req models.RestoreRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.logCh <- models.ToLog{Level: logger.ErrLevelKey, Error: err, Ctx: c.Request.Context()}
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Error: models.ErrInvalidRequestBody.Error(),
+		})
+		return
+	}
+
+	res, err := h.service.RestoreEvent(c.Request.Context(), c.Param("event_id"), req)
+	if err != nil {
+		h.logCh <- models.ToLog{Level: logger.ErrLevelKey, Error: err, Ctx: c.Request.Context()}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
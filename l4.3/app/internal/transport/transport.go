@@ -16,10 +16,12 @@ import (
 )
 
 type ServiceInterface interface {
-	CreateEvent(context.Context, models.UserEvent, string) (*models.Event, error)
+	CreateEvent(context.Context, models.UserEvent, models.Recipients) (*models.Event, error)
 	UpdateEvent(context.Context, models.UserEvent) (*models.Event, error)
 	DeleteEvent(context.Context, models.UserEvent) error
 	ReadEvents(context.Context, string, string, string) ([]models.Event, error)
+	ReadArchive(context.Context, string, string, string, int, int) (*models.ArchivePage, error)
+	RestoreEvent(context.Context, string, models.RestoreRequest) (*models.Event, error)
 }
 
 type ServerConfig struct {
@@ -68,8 +70,7 @@ func (s *Server) Stop() {
 
 func New(service ServiceInterface, serverCfg *ServerConfig, wrk *archiver.Wrk, notif *notifyer.Notifyer, ctx context.Context) *Server {
 	logCh := make(chan models.ToLog, logger.BufSize)
-	notifCh := make(chan models.EventTask, notifyer.BufSize)
-	hers := &handlers{ctx, service, logCh, notifCh}
+	hers := &handlers{ctx, service, logCh}
 
 	mux := ginext.New(serverCfg.ReleaseMode)
 	mux.Use(cors.Default())
@@ -82,6 +83,8 @@ func New(service ServiceInterface, serverCfg *ServerConfig, wrk *archiver.Wrk, n
 	mux.GET("/events_for_day", hers.eventsForDay)
 	mux.GET("/events_for_week", hers.eventsForWeek)
 	mux.GET("/events_for_month", hers.eventsForMonth)
+	mux.GET("/archive", hers.readArchive)
+	mux.POST("/archive/:event_id/restore", hers.restoreEvent)
 
 	ctx, canc := context.WithCancel(ctx)
 
@@ -90,7 +93,7 @@ func New(service ServiceInterface, serverCfg *ServerConfig, wrk *archiver.Wrk, n
 
 	go logger.LoggerFromCtx(ctx).Start(logCh, &wg)
 	go wrk.Start(ctx, &wg)
-	go notif.Start(ctx, &wg, notifCh)
+	go notif.Start(ctx, &wg)
 
 	return &Server{&http.Server{Addr: fmt.Sprintf("%s:%d", serverCfg.Host, serverCfg.Port), Handler: mux}, ctx, canc, &wg, logCh}
 }
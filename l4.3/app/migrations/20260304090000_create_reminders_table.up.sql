@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS reminders (
+    reminder_id UUID PRIMARY KEY,
+    event_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    email TEXT NOT NULL,
+    message TEXT NOT NULL,
+    event_date TIMESTAMPTZ NOT NULL,
+    send_at TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    locked_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS reminders_status_send_at_idx ON reminders (status, send_at);
+CREATE INDEX IF NOT EXISTS reminders_event_id_idx ON reminders (event_id);
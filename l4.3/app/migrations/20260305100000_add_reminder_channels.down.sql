@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS reminders_dead_letter;
+
+ALTER TABLE reminders DROP COLUMN IF EXISTS channel;
+ALTER TABLE reminders RENAME COLUMN recipient TO email;
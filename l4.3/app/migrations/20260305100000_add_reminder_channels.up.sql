@@ -0,0 +1,16 @@
+ALTER TABLE reminders RENAME COLUMN email TO recipient;
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS channel TEXT NOT NULL DEFAULT 'email';
+
+CREATE TABLE IF NOT EXISTS reminders_dead_letter (
+    reminder_id UUID PRIMARY KEY,
+    event_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    channel TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    message TEXT NOT NULL,
+    event_date TIMESTAMPTZ NOT NULL,
+    send_at TIMESTAMPTZ NOT NULL,
+    attempts INT NOT NULL,
+    last_error TEXT NOT NULL,
+    failed_at TIMESTAMPTZ DEFAULT NOW()
+);
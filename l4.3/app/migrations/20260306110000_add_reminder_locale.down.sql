@@ -0,0 +1,2 @@
+ALTER TABLE reminders_dead_letter DROP COLUMN IF EXISTS locale;
+ALTER TABLE reminders DROP COLUMN IF EXISTS locale;
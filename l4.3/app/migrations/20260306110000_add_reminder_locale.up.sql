@@ -0,0 +1,2 @@
+ALTER TABLE reminders ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT '';
+ALTER TABLE reminders_dead_letter ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS events_date_idx;
+DROP INDEX IF EXISTS archive_archived_at_idx;
+DROP INDEX IF EXISTS archive_user_id_date_idx;
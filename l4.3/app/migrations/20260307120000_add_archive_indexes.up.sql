@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS archive_user_id_date_idx ON archive (user_id, date);
+CREATE INDEX IF NOT EXISTS archive_archived_at_idx ON archive (archived_at);
+CREATE INDEX IF NOT EXISTS events_date_idx ON events (date);
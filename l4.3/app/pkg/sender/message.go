@@ -0,0 +1,177 @@
+package sender
+
+import (
+	"app/internal/models"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const icsDateLayout = "20060102T150405Z"
+
+// buildMessage renders an RFC 5322 message: multipart/mixed with a
+// multipart/alternative text+html body and an invite.ics attachment.
+func (s *Sender) buildMessage(rem models.Reminder) ([]byte, error) {
+	event := models.Event{EventId: rem.EventId, Message: rem.Message, Date: rem.EventDate.UTC()}
+	email, err := s.templates.forLocale(rem.Locale).render(event)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", models.ErrInvalidSmtpConfig, err)
+	}
+	to, err := mail.ParseAddress(rem.Recipient)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", models.ErrInvalidEmail, err)
+	}
+
+	var altBuf bytes.Buffer
+	alt := multipart.NewWriter(&altBuf)
+	if err := writeQuotedPrintable(alt, "text/plain; charset=UTF-8", email.text); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPrintable(alt, "text/html; charset=UTF-8", email.html); err != nil {
+		return nil, err
+	}
+	if err := alt.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	mixed := multipart.NewWriter(&buf)
+
+	writeHeader(&buf, "From", from.String())
+	writeHeader(&buf, "To", to.String())
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", email.subject))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", fmt.Sprintf("<%s@%s>", uuid.NewString(), domainOf(from.Address)))
+	writeHeader(&buf, "MIME-Version", "1.0")
+	writeHeader(&buf, "Content-Type", fmt.Sprintf("multipart/mixed; boundary=%q", mixed.Boundary()))
+	buf.WriteString("\r\n")
+
+	altPart, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {fmt.Sprintf("multipart/alternative; boundary=%q", alt.Boundary())},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := altPart.Write(altBuf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	icsPart, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/calendar; charset=UTF-8; method=PUBLISH; name="invite.ics"`},
+		"Content-Disposition":       {`attachment; filename="invite.ics"`},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBase64(icsPart, buildIcs(event, domainOf(from.Address))); err != nil {
+		return nil, err
+	}
+
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key + ": " + value + "\r\n")
+}
+
+func domainOf(address string) string {
+	if _, domain, ok := strings.Cut(address, "@"); ok && domain != "" {
+		return domain
+	}
+	return "localhost"
+}
+
+func writeQuotedPrintable(mw *multipart.Writer, contentType, body string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64 writes data base64 encoded in 76 character lines as RFC 2045 requires.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := min(76, len(encoded))
+		if _, err := w.Write([]byte(encoded[:n] + "\r\n")); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+func buildIcs(event models.Event, domain string) []byte {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//events//reminder//EN",
+		"METHOD:PUBLISH",
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:%s@%s", event.EventId, domain),
+		"DTSTAMP:" + time.Now().UTC().Format(icsDateLayout),
+		"DTSTART:" + event.Date.UTC().Format(icsDateLayout),
+		"SUMMARY:" + escapeIcsText(event.Message),
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}
+
+	var buf bytes.Buffer
+	for _, l := range lines {
+		buf.WriteString(foldIcsLine(l))
+	}
+	return buf.Bytes()
+}
+
+func escapeIcsText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// foldIcsLine splits content lines longer than 75 octets, RFC 5545 section 3.1.
+// It never cuts inside a multi-byte utf-8 sequence.
+func foldIcsLine(line string) string {
+	var b strings.Builder
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+	return b.String()
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
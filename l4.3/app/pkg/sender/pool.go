@@ -0,0 +1,89 @@
+package sender
+
+import (
+	"context"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+type pooledClient struct {
+	c        *smtp.Client
+	lastUsed time.Time
+}
+
+// pool keeps up to size smtp connections, so a burst of reminders doesn't pay
+// for a dial, TLS handshake and AUTH per message. Idle connections older than
+// idleTimeout are closed instead of reused.
+type pool struct {
+	dial        func(context.Context) (*smtp.Client, error)
+	idleTimeout time.Duration
+	sem         chan struct{}
+
+	mu   sync.Mutex
+	idle []pooledClient
+}
+
+func newPool(size int, idleTimeout time.Duration, dial func(context.Context) (*smtp.Client, error)) *pool {
+	return &pool{dial: dial, idleTimeout: idleTimeout, sem: make(chan struct{}, size)}
+}
+
+func (p *pool) get(ctx context.Context) (*smtp.Client, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		pc, ok := p.popIdle()
+		if !ok {
+			break
+		}
+		if time.Since(pc.lastUsed) < p.idleTimeout && pc.c.Noop() == nil {
+			return pc.c, nil
+		}
+		pc.c.Close()
+	}
+
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+	return c, nil
+}
+
+// put returns c to the pool, a connection that failed is closed because its state is unknown.
+func (p *pool) put(c *smtp.Client, sendErr error) {
+	defer func() { <-p.sem }()
+
+	if sendErr != nil || c.Reset() != nil {
+		c.Close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle = append(p.idle, pooledClient{c, time.Now()})
+}
+
+func (p *pool) popIdle() (pooledClient, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) == 0 {
+		return pooledClient{}, false
+	}
+	pc := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return pc, true
+}
+
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pc := range p.idle {
+		pc.c.Quit()
+	}
+	p.idle = nil
+}
@@ -2,63 +2,196 @@ package sender
 
 import (
 	"app/internal/models"
-	"bytes"
-	"html/template"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
 	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	AuthNone  = ""
+	AuthPlain = "plain"
+	AuthLogin = "login"
+
+	TlsNone     = ""
+	TlsStartTls = "starttls"
+	TlsImplicit = "tls"
 )
 
 type Sender struct {
 	SenderConfig
+	templates *templates
+	pool      *pool
+	rootCAs   *x509.CertPool
 }
 
 type SenderConfig struct {
-	From     string `env:"SMTP_FROM" env-default:"noreply@example.com"`
-	Password string `env:"SMTP_PASSWORD" env-default:""`
-	SmtpHost string `env:"SMTP_HOST" env-default:"localhost"`
-	SmtpPort string `env:"SMTP_PORT" env-default:"1025"`
+	From          string `env:"SMTP_FROM" env-default:"noreply@example.com"`
+	Username      string `env:"SMTP_USERNAME" env-default:""`
+	Password      string `env:"SMTP_PASSWORD" env-default:""`
+	SmtpHost      string `env:"SMTP_HOST" env-default:"localhost"`
+	SmtpPort      string `env:"SMTP_PORT" env-default:"1025"`
+	Auth          string `env:"SMTP_AUTH" env-default:""`
+	Tls           string `env:"SMTP_TLS" env-default:""`
+	CaFile        string `env:"SMTP_CA_FILE" env-default:""`
+	PoolSize      int    `env:"SMTP_POOL_SIZE" env-default:"4"`
+	IdleTimeout   int    `env:"SMTP_IDLE_TIMEOUT" env-default:"30"`
+	Timeout       int    `env:"SMTP_TIMEOUT" env-default:"10"`
+	TemplatesDir  string `env:"SMTP_TEMPLATES_DIR" env-default:"./templates"`
+	DefaultLocale string `env:"SMTP_DEFAULT_LOCALE" env-default:"ru"`
+}
+
+// Valid checks the settings that can't be checked by the smtp server itself.
+func (cfg SenderConfig) Valid() error {
+	switch cfg.Auth {
+	case AuthNone, AuthPlain, AuthLogin:
+	default:
+		return fmt.Errorf("%w: unsupported auth %q", models.ErrInvalidSmtpConfig, cfg.Auth)
+	}
+	switch cfg.Tls {
+	case TlsNone, TlsStartTls, TlsImplicit:
+	default:
+		return fmt.Errorf("%w: unsupported tls mode %q", models.ErrInvalidSmtpConfig, cfg.Tls)
+	}
+	if cfg.PoolSize <= 0 || cfg.IdleTimeout <= 0 || cfg.Timeout <= 0 {
+		return fmt.Errorf("%w: pool size and timeouts must be positive", models.ErrInvalidSmtpConfig)
+	}
+	return nil
 }
 
 func New(cfg SenderConfig) *Sender {
-	return &Sender{cfg}
+	tmpls, err := loadTemplates(cfg.TemplatesDir, cfg.DefaultLocale)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load email templates: %v", err))
+	}
+
+	s := &Sender{SenderConfig: cfg, templates: tmpls}
+	if cfg.CaFile != "" {
+		pem, err := os.ReadFile(cfg.CaFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to read smtp ca file: %v", err))
+		}
+		s.rootCAs = x509.NewCertPool()
+		if !s.rootCAs.AppendCertsFromPEM(pem) {
+			panic("failed to parse smtp ca file")
+		}
+	}
+	s.pool = newPool(cfg.PoolSize, time.Duration(cfg.IdleTimeout)*time.Second, s.dial)
+	return s
+}
+
+// Notify sends the reminder by email, the recipient is an email address.
+func (s *Sender) Notify(ctx context.Context, rem models.Reminder) error {
+	msg, err := s.buildMessage(rem)
+	if err != nil {
+		return err
+	}
+	return s.send(ctx, rem.Recipient, msg)
 }
 
-func (s *Sender) SendReminder(to string, event models.Event) error {
-	tmpl := `
-    <!DOCTYPE html>
-    <html>
-    <head>
-        <style>
-            .reminder { font-family: Arial; padding: 20px; }
-            .message { font-size: 18px; color: #333; }
-            .date { color: #666; }
-        </style>
-    </head>
-    <body>
-        <div class="reminder">
-            <h2>Напоминание о событии</h2>
-            <p class="message">{{.Message}}</p>
-            <p class="date">Создано: {{.CreatedAt.Format "02.01.2006 15:04"}}</p>
-        </div>
-    </body>
-    </html>
-    `
-
-	t, err := template.New("email").Parse(tmpl)
+// Close closes idle smtp connections.
+func (s *Sender) Close() {
+	s.pool.close()
+}
+
+func (s *Sender) send(ctx context.Context, to string, msg []byte) error {
+	c, err := s.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = sendOn(c, s.From, to, msg)
+	s.pool.put(c, err)
+	return err
+}
+
+func sendOn(c *smtp.Client, from, to string, msg []byte) error {
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
 	if err != nil {
 		return err
 	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *Sender) tlsConfig() *tls.Config {
+	return &tls.Config{ServerName: s.SmtpHost, RootCAs: s.rootCAs}
+}
+
+func (s *Sender) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.SmtpHost, s.SmtpPort)
+	dialer := &net.Dialer{Timeout: time.Duration(s.Timeout) * time.Second}
+
+	var conn net.Conn
+	var err error
+	if s.Tls == TlsImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig()}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	var body bytes.Buffer
-	if err := t.Execute(&body, event); err != nil {
+	c, err := smtp.NewClient(conn, s.SmtpHost)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := s.handshake(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *Sender) handshake(c *smtp.Client) error {
+	if err := c.Hello("localhost"); err != nil {
 		return err
 	}
 
-	headers := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";"
-	msg := "From: " + s.From + "\n" +
-		"To: " + to + "\n" +
-		"Subject: Напоминание о событии\n" +
-		headers + "\n\n" +
-		body.String()
+	if s.Tls == TlsStartTls {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%w: server does not support STARTTLS", models.ErrInvalidSmtpConfig)
+		}
+		if err := c.StartTLS(s.tlsConfig()); err != nil {
+			return err
+		}
+	}
 
-	return smtp.SendMail(s.SmtpHost+":"+s.SmtpPort, nil, s.From, []string{to}, []byte(msg))
+	if s.Auth == AuthNone {
+		return nil
+	}
+	_, mechanisms := c.Extension("AUTH")
+	mechanism := strings.ToUpper(s.Auth)
+	if !strings.Contains(" "+mechanisms+" ", " "+mechanism+" ") {
+		return fmt.Errorf("%w: server does not support AUTH %s", models.ErrInvalidSmtpConfig, mechanism)
+	}
+	return c.Auth(s.auth())
+}
+
+func (s *Sender) auth() smtp.Auth {
+	username := s.Username
+	if username == "" {
+		username = s.From
+	}
+	if s.Auth == AuthLogin {
+		return &loginAuth{username: username, password: s.Password, host: s.SmtpHost}
+	}
+	return smtp.PlainAuth("", username, s.Password, s.SmtpHost)
 }
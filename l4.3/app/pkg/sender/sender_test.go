@@ -0,0 +1,336 @@
+package sender
+
+import (
+	"app/internal/models"
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const templatesDir = "../../templates"
+
+type fakeMail struct {
+	from string
+	to   []string
+	user string
+	tls  bool
+	data string
+}
+
+// fakeSmtp is a minimal SMTP server that accepts every message and hands it to mails.
+// It supports AUTH PLAIN/LOGIN and, when tlsCfg is set, STARTTLS or implicit TLS.
+type fakeSmtp struct {
+	ln       net.Listener
+	mails    chan fakeMail
+	tlsCfg   *tls.Config
+	implicit bool
+	conns    atomic.Int32
+}
+
+func newFakeSmtp(t *testing.T, tlsCfg *tls.Config, implicit bool) *fakeSmtp {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if implicit {
+		ln = tls.NewListener(ln, tlsCfg)
+	}
+	s := &fakeSmtp{ln: ln, mails: make(chan fakeMail, 10), tlsCfg: tlsCfg, implicit: implicit}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns.Add(1)
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSmtp) hostPort() (string, string) {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	return host, port
+}
+
+func (s *fakeSmtp) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	readLine := func() (string, bool) {
+		l, err := r.ReadString('\n')
+		return strings.TrimRight(l, "\r\n"), err == nil
+	}
+	decode := func(s string) string {
+		raw, _ := base64.StdEncoding.DecodeString(s)
+		return string(raw)
+	}
+
+	isTls := s.implicit
+	reply("220 fake ESMTP")
+	var mail fakeMail
+	for {
+		cmd, ok := readLine()
+		if !ok {
+			return
+		}
+		upper := strings.ToUpper(cmd)
+		switch {
+		case strings.HasPrefix(upper, "EHLO"), strings.HasPrefix(upper, "HELO"):
+			reply("250-fake")
+			if s.tlsCfg != nil && !isTls {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN LOGIN")
+		case upper == "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tlsCfg)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, r, isTls = tlsConn, bufio.NewReader(tlsConn), true
+		case strings.HasPrefix(upper, "AUTH PLAIN"):
+			parts := strings.Split(decode(strings.TrimSpace(cmd[len("AUTH PLAIN"):])), "\x00")
+			mail.user = parts[1]
+			reply("235 ok")
+		case upper == "AUTH LOGIN":
+			reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+			user, _ := readLine()
+			reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+			readLine()
+			mail.user = decode(user)
+			reply("235 ok")
+		case strings.HasPrefix(upper, "MAIL FROM:"):
+			mail = fakeMail{from: strings.Trim(cmd[len("MAIL FROM:"):], "<> "), user: mail.user}
+			reply("250 OK")
+		case strings.HasPrefix(upper, "RCPT TO:"):
+			mail.to = append(mail.to, strings.Trim(cmd[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case upper == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, ok := readLine()
+				if !ok {
+					return
+				}
+				if l == "." {
+					break
+				}
+				data.WriteString(l + "\r\n")
+			}
+			mail.data, mail.tls = data.String(), isTls
+			s.mails <- mail
+			reply("250 OK")
+		case upper == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *fakeSmtp) receive(t *testing.T) fakeMail {
+	select {
+	case mail := <-s.mails:
+		return mail
+	case <-time.After(2 * time.Second):
+		t.Fatal("mail not received")
+		return fakeMail{}
+	}
+}
+
+// selfSigned returns a server tls config for 127.0.0.1 and writes its certificate to a pem file.
+func selfSigned(t *testing.T) (*tls.Config, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, caFile
+}
+
+func newTestSender(srv *fakeSmtp, cfg SenderConfig) *Sender {
+	cfg.SmtpHost, cfg.SmtpPort = srv.hostPort()
+	cfg.From = "Events <noreply@example.com>"
+	cfg.TemplatesDir = templatesDir
+	cfg.DefaultLocale = "ru"
+	cfg.PoolSize, cfg.IdleTimeout, cfg.Timeout = 2, 30, 2
+	return New(cfg)
+}
+
+func testReminder(locale string) models.Reminder {
+	return models.Reminder{
+		EventId:   "07fa23a8-ede2-419b-a0c7-9bf8d3813477",
+		Recipient: "user@example.com",
+		Locale:    locale,
+		Message:   "Зубной врач, 3 этаж",
+		EventDate: time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestSenderMessage(t *testing.T) {
+	srv := newFakeSmtp(t, nil, false)
+	snd := newTestSender(srv, SenderConfig{})
+	defer snd.Close()
+
+	testCases := []struct {
+		locale  string
+		subject string
+		text    string
+	}{
+		{locale: "", subject: "Напоминание: Зубной врач, 3 этаж", text: "Когда: 04.03.2026 15:00"},
+		{locale: "en-US", subject: "Reminder: Зубной врач, 3 этаж", text: "When: Mar 4, 2026 15:00"},
+		{locale: "de", subject: "Напоминание: Зубной врач, 3 этаж", text: "Когда: 04.03.2026 15:00"},
+	}
+
+	for _, v := range testCases {
+		if err := snd.Notify(context.Background(), testReminder(v.locale)); err != nil {
+			t.Fatal(err)
+		}
+		raw := srv.receive(t)
+
+		msg, err := mail.ReadMessage(strings.NewReader(raw.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if subject != v.subject || msg.Header.Get("Date") == "" || !strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>") {
+			t.Logf("locale: %s, headers: %v, subject: %s", v.locale, msg.Header, subject)
+			t.Fail()
+		}
+
+		mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if mediaType != "multipart/mixed" {
+			t.Fatalf("expected multipart/mixed, got: %s", mediaType)
+		}
+		parts := multipart.NewReader(msg.Body, params["boundary"])
+
+		alt, _ := parts.NextPart()
+		_, altParams, _ := mime.ParseMediaType(alt.Header.Get("Content-Type"))
+		altParts := multipart.NewReader(alt, altParams["boundary"])
+		text, _ := altParts.NextPart()
+		textBody, _ := io.ReadAll(text)
+		html, _ := altParts.NextPart()
+		htmlBody, _ := io.ReadAll(html)
+		if !strings.Contains(string(textBody), v.text) || !strings.Contains(string(htmlBody), "<p class=\"message\">Зубной врач, 3 этаж</p>") {
+			t.Logf("locale: %s, text: %s, html: %s", v.locale, textBody, htmlBody)
+			t.Fail()
+		}
+
+		ics, _ := parts.NextPart()
+		icsBody, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, ics))
+		if ics.FileName() != "invite.ics" || !strings.Contains(string(icsBody), "DTSTART:20260304T150000Z") || !strings.Contains(string(icsBody), `SUMMARY:Зубной врач\, 3 этаж`) {
+			t.Logf("filename: %s, ics: %s", ics.FileName(), icsBody)
+			t.Fail()
+		}
+	}
+}
+
+func TestSenderAuthAndTls(t *testing.T) {
+	tlsCfg, caFile := selfSigned(t)
+
+	testCases := []struct {
+		name     string
+		tlsCfg   *tls.Config
+		implicit bool
+		cfg      SenderConfig
+	}{
+		{name: "plain auth without tls", cfg: SenderConfig{Auth: AuthPlain, Username: "user", Password: "pass"}},
+		{name: "login auth over starttls", tlsCfg: tlsCfg, cfg: SenderConfig{Auth: AuthLogin, Username: "user", Password: "pass", Tls: TlsStartTls, CaFile: caFile}},
+		{name: "plain auth over implicit tls", tlsCfg: tlsCfg, implicit: true, cfg: SenderConfig{Auth: AuthPlain, Username: "user", Password: "pass", Tls: TlsImplicit, CaFile: caFile}},
+	}
+
+	for _, v := range testCases {
+		srv := newFakeSmtp(t, v.tlsCfg, v.implicit)
+		snd := newTestSender(srv, v.cfg)
+
+		if err := snd.Notify(context.Background(), testReminder("")); err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		mail := srv.receive(t)
+		if mail.user != "user" || mail.tls != (v.cfg.Tls != TlsNone) {
+			t.Logf("%s: got user %q, tls %v", v.name, mail.user, mail.tls)
+			t.Fail()
+		}
+		snd.Close()
+	}
+}
+
+func TestSenderPoolReusesConnections(t *testing.T) {
+	srv := newFakeSmtp(t, nil, false)
+	snd := newTestSender(srv, SenderConfig{})
+	defer snd.Close()
+
+	for range 5 {
+		if err := snd.Notify(context.Background(), testReminder("")); err != nil {
+			t.Fatal(err)
+		}
+		srv.receive(t)
+	}
+
+	if n := srv.conns.Load(); n != 1 {
+		t.Logf("expected 1 connection, got: %d", n)
+		t.Fail()
+	}
+}
+
+func TestFoldIcsLine(t *testing.T) {
+	t.Parallel()
+	line := "SUMMARY:" + strings.Repeat("ж", 100)
+
+	folded := foldIcsLine(line)
+	for _, l := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(l) > 75 {
+			t.Logf("line longer than 75 octets: %q", l)
+			t.Fail()
+		}
+	}
+	if strings.ReplaceAll(strings.TrimSuffix(folded, "\r\n"), "\r\n ", "") != line {
+		t.Logf("unfolded line differs: %q", folded)
+		t.Fail()
+	}
+}
@@ -0,0 +1,87 @@
+package sender
+
+import (
+	"app/internal/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+type TelegramConfig struct {
+	Token   string `env:"TELEGRAM_TOKEN" env-default:""`
+	ApiUrl  string `env:"TELEGRAM_API_URL" env-default:"https://api.telegram.org"`
+	Timeout int    `env:"TELEGRAM_TIMEOUT" env-default:"5"`
+}
+
+type telegramMessage struct {
+	ChatId string `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+type telegramResponse struct {
+	Ok          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+// Telegram sends reminders through the Bot API sendMessage method. Any server
+// compatible with that method can be used by changing TELEGRAM_API_URL. The
+// text is the plain text body of the email templates in the reminder's locale.
+type Telegram struct {
+	cfg       TelegramConfig
+	client    *http.Client
+	templates *templates
+}
+
+func NewTelegram(cfg TelegramConfig, templatesDir, defaultLocale string) *Telegram {
+	tmpls, err := loadTemplates(templatesDir, defaultLocale)
+	if err != nil {
+		panic(fmt.Sprintf("failed to load telegram templates: %v", err))
+	}
+	return &Telegram{cfg, &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}, tmpls}
+}
+
+// Notify sends the reminder, the recipient is a chat id.
+func (t *Telegram) Notify(ctx context.Context, rem models.Reminder) error {
+	event := models.Event{EventId: rem.EventId, Message: rem.Message, Date: rem.EventDate.UTC()}
+	text, err := t.templates.forLocale(rem.Locale).renderText(event)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(telegramMessage{ChatId: rem.Recipient, Text: text})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimRight(t.cfg.ApiUrl, "/"), t.cfg.Token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// url.Error carries the request url, which contains the bot token
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			return errors.Join(models.ErrOnDelivery, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("%w: telegram responded with %d", models.ErrOnDelivery, resp.StatusCode)
+	}
+	if !res.Ok {
+		return fmt.Errorf("%w: telegram: %s", models.ErrOnDelivery, res.Description)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package sender
+
+import (
+	"app/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTelegramNotify(t *testing.T) {
+	t.Parallel()
+
+	var path string
+	var got telegramMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+		if got.ChatId == "blocked" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"ok":false,"description":"Forbidden: bot was blocked by the user"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+
+	tg := NewTelegram(TelegramConfig{Token: "token", ApiUrl: srv.URL, Timeout: 1}, templatesDir, "ru")
+
+	date := time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC)
+	if err := tg.Notify(context.Background(), models.Reminder{Recipient: "42", Message: "dentist", EventDate: date}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bottoken/sendMessage" || got.ChatId != "42" || !strings.Contains(got.Text, "Напоминание о событии") || !strings.Contains(got.Text, "dentist") {
+		t.Logf("path: %s, message: %+v", path, got)
+		t.Fail()
+	}
+
+	// the text comes from the reminder's locale like the email does
+	if err := tg.Notify(context.Background(), models.Reminder{Recipient: "42", Locale: "en-US", Message: "dentist", EventDate: date}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got.Text, "Event reminder") || !strings.Contains(got.Text, "Mar 4, 2026 15:00") {
+		t.Logf("expected an english message, got: %q", got.Text)
+		t.Fail()
+	}
+
+	if err := tg.Notify(context.Background(), models.Reminder{Recipient: "blocked"}); !errors.Is(err, models.ErrOnDelivery) {
+		t.Logf("expected: %v, got: %v", models.ErrOnDelivery, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,111 @@
+package sender
+
+import (
+	"app/internal/models"
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+const (
+	subjectFile  = "subject.txt"
+	textBodyFile = "body.txt"
+	htmlBodyFile = "body.html"
+)
+
+type localeTemplates struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// templates holds email templates parsed once at startup. Every subdirectory of
+// the templates dir is a locale ("ru", "en", ...) with subject.txt, body.txt and body.html.
+type templates struct {
+	locales       map[string]*localeTemplates
+	defaultLocale string
+}
+
+func loadTemplates(dir, defaultLocale string) (*templates, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &templates{locales: make(map[string]*localeTemplates), defaultLocale: strings.ToLower(defaultLocale)}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		locDir := filepath.Join(dir, e.Name())
+
+		var lt localeTemplates
+		if lt.subject, err = texttemplate.ParseFiles(filepath.Join(locDir, subjectFile)); err != nil {
+			return nil, err
+		}
+		if lt.text, err = texttemplate.ParseFiles(filepath.Join(locDir, textBodyFile)); err != nil {
+			return nil, err
+		}
+		if lt.html, err = htmltemplate.ParseFiles(filepath.Join(locDir, htmlBodyFile)); err != nil {
+			return nil, err
+		}
+		t.locales[strings.ToLower(e.Name())] = &lt
+	}
+
+	if _, ok := t.locales[t.defaultLocale]; !ok {
+		return nil, fmt.Errorf("%w: default locale %q not found in %s", models.ErrNoTemplates, defaultLocale, dir)
+	}
+	return t, nil
+}
+
+// forLocale picks the best match for a BCP 47 tag: "en-US" falls back to "en",
+// unknown locales fall back to the default one.
+func (t *templates) forLocale(locale string) *localeTemplates {
+	locale = strings.ToLower(locale)
+	if lt, ok := t.locales[locale]; ok {
+		return lt
+	}
+	if base, _, found := strings.Cut(locale, "-"); found {
+		if lt, ok := t.locales[base]; ok {
+			return lt
+		}
+	}
+	return t.locales[t.defaultLocale]
+}
+
+type renderedEmail struct {
+	subject string
+	text    string
+	html    string
+}
+
+func (lt *localeTemplates) render(event models.Event) (*renderedEmail, error) {
+	var subject, text, html bytes.Buffer
+	if err := lt.subject.Execute(&subject, event); err != nil {
+		return nil, err
+	}
+	if err := lt.text.Execute(&text, event); err != nil {
+		return nil, err
+	}
+	if err := lt.html.Execute(&html, event); err != nil {
+		return nil, err
+	}
+
+	// a subject is a single header line, never let the event message break out of it
+	oneLine := strings.Join(strings.Fields(subject.String()), " ")
+	return &renderedEmail{subject: oneLine, text: text.String(), html: html.String()}, nil
+}
+
+// renderText renders only the plain text body, for channels without a subject
+// or html.
+func (lt *localeTemplates) renderText(event models.Event) (string, error) {
+	var text bytes.Buffer
+	if err := lt.text.Execute(&text, event); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
@@ -0,0 +1,74 @@
+package sender
+
+import (
+	"app/internal/models"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const SignatureHeader = "X-Signature-256"
+
+type WebhookConfig struct {
+	Url     string `env:"WEBHOOK_URL" env-default:""`
+	Secret  string `env:"WEBHOOK_SECRET" env-default:""`
+	Timeout int    `env:"WEBHOOK_TIMEOUT" env-default:"5"`
+}
+
+type webhookPayload struct {
+	UserId string       `json:"user_id"`
+	Event  models.Event `json:"event"`
+	SentAt time.Time    `json:"sent_at"`
+}
+
+// Webhook posts reminders as JSON to a single configured url. The body is signed
+// with HMAC-SHA256 of the shared secret, hex encoded in the X-Signature-256 header.
+type Webhook struct {
+	cfg    WebhookConfig
+	client *http.Client
+}
+
+func NewWebhook(cfg WebhookConfig) *Webhook {
+	return &Webhook{cfg, &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Second}}
+}
+
+// Notify posts the reminder, the recipient is the user id.
+func (w *Webhook) Notify(ctx context.Context, rem models.Reminder) error {
+	event := models.Event{EventId: rem.EventId, Message: rem.Message, Date: rem.EventDate}
+	body, err := json.Marshal(webhookPayload{UserId: rem.Recipient, Event: event, SentAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+Sign(w.cfg.Secret, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Join(models.ErrOnDelivery, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: webhook responded with %d", models.ErrOnDelivery, resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body, receivers use it to verify webhooks.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
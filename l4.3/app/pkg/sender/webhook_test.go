@@ -0,0 +1,64 @@
+package sender
+
+import (
+	"app/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	t.Parallel()
+	secret := "secret"
+
+	var got webhookPayload
+	var signature, expectedSignature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		expectedSignature = "sha256=" + Sign(secret, body)
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	wh := NewWebhook(WebhookConfig{Url: srv.URL, Secret: secret, Timeout: 1})
+	if err := wh.Notify(context.Background(), models.Reminder{Recipient: "user", EventId: "1", Message: "dentist"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if signature != expectedSignature || got.UserId != "user" || got.Event.Message != "dentist" {
+		t.Logf("signature: %s, expected: %s, payload: %+v", signature, expectedSignature, got)
+		t.Fail()
+	}
+}
+
+func TestWebhookNotifyBadStatus(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	wh := NewWebhook(WebhookConfig{Url: srv.URL, Timeout: 1})
+	if err := wh.Notify(context.Background(), models.Reminder{Recipient: "user"}); !errors.Is(err, models.ErrOnDelivery) {
+		t.Logf("expected: %v, got: %v", models.ErrOnDelivery, err)
+		t.Fail()
+	}
+}
+
+func TestWebhookNotifyUnreachable(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+
+	wh := NewWebhook(WebhookConfig{Url: srv.URL, Timeout: 1})
+	if err := wh.Notify(context.Background(), models.Reminder{Recipient: "user"}); !errors.Is(err, models.ErrOnDelivery) {
+		t.Logf("expected: %v, got: %v", models.ErrOnDelivery, err)
+		t.Fail()
+	}
+}
@@ -3,18 +3,28 @@ package archiver
 import (
 	"app/internal/models"
 	"app/pkg/logger"
+	"compress/gzip"
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/wb-go/wbf/dbpg"
 )
 
+const (
+	RetentionDelete = "delete"
+	RetentionExport = "export"
+)
+
 type Wrk struct {
-	db       *dbpg.DB
-	interval int
+	db  *dbpg.DB
+	cfg WrkConfig
 }
 
 type WrkConfig struct {
@@ -24,63 +34,200 @@ type WrkConfig struct {
 	DbPassword string `env:"DB_PASSWORD" env-default:"12345"`
 	DbName     string `env:"DB_NAME" env-default:"events"`
 	Interval   int    `env:"WRK_INTERVAL" env-default:"10"`
+	BatchSize  int    `env:"WRK_BATCH_SIZE" env-default:"1000"`
+	// RetentionDays is how long archived events are kept, 0 keeps them forever.
+	RetentionDays int    `env:"ARCHIVE_RETENTION_DAYS" env-default:"0"`
+	RetentionMode string `env:"ARCHIVE_RETENTION_MODE" env-default:"delete"`
+	ExportDir     string `env:"ARCHIVE_EXPORT_DIR" env-default:"./archive-export"`
+}
+
+// Valid checks the batch and retention settings.
+func (cfg WrkConfig) Valid() error {
+	if cfg.Interval <= 0 || cfg.BatchSize <= 0 || cfg.RetentionDays < 0 {
+		return models.ErrInvalidArchiveCfg
+	}
+	if cfg.RetentionMode != RetentionDelete && cfg.RetentionMode != RetentionExport {
+		return fmt.Errorf("%w: unsupported retention mode %q", models.ErrInvalidArchiveCfg, cfg.RetentionMode)
+	}
+	return nil
 }
 
 func (w *Wrk) archive(ctx context.Context) {
 	lg := logger.LoggerFromCtx(ctx).Lg
 
+	// one statement per batch: past events are deleted and inserted into archive
+	// without round-tripping rows through the app
+	q := `WITH moved AS (
+		DELETE FROM events WHERE event_id IN (
+			SELECT event_id FROM events WHERE date < NOW() ORDER BY date LIMIT $1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING event_id, user_id, message, date, created_at, updated_at
+	)
+	INSERT INTO archive (event_id, user_id, message, date, created_at, updated_at)
+	SELECT event_id, user_id, message, date, created_at, updated_at FROM moved
+	ON CONFLICT (event_id) DO UPDATE SET user_id = EXCLUDED.user_id, message = EXCLUDED.message, date = EXCLUDED.date,
+		created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at, archived_at = NOW()`
+
+	var total int64
+	for ctx.Err() == nil {
+		res, err := w.db.ExecContext(ctx, q, w.cfg.BatchSize)
+		if err != nil {
+			lg.Error().Str("worker", "archiver").Err(errors.Join(models.ErrOnDatabase, err)).Send()
+			return
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			lg.Error().Str("worker", "archiver").Err(errors.Join(models.ErrOnDatabase, err)).Send()
+			return
+		}
+		total += n
+		if n < int64(w.cfg.BatchSize) {
+			break
+		}
+	}
+
+	lg.Info().Str("worker", "archiver").Int64("quantity", total).Msg("events archived successfully")
+}
+
+func (w *Wrk) retain(ctx context.Context) {
+	if w.cfg.RetentionDays == 0 {
+		return
+	}
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	var total int64
+	for ctx.Err() == nil {
+		var n int64
+		var err error
+		if w.cfg.RetentionMode == RetentionExport {
+			n, err = w.exportBatch(ctx)
+		} else {
+			n, err = w.deleteBatch(ctx)
+		}
+		if err != nil {
+			lg.Error().Str("worker", "archiver").Err(err).Msg("while applying retention policy")
+			return
+		}
+		total += n
+		if n < int64(w.cfg.BatchSize) {
+			break
+		}
+	}
+
+	if total > 0 {
+		lg.Info().Str("worker", "archiver").Str("mode", w.cfg.RetentionMode).Int64("quantity", total).Msg("expired archived events removed")
+	}
+}
+
+func (w *Wrk) deleteBatch(ctx context.Context) (int64, error) {
+	q := `DELETE FROM archive WHERE event_id IN (
+		SELECT event_id FROM archive WHERE archived_at < NOW() - make_interval(days => $1) LIMIT $2 FOR UPDATE SKIP LOCKED
+	)`
+
+	res, err := w.db.ExecContext(ctx, q, w.cfg.RetentionDays, w.cfg.BatchSize)
+	if err != nil {
+		return 0, errors.Join(models.ErrOnDatabase, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Join(models.ErrOnDatabase, err)
+	}
+	return n, nil
+}
+
+// exportBatch writes one batch of expired events to a gzip'd JSONL file and deletes
+// them in the same transaction. The file is closed before commit, so a failed commit
+// can leave a duplicate export but never loses events.
+func (w *Wrk) exportBatch(ctx context.Context) (int64, error) {
 	tx, err := w.db.BeginTx(ctx, nil)
 	if err != nil {
-		lg.Error().Str("worker", "archiver").Err(errors.Join(models.ErrOnDatabase, err)).Send()
-		return
+		return 0, errors.Join(models.ErrOnDatabase, err)
 	}
 	defer tx.Rollback()
 
-	q1 := `DELETE FROM events WHERE date < NOW() RETURNING event_id, user_id, message, date, created_at, updated_at`
+	q := `DELETE FROM archive WHERE event_id IN (
+		SELECT event_id FROM archive WHERE archived_at < NOW() - make_interval(days => $1) ORDER BY archived_at LIMIT $2 FOR UPDATE SKIP LOCKED
+	)
+	RETURNING event_id, user_id, message, date, created_at, updated_at, archived_at`
 
-	events := make([]models.UserEvent, 0)
-	rows, err := tx.QueryContext(ctx, q1)
+	rows, err := tx.QueryContext(ctx, q, w.cfg.RetentionDays, w.cfg.BatchSize)
 	if err != nil {
-		lg.Error().Str("worker", "archiver").Err(errors.Join(models.ErrOnDatabase, err)).Send()
-		return
+		return 0, errors.Join(models.ErrOnDatabase, err)
+	}
+	events, err := scanArchived(rows)
+	if err != nil {
+		return 0, errors.Join(models.ErrOnDatabase, err)
+	}
+	if len(events) == 0 {
+		return 0, nil
 	}
 
+	if err := w.writeExport(events); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Join(models.ErrOnDatabase, err)
+	}
+	return int64(len(events)), nil
+}
+
+func scanArchived(rows *sql.Rows) ([]models.ArchivedEvent, error) {
+	defer rows.Close()
+
+	events := make([]models.ArchivedEvent, 0)
 	for rows.Next() {
-		var ev models.UserEvent
-		err := rows.Scan(&ev.EventId, &ev.UserId, &ev.Message, &ev.Date, &ev.CreatedAt, &ev.UpdatedAt)
-		if err != nil {
-			lg.Error().Str("worker", "archiver").Err(errors.Join(models.ErrOnDatabase, err)).Send()
-			return
+		var ev models.ArchivedEvent
+		if err := rows.Scan(&ev.EventId, &ev.UserId, &ev.Message, &ev.Date, &ev.CreatedAt, &ev.UpdatedAt, &ev.ArchivedAt); err != nil {
+			return nil, err
 		}
 		events = append(events, ev)
 	}
+	return events, rows.Err()
+}
 
-	if rows.Err() != nil {
-		lg.Error().Str("worker", "archiver").Err(errors.Join(models.ErrOnDatabase, err)).Send()
-		return
+func (w *Wrk) writeExport(events []models.ArchivedEvent) error {
+	if err := os.MkdirAll(w.cfg.ExportDir, 0o755); err != nil {
+		return err
 	}
 
-	q2 := `INSERT INTO archive (user_id, event_id, message, date, created_at, updated_at) values ($1, $2, $3, $4, $5, $6)`
-	for _, ev := range events {
-		if _, err := tx.ExecContext(ctx, q2, ev.UserId, ev.EventId, ev.Message, ev.Date, ev.CreatedAt, ev.UpdatedAt); err != nil {
-			lg.Error().Str("worker", "archiver").Err(errors.Join(models.ErrOnDatabase, err)).Send()
-			return
-		}
+	name := filepath.Join(w.cfg.ExportDir, fmt.Sprintf("archive-%s-%s.jsonl.gz", time.Now().UTC().Format("20060102T150405.000000000Z"), events[0].EventId))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		lg.Error().Str("worker", "archiver").Err(errors.Join(models.ErrOnDatabase, err)).Msg("while commiting")
-	} else {
-		lg.Info().Str("worker", "archiver").Int("quantity", len(events)).Msg("events archived successfully")
+	if err := writeJsonl(f, events); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(name)
+		return err
+	}
+	return f.Close()
+}
+
+func writeJsonl(f *os.File, events []models.ArchivedEvent) error {
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
 	}
+	return gz.Close()
 }
 
 func (w *Wrk) Start(ctx context.Context, wg *sync.WaitGroup) {
-	t := time.NewTicker(time.Duration(w.interval) * time.Second)
+	t := time.NewTicker(time.Duration(w.cfg.Interval) * time.Second)
 	for {
 		select {
 		case <-t.C:
 			w.archive(ctx)
+			w.retain(ctx)
 		case <-ctx.Done():
 			wg.Done()
 			return
@@ -103,7 +250,7 @@ func New(cfg WrkConfig) *Wrk {
 		panic(fmt.Sprintf("failed to ping db: %v", err))
 	}
 	return &Wrk{
-		db:       db,
-		interval: cfg.Interval,
+		db:  db,
+		cfg: cfg,
 	}
 }
@@ -0,0 +1,76 @@
+package archiver
+
+import (
+	"app/internal/models"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteExport(t *testing.T) {
+	t.Parallel()
+	w := &Wrk{cfg: WrkConfig{ExportDir: filepath.Join(t.TempDir(), "export")}}
+
+	date := time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC)
+	events := []models.ArchivedEvent{
+		{UserId: "u1", Event: models.Event{EventId: "e1", Message: "first", Date: date}, ArchivedAt: date},
+		{UserId: "u1", Event: models.Event{EventId: "e2", Message: "second", Date: date}, ArchivedAt: date},
+	}
+	if err := w.writeExport(events); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(w.cfg.ExportDir, "archive-*-e1.jsonl.gz"))
+	if len(files) != 1 {
+		t.Fatalf("expected one export file, got: %v", files)
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]models.ArchivedEvent, 0)
+	sc := bufio.NewScanner(gz)
+	for sc.Scan() {
+		var ev models.ArchivedEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev)
+	}
+
+	if len(got) != 2 || got[0].EventId != "e1" || got[1].Message != "second" || got[0].UserId != "u1" || !got[0].ArchivedAt.Equal(date) {
+		t.Logf("got: %+v", got)
+		t.Fail()
+	}
+}
+
+func TestWrkConfigValid(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		input WrkConfig
+		ok    bool
+	}{
+		{input: WrkConfig{Interval: 10, BatchSize: 1000, RetentionMode: RetentionDelete}, ok: true},
+		{input: WrkConfig{Interval: 10, BatchSize: 1000, RetentionDays: 30, RetentionMode: RetentionExport}, ok: true},
+		{input: WrkConfig{Interval: 10, BatchSize: 0, RetentionMode: RetentionDelete}, ok: false},
+		{input: WrkConfig{Interval: 10, BatchSize: 1000, RetentionMode: "truncate"}, ok: false},
+	}
+
+	for _, v := range testCases {
+		if err := v.input.Valid(); (err == nil) != v.ok {
+			t.Logf("input: %+v, got: %v", v.input, err)
+			t.Fail()
+		}
+	}
+}
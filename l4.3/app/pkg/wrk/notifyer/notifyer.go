@@ -3,100 +3,208 @@ package notifyer
 import (
 	"app/internal/models"
 	"app/pkg/logger"
-	"app/pkg/sender"
-	"container/heap"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
-)
-
-const BufSize = 50
 
-type PriorityQueue []models.EventTask
+	"github.com/wb-go/wbf/dbpg"
+)
 
-func (pq PriorityQueue) Len() int {
-	return len(pq)
-}
-func (pq PriorityQueue) Less(i, j int) bool {
-	return pq[i].Event.Date.Before(pq[j].Event.Date)
+type NotifConfig struct {
+	Interval      int `env:"NOTIF_INTERVAL" env-default:"1"`
+	BatchSize     int `env:"NOTIF_BATCH_SIZE" env-default:"50"`
+	Lease         int `env:"NOTIF_LEASE" env-default:"60"`
+	MaxAttempts   int `env:"NOTIF_MAX_ATTEMPTS" env-default:"5"`
+	RetryDelay    int `env:"NOTIF_RETRY_DELAY" env-default:"10"`
+	MaxRetryDelay int `env:"NOTIF_MAX_RETRY_DELAY" env-default:"3600"`
 }
-func (pq PriorityQueue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
-}
-func (pq *PriorityQueue) Push(x interface{}) {
-	*pq = append(*pq, x.(models.EventTask))
-}
-func (pq *PriorityQueue) Pop() interface{} {
-	old := *pq
-	n := len(old)
-	x := old[n-1]
-	*pq = old[:n-1]
-	return x
+
+// Notifier delivers a reminder to rem.Recipient over one channel.
+type Notifier interface {
+	Notify(ctx context.Context, rem models.Reminder) error
 }
 
+// Notifyer sends reminders stored in the reminders table. Due rows are claimed
+// with FOR UPDATE SKIP LOCKED and leased for cfg.Lease seconds, so several
+// replicas can poll the same table and a crashed replica's rows are picked up
+// again once the lease expires. Failed sends are retried with exponential backoff
+// and moved to reminders_dead_letter after cfg.MaxAttempts attempts.
 type Notifyer struct {
-	pq          PriorityQueue
-	timer       *time.Timer
-	emailSender *sender.Sender
+	db        *dbpg.DB
+	cfg       NotifConfig
+	notifiers map[string]Notifier
 }
 
-func New(snd *sender.Sender) *Notifyer {
-	s := &Notifyer{
-		pq:          make(PriorityQueue, 0),
-		emailSender: snd,
+func New(db *dbpg.DB, notifiers map[string]Notifier, cfg NotifConfig) *Notifyer {
+	return &Notifyer{
+		db:        db,
+		cfg:       cfg,
+		notifiers: notifiers,
 	}
-	heap.Init(&s.pq)
-	return s
 }
 
-func (s *Notifyer) Start(ctx context.Context, wg *sync.WaitGroup, taskChan chan models.EventTask) {
+func (s *Notifyer) Start(ctx context.Context, wg *sync.WaitGroup) {
+	t := time.NewTicker(time.Duration(s.cfg.Interval) * time.Second)
+	defer t.Stop()
 	for {
-		if s.pq.Len() > 0 {
-			nextTask := s.pq[0]
-			now := time.Now()
-
-			if nextTask.Event.Date.Before(now) {
-				s.sendNotification(ctx, nextTask)
-				heap.Pop(&s.pq)
-				continue
-			}
-
-			if s.timer == nil {
-				s.timer = time.NewTimer(nextTask.Event.Date.Sub(now))
-			} else {
-				s.timer.Reset(nextTask.Event.Date.Sub(now))
-			}
-		}
+		s.process(ctx)
 		select {
+		case <-t.C:
 		case <-ctx.Done():
 			wg.Done()
 			return
-		case newTask := <-taskChan:
-			heap.Push(&s.pq, newTask)
-			if s.timer != nil {
-				s.timer.Stop()
-			}
-		case <-func() <-chan time.Time {
-			if s.timer != nil {
-				return s.timer.C
-			}
-			return nil
-		}():
-			if s.pq.Len() > 0 {
-				task := heap.Pop(&s.pq).(models.EventTask)
-				s.sendNotification(ctx, task)
-			}
 		}
 	}
 }
 
-func (s *Notifyer) sendNotification(ctx context.Context, task models.EventTask) {
+func (s *Notifyer) process(ctx context.Context) {
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	for {
+		reminders, err := s.claim(ctx)
+		if err != nil {
+			lg.Error().Str("worker", "notifyer").Err(errors.Join(models.ErrOnDatabase, err)).Msg("while claiming reminders")
+			return
+		}
+
+		for _, rem := range reminders {
+			s.sendNotification(ctx, rem)
+		}
+
+		if len(reminders) < s.cfg.BatchSize || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Notifyer) claim(ctx context.Context) ([]models.Reminder, error) {
+	q := `WITH due AS (
+		SELECT reminder_id FROM reminders
+		WHERE (status = $1 AND send_at <= NOW()) OR (status = $2 AND locked_until < NOW())
+		ORDER BY send_at
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE reminders r SET status = $2, attempts = r.attempts + 1, locked_until = NOW() + make_interval(secs => $4), updated_at = NOW()
+	FROM due WHERE r.reminder_id = due.reminder_id
+	RETURNING r.reminder_id, r.event_id, r.user_id, r.channel, r.recipient, r.locale, r.message, r.event_date, r.send_at, r.status, r.attempts, r.last_error`
+
+	rows, err := s.db.Master.QueryContext(ctx, q, models.ReminderPending, models.ReminderProcessing, s.cfg.BatchSize, s.cfg.Lease)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := make([]models.Reminder, 0)
+	for rows.Next() {
+		var rem models.Reminder
+		if err := rows.Scan(&rem.ReminderId, &rem.EventId, &rem.UserId, &rem.Channel, &rem.Recipient, &rem.Locale, &rem.Message, &rem.EventDate, &rem.SendAt, &rem.Status, &rem.Attempts, &rem.LastError); err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, rem)
+	}
+
+	return reminders, rows.Err()
+}
+
+func (s *Notifyer) sendNotification(ctx context.Context, rem models.Reminder) {
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	rem, due, err := s.refresh(ctx, rem)
+	if err != nil {
+		lg.Error().Str("worker", "notifyer").Err(errors.Join(models.ErrOnDatabase, err)).Msg(fmt.Sprintf("Failed to read reminder %s", rem.ReminderId))
+		return
+	}
+	if !due {
+		return
+	}
+
+	notifier, ok := s.notifiers[rem.Channel]
+	if !ok {
+		err := fmt.Errorf("%w: %s", models.ErrUnsupportedChannel, rem.Channel)
+		lg.Error().Str("worker", "notifyer").Err(err).Msg(fmt.Sprintf("Failed to send reminder for event %s", rem.EventId))
+		s.deadLetter(ctx, rem, err)
+		return
+	}
+
+	err = notifier.Notify(ctx, rem)
+	if err != nil {
+		lg.Error().Str("worker", "notifyer").Str("channel", rem.Channel).Err(err).Msg(fmt.Sprintf("Failed to send reminder for event %s", rem.EventId))
+		if rem.Attempts >= s.cfg.MaxAttempts {
+			s.deadLetter(ctx, rem, err)
+		} else {
+			s.retryLater(ctx, rem, err)
+		}
+		return
+	}
+
+	lg.Info().Str("worker", "notifyer").Str("channel", rem.Channel).Msg(fmt.Sprintf("Reminder sent for event %s to %s", rem.EventId, rem.Recipient))
+	q := `UPDATE reminders SET status = $1, locked_until = NULL, updated_at = NOW() WHERE reminder_id = $2`
+	if _, err := s.db.ExecContext(ctx, q, models.ReminderSent, rem.ReminderId); err != nil {
+		lg.Error().Str("worker", "notifyer").Err(errors.Join(models.ErrOnDatabase, err)).Msg(fmt.Sprintf("Failed to mark reminder %s as sent", rem.ReminderId))
+	}
+}
+
+// refresh reads the message and dates of a claimed reminder again, the event
+// may have been updated since the claim. A reminder moved to a later date is
+// released back to pending without counting the attempt, due is false then.
+func (s *Notifyer) refresh(ctx context.Context, rem models.Reminder) (models.Reminder, bool, error) {
+	q1 := `SELECT message, event_date, send_at FROM reminders WHERE reminder_id = $1 AND status = $2`
+	if err := s.db.QueryRowContext(ctx, q1, rem.ReminderId, models.ReminderProcessing).Scan(&rem.Message, &rem.EventDate, &rem.SendAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// cancelled since the claim
+			return rem, false, nil
+		}
+		return rem, false, err
+	}
+	if !rem.SendAt.After(time.Now()) {
+		return rem, true, nil
+	}
+
+	q2 := `UPDATE reminders SET status = $1, attempts = attempts - 1, locked_until = NULL, updated_at = NOW() WHERE reminder_id = $2 AND status = $3`
+	_, err := s.db.ExecContext(ctx, q2, models.ReminderPending, rem.ReminderId, models.ReminderProcessing)
+	return rem, false, err
+}
+
+// backoff returns the delay in seconds before the next attempt: RetryDelay doubled
+// after every failed attempt, capped at MaxRetryDelay.
+func (s *Notifyer) backoff(attempts int) int {
+	delay := s.cfg.RetryDelay
+	for i := 1; i < attempts && delay < s.cfg.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, s.cfg.MaxRetryDelay)
+}
+
+func (s *Notifyer) retryLater(ctx context.Context, rem models.Reminder, sendErr error) {
 	lg := logger.LoggerFromCtx(ctx).Lg
-	err := s.emailSender.SendReminder(task.Email, task.Event)
+
+	// a reminder moved to a later date while it was being sent waits for that date
+	q := `UPDATE reminders SET status = $1, last_error = $2, send_at = GREATEST(send_at, NOW() + make_interval(secs => $3)), locked_until = NULL, updated_at = NOW() WHERE reminder_id = $4`
+	if _, err := s.db.ExecContext(ctx, q, models.ReminderPending, sendErr.Error(), s.backoff(rem.Attempts), rem.ReminderId); err != nil {
+		lg.Error().Str("worker", "notifyer").Err(errors.Join(models.ErrOnDatabase, err)).Msg(fmt.Sprintf("Failed to reschedule reminder %s", rem.ReminderId))
+	}
+}
+
+func (s *Notifyer) deadLetter(ctx context.Context, rem models.Reminder, sendErr error) {
+	lg := logger.LoggerFromCtx(ctx).Lg
+
+	err := s.db.WithTx(ctx, func(tx *sql.Tx) error {
+		q1 := `UPDATE reminders SET status = $1, last_error = $2, locked_until = NULL, updated_at = NOW() WHERE reminder_id = $3`
+		if _, err := tx.ExecContext(ctx, q1, models.ReminderFailed, sendErr.Error(), rem.ReminderId); err != nil {
+			return err
+		}
+
+		q2 := `INSERT INTO reminders_dead_letter (reminder_id, event_id, user_id, channel, recipient, locale, message, event_date, send_at, attempts, last_error)
+		SELECT reminder_id, event_id, user_id, channel, recipient, locale, message, event_date, send_at, attempts, last_error FROM reminders WHERE reminder_id = $1
+		ON CONFLICT (reminder_id) DO NOTHING`
+		_, err := tx.ExecContext(ctx, q2, rem.ReminderId)
+		return err
+	})
 	if err != nil {
-		lg.Error().Str("worker", "notifyer").Err(err).Msg(fmt.Sprintf("Failed to send email for event %s", task.Event.EventId))
-	} else {
-		lg.Info().Str("worker", "notifyer").Msg(fmt.Sprintf("Reminder sent for event %s to %s", task.Event.EventId, task.Email))
+		lg.Error().Str("worker", "notifyer").Err(errors.Join(models.ErrOnDatabase, err)).Msg(fmt.Sprintf("Failed to dead-letter reminder %s", rem.ReminderId))
 	}
 }
@@ -0,0 +1,134 @@
+package notifyer
+
+import (
+	"app/internal/models"
+	"app/pkg/logger"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/wb-go/wbf/dbpg"
+)
+
+// fakeNotifier records the reminders it was asked to send.
+type fakeNotifier struct {
+	sent []models.Reminder
+	err  error
+}
+
+func (n *fakeNotifier) Notify(_ context.Context, rem models.Reminder) error {
+	n.sent = append(n.sent, rem)
+	return n.err
+}
+
+func newMockNotifyer(t *testing.T, notifier Notifier) (*Notifyer, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	cfg := NotifConfig{MaxAttempts: 5, RetryDelay: 10, MaxRetryDelay: 3600}
+	return New(&dbpg.DB{Master: db}, map[string]Notifier{"email": notifier}, cfg), mock
+}
+
+func claimedReminder() models.Reminder {
+	return models.Reminder{
+		ReminderId: "r1",
+		EventId:    "e1",
+		Channel:    "email",
+		Recipient:  "user@example.com",
+		Message:    "old message",
+		EventDate:  time.Now().Add(time.Hour),
+		SendAt:     time.Now().Add(-time.Minute),
+		Status:     models.ReminderProcessing,
+		Attempts:   1,
+	}
+}
+
+func TestSendNotificationRereadsReminder(t *testing.T) {
+	t.Parallel()
+	ctx := context.WithValue(context.Background(), logger.LoggerKey, logger.New())
+	eventDate := time.Now().Add(2 * time.Hour)
+	past := time.Now().Add(-time.Minute)
+	later := time.Now().Add(time.Hour)
+
+	testCases := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		sendErr error
+		expect  func(mock sqlmock.Sqlmock)
+		sent    bool
+	}{
+		{
+			"updated before the send",
+			sqlmock.NewRows([]string{"message", "event_date", "send_at"}).AddRow("new message", eventDate, past),
+			nil,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`UPDATE reminders SET status = \$1, locked_until = NULL`).
+					WithArgs(models.ReminderSent, "r1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			true,
+		},
+		{
+			"moved to a later date",
+			sqlmock.NewRows([]string{"message", "event_date", "send_at"}).AddRow("new message", eventDate, later),
+			nil,
+			func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`UPDATE reminders SET status = \$1, attempts = attempts - 1, locked_until = NULL, updated_at = NOW\(\) WHERE reminder_id = \$2 AND status = \$3`).
+					WithArgs(models.ReminderPending, "r1", models.ReminderProcessing).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			false,
+		},
+		{
+			"cancelled since the claim",
+			sqlmock.NewRows([]string{"message", "event_date", "send_at"}),
+			nil,
+			func(sqlmock.Sqlmock) {},
+			false,
+		},
+		{
+			"failed after an update",
+			sqlmock.NewRows([]string{"message", "event_date", "send_at"}).AddRow("new message", eventDate, past),
+			errors.New("smtp is down"),
+			func(mock sqlmock.Sqlmock) {
+				// the retry doesn't go before a date the event was moved to
+				mock.ExpectExec(`UPDATE reminders SET status = \$1, last_error = \$2, send_at = GREATEST\(send_at, NOW\(\) \+ make_interval\(secs => \$3\)\)`).
+					WithArgs(models.ReminderPending, "smtp is down", 10, "r1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			notifier := &fakeNotifier{err: tc.sendErr}
+			s, mock := newMockNotifyer(t, notifier)
+			mock.ExpectQuery(`SELECT message, event_date, send_at FROM reminders WHERE reminder_id = \$1 AND status = \$2`).
+				WithArgs("r1", models.ReminderProcessing).
+				WillReturnRows(tc.rows)
+			tc.expect(mock)
+
+			s.sendNotification(ctx, claimedReminder())
+
+			if !tc.sent {
+				if len(notifier.sent) != 0 {
+					t.Fatalf("expected no reminder to be sent, got: %+v", notifier.sent)
+				}
+				return
+			}
+			if len(notifier.sent) != 1 || notifier.sent[0].Message != "new message" || !notifier.sent[0].EventDate.Equal(eventDate) {
+				t.Fatalf("expected the updated reminder to be sent, got: %+v", notifier.sent)
+			}
+		})
+	}
+}